@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/andkolbe/bookings/internal/ical"
+	"github.com/andkolbe/bookings/internal/models"
+)
+
+// the most we will read from one external calendar
+const maxCalendarSize = 10 << 20
+
+// the part of the repository the calendar sync uses. The database repo satisfies it
+type calendarStore interface {
+	AllCalendarSources(ctx context.Context) ([]models.CalendarSource, error)
+	UpdateCalendarSourceSynced(ctx context.Context, id int, at time.Time, lastError string) error
+	ExternalBlocksForSource(ctx context.Context, sourceID int) ([]models.RoomRestriction, error)
+	InsertExternalBlock(ctx context.Context, r models.RoomRestriction) error
+	UpdateExternalBlock(ctx context.Context, id int, start, end time.Time) error
+	DeleteBlockByID(ctx context.Context, id int) error
+}
+
+// starts a goroutine that syncs the blocks of every external calendar straight away, then every
+// app.CalendarSyncInterval, or sooner when something is put on app.SyncCalendars.
+// The returned func stops it, abandoning a sync that is still running
+func startCalendarSync(store calendarStore, client *http.Client) (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		ticker := time.NewTicker(app.CalendarSyncInterval)
+		defer ticker.Stop()
+
+		for {
+			syncCalendars(ctx, store, client)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-app.SyncCalendars:
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return func() {
+		cancel()
+		wg.Wait()
+	}
+}
+
+// syncs every external calendar, recording how each one went
+func syncCalendars(ctx context.Context, store calendarStore, client *http.Client) {
+	sources, err := store.AllCalendarSources(ctx)
+	if err != nil {
+		errorLog.Println(err)
+		return
+	}
+
+	for _, s := range sources {
+		lastError := ""
+		err := syncCalendar(ctx, store, client, s)
+		if err != nil {
+			if ctx.Err() != nil {
+				// we are shutting down, so this isn't the calendar's fault
+				return
+			}
+			errorLog.Printf("syncing calendar %d (%s): %s", s.ID, s.URL, err)
+			lastError = err.Error()
+		}
+
+		err = store.UpdateCalendarSourceSynced(ctx, s.ID, time.Now(), lastError)
+		if err != nil {
+			errorLog.Println(err)
+		}
+	}
+}
+
+// makes the blocks for a calendar source match the events in its calendar: new events are blocked,
+// events that moved move their block, and blocks for events that went away are removed.
+// If the calendar can't be read, its blocks are left as they are
+func syncCalendar(ctx context.Context, store calendarStore, client *http.Client, s models.CalendarSource) error {
+	events, err := fetchCalendar(ctx, client, s.URL)
+	if err != nil {
+		return err
+	}
+
+	blocks, err := store.ExternalBlocksForSource(ctx, s.ID)
+	if err != nil {
+		return err
+	}
+
+	existing := make(map[string]models.RoomRestriction)
+	for _, b := range blocks {
+		existing[b.ExternalUID] = b
+	}
+
+	seen := make(map[string]bool)
+	for _, e := range events {
+		// some calendars repeat an event, but one block is enough
+		if seen[e.UID] {
+			continue
+		}
+		seen[e.UID] = true
+
+		b, ok := existing[e.UID]
+		delete(existing, e.UID)
+
+		switch {
+		case !ok:
+			err = store.InsertExternalBlock(ctx, models.RoomRestriction{
+				RoomID:      s.RoomID,
+				SourceID:    s.ID,
+				ExternalUID: e.UID,
+				StartDate:   e.Start,
+				EndDate:     e.End,
+			})
+		case !sameDay(b.StartDate, e.Start) || !sameDay(b.EndDate, e.End):
+			err = store.UpdateExternalBlock(ctx, b.ID, e.Start, e.End)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	// whatever is left is no longer in the calendar. One that is already gone was removed by hand
+	for _, b := range existing {
+		err = store.DeleteBlockByID(ctx, b.ID)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// reads and parses a calendar from an http(s) url. The admin form only takes http(s) urls, but the url comes from
+// the database here, so anything else is refused rather than, say, read from the local disk
+func fetchCalendar(ctx context.Context, client *http.Client, rawURL string) ([]ical.Event, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("can't sync a %q url, only http and https", u.Scheme)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("got %s", resp.Status)
+	}
+
+	return ical.Parse(io.LimitReader(resp.Body, maxCalendarSize))
+}
+
+func sameDay(a, b time.Time) bool {
+	return a.Format("2006-01-02") == b.Format("2006-01-02")
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/andkolbe/bookings/internal/ical"
+	"github.com/andkolbe/bookings/internal/models"
+)
+
+// in memory calendar sources and their blocks, like the calendar_sources and room_restrictions tables
+type fakeCalendarStore struct {
+	mu      sync.Mutex
+	sources []models.CalendarSource
+	blocks  map[int]models.RoomRestriction
+	nextID  int
+}
+
+func newFakeCalendarStore(sources ...models.CalendarSource) *fakeCalendarStore {
+	return &fakeCalendarStore{sources: sources, blocks: make(map[int]models.RoomRestriction)}
+}
+
+func (s *fakeCalendarStore) AllCalendarSources(ctx context.Context) ([]models.CalendarSource, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]models.CalendarSource(nil), s.sources...), nil
+}
+
+func (s *fakeCalendarStore) UpdateCalendarSourceSynced(ctx context.Context, id int, at time.Time, lastError string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.sources {
+		if s.sources[i].ID == id {
+			s.sources[i].LastSyncedAt = at
+			s.sources[i].LastError = lastError
+		}
+	}
+	return nil
+}
+
+func (s *fakeCalendarStore) ExternalBlocksForSource(ctx context.Context, sourceID int) ([]models.RoomRestriction, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var blocks []models.RoomRestriction
+	for _, b := range s.blocks {
+		if b.SourceID == sourceID {
+			blocks = append(blocks, b)
+		}
+	}
+	return blocks, nil
+}
+
+func (s *fakeCalendarStore) InsertExternalBlock(ctx context.Context, r models.RoomRestriction) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextID++
+	r.ID = s.nextID
+	r.RestrictionID = models.RestrictionExternal
+	s.blocks[r.ID] = r
+	return nil
+}
+
+func (s *fakeCalendarStore) UpdateExternalBlock(ctx context.Context, id int, start, end time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b := s.blocks[id]
+	b.StartDate, b.EndDate = start, end
+	s.blocks[id] = b
+	return nil
+}
+
+func (s *fakeCalendarStore) DeleteBlockByID(ctx context.Context, id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.blocks, id)
+	return nil
+}
+
+// the blocks, as "uid start end", sorted by uid
+func (s *fakeCalendarStore) summary() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var lines []string
+	for _, b := range s.blocks {
+		lines = append(lines, fmt.Sprintf("%s %s %s", b.ExternalUID, b.StartDate.Format("01-02"), b.EndDate.Format("01-02")))
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, ", ")
+}
+
+func (s *fakeCalendarStore) source(id int) models.CalendarSource {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, x := range s.sources {
+		if x.ID == id {
+			return x
+		}
+	}
+	return models.CalendarSource{}
+}
+
+// a booking site's calendar, as "uid start end" with days in January 2050
+func testCalendar(events ...string) []byte {
+	cal := ical.Calendar{Name: "Airbnb"}
+	for _, e := range events {
+		var uid string
+		var start, end int
+		fmt.Sscanf(e, "%s %d %d", &uid, &start, &end)
+		cal.Events = append(cal.Events, ical.Event{
+			UID:   uid,
+			Start: time.Date(2050, 1, start, 0, 0, 0, 0, time.UTC),
+			End:   time.Date(2050, 1, end, 0, 0, 0, 0, time.UTC),
+		})
+	}
+	return cal.Marshal()
+}
+
+func TestSyncCalendars(t *testing.T) {
+	errorLog = log.New(ioutil.Discard, "", 0)
+
+	var mu sync.Mutex
+	feed := testCalendar("a 1 3", "b 10 12")
+	status := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		w.WriteHeader(status)
+		w.Write(feed)
+	}))
+	defer srv.Close()
+
+	store := newFakeCalendarStore(models.CalendarSource{ID: 1, RoomID: 2, URL: srv.URL})
+	ctx := context.Background()
+
+	syncCalendars(ctx, store, srv.Client())
+	if got := store.summary(); got != "a 01-01 01-03, b 01-10 01-12" {
+		t.Fatalf("expected a block for each event, got %s", got)
+	}
+	if s := store.source(1); s.LastSyncedAt.IsZero() || s.LastError != "" {
+		t.Errorf("expected a successful sync to be recorded, got %+v", s)
+	}
+	for _, b := range store.blocks {
+		if b.RoomID != 2 || b.SourceID != 1 {
+			t.Errorf("expected the block to belong to room 2 and source 1, got %+v", b)
+		}
+	}
+
+	// b moves, a goes away, and c is new. The duplicate c only needs one block
+	mu.Lock()
+	feed = testCalendar("b 11 14", "c 20 21", "c 20 21")
+	mu.Unlock()
+
+	syncCalendars(ctx, store, srv.Client())
+	if got := store.summary(); got != "b 01-11 01-14, c 01-20 01-21" {
+		t.Fatalf("expected the blocks to follow the calendar, got %s", got)
+	}
+
+	// when the calendar can't be fetched, the blocks stay as they were
+	mu.Lock()
+	status = http.StatusServiceUnavailable
+	mu.Unlock()
+
+	syncCalendars(ctx, store, srv.Client())
+	if got := store.summary(); got != "b 01-11 01-14, c 01-20 01-21" {
+		t.Errorf("expected a failed fetch to leave the blocks alone, got %s", got)
+	}
+	if s := store.source(1); !strings.Contains(s.LastError, "503") {
+		t.Errorf("expected the failure to be recorded, got %q", s.LastError)
+	}
+}
+
+func TestSyncCalendars_OnlyHTTP(t *testing.T) {
+	errorLog = log.New(ioutil.Discard, "", 0)
+
+	path := filepath.Join(t.TempDir(), "airbnb.ics")
+	err := ioutil.WriteFile(path, testCalendar("a 5 7"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := newFakeCalendarStore(
+		models.CalendarSource{ID: 1, RoomID: 1, URL: path},
+		models.CalendarSource{ID: 2, RoomID: 1, URL: "file://" + path},
+		models.CalendarSource{ID: 3, RoomID: 1, URL: "ftp://example.com/airbnb.ics"},
+	)
+
+	syncCalendars(context.Background(), store, http.DefaultClient)
+
+	for id := 1; id <= 3; id++ {
+		blocks, _ := store.ExternalBlocksForSource(context.Background(), id)
+		if len(blocks) != 0 {
+			t.Errorf("source %d: expected nothing to be read, got %+v", id, blocks)
+		}
+		if store.source(id).LastError == "" {
+			t.Errorf("source %d: expected the url to be recorded as an error", id)
+		}
+	}
+}
+
+func TestCalendarSync_Wake(t *testing.T) {
+	errorLog = log.New(ioutil.Discard, "", 0)
+
+	requests := make(chan struct{}, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(testCalendar())
+		requests <- struct{}{}
+	}))
+	defer srv.Close()
+
+	// a long interval, so only the first sync and the nudge fetch the calendar
+	app.CalendarSyncInterval = time.Hour
+	app.SyncCalendars = make(chan struct{}, 1)
+	defer func() { app.SyncCalendars = nil }()
+
+	store := newFakeCalendarStore(models.CalendarSource{ID: 1, RoomID: 1, URL: srv.URL})
+	stop := startCalendarSync(store, srv.Client())
+	defer stop()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-requests:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("sync %d didn't fetch the calendar", i+1)
+		}
+		app.SyncCalendars <- struct{}{}
+	}
+}
@@ -3,11 +3,15 @@
 package main
 
 import (
+	"context"
 	"encoding/gob"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/alexedwards/scs/v2"
@@ -15,42 +19,103 @@ import (
 	"github.com/andkolbe/bookings/internal/driver"
 	"github.com/andkolbe/bookings/internal/handlers"
 	"github.com/andkolbe/bookings/internal/helpers"
+	"github.com/andkolbe/bookings/internal/mailer"
 	"github.com/andkolbe/bookings/internal/models"
 	"github.com/andkolbe/bookings/internal/render"
 )
 
-const portNumber = ":8080"
-
 var app config.AppConfig
 var session *scs.SessionManager
+var stopSessionCleanup = func() {}
 var infoLog *log.Logger
 var errorLog *log.Logger
 
 // main is the main application function
 func main() {
-	db, err := run()
+	db, err := run(os.Args[1:])
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer db.SQL.Close() // putting this line here makse sure the db connection won't close until the main function stops running
-
-	defer close(app.MailChan)
-	fmt.Println("Starting mail listener...")
-	listenForMail()
 
-	fmt.Println(fmt.Sprintf("Starting application on port %s", portNumber))
-	// _ = http.ListenAndServe(portNumber, nil)
+	fmt.Println("Starting mail workers...")
+	smtp := mailer.NewSMTP(&app)
+	stopWorkers := startMailWorkers(handlers.Repo.DB, smtp)
+
+	fmt.Println("Starting calendar sync...")
+	stopSync := startCalendarSync(handlers.Repo.DB, &http.Client{Timeout: 30 * time.Second})
+
+	stopBackground := func() {
+		stopSync()
+		stopWorkers()
+		stopSessionCleanup()
+		err := smtp.Close()
+		if err != nil {
+			errorLog.Println(err)
+		}
+	}
 
 	srv := &http.Server{
-		Addr:    portNumber,
+		Addr:    app.Port,
 		Handler: routes(&app),
 	}
 
-	err = srv.ListenAndServe()
-	log.Fatal(err)
+	// stop when we get ctrl-c or a SIGTERM from whatever is running us
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	serverErr := make(chan error, 1)
+	go func() {
+		fmt.Println(fmt.Sprintf("Starting application on port %s", app.Port))
+		serverErr <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serverErr:
+		if err != http.ErrServerClosed {
+			errorLog.Println(err)
+		}
+	case <-ctx.Done():
+		infoLog.Println("Shutting down...")
+	}
+
+	err = shutdown(srv, stopBackground, db)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// stops the server from accepting new connections, waits up to app.ShutdownTimeout for active requests to finish,
+// stops the background work (the calendar sync, and the mail workers once they have sent whatever is due in the outbox),
+// and then closes the database pool
+func shutdown(srv *http.Server, stopBackground func(), db *driver.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), app.ShutdownTimeout)
+	defer cancel()
+
+	err := srv.Shutdown(ctx)
+	if err != nil {
+		// some requests didn't finish in time, so drop their connections
+		errorLog.Println("forcing server to close:", err)
+		_ = srv.Close()
+	}
+
+	// the handlers are done, so nothing else will be added to the outbox. Anything that fails to send now
+	// stays in the outbox and is picked up again next time we start
+	stopBackground()
+	infoLog.Println("Mail outbox flushed")
+
+	if db != nil {
+		return db.SQL.Close()
+	}
+	return nil
 }
 
-func run() (*driver.DB, error) {
+func run(args []string) (*driver.DB, error) {
+	// read settings from flags, environment variables and an optional config file
+	err := config.Load(&app, args)
+	if err != nil {
+		return nil, err
+	}
+
 	// what I am going to put in the session
 	gob.Register(models.Reservation{})	
 	gob.Register(models.User{})
@@ -58,12 +123,12 @@ func run() (*driver.DB, error) {
 	gob.Register(models.Restriction{})
 	gob.Register(map[string]int{})
 
-	// create a channel that will be available to all parts of the app. It listens for a data type of models.MailData
-	mailChan := make(chan models.MailData)
-	app.MailChan = mailChan
+	// mail is saved to the outbox table and sent by the mail workers. This channel lets the handlers wake them up
+	// as soon as something is queued; it only needs room for one nudge
+	app.MailQueued = make(chan struct{}, 1)
 
-	// change this to true when in production
-	app.InProduction = false
+	// the same for the calendar sync, so a new external calendar doesn't wait for the next interval
+	app.SyncCalendars = make(chan struct{}, 1)
 
 	// print these to the terminal
 	infoLog = log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
@@ -71,21 +136,36 @@ func run() (*driver.DB, error) {
 	errorLog = log.New(os.Stdout, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
 	app.ErrorLog = errorLog
 
-	session = scs.New()
-	session.Lifetime = 24 * time.Hour
-	session.Cookie.Persist = true // true = cookie persists even if the browser window closes
-	session.Cookie.SameSite = http.SameSiteLaxMode
-	session.Cookie.Secure = app.InProduction
-
-	app.Session = session
+	// guest links are signed with this. A random one works, but the links we already sent stop working on a restart
+	if app.LinkSecret == "" {
+		if app.InProduction {
+			return nil, errors.New("link-secret has to be set in production")
+		}
+		app.LinkSecret, err = helpers.RandomToken()
+		if err != nil {
+			return nil, err
+		}
+		infoLog.Println("No link-secret set, guest links will stop working when the app restarts")
+	}
 
 	// connect to db
 	log.Println("Connecting to database...")
-	db, err := driver.ConnectSQL("host=localhost port=5432 dbname=bookings user=postgres password=andrew00")
+	db, err := driver.ConnectSQL(app.DSN())
 	if err != nil {
 		log.Fatal("Cannot connect to database! Dying...")
 	}
 
+	// sessions are kept in the database by default, so a restart doesn't log everyone out
+	session, stopSessionCleanup, err = newSessionManager(&app, db.SQL)
+	if err != nil {
+		return nil, err
+	}
+	if app.SessionStore == "memory" {
+		infoLog.Println("Sessions are kept in memory, everyone will be logged out when the app restarts")
+	}
+
+	app.Session = session
+
 	tc, err := render.CreateTemplateCache()
 	if err != nil {
 		log.Fatal("cannot create template cache")
@@ -93,7 +173,6 @@ func run() (*driver.DB, error) {
 	}
 
 	app.TemplateCache = tc
-	app.UseCache = false
 
 	repo := handlers.NewRepo(&app, db)
 	handlers.NewHandlers(repo)
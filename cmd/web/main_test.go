@@ -1,10 +1,98 @@
 package main
 
-import "testing"
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/andkolbe/bookings/internal/mailer"
+	"github.com/andkolbe/bookings/internal/models"
+)
 
 func TestRun(t *testing.T) {
-	_, err := run()
+	_, err := run(nil)
 	if err != nil {
 		t.Error("failed run()")
 	}
-}
\ No newline at end of file
+}
+
+func TestShutdown(t *testing.T) {
+	infoLog = log.New(ioutil.Discard, "", 0)
+	errorLog = log.New(ioutil.Discard, "", 0)
+	app.ShutdownTimeout = 5 * time.Second
+	app.MailWorkers = 2
+	app.MailMaxAttempts = 5
+	app.MailQueued = make(chan struct{}, 1)
+
+	// a slow mailer, so messages are still in the outbox when shutdown starts
+	m := &mailer.Recorder{
+		OnSend: func(models.MailData) error {
+			time.Sleep(10 * time.Millisecond)
+			return nil
+		},
+	}
+	store := &fakeMailStore{}
+	stopMail := startMailWorkers(store, m)
+
+	const requests = 10
+	var started sync.WaitGroup
+	started.Add(requests)
+
+	srv := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			started.Done()
+			store.add(models.MailData{To: r.URL.Query().Get("to")})
+			select {
+			case app.MailQueued <- struct{}{}:
+			default:
+			}
+		}),
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(l)
+
+	var finished sync.WaitGroup
+	for i := 0; i < requests; i++ {
+		finished.Add(1)
+		go func(i int) {
+			defer finished.Done()
+			resp, err := http.Get(fmt.Sprintf("http://%s/?to=guest%d@here.com", l.Addr(), i))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("request %d got status %d", i, resp.StatusCode)
+			}
+		}(i)
+	}
+
+	// every handler is now in flight
+	started.Wait()
+
+	err = shutdown(srv, stopMail, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	finished.Wait()
+
+	if sent := m.Sent(); len(sent) != requests {
+		t.Errorf("expected %d messages to be sent, got %d", requests, len(sent))
+	}
+
+	// the server should no longer accept connections
+	_, err = http.Get(fmt.Sprintf("http://%s/", l.Addr()))
+	if err == nil {
+		t.Error("server still accepting connections after shutdown")
+	}
+}
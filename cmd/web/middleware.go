@@ -1,9 +1,15 @@
 package main
 
 import (
+	"database/sql"
+	"errors"
 	"net/http"
+	"strings"
 
+	"github.com/andkolbe/bookings/internal/handlers"
 	"github.com/andkolbe/bookings/internal/helpers"
+	"github.com/andkolbe/bookings/internal/models"
+	"github.com/andkolbe/bookings/internal/render"
 	"github.com/justinas/nosurf"
 )
 
@@ -17,6 +23,16 @@ func NoSurf(next http.Handler) http.Handler {
 		Secure: app.InProduction,
 		SameSite: http.SameSiteLaxMode,
 	})
+	csrfHandler.ExemptFunc(func(r *http.Request) bool {
+		// requests authenticated with an api token don't use the session cookie, so they can't be forged by another site
+		if _, ok := helpers.APIToken(r); ok {
+			return true
+		}
+		// the json api isn't used from our html forms. Browsers won't send its JSON bodies or DELETEs cross-site
+		// without a CORS preflight, which we never allow, so it doesn't need a CSRF token
+		return strings.HasPrefix(r.URL.Path, "/api/")
+	})
+
 	return csrfHandler
 }
 
@@ -33,6 +49,117 @@ func Auth(next http.Handler) http.Handler {
 			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
 			return
 		}
+
+		// sessions that logged in before the password was last changed, e.g. by a reset, are logged out, and so are
+		// users who have been deactivated
+		if _, ok := helpers.APIToken(r); !ok {
+			user, err := handlers.Repo.DB.GetUserByID(r.Context(), session.GetInt(r.Context(), "user_id"))
+			if errors.Is(err, sql.ErrNoRows) || (err == nil && !user.Active) {
+				_ = session.Destroy(r.Context())
+				session.Put(r.Context(), "error", "Your account isn't active any more")
+				http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+				return
+			} else if err != nil {
+				helpers.ServerError(w, err)
+				return
+			}
+			if user.PasswordChangedAt.Unix() > int64(session.GetInt(r.Context(), "logged_in_at")) {
+				_ = session.Destroy(r.Context())
+				session.Put(r.Context(), "error", "Your password has changed, log in again")
+				http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+				return
+			}
+
+			// an owner may have changed the user's access level since they logged in
+			if session.GetInt(r.Context(), "access_level") != user.AccessLevel {
+				session.Put(r.Context(), "access_level", user.AccessLevel)
+			}
+
+			// when owners require two-factor login, users who haven't turned it on can only go to where they do
+			if !user.TwoFactor() && !strings.HasPrefix(r.URL.Path, "/admin/two-factor") {
+				required, err := handlers.Repo.DB.GetSetting(r.Context(), models.SettingRequireTwoFactor)
+				if err != nil {
+					helpers.ServerError(w, err)
+					return
+				}
+				if required == "1" {
+					session.Put(r.Context(), "warning", "Turn on two-factor login to carry on")
+					http.Redirect(w, r, "/admin/two-factor", http.StatusSeeOther)
+					return
+				}
+			}
+		}
+
 		next.ServeHTTP(w, r)
 	})
-}
\ No newline at end of file
+}
+
+// only lets through users whose access level is at least level. Everybody else gets the 403 page
+func RequireAccess(level int) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !helpers.HasAccess(r, level) {
+				app.InfoLog.Println("access denied to", r.URL.Path)
+				stringMap := make(map[string]string)
+				stringMap["required"] = models.AccessLevelName(level)
+
+				w.WriteHeader(http.StatusForbidden)
+				_ = render.Template(w, r, "forbidden.page.html", &models.TemplateData{
+					StringMap: stringMap,
+				})
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// authenticates requests that send an "Authorization: Bearer <token>" header. Requests without the header carry on
+// to the usual session based auth, but a bad token is rejected straight away
+func APITokenAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("Authorization")
+		if header == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		token := strings.TrimPrefix(header, "Bearer ")
+		if token == header || token == "" {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			helpers.ClientError(w, http.StatusUnauthorized)
+			return
+		}
+
+		t, err := handlers.Repo.DB.GetAPITokenByHash(r.Context(), helpers.HashAPIToken(token))
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			helpers.ClientError(w, http.StatusUnauthorized)
+			return
+		}
+
+		// read tokens can look but not touch
+		if t.Scope != models.APIScopeWrite && r.Method != http.MethodGet && r.Method != http.MethodHead {
+			helpers.ClientError(w, http.StatusForbidden)
+			return
+		}
+
+		err = handlers.Repo.DB.UpdateAPITokenLastUsed(r.Context(), t.ID)
+		if err != nil {
+			app.ErrorLog.Println(err)
+		}
+
+		next.ServeHTTP(w, r.WithContext(helpers.WithAPIToken(r.Context(), t)))
+	})
+}
+
+// keeps requests authenticated with an api token out of pages that must only be used from the browser
+func RequireSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := helpers.APIToken(r); ok {
+			helpers.ClientError(w, http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
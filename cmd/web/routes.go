@@ -5,6 +5,7 @@ import (
 
 	"github.com/andkolbe/bookings/internal/config"
 	"github.com/andkolbe/bookings/internal/handlers"
+	"github.com/andkolbe/bookings/internal/models"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 )
@@ -15,18 +16,25 @@ func routes(app *config.AppConfig) http.Handler {
 
 	// middleware allows you process a request as it comes into your web app and perform some action on it
 	mux.Use(middleware.Recoverer)
+	mux.Use(APITokenAuth)
 	mux.Use(NoSurf)
 	mux.Use(SessionLoad)
 
 	mux.Get("/", handlers.Repo.Home)
 	mux.Get("/about", handlers.Repo.About)
-	mux.Get("/generals-quarters", handlers.Repo.Generals)
-	mux.Get("/majors-suite", handlers.Repo.Majors)
+	mux.Get("/rooms", handlers.Repo.Rooms)
+	mux.Get("/rooms/{slug}", handlers.Repo.RoomPage)
+	// the rooms had their own pages before they were kept in the database
+	mux.Get("/generals-quarters", http.RedirectHandler("/rooms/generals-quarters", http.StatusMovedPermanently).ServeHTTP)
+	mux.Get("/majors-suite", http.RedirectHandler("/rooms/majors-suite", http.StatusMovedPermanently).ServeHTTP)
 	mux.Get("/contact", handlers.Repo.Contact)
 
 	mux.Get("/make-reservation", handlers.Repo.Reservation)
 	mux.Post("/make-reservation", handlers.Repo.PostReservation)
 	mux.Get("/reservation-summary", handlers.Repo.ReservationSummary)
+	mux.Get("/my-reservation/{token}", handlers.Repo.GuestReservation)
+	mux.Post("/my-reservation/{token}/change", handlers.Repo.GuestPostChangeDates)
+	mux.Post("/my-reservation/{token}/cancel", handlers.Repo.GuestPostCancel)
 
 	mux.Get("/search-availability", handlers.Repo.Availability)
 	mux.Post("/search-availability", handlers.Repo.PostAvailability)
@@ -36,21 +44,92 @@ func routes(app *config.AppConfig) http.Handler {
 
 	mux.Get("/user/login", handlers.Repo.ShowLogin)
 	mux.Post("/user/login", handlers.Repo.PostShowLogin)
+	mux.Get("/user/login/two-factor", handlers.Repo.ShowLoginTwoFactor)
+	mux.Post("/user/login/two-factor", handlers.Repo.PostLoginTwoFactor)
 	mux.Get("/user/logout", handlers.Repo.Logout)
+	mux.Get("/user/forgot-password", handlers.Repo.ShowForgotPassword)
+	mux.Post("/user/forgot-password", handlers.Repo.PostForgotPassword)
+	mux.Get("/user/reset-password/{token}", handlers.Repo.ShowResetPassword)
+	mux.Post("/user/reset-password/{token}", handlers.Repo.PostResetPassword)
+
+	// the token in the url is the secret, so calendar apps can subscribe without logging in
+	mux.Get("/calendars/{token}.ics", handlers.Repo.RoomCalendarFeed)
+
+	mux.Route("/api/v1", func(mux chi.Router) {
+		mux.Get("/rooms", handlers.Repo.APIRooms)
+		mux.Get("/availability", handlers.Repo.APIAvailability)
+		mux.Post("/reservations", handlers.Repo.APICreateReservation)
+		mux.Get("/reservations/{id}", handlers.Repo.APIGetReservation)
+		mux.Delete("/reservations/{id}", handlers.Repo.APICancelReservation)
+	})
 
 	mux.Route("/admin", func(mux chi.Router) {
-		// mux.Use(Auth)
+		mux.Use(Auth)
+		// everybody with an account is at least front desk
+		mux.Use(RequireAccess(models.AccessFrontDesk))
+
 		mux.Get("/dashboard", handlers.Repo.AdminDashboard)
 
 		mux.Get("/reservations-new", handlers.Repo.AdminNewReservations)
 		mux.Get("/reservations-all", handlers.Repo.AdminAllReservations)
 		mux.Get("/reservations-calendar", handlers.Repo.AdminReservationsCalendar)
-		mux.Post("/reservations-calendar", handlers.Repo.AdminPostReservationsCalendar)
-		mux.Get("/process-reservation/{src}/{id}/do", handlers.Repo.AdminProcessReservation)
-		mux.Delete("/delete-reservation/{src}/{id}/do", handlers.Repo.AdminDeleteReservation)
+		mux.With(RequireAccess(models.AccessOwner)).Post("/blocks", handlers.Repo.AdminPostBlock)
+		mux.With(RequireAccess(models.AccessOwner)).Post("/blocks/{id}/delete", handlers.Repo.AdminDeleteBlock)
 
 		mux.Get("/reservations/{src}/{id}/show", handlers.Repo.AdminShowReservation)
 		mux.Post("/reservations/{src}/{id}", handlers.Repo.AdminPostShowReservation)
+		// only owners can cancel, the handler checks that
+		mux.Post("/reservations/{src}/{id}/status", handlers.Repo.AdminPostReservationStatus)
+
+		mux.With(RequireAccess(models.AccessManager)).Get("/calendar-feeds", handlers.Repo.AdminCalendarFeeds)
+		mux.With(RequireAccess(models.AccessManager)).Post("/calendar-feeds/{id}", handlers.Repo.AdminPostCalendarFeed)
+		mux.With(RequireAccess(models.AccessManager)).Get("/calendar-sources", handlers.Repo.AdminCalendarSources)
+		mux.With(RequireAccess(models.AccessManager)).Post("/calendar-sources", handlers.Repo.AdminPostCalendarSource)
+		mux.With(RequireAccess(models.AccessManager)).Post("/calendar-sources/sync", handlers.Repo.AdminSyncCalendarSources)
+		mux.With(RequireAccess(models.AccessManager)).Post("/calendar-sources/{id}/delete", handlers.Repo.AdminDeleteCalendarSource)
+		mux.With(RequireAccess(models.AccessManager)).Get("/rooms", handlers.Repo.AdminRooms)
+		mux.With(RequireAccess(models.AccessManager)).Get("/rooms/new", handlers.Repo.AdminNewRoom)
+		mux.With(RequireAccess(models.AccessManager)).Post("/rooms", handlers.Repo.AdminPostNewRoom)
+		mux.With(RequireAccess(models.AccessManager)).Get("/rooms/{id}", handlers.Repo.AdminShowRoom)
+		mux.With(RequireAccess(models.AccessManager)).Post("/rooms/{id}", handlers.Repo.AdminPostRoom)
+		mux.With(RequireAccess(models.AccessOwner)).Post("/rooms/{id}/delete", handlers.Repo.AdminDeleteRoom)
+		mux.With(RequireAccess(models.AccessManager)).Get("/rates", handlers.Repo.AdminRates)
+		mux.With(RequireAccess(models.AccessManager)).Post("/rates/rooms/{id}", handlers.Repo.AdminPostRoomRates)
+		mux.With(RequireAccess(models.AccessManager)).Post("/rates/seasons", handlers.Repo.AdminPostSeasonalRate)
+		mux.With(RequireAccess(models.AccessManager)).Post("/rates/seasons/{id}/delete", handlers.Repo.AdminDeleteSeasonalRate)
+		mux.With(RequireAccess(models.AccessManager)).Get("/stay-rules", handlers.Repo.AdminStayRules)
+		mux.With(RequireAccess(models.AccessManager)).Post("/stay-rules", handlers.Repo.AdminPostStayRule)
+		mux.With(RequireAccess(models.AccessManager)).Post("/stay-rules/{id}/delete", handlers.Repo.AdminDeleteStayRule)
+		mux.With(RequireAccess(models.AccessManager)).Get("/mail-failed", handlers.Repo.AdminFailedMail)
+		mux.With(RequireAccess(models.AccessManager)).Post("/mail/{id}/resend", handlers.Repo.AdminResendMail)
+
+		// tokens can't be used to create more tokens
+		mux.Group(func(mux chi.Router) {
+			mux.Use(RequireSession)
+			mux.Get("/api-tokens", handlers.Repo.AdminAPITokens)
+			mux.Post("/api-tokens", handlers.Repo.AdminPostAPIToken)
+			mux.Post("/api-tokens/{id}/revoke", handlers.Repo.AdminRevokeAPIToken)
+			mux.Get("/two-factor", handlers.Repo.AdminTwoFactor)
+			mux.Post("/two-factor", handlers.Repo.AdminPostTwoFactor)
+			mux.Post("/two-factor/recovery-codes", handlers.Repo.AdminPostRecoveryCodes)
+			mux.Post("/two-factor/disable", handlers.Repo.AdminDisableTwoFactor)
+		})
+
+		// nor to manage users
+		mux.Group(func(mux chi.Router) {
+			mux.Use(RequireSession)
+			mux.Use(RequireAccess(models.AccessOwner))
+			mux.Get("/users", handlers.Repo.AdminUsers)
+			mux.Get("/users/new", handlers.Repo.AdminNewUser)
+			mux.Post("/users", handlers.Repo.AdminPostNewUser)
+			mux.Get("/users/{id}", handlers.Repo.AdminShowUser)
+			mux.Post("/users/{id}", handlers.Repo.AdminPostUser)
+			mux.Post("/users/{id}/invite", handlers.Repo.AdminPostUserInvite)
+			mux.Post("/users/{id}/two-factor/reset", handlers.Repo.AdminResetUserTwoFactor)
+			mux.Post("/users/two-factor-policy", handlers.Repo.AdminPostTwoFactorPolicy)
+			mux.Get("/login-lockouts", handlers.Repo.AdminLoginLockouts)
+			mux.Post("/login-lockouts/unlock", handlers.Repo.AdminUnlockLogin)
+		})
 	})
 
 	// create a file server - a place to get static files from
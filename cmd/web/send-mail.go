@@ -1,59 +1,120 @@
 package main
 
 import (
-	"fmt"
-	"io/ioutil"
-	"log"
-	"strings"
+	"context"
+	"sync"
 	"time"
 
+	"github.com/andkolbe/bookings/internal/mailer"
 	"github.com/andkolbe/bookings/internal/models"
-	mail "github.com/xhit/go-simple-mail/v2"
 )
 
-func listenForMail() {
-	// listen to app.MailChan channel and fire off a message asyncronously
+// how often the mail workers check the outbox when nobody has woken them up
+var mailPollInterval = 5 * time.Second
 
-	go func() {
-		// for loop doesn't end so whenever there is a new message added, it gets fired off immediately
-		for {
-			msg := <-app.MailChan
-			sendMsg(msg)
-		}
-	}()
+// how long a worker has to send a message it claimed before another worker may try it again
+const mailLease = 5 * time.Minute
+
+// how many messages a worker claims from the outbox at a time
+const mailBatchSize = 10
 
+// the part of the repository the mail workers use. The database repo satisfies it
+type mailStore interface {
+	ClaimMail(ctx context.Context, limit int, lease time.Duration) ([]models.OutboxMail, error)
+	MarkMailSent(ctx context.Context, id int) error
+	RetryMail(ctx context.Context, id int, at time.Time, lastError string) error
+	MarkMailFailed(ctx context.Context, id int, lastError string) error
 }
 
-func sendMsg(m models.MailData) {
-	server := mail.NewSMTPClient()
-	server.Host = "localhost"
-	server.Port = 1025
-	server.KeepAlive = false
-	server.ConnectTimeout = 10 * time.Second
+// starts app.MailWorkers goroutines that send the mail in the outbox with m. They check the outbox whenever
+// something is put on app.MailQueued, and every mailPollInterval so retries go out when they are due.
+// The returned func stops the workers, once every message that is due has been tried one last time
+func startMailWorkers(store mailStore, m mailer.Mailer) (stop func()) {
+	quit := make(chan struct{})
+	var wg sync.WaitGroup
 
-	client, err := server.Connect()
-	if err != nil {
-		errorLog.Println(err)
+	for i := 0; i < app.MailWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			ticker := time.NewTicker(mailPollInterval)
+			defer ticker.Stop()
+
+			for {
+				deliverMail(store, m)
+
+				select {
+				case <-quit:
+					// flush whatever was queued while we were waiting
+					deliverMail(store, m)
+					return
+				case <-app.MailQueued:
+				case <-ticker.C:
+				}
+			}
+		}()
 	}
 
-	email := mail.NewMSG()
-	email.SetFrom(m.From).AddTo(m.To).SetSubject(m.Subject)
-	if m.Template == "" {
-	email.SetBody(mail.TextHTML, m.Content)
-	} else {
-		data, err := ioutil.ReadFile(fmt.Sprintf("./email-templates/%s", m.Template))
+	return func() {
+		close(quit)
+		wg.Wait()
+	}
+}
+
+// sends every message in the outbox that is due, until there are none left
+func deliverMail(store mailStore, m mailer.Mailer) {
+	for {
+		batch, err := store.ClaimMail(context.Background(), mailBatchSize, mailLease)
 		if err != nil {
-			app.ErrorLog.Println(err)
+			errorLog.Println(err)
+			return
+		}
+		if len(batch) == 0 {
+			return
+		}
+
+		for _, o := range batch {
+			deliverOne(store, m, o)
 		}
-		// convert the array of bytes into a string
-		mailTemplate := string(data)
-		msgToSend := strings.Replace(mailTemplate, "[%body%]", m.Content, 1)
-		email.SetBody(mail.TextHTML, msgToSend)
 	}
-	err = email.Send(client)
-	if err != nil {
-		log.Println(err)
+}
+
+// tries to send one message, and records how it went. A message that fails is tried again later,
+// unless it has already been tried app.MailMaxAttempts times, in which case it is marked failed
+func deliverOne(store mailStore, m mailer.Mailer, o models.OutboxMail) {
+	ctx := context.Background()
+
+	err := m.Send(o.Mail)
+	if err == nil {
+		err = store.MarkMailSent(ctx, o.ID)
+		if err != nil {
+			errorLog.Println(err)
+		}
+		return
+	}
+
+	if o.Attempts >= app.MailMaxAttempts {
+		errorLog.Printf("giving up on mail %d to %s after %d attempts: %s", o.ID, o.Mail.To, o.Attempts, err)
+		err = store.MarkMailFailed(ctx, o.ID, err.Error())
 	} else {
-		log.Println("Email sent!")
+		errorLog.Printf("mail %d to %s failed, will retry: %s", o.ID, o.Mail.To, err)
+		err = store.RetryMail(ctx, o.ID, time.Now().Add(mailBackoff(o.Attempts)), err.Error())
+	}
+	if err != nil {
+		errorLog.Println(err)
+	}
+}
+
+// returns how long to wait before trying a message again after its nth failed attempt.
+// It doubles every time, starting at 30 seconds, and never goes past an hour
+func mailBackoff(attempt int) time.Duration {
+	wait := 30 * time.Second
+	for i := 1; i < attempt; i++ {
+		wait *= 2
+		if wait >= time.Hour {
+			return time.Hour
+		}
 	}
+	return wait
 }
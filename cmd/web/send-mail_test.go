@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"log"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/andkolbe/bookings/internal/mailer"
+	"github.com/andkolbe/bookings/internal/models"
+)
+
+// an in memory mail outbox that behaves like the mail_outbox table
+type fakeMailStore struct {
+	mu   sync.Mutex
+	mail []models.OutboxMail
+}
+
+func (s *fakeMailStore) add(m models.MailData) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mail = append(s.mail, models.OutboxMail{
+		ID:            len(s.mail) + 1,
+		Mail:          m,
+		Status:        models.MailPending,
+		NextAttemptAt: time.Now(),
+	})
+}
+
+func (s *fakeMailStore) get(id int) models.OutboxMail {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.mail[id-1]
+}
+
+func (s *fakeMailStore) ClaimMail(ctx context.Context, limit int, lease time.Duration) ([]models.OutboxMail, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var claimed []models.OutboxMail
+	now := time.Now()
+	for i := range s.mail {
+		m := &s.mail[i]
+		if len(claimed) == limit {
+			break
+		}
+		if (m.Status == models.MailPending || m.Status == models.MailSending) && !m.NextAttemptAt.After(now) {
+			m.Status = models.MailSending
+			m.Attempts++
+			m.NextAttemptAt = now.Add(lease)
+			claimed = append(claimed, *m)
+		}
+	}
+	return claimed, nil
+}
+
+func (s *fakeMailStore) MarkMailSent(ctx context.Context, id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mail[id-1].Status = models.MailSent
+	return nil
+}
+
+func (s *fakeMailStore) RetryMail(ctx context.Context, id int, at time.Time, lastError string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mail[id-1].Status = models.MailPending
+	s.mail[id-1].NextAttemptAt = at
+	s.mail[id-1].LastError = lastError
+	return nil
+}
+
+func (s *fakeMailStore) MarkMailFailed(ctx context.Context, id int, lastError string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mail[id-1].Status = models.MailFailed
+	s.mail[id-1].LastError = lastError
+	return nil
+}
+
+func TestMailBackoff(t *testing.T) {
+	var tests = []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{5, 8 * time.Minute},
+		{8, time.Hour},
+		{50, time.Hour},
+	}
+
+	for _, e := range tests {
+		got := mailBackoff(e.attempt)
+		if got != e.want {
+			t.Errorf("attempt %d: expected %s, got %s", e.attempt, e.want, got)
+		}
+	}
+}
+
+func TestDeliverMail(t *testing.T) {
+	errorLog = log.New(ioutil.Discard, "", 0)
+	app.MailMaxAttempts = 3
+
+	store := &fakeMailStore{}
+	store.add(models.MailData{To: "ok@here.com"})
+	store.add(models.MailData{To: "down@here.com"})
+
+	m := &mailer.Recorder{
+		OnSend: func(m models.MailData) error {
+			if m.To == "down@here.com" {
+				return errors.New("connection refused")
+			}
+			return nil
+		},
+	}
+
+	deliverMail(store, m)
+
+	if o := store.get(1); o.Status != models.MailSent || o.Attempts != 1 {
+		t.Errorf("expected first message to be sent on the first attempt, got %+v", o)
+	}
+	if sent := m.Sent(); len(sent) != 1 || sent[0].To != "ok@here.com" {
+		t.Errorf("expected only the first message to go out, got %+v", sent)
+	}
+
+	// the failed message is put back with a backoff, so it isn't tried again straight away
+	o := store.get(2)
+	if o.Status != models.MailPending || o.LastError != "connection refused" {
+		t.Errorf("expected second message to be waiting for a retry, got %+v", o)
+	}
+	if wait := time.Until(o.NextAttemptAt); wait < 25*time.Second || wait > 30*time.Second {
+		t.Errorf("expected the retry about 30s from now, got %s", wait)
+	}
+
+	// pretend the backoff has passed each time until it runs out of attempts
+	for i := 0; i < 5; i++ {
+		store.mu.Lock()
+		store.mail[1].NextAttemptAt = time.Now()
+		store.mu.Unlock()
+		deliverMail(store, m)
+	}
+
+	o = store.get(2)
+	if o.Status != models.MailFailed || o.Attempts != app.MailMaxAttempts {
+		t.Errorf("expected the message to fail after %d attempts, got %+v", app.MailMaxAttempts, o)
+	}
+}
+
+func TestMailWorkers_Wake(t *testing.T) {
+	errorLog = log.New(ioutil.Discard, "", 0)
+	app.MailWorkers = 2
+	app.MailMaxAttempts = 3
+	app.MailQueued = make(chan struct{}, 1)
+
+	// make sure the workers only notice the mail because they were woken up
+	defer func(d time.Duration) { mailPollInterval = d }(mailPollInterval)
+	mailPollInterval = time.Hour
+
+	sent := make(chan models.MailData, 1)
+	store := &fakeMailStore{}
+	stop := startMailWorkers(store, &mailer.Recorder{
+		OnSend: func(m models.MailData) error {
+			sent <- m
+			return nil
+		},
+	})
+	defer stop()
+
+	// give the workers a moment to do their first, empty, pass over the outbox
+	time.Sleep(20 * time.Millisecond)
+
+	store.add(models.MailData{To: "guest@here.com"})
+	app.MailQueued <- struct{}{}
+
+	select {
+	case m := <-sent:
+		if m.To != "guest@here.com" {
+			t.Errorf("sent the wrong message: %+v", m)
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("workers didn't wake up when mail was queued")
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/alexedwards/scs/v2"
+	"github.com/alexedwards/scs/v2/memstore"
+	"github.com/andkolbe/bookings/internal/config"
+	"github.com/andkolbe/bookings/internal/sessionstore"
+)
+
+// returns the session manager, keeping sessions in the store picked by the session-store setting,
+// and a func that stops it deleting expired sessions
+func newSessionManager(a *config.AppConfig, db *sql.DB) (*scs.SessionManager, func(), error) {
+	s := scs.New()
+	s.Lifetime = 24 * time.Hour
+	s.Cookie.Persist = true // true = cookie persists even if the browser window closes
+	s.Cookie.SameSite = http.SameSiteLaxMode
+	s.Cookie.Secure = a.InProduction
+
+	switch a.SessionStore {
+	case "memory":
+		store := memstore.NewWithCleanupInterval(a.SessionCleanupInterval)
+		s.Store = store
+		return s, store.StopCleanup, nil
+	case "postgres":
+		store := sessionstore.NewPostgres(db, a.SessionCleanupInterval)
+		s.Store = store
+		return s, store.StopCleanup, nil
+	case "file":
+		store, err := sessionstore.NewFile(a.SessionDir, a.SessionCleanupInterval)
+		if err != nil {
+			return nil, nil, err
+		}
+		s.Store = store
+		return s, store.StopCleanup, nil
+	}
+
+	return nil, nil, fmt.Errorf("unknown session store %q", a.SessionStore)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/andkolbe/bookings/internal/config"
+	_ "github.com/jackc/pgx/v4/stdlib"
+)
+
+// logs in on one session manager, then makes a new one the way a restart would and asks it who is logged in
+func sessionSurvivesRestart(t *testing.T, a *config.AppConfig, db *sql.DB) bool {
+	before, stop, err := newSessionManager(a, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	login := before.LoadAndSave(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		before.Put(r.Context(), "user_id", 7)
+	}))
+	rr := httptest.NewRecorder()
+	login.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+	stop()
+
+	cookies := rr.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("expected a session cookie, got %v", cookies)
+	}
+
+	after, stop, err := newSessionManager(a, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+
+	userID := 0
+	whoami := after.LoadAndSave(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID = after.GetInt(r.Context(), "user_id")
+	}))
+	req := httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(cookies[0])
+	whoami.ServeHTTP(httptest.NewRecorder(), req)
+
+	return userID == 7
+}
+
+func TestSessionStore_Memory(t *testing.T) {
+	a := &config.AppConfig{SessionStore: "memory", SessionCleanupInterval: time.Minute}
+	if sessionSurvivesRestart(t, a, nil) {
+		t.Error("expected memory sessions to be lost on a restart")
+	}
+}
+
+func TestSessionStore_File(t *testing.T) {
+	a := &config.AppConfig{SessionStore: "file", SessionDir: filepath.Join(t.TempDir(), "sessions"), SessionCleanupInterval: time.Minute}
+	if !sessionSurvivesRestart(t, a, nil) {
+		t.Error("expected file sessions to survive a restart")
+	}
+}
+
+// needs a migrated database, so it only runs when BOOKINGS_TEST_DSN says where one is
+func TestSessionStore_Postgres(t *testing.T) {
+	dsn := os.Getenv("BOOKINGS_TEST_DSN")
+	if dsn == "" {
+		t.Skip("BOOKINGS_TEST_DSN isn't set")
+	}
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	a := &config.AppConfig{SessionStore: "postgres", SessionCleanupInterval: time.Minute}
+	if !sessionSurvivesRestart(t, a, db) {
+		t.Error("expected postgres sessions to survive a restart")
+	}
+}
+
+func TestSessionStore_Unknown(t *testing.T) {
+	_, _, err := newSessionManager(&config.AppConfig{SessionStore: "redis"}, nil)
+	if err == nil {
+		t.Error("expected an unknown store to be an error")
+	}
+}
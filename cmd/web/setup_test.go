@@ -3,14 +3,29 @@
 package main
 
 import (
+	"io/ioutil"
+	"log"
 	"net/http"
 	"os"
 	"testing"
+
+	"github.com/alexedwards/scs/v2"
+	"github.com/andkolbe/bookings/internal/handlers"
+	"github.com/andkolbe/bookings/internal/helpers"
+	"github.com/andkolbe/bookings/internal/render"
 )
 
 
 func TestMain(m *testing.M) { // there must be a func called TestMain in the setup_test.go file
+	app.InfoLog = log.New(ioutil.Discard, "", 0)
+	app.ErrorLog = log.New(ioutil.Discard, "", 0)
+
+	session = scs.New()
+	app.Session = session
 
+	helpers.NewHelpers(&app)
+	render.NewRenderer(&app)
+	handlers.NewHandlers(handlers.NewTestRepo(&app))
 
 	os.Exit(m.Run()) // exit all of the tests when they are finished
 }
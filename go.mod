@@ -3,14 +3,15 @@ module github.com/andkolbe/bookings
 go 1.16
 
 require (
+	github.com/BurntSushi/toml v1.3.2
 	github.com/alexedwards/scs/v2 v2.4.0
 	github.com/asaskevich/govalidator v0.0.0-20210307081110-f21760c49a8d
-	github.com/go-chi/chi v1.5.4
 	github.com/go-chi/chi/v5 v5.0.3
-	github.com/jackc/pgconn v1.8.1 // indirect
-	github.com/jackc/pgx/v4 v4.11.0 // indirect
+	github.com/jackc/pgconn v1.8.1
+	github.com/jackc/pgx/v4 v4.11.0
 	github.com/justinas/nosurf v1.1.1
-	github.com/xhit/go-simple-mail/v2 v2.9.1 // indirect
-	golang.org/x/crypto v0.0.0-20210322153248-0c34fe9e7dc2 // indirect
-
+	github.com/skip2/go-qrcode v0.0.0-20200617195104-da1b6568686e
+	github.com/xhit/go-simple-mail/v2 v2.9.1
+	golang.org/x/crypto v0.0.0-20210322153248-0c34fe9e7dc2
+	gopkg.in/yaml.v3 v3.0.1
 )
@@ -1,11 +1,13 @@
 package config
 
 import (
+	"fmt"
 	"html/template"
 	"log"
+	"strings"
+	"time"
 
 	"github.com/alexedwards/scs/v2"
-	"github.com/andkolbe/bookings/internal/models"
 )
 
 // holds the application config
@@ -17,5 +19,62 @@ type AppConfig struct {
 	ErrorLog      *log.Logger
 	InProduction  bool
 	Session       *scs.SessionManager
-	MailChan      chan models.MailData
+	MailQueued    chan struct{} // nudges the mail workers when something is added to the outbox
+	SyncCalendars chan struct{} // asks for the external calendars to be synced now
+
+	// settings filled in by Load at startup
+	Port            string
+	ShutdownTimeout time.Duration
+	DBHost          string
+	DBPort          string
+	DBName          string
+	DBUser          string
+	DBPassword      string
+	DBSSL           string
+	DBTimeout       time.Duration
+	MailHost        string
+	MailPort        int
+	MailUsername    string
+	MailPassword    string
+	MailEncryption  string
+	MailTimeout     time.Duration
+	MailKeepAlive   bool
+	MailWorkers     int
+	MailMaxAttempts int
+
+	CalendarSyncInterval time.Duration
+	SiteURL              string        // without a trailing slash
+	LinkSecret           string        // signs guest links. Blank means a random one is made at startup
+	GuestLinkTTL         time.Duration // how long after the stay ends a guest link still works
+	ResetLinkTTL         time.Duration // how long a link to reset a password works
+	InviteLinkTTL        time.Duration // how long the link to choose a password in an invite works
+
+	LoginMaxFailures   int           // failed logins in a row before an account is locked
+	LoginMaxIPFailures int           // failed logins in a row from one ip address before it is locked out
+	LoginLockout       time.Duration // how long a lock lasts
+	LoginFailureWindow time.Duration // failures further apart than this start the count again
+	LoginIPLockout     bool          // whether ip addresses are locked out as well as accounts
+	TrustedProxyHeader string        // the header a reverse proxy puts the client's address in. Blank means RemoteAddr
+
+	SessionStore           string // where sessions are kept: memory, postgres or file
+	SessionDir             string // the directory the file store keeps sessions in
+	SessionCleanupInterval time.Duration
 }
+
+// returns the connection string for the postgres database
+func (a *AppConfig) DSN() string {
+	dsn := fmt.Sprintf("host=%s port=%s dbname=%s user=%s sslmode=%s",
+		dsnValue(a.DBHost), dsnValue(a.DBPort), dsnValue(a.DBName), dsnValue(a.DBUser), dsnValue(a.DBSSL))
+	if a.DBPassword != "" {
+		dsn = fmt.Sprintf("%s password=%s", dsn, dsnValue(a.DBPassword))
+	}
+	return dsn
+}
+
+// quotes a value for a keyword=value connection string, so spaces, quotes and backslashes in it
+// can't end it early or add settings of their own
+func dsnValue(v string) string {
+	return "'" + dsnEscaper.Replace(v) + "'"
+}
+
+var dsnEscaper = strings.NewReplacer(`\`, `\\`, `'`, `\'`)
@@ -0,0 +1,400 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// prefix for every environment variable we read, e.g. BOOKINGS_DB_HOST
+const envPrefix = "BOOKINGS_"
+
+// a single configurable value. The name is used as the flag name and as the key in the config file,
+// and the env var name is derived from it (db-host becomes BOOKINGS_DB_HOST)
+type setting struct {
+	name   string
+	value  string // default value
+	usage  string
+	isBool bool
+	apply  func(a *AppConfig, v string) error
+}
+
+// every setting the app understands, with its default value
+var settings = []setting{
+	{name: "port", value: "8080", usage: "port to listen on", apply: func(a *AppConfig, v string) error {
+		p, err := parsePort(strings.TrimPrefix(v, ":"))
+		if err != nil {
+			return err
+		}
+		a.Port = fmt.Sprintf(":%d", p)
+		return nil
+	}},
+	{name: "shutdown-timeout", value: "30s", usage: "how long to wait for active requests when shutting down", apply: func(a *AppConfig, v string) error {
+		d, err := time.ParseDuration(v)
+		if err == nil && d <= 0 {
+			err = fmt.Errorf("%q must be greater than zero", v)
+		}
+		a.ShutdownTimeout = d
+		return err
+	}},
+	{name: "production", value: "false", usage: "run in production mode", isBool: true, apply: func(a *AppConfig, v string) error {
+		b, err := strconv.ParseBool(v)
+		a.InProduction = b
+		return err
+	}},
+	{name: "cache", value: "false", usage: "use the template cache", isBool: true, apply: func(a *AppConfig, v string) error {
+		b, err := strconv.ParseBool(v)
+		a.UseCache = b
+		return err
+	}},
+	{name: "db-host", value: "localhost", usage: "database host", apply: func(a *AppConfig, v string) error {
+		a.DBHost = v
+		return required(v)
+	}},
+	{name: "db-port", value: "5432", usage: "database port", apply: func(a *AppConfig, v string) error {
+		_, err := parsePort(v)
+		a.DBPort = v
+		return err
+	}},
+	{name: "db-name", value: "bookings", usage: "database name", apply: func(a *AppConfig, v string) error {
+		a.DBName = v
+		return required(v)
+	}},
+	{name: "db-user", value: "postgres", usage: "database user", apply: func(a *AppConfig, v string) error {
+		a.DBUser = v
+		return required(v)
+	}},
+	{name: "db-password", value: "", usage: "database password", apply: func(a *AppConfig, v string) error {
+		a.DBPassword = v
+		return nil
+	}},
+	{name: "db-ssl", value: "disable", usage: "database ssl mode (disable, allow, prefer, require, verify-ca, verify-full)", apply: func(a *AppConfig, v string) error {
+		a.DBSSL = v
+		return oneOf(v, "disable", "allow", "prefer", "require", "verify-ca", "verify-full")
+	}},
+	{name: "db-timeout", value: "3s", usage: "default timeout for a single database query", apply: func(a *AppConfig, v string) error {
+		d, err := time.ParseDuration(v)
+		if err == nil && d <= 0 {
+			err = fmt.Errorf("%q must be greater than zero", v)
+		}
+		a.DBTimeout = d
+		return err
+	}},
+	{name: "mail-host", value: "localhost", usage: "smtp host", apply: func(a *AppConfig, v string) error {
+		a.MailHost = v
+		return required(v)
+	}},
+	{name: "mail-port", value: "1025", usage: "smtp port", apply: func(a *AppConfig, v string) error {
+		p, err := parsePort(v)
+		a.MailPort = p
+		return err
+	}},
+	{name: "mail-username", value: "", usage: "smtp username, leave blank if the server doesn't need a login", apply: func(a *AppConfig, v string) error {
+		a.MailUsername = v
+		return nil
+	}},
+	{name: "mail-password", value: "", usage: "smtp password", apply: func(a *AppConfig, v string) error {
+		a.MailPassword = v
+		return nil
+	}},
+	{name: "mail-encryption", value: "none", usage: "how to encrypt the smtp connection (none, starttls, tls)", apply: func(a *AppConfig, v string) error {
+		a.MailEncryption = v
+		return oneOf(v, "none", "starttls", "tls")
+	}},
+	{name: "mail-timeout", value: "10s", usage: "how long to wait when connecting to or sending through the smtp server", apply: func(a *AppConfig, v string) error {
+		d, err := time.ParseDuration(v)
+		if err == nil && d <= 0 {
+			err = fmt.Errorf("%q must be greater than zero", v)
+		}
+		a.MailTimeout = d
+		return err
+	}},
+	{name: "mail-keep-alive", value: "false", usage: "keep the smtp connection open between messages", isBool: true, apply: func(a *AppConfig, v string) error {
+		b, err := strconv.ParseBool(v)
+		a.MailKeepAlive = b
+		return err
+	}},
+	{name: "mail-workers", value: "2", usage: "number of goroutines sending mail from the outbox", apply: func(a *AppConfig, v string) error {
+		n, err := parsePositive(v)
+		a.MailWorkers = n
+		return err
+	}},
+	{name: "mail-max-attempts", value: "5", usage: "how many times to try sending a message before giving up on it", apply: func(a *AppConfig, v string) error {
+		n, err := parsePositive(v)
+		a.MailMaxAttempts = n
+		return err
+	}},
+	{name: "calendar-sync-interval", value: "15m", usage: "how often to sync blocks from external calendars", apply: func(a *AppConfig, v string) error {
+		d, err := time.ParseDuration(v)
+		if err == nil && d < time.Minute {
+			err = fmt.Errorf("%q must be at least a minute", v)
+		}
+		a.CalendarSyncInterval = d
+		return err
+	}},
+	{name: "site-url", value: "http://localhost:8080", usage: "the address guests use to reach the site, for links in emails", apply: func(a *AppConfig, v string) error {
+		v = strings.TrimSuffix(v, "/")
+		a.SiteURL = v
+		u, err := url.Parse(v)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("%q must be an http or https url", v)
+		}
+		return nil
+	}},
+	{name: "link-secret", value: "", usage: "key that signs the links emailed to guests, at least 32 characters", apply: func(a *AppConfig, v string) error {
+		a.LinkSecret = v
+		if v != "" && len(v) < 32 {
+			return errors.New("must be at least 32 characters")
+		}
+		return nil
+	}},
+	{name: "guest-link-ttl", value: "168h", usage: "how long after a stay the guest's link to their reservation keeps working", apply: func(a *AppConfig, v string) error {
+		d, err := time.ParseDuration(v)
+		if err == nil && d < 0 {
+			err = fmt.Errorf("%q can't be negative", v)
+		}
+		a.GuestLinkTTL = d
+		return err
+	}},
+	{name: "reset-link-ttl", value: "1h", usage: "how long a link to reset a password keeps working", apply: func(a *AppConfig, v string) error {
+		d, err := time.ParseDuration(v)
+		if err == nil && d <= 0 {
+			err = fmt.Errorf("%q must be greater than zero", v)
+		}
+		a.ResetLinkTTL = d
+		return err
+	}},
+	{name: "invite-link-ttl", value: "72h", usage: "how long the link in an invite to a new admin user keeps working", apply: func(a *AppConfig, v string) error {
+		d, err := time.ParseDuration(v)
+		if err == nil && d <= 0 {
+			err = fmt.Errorf("%q must be greater than zero", v)
+		}
+		a.InviteLinkTTL = d
+		return err
+	}},
+	{name: "login-max-failures", value: "5", usage: "failed logins in a row before an account is locked", apply: func(a *AppConfig, v string) error {
+		n, err := parsePositive(v)
+		a.LoginMaxFailures = n
+		return err
+	}},
+	{name: "login-max-ip-failures", value: "20", usage: "failed logins in a row from one ip address before it is locked out", apply: func(a *AppConfig, v string) error {
+		n, err := parsePositive(v)
+		a.LoginMaxIPFailures = n
+		return err
+	}},
+	{name: "login-lockout", value: "15m", usage: "how long an account or ip address is locked out for", apply: func(a *AppConfig, v string) error {
+		d, err := time.ParseDuration(v)
+		if err == nil && d <= 0 {
+			err = fmt.Errorf("%q must be greater than zero", v)
+		}
+		a.LoginLockout = d
+		return err
+	}},
+	{name: "login-failure-window", value: "1h", usage: "failed logins further apart than this don't count as in a row", apply: func(a *AppConfig, v string) error {
+		d, err := time.ParseDuration(v)
+		if err == nil && d <= 0 {
+			err = fmt.Errorf("%q must be greater than zero", v)
+		}
+		a.LoginFailureWindow = d
+		return err
+	}},
+	{name: "login-ip-lockout", value: "true", usage: "lock out ip addresses with too many failed logins, as well as accounts", isBool: true, apply: func(a *AppConfig, v string) error {
+		b, err := strconv.ParseBool(v)
+		a.LoginIPLockout = b
+		return err
+	}},
+	{name: "trusted-proxy-header", value: "", usage: "header the reverse proxy in front of the site puts the client's ip address in, e.g. X-Forwarded-For. Leave blank if clients connect directly", apply: func(a *AppConfig, v string) error {
+		a.TrustedProxyHeader = v
+		return nil
+	}},
+	{name: "session-store", value: "postgres", usage: "where to keep sessions (memory, postgres, file). Memory sessions are lost on a restart", apply: func(a *AppConfig, v string) error {
+		a.SessionStore = v
+		return oneOf(v, "memory", "postgres", "file")
+	}},
+	{name: "session-dir", value: "sessions", usage: "directory the file session store keeps sessions in", apply: func(a *AppConfig, v string) error {
+		a.SessionDir = v
+		return required(v)
+	}},
+	{name: "session-cleanup-interval", value: "5m", usage: "how often to delete expired sessions", apply: func(a *AppConfig, v string) error {
+		d, err := time.ParseDuration(v)
+		if err == nil && d <= 0 {
+			err = fmt.Errorf("%q must be greater than zero", v)
+		}
+		a.SessionCleanupInterval = d
+		return err
+	}},
+}
+
+// fills in the settings of the app config. Values are applied in order of precedence, lowest first:
+// defaults, the config file (from -config or BOOKINGS_CONFIG), environment variables, command line flags
+func Load(a *AppConfig, args []string) error {
+	return load(a, args, os.LookupEnv)
+}
+
+func load(a *AppConfig, args []string, lookupEnv func(string) (string, bool)) error {
+	values := make(map[string]string)
+	for _, s := range settings {
+		values[s.name] = s.value
+	}
+
+	fs := flag.NewFlagSet("bookings", flag.ContinueOnError)
+	configFile, _ := lookupEnv(envPrefix + "CONFIG")
+	fs.StringVar(&configFile, "config", configFile, "path to a YAML or TOML config file")
+	for _, s := range settings {
+		fs.Var(&flagValue{isBool: s.isBool}, s.name, s.usage)
+	}
+
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if configFile != "" {
+		fileValues, err := readConfigFile(configFile)
+		if err != nil {
+			return err
+		}
+		for k, v := range fileValues {
+			if _, ok := values[k]; !ok {
+				return fmt.Errorf("config file %s: unknown setting %q", configFile, k)
+			}
+			values[k] = v
+		}
+	}
+
+	for _, s := range settings {
+		if v, ok := lookupEnv(envName(s.name)); ok {
+			values[s.name] = v
+		}
+	}
+
+	// only the flags that were actually passed override the other sources
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name != "config" {
+			values[f.Name] = f.Value.String()
+		}
+	})
+
+	var problems []string
+	for _, s := range settings {
+		err := s.apply(a, values[s.name])
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %s", s.name, err))
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid configuration:\n\t%s", strings.Join(problems, "\n\t"))
+	}
+
+	return nil
+}
+
+// reads a YAML or TOML file, based on its extension, and flattens it into setting names.
+// Nested keys are joined with a dash, so db: {host: x} sets db-host
+func readConfigFile(path string) (map[string]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	raw := make(map[string]interface{})
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yml", ".yaml":
+		err = yaml.Unmarshal(data, &raw)
+	case ".toml":
+		err = toml.Unmarshal(data, &raw)
+	default:
+		return nil, fmt.Errorf("config file %s: unsupported format, use .yaml or .toml", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("config file %s: %w", path, err)
+	}
+
+	values := make(map[string]string)
+	flatten("", raw, values)
+	return values, nil
+}
+
+func flatten(prefix string, in map[string]interface{}, out map[string]string) {
+	for k, v := range in {
+		key := strings.ReplaceAll(strings.ToLower(k), "_", "-")
+		if prefix != "" {
+			key = prefix + "-" + key
+		}
+
+		if nested, ok := v.(map[string]interface{}); ok {
+			flatten(key, nested, out)
+			continue
+		}
+		out[key] = fmt.Sprint(v)
+	}
+}
+
+// returns the env var name for a setting
+func envName(name string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+func parsePort(v string) (int, error) {
+	p, err := strconv.Atoi(v)
+	if err != nil || p < 1 || p > 65535 {
+		return 0, fmt.Errorf("%q is not a valid port", v)
+	}
+	return p, nil
+}
+
+func parsePositive(v string) (int, error) {
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("%q must be a whole number greater than zero", v)
+	}
+	return n, nil
+}
+
+func required(v string) error {
+	if strings.TrimSpace(v) == "" {
+		return errors.New("cannot be blank")
+	}
+	return nil
+}
+
+func oneOf(v string, allowed ...string) error {
+	for _, x := range allowed {
+		if v == x {
+			return nil
+		}
+	}
+	return fmt.Errorf("%q must be one of %s", v, strings.Join(allowed, ", "))
+}
+
+// flag.Value that remembers the raw string so it can be merged with the other sources
+type flagValue struct {
+	value  string
+	isBool bool
+}
+
+func (f *flagValue) String() string {
+	return f.value
+}
+
+func (f *flagValue) Set(v string) error {
+	f.value = v
+	return nil
+}
+
+// lets boolean settings be passed as just -production
+func (f *flagValue) IsBoolFlag() bool {
+	return f.isBool
+}
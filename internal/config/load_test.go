@@ -0,0 +1,219 @@
+package config
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgconn"
+)
+
+// builds a lookupEnv func from a map so tests don't touch the real environment
+func fakeEnv(env map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		v, ok := env[key]
+		return v, ok
+	}
+}
+
+func writeFile(t *testing.T, name, contents string) string {
+	path := filepath.Join(t.TempDir(), name)
+	err := ioutil.WriteFile(path, []byte(contents), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoad_Defaults(t *testing.T) {
+	var a AppConfig
+	err := load(&a, nil, fakeEnv(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if a.Port != ":8080" {
+		t.Errorf("expected port :8080, got %s", a.Port)
+	}
+	if a.ShutdownTimeout != 30*time.Second {
+		t.Errorf("expected 30s shutdown timeout, got %s", a.ShutdownTimeout)
+	}
+	if a.DBTimeout != 3*time.Second {
+		t.Errorf("expected 3s query timeout, got %s", a.DBTimeout)
+	}
+	if a.InProduction || a.UseCache {
+		t.Error("expected production and cache to default to false")
+	}
+	if a.MailHost != "localhost" || a.MailPort != 1025 {
+		t.Errorf("unexpected mail defaults %s:%d", a.MailHost, a.MailPort)
+	}
+	if a.MailEncryption != "none" || a.MailTimeout != 10*time.Second || a.MailKeepAlive {
+		t.Errorf("unexpected smtp defaults %s %s %t", a.MailEncryption, a.MailTimeout, a.MailKeepAlive)
+	}
+	if a.CalendarSyncInterval != 15*time.Minute {
+		t.Errorf("expected calendars to sync every 15m, got %s", a.CalendarSyncInterval)
+	}
+	if a.SiteURL != "http://localhost:8080" || a.LinkSecret != "" || a.GuestLinkTTL != 7*24*time.Hour {
+		t.Errorf("unexpected guest link defaults %s %q %s", a.SiteURL, a.LinkSecret, a.GuestLinkTTL)
+	}
+	if a.ResetLinkTTL != time.Hour {
+		t.Errorf("expected reset links to last an hour, got %s", a.ResetLinkTTL)
+	}
+	if a.InviteLinkTTL != 72*time.Hour {
+		t.Errorf("expected invite links to last three days, got %s", a.InviteLinkTTL)
+	}
+	if a.LoginMaxFailures != 5 || a.LoginMaxIPFailures != 20 || a.LoginLockout != 15*time.Minute || a.LoginFailureWindow != time.Hour {
+		t.Errorf("unexpected login throttling defaults %d, %d, %s, %s", a.LoginMaxFailures, a.LoginMaxIPFailures, a.LoginLockout, a.LoginFailureWindow)
+	}
+	if !a.LoginIPLockout || a.TrustedProxyHeader != "" {
+		t.Errorf("unexpected client ip defaults %t %q", a.LoginIPLockout, a.TrustedProxyHeader)
+	}
+	if a.SessionStore != "postgres" || a.SessionDir != "sessions" || a.SessionCleanupInterval != 5*time.Minute {
+		t.Errorf("unexpected session defaults %s %s %s", a.SessionStore, a.SessionDir, a.SessionCleanupInterval)
+	}
+	if a.DSN() != "host='localhost' port='5432' dbname='bookings' user='postgres' sslmode='disable'" {
+		t.Errorf("unexpected default dsn %q", a.DSN())
+	}
+}
+
+func TestLoad_Precedence(t *testing.T) {
+	path := writeFile(t, "bookings.yaml", `
+port: 9000
+db:
+  host: file-host
+  name: file-db
+  password: secret
+mail:
+  port: 2525
+`)
+
+	env := map[string]string{
+		"BOOKINGS_CONFIG":  path,
+		"BOOKINGS_DB_HOST": "env-host",
+		"BOOKINGS_PORT":    "9100",
+	}
+
+	var a AppConfig
+	err := load(&a, []string{"-port", "9200", "-production"}, fakeEnv(env))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// flag beats env beats file
+	if a.Port != ":9200" {
+		t.Errorf("expected flag port :9200, got %s", a.Port)
+	}
+	// env beats file
+	if a.DBHost != "env-host" {
+		t.Errorf("expected env db host, got %s", a.DBHost)
+	}
+	// file beats default
+	if a.DBName != "file-db" || a.DBPassword != "secret" || a.MailPort != 2525 {
+		t.Errorf("file values not applied: %+v", a)
+	}
+	if !a.InProduction {
+		t.Error("expected -production to set InProduction")
+	}
+	if !strings.Contains(a.DSN(), "password='secret'") {
+		t.Errorf("expected password in dsn, got %q", a.DSN())
+	}
+}
+
+func TestLoad_TOML(t *testing.T) {
+	path := writeFile(t, "bookings.toml", `
+cache = true
+
+[db]
+host = "toml-host"
+ssl = "require"
+`)
+
+	var a AppConfig
+	err := load(&a, []string{"-config", path}, fakeEnv(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !a.UseCache || a.DBHost != "toml-host" || a.DBSSL != "require" {
+		t.Errorf("toml values not applied: %+v", a)
+	}
+}
+
+func TestLoad_Invalid(t *testing.T) {
+	var tests = []struct {
+		name string
+		args []string
+		env  map[string]string
+		want string
+	}{
+		{"bad port", []string{"-port", "99999"}, nil, "port"},
+		{"blank db name", nil, map[string]string{"BOOKINGS_DB_NAME": " "}, "db-name"},
+		{"bad ssl mode", []string{"-db-ssl", "maybe"}, nil, "db-ssl"},
+		{"bad timeout", []string{"-shutdown-timeout", "-1s"}, nil, "shutdown-timeout"},
+		{"bad mail encryption", []string{"-mail-encryption", "ssl"}, nil, "mail-encryption"},
+		{"no mail workers", []string{"-mail-workers", "0"}, nil, "mail-workers"},
+		{"calendar sync too often", []string{"-calendar-sync-interval", "5s"}, nil, "calendar-sync-interval"},
+		{"bad site url", []string{"-site-url", "bookings.example.com"}, nil, "site-url"},
+		{"short link secret", nil, map[string]string{"BOOKINGS_LINK_SECRET": "secret"}, "link-secret"},
+		{"negative guest link ttl", []string{"-guest-link-ttl", "-1h"}, nil, "guest-link-ttl"},
+		{"no reset link ttl", []string{"-reset-link-ttl", "0s"}, nil, "reset-link-ttl"},
+		{"no invite link ttl", []string{"-invite-link-ttl", "-1h"}, nil, "invite-link-ttl"},
+		{"no login failures", []string{"-login-max-failures", "0"}, nil, "login-max-failures"},
+		{"no lockout", []string{"-login-lockout", "0s"}, nil, "login-lockout"},
+		{"bad session store", []string{"-session-store", "redis"}, nil, "session-store"},
+		{"no session cleanup", []string{"-session-cleanup-interval", "0s"}, nil, "session-cleanup-interval"},
+		{"bad bool", nil, map[string]string{"BOOKINGS_PRODUCTION": "sometimes"}, "production"},
+		{"unknown flag", []string{"-nope"}, nil, "nope"},
+		{"missing file", []string{"-config", "does-not-exist.yaml"}, nil, "does-not-exist"},
+	}
+
+	for _, e := range tests {
+		var a AppConfig
+		err := load(&a, e.args, fakeEnv(e.env))
+		if err == nil {
+			t.Errorf("%s: expected an error", e.name)
+			continue
+		}
+		if !strings.Contains(err.Error(), e.want) {
+			t.Errorf("%s: expected error mentioning %q, got %q", e.name, e.want, err)
+		}
+	}
+
+	path := writeFile(t, "bookings.yaml", "colour: blue\n")
+	var a AppConfig
+	err := load(&a, []string{"-config", path}, fakeEnv(nil))
+	if err == nil || !strings.Contains(err.Error(), "colour") {
+		t.Errorf("expected unknown setting error, got %v", err)
+	}
+}
+
+func TestAppConfig_DSN(t *testing.T) {
+	var tests = []struct {
+		name     string
+		password string
+	}{
+		{"plain", "secret"},
+		{"space", "two words"},
+		{"extra setting", "x sslmode=disable"},
+		{"quote", "it's"},
+		{"backslash", `back\slash`},
+		{"trailing backslash", `end\`},
+	}
+
+	for _, e := range tests {
+		a := AppConfig{DBHost: "localhost", DBPort: "5432", DBName: "bookings", DBUser: "postgres", DBPassword: e.password, DBSSL: "require"}
+		cfg, err := pgconn.ParseConfig(a.DSN())
+		if err != nil {
+			t.Errorf("%s: could not parse %q: %s", e.name, a.DSN(), err)
+			continue
+		}
+		if cfg.Password != e.password {
+			t.Errorf("%s: expected password %q, got %q", e.name, e.password, cfg.Password)
+		}
+		if cfg.Host != "localhost" || cfg.Database != "bookings" || cfg.User != "postgres" || cfg.TLSConfig == nil {
+			t.Errorf("%s: other settings changed: %+v", e.name, cfg)
+		}
+	}
+}
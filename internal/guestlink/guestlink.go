@@ -0,0 +1,28 @@
+package guestlink
+
+import (
+	"time"
+
+	"github.com/andkolbe/bookings/internal/signedlink"
+)
+
+var (
+	// the token wasn't made with our secret, or has been changed
+	ErrInvalid = signedlink.ErrInvalid
+	// the token was ours, but it has expired
+	ErrExpired = signedlink.ErrExpired
+)
+
+const purpose = "guest"
+
+// returns a token that lets a guest manage their reservation until expires, without an account.
+// The token says which reservation it is for, so it only needs checking, not looking up. The stamp is whatever
+// has to stay the same for the token to keep working, like the reservation's dates
+func Sign(secret []byte, reservationID int, stamp string, expires time.Time) string {
+	return signedlink.Sign(secret, purpose, reservationID, stamp, "", expires)
+}
+
+// checks a token made by Sign and returns the reservation id and stamp in it
+func Verify(secret []byte, token string, now time.Time) (int, string, error) {
+	return signedlink.Verify(secret, purpose, token, "", now)
+}
@@ -0,0 +1,47 @@
+package guestlink
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andkolbe/bookings/internal/resetlink"
+)
+
+var secret = []byte("0123456789abcdef0123456789abcdef")
+
+func TestVerify(t *testing.T) {
+	now := time.Date(2050, 1, 1, 12, 0, 0, 0, time.UTC)
+	token := Sign(secret, 42, "20500101-20500103", now.Add(time.Hour))
+
+	id, stamp, err := Verify(secret, token, now)
+	if err != nil || id != 42 || stamp != "20500101-20500103" {
+		t.Fatalf("expected reservation 42 with its stamp, got %d %q, %v", id, stamp, err)
+	}
+
+	_, _, err = Verify(secret, token, now.Add(2*time.Hour))
+	if err != ErrExpired {
+		t.Errorf("expected an expired token, got %v", err)
+	}
+
+	// a token for another reservation can't be made by editing this one
+	other := Sign([]byte("a different secret of enough length"), 43, "20500101-20500103", now.Add(time.Hour))
+	payload := strings.Split(other, ".")[0]
+	signature := strings.Split(token, ".")[1]
+
+	var tests = []string{
+		"",
+		"nonsense",
+		payload + "." + signature,
+		other,
+		token + "x",
+		"a.b.c",
+		resetlink.Sign(secret, 42, "", now.Add(time.Hour)),
+	}
+	for _, e := range tests {
+		_, _, err := Verify(secret, e, now)
+		if err != ErrInvalid {
+			t.Errorf("%q: expected an invalid token, got %v", e, err)
+		}
+	}
+}
@@ -0,0 +1,446 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/andkolbe/bookings/internal/forms"
+	"github.com/andkolbe/bookings/internal/guestlink"
+	"github.com/andkolbe/bookings/internal/helpers"
+	"github.com/andkolbe/bookings/internal/mailer"
+	"github.com/andkolbe/bookings/internal/models"
+	"github.com/andkolbe/bookings/internal/repository"
+	"github.com/go-chi/chi/v5"
+)
+
+// the handlers in this file make up the /api/v1 JSON api. They use the same repository as the web pages,
+// but always answer with JSON and a meaningful status code
+
+type apiRoom struct {
+	ID       int    `json:"id"`
+	Name     string `json:"name"`
+	Slug     string `json:"slug"`
+	Capacity int    `json:"capacity"`
+}
+
+type apiReservation struct {
+	ID        int    `json:"id"`
+	RoomID    int    `json:"room_id"`
+	RoomName  string `json:"room_name,omitempty"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Email     string `json:"email"`
+	Phone     string `json:"phone"`
+	StartDate string `json:"start_date"`
+	EndDate   string `json:"end_date"`
+	Adults    int    `json:"adults"` // 0 or left out means 1
+	Children  int    `json:"children"`
+	Status    string `json:"status,omitempty"` // only in responses, new reservations are always pending
+	// only in responses, in cents. It is worked out from the room's rates when the reservation is made
+	TotalPrice int `json:"total_price,omitempty"`
+	// only in the response to a new reservation. Send it back in the Guest-Token header to look at or cancel it
+	GuestToken string `json:"guest_token,omitempty"`
+}
+
+type apiAvailability struct {
+	StartDate string    `json:"start_date"`
+	EndDate   string    `json:"end_date"`
+	Adults    int       `json:"adults"`
+	Children  int       `json:"children"`
+	Rooms     []apiRoom `json:"rooms"`
+	// free rooms whose stay rules don't allow the stay, and why
+	Unavailable []apiUnavailableRoom `json:"unavailable,omitempty"`
+}
+
+type apiUnavailableRoom struct {
+	Name    string   `json:"name"`
+	Reasons []string `json:"reasons"`
+}
+
+type apiError struct {
+	Error apiErrorBody `json:"error"`
+}
+
+type apiErrorBody struct {
+	Message string              `json:"message"`
+	Fields  map[string][]string `json:"fields,omitempty"`
+}
+
+const apiDateLayout = "2006-01-02"
+
+// lists every room
+func (m *Repository) APIRooms(w http.ResponseWriter, r *http.Request) {
+	rooms, err := m.DB.AllRooms(r.Context())
+	if err != nil {
+		apiServerError(w, err)
+		return
+	}
+
+	out := []apiRoom{}
+	for _, x := range activeRooms(rooms) {
+		out = append(out, apiRoom{ID: x.ID, Name: x.RoomName, Slug: x.Slug, Capacity: x.Capacity})
+	}
+
+	writeJSON(w, http.StatusOK, out)
+}
+
+// lists the rooms that are free for the whole stay given by the start and end query parameters, and sleep the
+// adults and children query parameters
+func (m *Repository) APIAvailability(w http.ResponseWriter, r *http.Request) {
+	form := forms.New(r.URL.Query())
+	startDate, endDate := validateStay(form, "start", "end")
+	adults, children := validateGuests(form, "adults", "children")
+	if !form.Valid() {
+		apiValidationError(w, form)
+		return
+	}
+
+	rooms, err := m.DB.SearchAvailabilityForAllRooms(r.Context(), startDate, endDate, adults+children)
+	if err != nil {
+		apiServerError(w, err)
+		return
+	}
+
+	rooms, rejected, err := m.allowedRooms(r.Context(), rooms, startDate, endDate)
+	if err != nil {
+		apiServerError(w, err)
+		return
+	}
+
+	out := apiAvailability{
+		StartDate: startDate.Format(apiDateLayout),
+		EndDate:   endDate.Format(apiDateLayout),
+		Adults:    adults,
+		Children:  children,
+		Rooms:     []apiRoom{},
+	}
+	for _, x := range rooms {
+		out.Rooms = append(out.Rooms, apiRoom{ID: x.ID, Name: x.RoomName, Slug: x.Slug, Capacity: x.Capacity})
+	}
+	for name, reasons := range rejected {
+		out.Unavailable = append(out.Unavailable, apiUnavailableRoom{Name: name, Reasons: reasons})
+	}
+	sort.Slice(out.Unavailable, func(i, j int) bool { return out.Unavailable[i].Name < out.Unavailable[j].Name })
+
+	writeJSON(w, http.StatusOK, out)
+}
+
+// books a room. The body is a JSON reservation and is validated with the same rules as the reservation form
+func (m *Repository) APICreateReservation(w http.ResponseWriter, r *http.Request) {
+	// insisting on a JSON content type means a plain cross-site form post can't create a booking
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "application/json" {
+		apiErrorJSON(w, http.StatusUnsupportedMediaType, "content type must be application/json")
+		return
+	}
+
+	var in apiReservation
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	err := dec.Decode(&in)
+	if err != nil {
+		apiErrorJSON(w, http.StatusBadRequest, "request body must be a JSON reservation: "+err.Error())
+		return
+	}
+	if in.Status != "" {
+		apiErrorJSON(w, http.StatusBadRequest, "status can't be set, new reservations are always pending")
+		return
+	}
+	if in.TotalPrice != 0 {
+		apiErrorJSON(w, http.StatusBadRequest, "total_price can't be set, it comes from the room's rates")
+		return
+	}
+	if in.GuestToken != "" {
+		apiErrorJSON(w, http.StatusBadRequest, "guest_token can't be set, it is made for the new reservation")
+		return
+	}
+
+	// run the posted values through the same form validation the web site uses
+	values := url.Values{}
+	values.Set("first_name", in.FirstName)
+	values.Set("last_name", in.LastName)
+	values.Set("email", in.Email)
+	values.Set("phone", in.Phone)
+	values.Set("start_date", in.StartDate)
+	values.Set("end_date", in.EndDate)
+	if in.Adults != 0 {
+		values.Set("adults", strconv.Itoa(in.Adults))
+	}
+	values.Set("children", strconv.Itoa(in.Children))
+
+	form := forms.New(values)
+	validateReservationForm(form)
+	startDate, endDate := validateStay(form, "start_date", "end_date")
+	adults, children := validateGuests(form, "adults", "children")
+	if !form.Valid() {
+		apiValidationError(w, form)
+		return
+	}
+
+	room, err := m.DB.GetRoomByID(r.Context(), in.RoomID)
+	if err != nil {
+		apiErrorJSON(w, http.StatusNotFound, "room not found")
+		return
+	}
+
+	if adults+children > room.Capacity {
+		form.Errors.Add("adults", tooManyGuests(room))
+		apiValidationError(w, form)
+		return
+	}
+
+	problems, err := m.stayProblems(r.Context(), in.RoomID, startDate, endDate)
+	if err != nil {
+		apiServerError(w, err)
+		return
+	}
+	if len(problems) > 0 {
+		for _, p := range problems {
+			form.Errors.Add("stay", p)
+		}
+		apiValidationError(w, form)
+		return
+	}
+
+	price, err := m.quoteStay(r.Context(), room, startDate, endDate)
+	if err != nil {
+		apiServerError(w, err)
+		return
+	}
+
+	reservation := models.Reservation{
+		Price:     price,
+		Status:    models.ReservationPending,
+		FirstName: in.FirstName,
+		LastName:  in.LastName,
+		Email:     in.Email,
+		Phone:     in.Phone,
+		StartDate: startDate,
+		EndDate:   endDate,
+		RoomID:    in.RoomID,
+		Adults:    adults,
+		Children:  children,
+		Room:      room,
+	}
+
+	reservation.ID, err = m.DB.CreateReservation(r.Context(), reservation)
+	if err != nil {
+		var conflict *repository.ConflictError
+		if errors.As(err, &conflict) {
+			apiErrorJSON(w, http.StatusConflict, "the room is not available for those dates")
+			return
+		}
+		apiServerError(w, err)
+		return
+	}
+
+	m.sendBookingMail(r.Context(), reservation)
+
+	out := toAPIReservation(reservation)
+	out.GuestToken = m.guestToken(reservation)
+
+	w.Header().Set("Location", "/api/v1/reservations/"+strconv.Itoa(reservation.ID))
+	writeJSON(w, http.StatusCreated, out)
+}
+
+// returns one reservation, to staff with an api token or to the guest with its guest token
+func (m *Repository) APIGetReservation(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.apiReservationFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, toAPIReservation(res))
+}
+
+// cancels a reservation and frees up its dates. Staff need a write api token, guests need the guest token
+func (m *Repository) APICancelReservation(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.apiReservationFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	err := m.DB.UpdateReservationStatus(r.Context(), res.ID, models.ReservationCancelled, 0)
+	if err != nil {
+		var statusErr *repository.StatusError
+		if errors.As(err, &statusErr) {
+			apiErrorJSON(w, http.StatusConflict, "the reservation is "+statusErr.From+" and can't be cancelled")
+			return
+		}
+		apiServerError(w, err)
+		return
+	}
+
+	err = m.queueMail(r.Context(), res.Email, mailer.Cancellation{Reservation: res})
+	if err != nil {
+		helpers.LogError(err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// looks up the reservation from the {id} url parameter. The request needs an api token, or the guest token for
+// this reservation in the Guest-Token header. A header keeps the token out of access logs.
+// If it returns false, an error has already been written
+func (m *Repository) apiReservationFromRequest(w http.ResponseWriter, r *http.Request) (models.Reservation, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		apiErrorJSON(w, http.StatusNotFound, "reservation not found")
+		return models.Reservation{}, false
+	}
+
+	// the api token middleware has already checked the token and its scope
+	_, hasAPIToken := helpers.APIToken(r)
+	var stamp string
+	if !hasAPIToken {
+		token := r.Header.Get("Guest-Token")
+		if token == "" {
+			apiErrorJSON(w, http.StatusUnauthorized, "an api token or the reservation's guest token is required")
+			return models.Reservation{}, false
+		}
+
+		// don't tell callers whether an id exists unless they have its token
+		var tokenID int
+		tokenID, stamp, err = guestlink.Verify([]byte(m.App.LinkSecret), token, time.Now())
+		if err != nil || tokenID != id {
+			apiErrorJSON(w, http.StatusNotFound, "reservation not found")
+			return models.Reservation{}, false
+		}
+	}
+
+	res, err := m.DB.GetReservationByID(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		apiErrorJSON(w, http.StatusNotFound, "reservation not found")
+		return res, false
+	} else if err != nil {
+		apiServerError(w, err)
+		return res, false
+	}
+
+	// a guest token from before the dates were changed doesn't work any more
+	if !hasAPIToken && stamp != guestStamp(res) {
+		apiErrorJSON(w, http.StatusNotFound, "reservation not found")
+		return models.Reservation{}, false
+	}
+
+	return res, true
+}
+
+// checks the guest details on a reservation form. The web form and the api both use it so the rules stay the same
+func validateReservationForm(form *forms.Form) {
+	form.Required("first_name", "last_name", "email")
+	form.MinLength("first_name", 3)
+	form.IsEmail("email")
+}
+
+// parses and checks a start and end date, adding errors to the form if they are missing, invalid or out of order
+func validateStay(form *forms.Form, startField, endField string) (time.Time, time.Time) {
+	form.Required(startField, endField)
+
+	startDate, err := time.Parse(apiDateLayout, form.Get(startField))
+	if err != nil && form.Get(startField) != "" {
+		form.Errors.Add(startField, "Must be a date in YYYY-MM-DD format")
+	}
+	endDate, err := time.Parse(apiDateLayout, form.Get(endField))
+	if err != nil && form.Get(endField) != "" {
+		form.Errors.Add(endField, "Must be a date in YYYY-MM-DD format")
+	}
+
+	if !startDate.IsZero() && !endDate.IsZero() && !endDate.After(startDate) {
+		form.Errors.Add(endField, "Must be after the start date")
+	}
+
+	return startDate, endDate
+}
+
+// parses the number of adults and children in a party, adding errors to the form if they aren't numbers.
+// Left blank, there is one adult and no children
+func validateGuests(form *forms.Form, adultsField, childrenField string) (int, int) {
+	adults, children := 1, 0
+
+	if form.Get(adultsField) != "" {
+		n, err := strconv.Atoi(form.Get(adultsField))
+		if err != nil || n < 1 {
+			form.Errors.Add(adultsField, "At least one adult has to stay")
+		}
+		adults = n
+	}
+	if form.Get(childrenField) != "" {
+		n, err := strconv.Atoi(form.Get(childrenField))
+		if err != nil || n < 0 {
+			form.Errors.Add(childrenField, "Must be a number of children")
+		}
+		children = n
+	}
+
+	return adults, children
+}
+
+// returns what validateGuests found wrong with the party, for pages that show one message rather than a form
+func guestsError(form *forms.Form, adultsField, childrenField string) string {
+	if msg := form.Errors.Get(adultsField); msg != "" {
+		return msg
+	}
+	return form.Errors.Get(childrenField)
+}
+
+// the message for a party that doesn't fit in room
+func tooManyGuests(room models.Room) string {
+	return fmt.Sprintf("%s sleeps up to %d guests", room.RoomName, room.Capacity)
+}
+
+func toAPIReservation(res models.Reservation) apiReservation {
+	return apiReservation{
+		ID:         res.ID,
+		RoomID:     res.RoomID,
+		RoomName:   res.Room.RoomName,
+		FirstName:  res.FirstName,
+		LastName:   res.LastName,
+		Email:      res.Email,
+		Phone:      res.Phone,
+		StartDate:  res.StartDate.Format(apiDateLayout),
+		EndDate:    res.EndDate.Format(apiDateLayout),
+		Adults:     res.Adults,
+		Children:   res.Children,
+		Status:     res.Status,
+		TotalPrice: res.Price.Total,
+	}
+}
+
+// writes v as JSON with the given status code
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	out, err := json.MarshalIndent(v, "", "    ")
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(out)
+}
+
+func apiErrorJSON(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, apiError{Error: apiErrorBody{Message: message}})
+}
+
+func apiValidationError(w http.ResponseWriter, form *forms.Form) {
+	writeJSON(w, http.StatusUnprocessableEntity, apiError{Error: apiErrorBody{
+		Message: "validation failed",
+		Fields:  form.Errors,
+	}})
+}
+
+// logs the real error, but only tells the client something went wrong
+func apiServerError(w http.ResponseWriter, err error) {
+	helpers.LogError(err)
+	apiErrorJSON(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+}
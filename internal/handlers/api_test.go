@@ -0,0 +1,237 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andkolbe/bookings/internal/guestlink"
+	"github.com/andkolbe/bookings/internal/helpers"
+	"github.com/andkolbe/bookings/internal/models"
+	"github.com/go-chi/chi/v5"
+)
+
+// the api handlers read url parameters with chi v5, so they get their own router
+func getAPIRoutes() http.Handler {
+	mux := chi.NewRouter()
+	mux.Use(fakeAPITokenAuth)
+	mux.Route("/api/v1", func(mux chi.Router) {
+		mux.Get("/rooms", Repo.APIRooms)
+		mux.Get("/availability", Repo.APIAvailability)
+		mux.Post("/reservations", Repo.APICreateReservation)
+		mux.Get("/reservations/{id}", Repo.APIGetReservation)
+		mux.Delete("/reservations/{id}", Repo.APICancelReservation)
+	})
+	return mux
+}
+
+// stands in for the api token middleware, which checks the token and its scope before the handlers run
+func fakeAPITokenAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "Bearer staff" {
+			r = r.WithContext(helpers.WithAPIToken(r.Context(), models.APIToken{ID: 2, Scope: models.APIScopeWrite}))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+var apiTests = []struct {
+	name               string
+	method             string
+	url                string
+	contentType        string
+	body               string
+	expectedStatusCode int
+	expectedJSON       string // a fragment we expect to find in the response
+}{
+	{"rooms", "GET", "/api/v1/rooms", "", "", http.StatusOK, "["},
+	{"availability", "GET", "/api/v1/availability?start=2050-01-01&end=2050-01-02", "", "", http.StatusOK, `"rooms": []`},
+	{"availability missing dates", "GET", "/api/v1/availability", "", "", http.StatusUnprocessableEntity, `"start"`},
+	{"availability bad date", "GET", "/api/v1/availability?start=tomorrow&end=2050-01-02", "", "", http.StatusUnprocessableEntity, "YYYY-MM-DD"},
+	{"availability backwards", "GET", "/api/v1/availability?start=2050-01-05&end=2050-01-02", "", "", http.StatusUnprocessableEntity, "after the start date"},
+	{"availability for a party", "GET", "/api/v1/availability?start=2050-01-01&end=2050-01-02&adults=2&children=1", "", "", http.StatusOK, `"children": 1`},
+	{"availability without adults", "GET", "/api/v1/availability?start=2050-01-01&end=2050-01-02&adults=0", "", "", http.StatusUnprocessableEntity, "At least one adult"},
+	{
+		"create", "POST", "/api/v1/reservations", "application/json",
+		`{"room_id": 1, "first_name": "Andrew", "last_name": "Kolbe", "email": "me@here.com", "start_date": "2050-01-01", "end_date": "2050-01-02"}`,
+		http.StatusCreated, `"id": 1`,
+	},
+	{
+		"create invalid", "POST", "/api/v1/reservations", "application/json",
+		`{"room_id": 1, "first_name": "A", "last_name": "", "email": "nope", "start_date": "2050-01-01", "end_date": "2050-01-02"}`,
+		http.StatusUnprocessableEntity, `"first_name"`,
+	},
+	{
+		"create unknown room", "POST", "/api/v1/reservations", "application/json",
+		`{"room_id": 3, "first_name": "Andrew", "last_name": "Kolbe", "email": "me@here.com", "start_date": "2050-01-01", "end_date": "2050-01-02"}`,
+		http.StatusNotFound, "room not found",
+	},
+	{
+		"create conflict", "POST", "/api/v1/reservations", "application/json",
+		`{"room_id": 1, "first_name": "Andrew", "last_name": "Kolbe", "email": "me@here.com", "start_date": "2050-12-31", "end_date": "2051-01-02"}`,
+		http.StatusConflict, "not available",
+	},
+	{
+		"create priced", "POST", "/api/v1/reservations", "application/json",
+		`{"room_id": 1, "first_name": "Andrew", "last_name": "Kolbe", "email": "me@here.com", "start_date": "2050-01-01", "end_date": "2050-01-03"}`,
+		http.StatusCreated, `"total_price": 32000`,
+	},
+	{
+		"create with price", "POST", "/api/v1/reservations", "application/json",
+		`{"room_id": 1, "first_name": "Andrew", "last_name": "Kolbe", "email": "me@here.com", "start_date": "2050-01-01", "end_date": "2050-01-02", "total_price": 1}`,
+		http.StatusBadRequest, "total_price",
+	},
+	{
+		"create against the rules", "POST", "/api/v1/reservations", "application/json",
+		`{"room_id": 1, "first_name": "Andrew", "last_name": "Kolbe", "email": "me@here.com", "start_date": "2050-06-06", "end_date": "2050-06-07"}`,
+		http.StatusUnprocessableEntity, "at least 3 nights",
+	},
+	{
+		"create with children", "POST", "/api/v1/reservations", "application/json",
+		`{"room_id": 1, "first_name": "Andrew", "last_name": "Kolbe", "email": "me@here.com", "start_date": "2050-01-01", "end_date": "2050-01-02", "children": 1}`,
+		http.StatusCreated, `"children": 1`,
+	},
+	{
+		"create too many guests", "POST", "/api/v1/reservations", "application/json",
+		`{"room_id": 1, "first_name": "Andrew", "last_name": "Kolbe", "email": "me@here.com", "start_date": "2050-01-01", "end_date": "2050-01-02", "adults": 2, "children": 1}`,
+		http.StatusUnprocessableEntity, "sleeps up to 2 guests",
+	},
+	{
+		"create negative children", "POST", "/api/v1/reservations", "application/json",
+		`{"room_id": 1, "first_name": "Andrew", "last_name": "Kolbe", "email": "me@here.com", "start_date": "2050-01-01", "end_date": "2050-01-02", "children": -1}`,
+		http.StatusUnprocessableEntity, `"children"`,
+	},
+	{"create bad json", "POST", "/api/v1/reservations", "application/json", `{"room_id": "one"}`, http.StatusBadRequest, "JSON reservation"},
+	{"create form post", "POST", "/api/v1/reservations", "application/x-www-form-urlencoded", "room_id=1", http.StatusUnsupportedMediaType, "application/json"},
+	{
+		"create with guest token", "POST", "/api/v1/reservations", "application/json",
+		`{"room_id": 1, "first_name": "Andrew", "last_name": "Kolbe", "email": "me@here.com", "start_date": "2050-01-01", "end_date": "2050-01-02", "guest_token": "x"}`,
+		http.StatusBadRequest, "guest_token",
+	},
+	{
+		"create returns guest token", "POST", "/api/v1/reservations", "application/json",
+		`{"room_id": 1, "first_name": "Andrew", "last_name": "Kolbe", "email": "me@here.com", "start_date": "2050-01-01", "end_date": "2050-01-02"}`,
+		http.StatusCreated, `"guest_token": "`,
+	},
+}
+
+func TestAPI(t *testing.T) {
+	ts := httptest.NewServer(getAPIRoutes())
+	defer ts.Close()
+
+	for _, e := range apiTests {
+		req, _ := http.NewRequest(e.method, ts.URL+e.url, strings.NewReader(e.body))
+		if e.contentType != "" {
+			req.Header.Set("Content-Type", e.contentType)
+		}
+
+		resp, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var body json.RawMessage
+		if resp.StatusCode != http.StatusNoContent {
+			if resp.Header.Get("Content-Type") != "application/json" {
+				t.Errorf("%s: expected a JSON response, got %s", e.name, resp.Header.Get("Content-Type"))
+			}
+			err = json.NewDecoder(resp.Body).Decode(&body)
+			if err != nil {
+				t.Errorf("%s: could not parse response: %s", e.name, err)
+			}
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != e.expectedStatusCode {
+			t.Errorf("%s: expected status %d, got %d", e.name, e.expectedStatusCode, resp.StatusCode)
+		}
+
+		if !strings.Contains(string(body), e.expectedJSON) {
+			t.Errorf("%s: expected %s in response, got %s", e.name, e.expectedJSON, body)
+		}
+	}
+}
+
+func TestAPI_ReservationAccess(t *testing.T) {
+	var tests = []struct {
+		name               string
+		method             string
+		id                 string
+		auth               string // an Authorization header
+		guestToken         string
+		expectedStatusCode int
+		expectedJSON       string
+	}{
+		{"get with guest token", "GET", "1", "", testGuestToken(1), http.StatusOK, `"email": "me@here.com"`},
+		{"get with api token", "GET", "1", "Bearer staff", "", http.StatusOK, `"email": "me@here.com"`},
+		{"get without a token", "GET", "1", "", "", http.StatusUnauthorized, "token is required"},
+		{"get with another reservation's token", "GET", "1", "", testGuestToken(2), http.StatusNotFound, "not found"},
+		{"get with a bad token", "GET", "1", "", "nope", http.StatusNotFound, "not found"},
+		{"get with an expired token", "GET", "1", "", guestlink.Sign([]byte(app.LinkSecret), 1, "20500101-20500103-0", time.Now().Add(-time.Minute)), http.StatusNotFound, "not found"},
+		{"get with a token for old dates", "GET", "1", "", guestlink.Sign([]byte(app.LinkSecret), 1, "20500201-20500204-0", time.Now().Add(time.Hour)), http.StatusNotFound, "not found"},
+		{"get with a token from before a move back", "GET", "98", "", guestlink.Sign([]byte(app.LinkSecret), 98, "20500101-20500103-0", time.Now().Add(time.Hour)), http.StatusNotFound, "not found"},
+		{"get missing", "GET", "101", "Bearer staff", "", http.StatusNotFound, "not found"},
+		{"get status", "GET", "100", "", testGuestToken(100), http.StatusOK, `"status": "checked-in"`},
+		{"cancel", "DELETE", "1", "", testGuestToken(1), http.StatusNoContent, ""},
+		{"cancel with api token", "DELETE", "1", "Bearer staff", "", http.StatusNoContent, ""},
+		{"cancel without a token", "DELETE", "1", "", "", http.StatusUnauthorized, "token is required"},
+		{"cancel twice", "DELETE", "99", "", testGuestToken(99), http.StatusConflict, "cancelled"},
+	}
+
+	ts := httptest.NewServer(getAPIRoutes())
+	defer ts.Close()
+
+	for _, e := range tests {
+		req, _ := http.NewRequest(e.method, ts.URL+"/api/v1/reservations/"+e.id, nil)
+		if e.auth != "" {
+			req.Header.Set("Authorization", e.auth)
+		}
+		if e.guestToken != "" {
+			req.Header.Set("Guest-Token", e.guestToken)
+		}
+
+		resp, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode != e.expectedStatusCode {
+			t.Errorf("%s: expected status %d, got %d", e.name, e.expectedStatusCode, resp.StatusCode)
+		}
+		if !strings.Contains(string(body), e.expectedJSON) {
+			t.Errorf("%s: expected %s in response, got %s", e.name, e.expectedJSON, body)
+		}
+	}
+}
+
+func TestRepository_APICreateReservation_Mail(t *testing.T) {
+	mail := &recordingRepo{DatabaseRepo: Repo.DB}
+	repo := *Repo
+	repo.DB = mail
+
+	body := `{"room_id": 1, "first_name": "Andrew", "last_name": "Kolbe", "email": "me@here.com", "start_date": "2050-01-01", "end_date": "2050-01-02"}`
+	req, _ := http.NewRequest("POST", "/api/v1/reservations", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(repo.APICreateReservation).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected %d, got %d", http.StatusCreated, rr.Code)
+	}
+	if len(mail.mail) != 2 {
+		t.Fatalf("expected a confirmation and an owner notification, got %d emails", len(mail.mail))
+	}
+	if mail.mail[0].To != "me@here.com" || !strings.Contains(mail.mail[0].Content, "/my-reservation/") {
+		t.Errorf("expected the guest to be sent a link to their reservation, got %+v", mail.mail[0])
+	}
+	if mail.mail[1].To != bookingsEmail {
+		t.Errorf("expected the owner to be told, got mail to %s", mail.mail[1].To)
+	}
+}
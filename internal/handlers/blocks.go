@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andkolbe/bookings/internal/forms"
+	"github.com/andkolbe/bookings/internal/helpers"
+	"github.com/andkolbe/bookings/internal/models"
+	"github.com/andkolbe/bookings/internal/repository"
+	"github.com/go-chi/chi/v5"
+)
+
+// the longest reason a block can have
+const maxBlockReason = 100
+
+// one cell in a room's row of the reservations calendar. A block is a single cell spanning all of its nights in
+// the month, so it can be removed as a unit
+type calendarCell struct {
+	Day           time.Time
+	Span          int
+	ReservationID int
+	External      bool
+	Block         models.RoomRestriction
+}
+
+// blocks a room from the night of start_date up to end_date, so guests can't book it
+func (m *Repository) AdminPostBlock(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("room_id")
+
+	roomID, err := strconv.Atoi(form.Get("room_id"))
+	if err != nil || roomID < 1 {
+		form.Errors.Add("room_id", "Choose a room")
+	}
+	startDate, endDate := validateStay(form, "start_date", "end_date")
+	reason := strings.TrimSpace(form.Get("reason"))
+	if len(reason) > maxBlockReason {
+		form.Errors.Add("reason", fmt.Sprintf("Keep the reason under %d characters", maxBlockReason))
+	}
+
+	// the calendar the form was on
+	year, _ := strconv.Atoi(form.Get("y"))
+	month, _ := strconv.Atoi(form.Get("m"))
+	now := time.Now()
+	if year > 0 && month > 0 {
+		now = time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC)
+	}
+
+	if !form.Valid() {
+		m.renderReservationsCalendar(w, r, now, form)
+		return
+	}
+
+	// the repo checks for overlaps in the same transaction as the insert, so a booking can't slip in between
+	err = m.DB.InsertBlockForRoom(r.Context(), roomID, startDate, endDate, reason)
+	var conflict *repository.ConflictError
+	if errors.As(err, &conflict) {
+		form.Errors.Add("start_date", "The room is already booked or blocked on some of these nights")
+		m.renderReservationsCalendar(w, r, now, form)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Room blocked")
+	http.Redirect(w, r, fmt.Sprintf("/admin/reservations-calendar?y=%d&m=%d", startDate.Year(), startDate.Month()), http.StatusSeeOther)
+}
+
+// removes a whole block, however many nights it covers
+func (m *Repository) AdminDeleteBlock(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	err = r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	err = m.DB.DeleteBlockByID(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Block removed")
+	http.Redirect(w, r, fmt.Sprintf("/admin/reservations-calendar?y=%s&m=%s", r.Form.Get("y"), r.Form.Get("m")), http.StatusSeeOther)
+}
+
+// lays out a room's restrictions as the cells of its row on the calendar from first to last. Every day gets a cell
+// of its own, except the nights of an owner block, which share one
+func calendarCells(restrictions []models.RoomRestriction, first, last time.Time) []calendarCell {
+	reservations := make(map[string]int)
+	external := make(map[string]bool)
+	blocks := make(map[string]models.RoomRestriction) // keyed by the block's first night this month
+
+	for _, y := range restrictions {
+		// if reservation id is greater than 0, it is a reservation, otherwise, it is a block
+		if y.ReservationID > 0 {
+			for d := y.StartDate; d.After(y.EndDate) == false; d = d.AddDate(0, 0, 1) {
+				reservations[d.Format("2006-01-2")] = y.ReservationID
+			}
+		} else if y.RestrictionID == models.RestrictionExternal {
+			// a block from an external calendar, which covers every night of the booking
+			for d := y.StartDate; d.Before(y.EndDate); d = d.AddDate(0, 0, 1) {
+				external[d.Format("2006-01-2")] = true
+			}
+		} else {
+			start := y.StartDate
+			if start.Before(first) {
+				start = first
+			}
+			blocks[start.Format("2006-01-2")] = y
+		}
+	}
+
+	var cells []calendarCell
+	for d := first; d.After(last) == false; {
+		key := d.Format("2006-01-2")
+
+		if b, ok := blocks[key]; ok {
+			// the block's last night, as a day of this calendar so the two can be compared
+			y, mo, day := b.EndDate.AddDate(0, 0, -1).Date()
+			end := time.Date(y, mo, day, 0, 0, 0, 0, d.Location())
+
+			cell := calendarCell{Day: d, Block: b}
+			for cell.Span == 0 || (d.After(end) == false && d.After(last) == false) {
+				cell.Span++
+				d = d.AddDate(0, 0, 1)
+			}
+			cells = append(cells, cell)
+			continue
+		}
+
+		cells = append(cells, calendarCell{Day: d, Span: 1, ReservationID: reservations[key], External: external[key]})
+		d = d.AddDate(0, 0, 1)
+	}
+
+	return cells
+}
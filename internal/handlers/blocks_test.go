@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andkolbe/bookings/internal/models"
+	"github.com/go-chi/chi/v5"
+)
+
+// the test repo has room 1 blocked for a deep clean from July 10 to 13, 2050
+func TestRepository_AdminPostBlock(t *testing.T) {
+	var tests = []struct {
+		name               string
+		roomID             string
+		startDate          string
+		endDate            string
+		reason             string
+		expectedStatusCode int
+		expectedLocation   string
+		expectedError      string
+	}{
+		{"added", "1", "2050-08-01", "2050-08-15", "Maintenance", http.StatusSeeOther, "/admin/reservations-calendar?y=2050&m=8", ""},
+		{"no reason", "2", "2050-07-10", "2050-07-12", "", http.StatusSeeOther, "/admin/reservations-calendar?y=2050&m=7", ""},
+		{"ends as the block starts", "1", "2050-07-08", "2050-07-10", "", http.StatusSeeOther, "/admin/reservations-calendar?y=2050&m=7", ""},
+		{"overlaps a block", "1", "2050-07-12", "2050-07-20", "", http.StatusOK, "", "already booked or blocked"},
+		{"ends before it starts", "1", "2050-08-15", "2050-08-01", "", http.StatusOK, "", "Must be after the start date"},
+		{"no room", "", "2050-08-01", "2050-08-15", "", http.StatusOK, "", "This field cannot be blank"},
+		{"reason too long", "1", "2050-08-01", "2050-08-15", strings.Repeat("x", 101), http.StatusOK, "", "under 100 characters"},
+		{"database error", "3", "2050-08-01", "2050-08-15", "", http.StatusInternalServerError, "", ""},
+	}
+
+	for _, e := range tests {
+		postedData := url.Values{}
+		postedData.Add("room_id", e.roomID)
+		postedData.Add("start_date", e.startDate)
+		postedData.Add("end_date", e.endDate)
+		postedData.Add("reason", e.reason)
+		postedData.Add("y", "2050")
+		postedData.Add("m", "07")
+
+		req, _ := http.NewRequest("POST", "/admin/blocks", strings.NewReader(postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		session.Put(ctx, "user_id", 1)
+		session.Put(ctx, "access_level", models.AccessOwner)
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminPostBlock)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected %d, got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if e.expectedLocation != "" && rr.Header().Get("Location") != e.expectedLocation {
+			t.Errorf("%s: expected a redirect to %s, got %s", e.name, e.expectedLocation, rr.Header().Get("Location"))
+		}
+		if e.expectedError != "" && !strings.Contains(rr.Body.String(), e.expectedError) {
+			t.Errorf("%s: expected the form to say %q", e.name, e.expectedError)
+		}
+	}
+}
+
+func TestRepository_AdminDeleteBlock(t *testing.T) {
+	var tests = []struct {
+		id                 string
+		expectedStatusCode int
+	}{
+		{"7", http.StatusSeeOther},
+		{"9", http.StatusNotFound},
+		{"x", http.StatusBadRequest},
+	}
+
+	for _, e := range tests {
+		postedData := url.Values{}
+		postedData.Add("y", "2050")
+		postedData.Add("m", "07")
+
+		req, _ := http.NewRequest("POST", "/admin/blocks/"+e.id+"/delete", strings.NewReader(postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", e.id)
+		req = req.WithContext(context.WithValue(getCtx(req), chi.RouteCtxKey, rctx))
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminDeleteBlock)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("id %s: expected %d, got %d", e.id, e.expectedStatusCode, rr.Code)
+		}
+		if e.expectedStatusCode == http.StatusSeeOther && rr.Header().Get("Location") != "/admin/reservations-calendar?y=2050&m=07" {
+			t.Errorf("id %s: expected a redirect back to the calendar, got %s", e.id, rr.Header().Get("Location"))
+		}
+	}
+}
+
+func TestRepository_AdminReservationsCalendar_Blocks(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/admin/reservations-calendar?y=2050&m=7", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	session.Put(ctx, "user_id", 1)
+	session.Put(ctx, "access_level", models.AccessOwner)
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.AdminReservationsCalendar).ServeHTTP(rr, req)
+
+	body := rr.Body.String()
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected the calendar, got %d", rr.Code)
+	}
+	if !strings.Contains(body, `colspan="3"`) || !strings.Contains(body, "Blocked: Deep clean") {
+		t.Error("expected the deep clean to be one cell three nights wide")
+	}
+	if !strings.Contains(body, "/admin/blocks/7/delete") {
+		t.Error("expected an owner to be able to remove the block")
+	}
+}
+
+func TestCalendarCells(t *testing.T) {
+	first := time.Date(2050, 7, 1, 0, 0, 0, 0, time.UTC)
+	last := time.Date(2050, 7, 31, 0, 0, 0, 0, time.UTC)
+	restrictions := []models.RoomRestriction{
+		// started in june, so only the first two nights of july are shown
+		{ID: 1, RestrictionID: models.RestrictionOwnerBlock, StartDate: time.Date(2050, 6, 28, 0, 0, 0, 0, time.UTC), EndDate: time.Date(2050, 7, 3, 0, 0, 0, 0, time.UTC)},
+		{ID: 2, ReservationID: 5, RestrictionID: models.RestrictionReservation, StartDate: time.Date(2050, 7, 5, 0, 0, 0, 0, time.UTC), EndDate: time.Date(2050, 7, 6, 0, 0, 0, 0, time.UTC)},
+		{ID: 3, RestrictionID: models.RestrictionExternal, StartDate: time.Date(2050, 7, 8, 0, 0, 0, 0, time.UTC), EndDate: time.Date(2050, 7, 9, 0, 0, 0, 0, time.UTC)},
+		// runs into august
+		{ID: 4, RestrictionID: models.RestrictionOwnerBlock, StartDate: time.Date(2050, 7, 30, 0, 0, 0, 0, time.UTC), EndDate: time.Date(2050, 8, 4, 0, 0, 0, 0, time.UTC), Reason: "Owner use"},
+	}
+
+	cells := calendarCells(restrictions, first, last)
+
+	days := 0
+	for _, c := range cells {
+		days += c.Span
+	}
+	if days != 31 {
+		t.Errorf("expected the cells to cover 31 days, got %d", days)
+	}
+
+	if cells[0].Block.ID != 1 || cells[0].Span != 2 {
+		t.Errorf("expected the june block to cover july 1 and 2, got %+v", cells[0])
+	}
+	if c := cells[3]; c.ReservationID != 5 || c.Day.Day() != 5 {
+		t.Errorf("expected the reservation on july 5, got %+v", c)
+	}
+	if c := cells[6]; !c.External || c.Day.Day() != 8 {
+		t.Errorf("expected the external block on july 8, got %+v", c)
+	}
+	if c := cells[len(cells)-1]; c.Block.ID != 4 || c.Span != 2 || c.Day.Day() != 30 {
+		t.Errorf("expected the last block to cover july 30 and 31, got %+v", c)
+	}
+}
@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/andkolbe/bookings/internal/helpers"
+	"github.com/andkolbe/bookings/internal/ical"
+	"github.com/andkolbe/bookings/internal/models"
+	"github.com/andkolbe/bookings/internal/render"
+	"github.com/go-chi/chi/v5"
+)
+
+// how far back and ahead a room's calendar feed goes
+const (
+	feedHistory = 1 // years
+	feedFuture  = 2 // years
+)
+
+// serves a room's reservations and owner blocks as an iCalendar feed. The token in the url is the only
+// thing protecting it, so an unknown token looks the same as a room that doesn't exist
+func (m *Repository) RoomCalendarFeed(w http.ResponseWriter, r *http.Request) {
+	room, err := m.DB.GetRoomByICalToken(r.Context(), chi.URLParam(r, "token"))
+	if errors.Is(err, sql.ErrNoRows) {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	now := time.Now()
+	restrictions, err := m.DB.GetRestrictionsForRoomByDate(r.Context(), room.ID, now.AddDate(-feedHistory, 0, 0), now.AddDate(feedFuture, 0, 0))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	cal := ical.Calendar{
+		Name:   room.RoomName,
+		Method: "PUBLISH",
+	}
+	for _, x := range restrictions {
+		cal.Events = append(cal.Events, feedEvent(room, x))
+	}
+
+	w.Header().Set("Content-Type", ical.ContentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`inline; filename="room-%d.ics"`, room.ID))
+	w.Write(cal.Marshal())
+}
+
+// turns a room restriction into a calendar event. Feeds may be shared with booking sites,
+// so they say when the room is taken but not who by
+func feedEvent(room models.Room, x models.RoomRestriction) ical.Event {
+	e := ical.Event{
+		Location: room.RoomName,
+		Start:    x.StartDate,
+		End:      x.EndDate,
+		Stamp:    x.UpdatedAt,
+	}
+
+	if x.ReservationID > 0 {
+		e.UID = fmt.Sprintf("reservation-%d@bookings", x.ReservationID)
+		e.Summary = "Reserved"
+		e.Description = fmt.Sprintf("Reservation %d", x.ReservationID)
+	} else {
+		e.UID = fmt.Sprintf("block-%d@bookings", x.ID)
+		e.Summary = "Blocked"
+	}
+
+	return e
+}
+
+// lists every room with the url of its calendar feed
+func (m *Repository) AdminCalendarFeeds(w http.ResponseWriter, r *http.Request) {
+	rooms, err := m.DB.AllRooms(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	scheme := "http"
+	if m.App.InProduction {
+		scheme = "https"
+	}
+
+	data := make(map[string]interface{})
+	data["rooms"] = rooms
+
+	stringMap := make(map[string]string)
+	stringMap["feed_url"] = fmt.Sprintf("%s://%s/calendars/", scheme, r.Host)
+
+	render.Template(w, r, "admin-calendar-feeds.page.html", &models.TemplateData{
+		Data:      data,
+		StringMap: stringMap,
+	})
+}
+
+// gives a room's calendar feed a new url, which stops the old one working, or turns the feed off
+func (m *Repository) AdminPostCalendarFeed(w http.ResponseWriter, r *http.Request) {
+	roomID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	err = r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	token := ""
+	flash := "Calendar feed turned off"
+	if r.Form.Get("action") != "off" {
+		token, err = helpers.RandomToken()
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		flash = "Calendar feed has a new link"
+	}
+
+	err = m.DB.UpdateRoomICalToken(r.Context(), roomID, token)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", flash)
+	http.Redirect(w, r, "/admin/calendar-feeds", http.StatusSeeOther)
+}
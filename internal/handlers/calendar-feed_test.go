@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andkolbe/bookings/internal/models"
+	"github.com/go-chi/chi/v5"
+)
+
+func TestRoomCalendarFeed(t *testing.T) {
+	mux := chi.NewRouter()
+	mux.Get("/calendars/{token}.ics", Repo.RoomCalendarFeed)
+
+	var tests = []struct {
+		url                string
+		expectedStatusCode int
+	}{
+		{"/calendars/room-1-token.ics", http.StatusOK},
+		{"/calendars/guess.ics", http.StatusNotFound},
+		{"/calendars/room-1-token", http.StatusNotFound},
+	}
+
+	for _, e := range tests {
+		req := httptest.NewRequest("GET", e.url, nil)
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected %d, got %d", e.url, e.expectedStatusCode, rr.Code)
+			continue
+		}
+		if rr.Code != http.StatusOK {
+			continue
+		}
+
+		if !strings.HasPrefix(rr.Header().Get("Content-Type"), "text/calendar") {
+			t.Errorf("%s: expected a calendar, got %s", e.url, rr.Header().Get("Content-Type"))
+		}
+		if !strings.Contains(rr.Body.String(), "X-WR-CALNAME:General's Quarters") {
+			t.Errorf("%s: expected the room name in the feed:\n%s", e.url, rr.Body.String())
+		}
+	}
+}
+
+func TestFeedEvent(t *testing.T) {
+	room := models.Room{ID: 1, RoomName: "General's Quarters"}
+	start := time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	reserved := feedEvent(room, models.RoomRestriction{ID: 4, ReservationID: 9, StartDate: start, EndDate: start.AddDate(0, 0, 2)})
+	if reserved.UID != "reservation-9@bookings" || reserved.Summary != "Reserved" {
+		t.Errorf("unexpected reservation event %+v", reserved)
+	}
+
+	blocked := feedEvent(room, models.RoomRestriction{ID: 4, StartDate: start, EndDate: start.AddDate(0, 0, 1)})
+	if blocked.UID != "block-4@bookings" || blocked.Summary != "Blocked" {
+		t.Errorf("unexpected block event %+v", blocked)
+	}
+}
+
+func TestRepository_AdminPostCalendarFeed(t *testing.T) {
+	var tests = []struct {
+		id                 string
+		action             string
+		expectedStatusCode int
+	}{
+		{"1", "new", http.StatusSeeOther},
+		{"1", "off", http.StatusSeeOther},
+		{"3", "new", http.StatusInternalServerError},
+		{"x", "new", http.StatusBadRequest},
+	}
+
+	for _, e := range tests {
+		postedData := url.Values{}
+		postedData.Add("action", e.action)
+
+		req, _ := http.NewRequest("POST", "/admin/calendar-feeds/"+e.id, strings.NewReader(postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", e.id)
+		ctx := getCtx(req)
+		req = req.WithContext(context.WithValue(ctx, chi.RouteCtxKey, rctx))
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminPostCalendarFeed)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("id %s %s: expected %d, got %d", e.id, e.action, e.expectedStatusCode, rr.Code)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/andkolbe/bookings/internal/forms"
+	"github.com/andkolbe/bookings/internal/helpers"
+	"github.com/andkolbe/bookings/internal/models"
+	"github.com/andkolbe/bookings/internal/render"
+	"github.com/go-chi/chi/v5"
+)
+
+// lists the external calendars we block rooms from, and the form to add another
+func (m *Repository) AdminCalendarSources(w http.ResponseWriter, r *http.Request) {
+	m.renderCalendarSources(w, r, forms.New(nil))
+}
+
+// adds an external calendar to a room, and syncs it straight away
+func (m *Repository) AdminPostCalendarSource(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("room_id", "name", "url")
+
+	roomID, err := strconv.Atoi(form.Get("room_id"))
+	if err != nil || roomID < 1 {
+		form.Errors.Add("room_id", "Choose a room")
+	}
+
+	// files can only be added straight to the database. Anyone who can use this form shouldn't be able to read them
+	u, err := url.Parse(form.Get("url"))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		form.Errors.Add("url", "Enter the http or https link the booking site gives you")
+	}
+
+	if !form.Valid() {
+		m.renderCalendarSources(w, r, form)
+		return
+	}
+
+	_, err = m.DB.InsertCalendarSource(r.Context(), models.CalendarSource{
+		RoomID: roomID,
+		Name:   form.Get("name"),
+		URL:    form.Get("url"),
+	})
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.syncCalendars()
+
+	m.App.Session.Put(r.Context(), "flash", "Calendar added, its blocks will show up shortly")
+	http.Redirect(w, r, "/admin/calendar-sources", http.StatusSeeOther)
+}
+
+// stops syncing an external calendar and removes the blocks it made
+func (m *Repository) AdminDeleteCalendarSource(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	err = m.DB.DeleteCalendarSource(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Calendar removed")
+	http.Redirect(w, r, "/admin/calendar-sources", http.StatusSeeOther)
+}
+
+// syncs every external calendar now instead of waiting for the next interval
+func (m *Repository) AdminSyncCalendarSources(w http.ResponseWriter, r *http.Request) {
+	m.syncCalendars()
+
+	m.App.Session.Put(r.Context(), "flash", "Calendars are syncing, refresh in a moment to see how it went")
+	http.Redirect(w, r, "/admin/calendar-sources", http.StatusSeeOther)
+}
+
+func (m *Repository) renderCalendarSources(w http.ResponseWriter, r *http.Request, form *forms.Form) {
+	sources, err := m.DB.AllCalendarSources(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	rooms, err := m.DB.AllRooms(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["sources"] = sources
+	data["rooms"] = rooms
+
+	render.Template(w, r, "admin-calendar-sources.page.html", &models.TemplateData{
+		Data: data,
+		Form: form,
+	})
+}
+
+// nudges the calendar sync without waiting. If a nudge is already waiting, that sync will pick up our change too
+func (m *Repository) syncCalendars() {
+	select {
+	case m.App.SyncCalendars <- struct{}{}:
+	default:
+	}
+}
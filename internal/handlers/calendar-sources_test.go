@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestRepository_AdminPostCalendarSource(t *testing.T) {
+	var tests = []struct {
+		name               string
+		roomID             string
+		url                string
+		expectedStatusCode int
+	}{
+		{"added", "1", "https://www.airbnb.com/calendar/ical/1.ics?s=abc", http.StatusSeeOther},
+		{"no room", "", "https://www.airbnb.com/calendar/ical/1.ics", http.StatusOK},
+		{"file", "1", "/etc/passwd", http.StatusOK},
+		{"not a link", "1", "airbnb", http.StatusOK},
+		{"database error", "3", "https://www.airbnb.com/calendar/ical/1.ics", http.StatusInternalServerError},
+	}
+
+	for _, e := range tests {
+		postedData := url.Values{}
+		postedData.Add("room_id", e.roomID)
+		postedData.Add("name", "Airbnb")
+		postedData.Add("url", e.url)
+
+		req, _ := http.NewRequest("POST", "/admin/calendar-sources", strings.NewReader(postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminPostCalendarSource)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected %d, got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+	}
+}
@@ -0,0 +1,261 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/andkolbe/bookings/internal/forms"
+	"github.com/andkolbe/bookings/internal/guestlink"
+	"github.com/andkolbe/bookings/internal/helpers"
+	"github.com/andkolbe/bookings/internal/mailer"
+	"github.com/andkolbe/bookings/internal/models"
+	"github.com/andkolbe/bookings/internal/render"
+	"github.com/andkolbe/bookings/internal/repository"
+	"github.com/andkolbe/bookings/internal/stayrules"
+	"github.com/go-chi/chi/v5"
+)
+
+// shows a guest their reservation, from the link in their confirmation email
+func (m *Repository) GuestReservation(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.guestReservationFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	m.renderGuestReservation(w, r, res, forms.New(nil))
+}
+
+// moves a guest's stay to new dates, if the room is free for them
+func (m *Repository) GuestPostChangeDates(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.guestReservationFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if !m.guestCanChange(w, r, res) {
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("start", "end")
+
+	layout := "2006-01-02"
+	startDate, err := time.Parse(layout, form.Get("start"))
+	if err != nil {
+		form.Errors.Add("start", "Choose an arrival date")
+	}
+	endDate, err := time.Parse(layout, form.Get("end"))
+	if err != nil {
+		form.Errors.Add("end", "Choose a departure date")
+	}
+	if form.Valid() {
+		if !endDate.After(startDate) {
+			form.Errors.Add("end", "Departure has to be after arrival")
+		}
+		if startDate.Before(today()) {
+			form.Errors.Add("start", "Arrival can't be in the past")
+		}
+	}
+
+	if !form.Valid() {
+		m.renderGuestReservation(w, r, res, form)
+		return
+	}
+
+	if startDate.Equal(res.StartDate) && endDate.Equal(res.EndDate) {
+		m.App.Session.Put(r.Context(), "flash", "Those are already your dates")
+		http.Redirect(w, r, "/my-reservation/"+chi.URLParam(r, "token"), http.StatusSeeOther)
+		return
+	}
+
+	room, err := m.DB.GetRoomByID(r.Context(), res.RoomID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	// the same checks as a new booking, since the room's rules or size may have changed since they booked
+	problems, err := m.bookingProblems(r.Context(), room, startDate, endDate, res.Guests())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	if len(problems) > 0 {
+		form.Errors.Add("start", stayrules.Explain(problems))
+		m.renderGuestReservation(w, r, res, form)
+		return
+	}
+
+	// the new dates are priced at today's rates
+	price, err := m.quoteStay(r.Context(), room, startDate, endDate)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	err = m.DB.ChangeReservationDates(r.Context(), res.ID, startDate, endDate, price)
+	if err != nil {
+		var conflict *repository.ConflictError
+		if errors.As(err, &conflict) {
+			form.Errors.Add("start", "Sorry, the room isn't free for those dates")
+			m.renderGuestReservation(w, r, res, form)
+			return
+		}
+		helpers.ServerError(w, err)
+		return
+	}
+
+	previous := res
+	res.StartDate = startDate
+	res.EndDate = endDate
+	res.Price = price
+	res.DateChanges++
+
+	// the link depends on the dates, so the guest gets a new one and the old one stops working
+	token := m.guestToken(res)
+
+	err = m.queueMail(r.Context(), res.Email, mailer.DatesChanged{Reservation: res, ManageURL: m.guestURL(token)})
+	if err != nil {
+		helpers.LogError(err)
+	}
+
+	err = m.queueMail(r.Context(), bookingsEmail, mailer.OwnerDatesChanged{Reservation: res, Previous: previous})
+	if err != nil {
+		helpers.LogError(err)
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Your reservation has been changed, we've emailed you the details")
+	http.Redirect(w, r, "/my-reservation/"+token, http.StatusSeeOther)
+}
+
+// cancels a guest's reservation, which frees the room for its dates
+func (m *Repository) GuestPostCancel(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.guestReservationFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if !m.guestCanChange(w, r, res) {
+		return
+	}
+
+	err := m.DB.UpdateReservationStatus(r.Context(), res.ID, models.ReservationCancelled, 0)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	err = m.queueMail(r.Context(), res.Email, mailer.Cancellation{Reservation: res})
+	if err != nil {
+		helpers.LogError(err)
+	}
+
+	err = m.queueMail(r.Context(), bookingsEmail, mailer.OwnerCancellation{Reservation: res})
+	if err != nil {
+		helpers.LogError(err)
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Your reservation has been cancelled")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// looks up the reservation from the {token} url parameter. If it returns false, a response has already been written
+func (m *Repository) guestReservationFromRequest(w http.ResponseWriter, r *http.Request) (models.Reservation, bool) {
+	id, stamp, err := guestlink.Verify([]byte(m.App.LinkSecret), chi.URLParam(r, "token"), time.Now())
+	if errors.Is(err, guestlink.ErrExpired) {
+		m.App.Session.Put(r.Context(), "error", "That link has expired, please contact us about your reservation")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return models.Reservation{}, false
+	} else if err != nil {
+		http.NotFound(w, r)
+		return models.Reservation{}, false
+	}
+
+	res, err := m.DB.GetReservationByID(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		m.App.Session.Put(r.Context(), "error", "We couldn't find that reservation, please contact us about it")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return res, false
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return res, false
+	}
+
+	// links from before the dates were changed don't work any more
+	if stamp != guestStamp(res) {
+		m.App.Session.Put(r.Context(), "error", "That link is for dates that have since changed, please use the link in your latest email")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return res, false
+	}
+
+	return res, true
+}
+
+// guests can only change a stay that hasn't started yet and could still be cancelled.
+// If it returns false, a response has already been written
+func (m *Repository) guestCanChange(w http.ResponseWriter, r *http.Request, res models.Reservation) bool {
+	if canGuestChange(res) {
+		return true
+	}
+
+	if res.Status == models.ReservationCancelled {
+		m.App.Session.Put(r.Context(), "error", "This reservation has been cancelled")
+	} else {
+		m.App.Session.Put(r.Context(), "error", "Your stay has already started, please contact us to change it")
+	}
+	http.Redirect(w, r, "/my-reservation/"+chi.URLParam(r, "token"), http.StatusSeeOther)
+	return false
+}
+
+func canGuestChange(res models.Reservation) bool {
+	return res.StartDate.After(today()) && models.CanChangeReservationStatus(res.Status, models.ReservationCancelled)
+}
+
+func (m *Repository) renderGuestReservation(w http.ResponseWriter, r *http.Request, res models.Reservation, form *forms.Form) {
+	data := make(map[string]interface{})
+	data["reservation"] = res
+	data["can_change"] = canGuestChange(res)
+
+	stringMap := make(map[string]string)
+	stringMap["start_date"] = res.StartDate.Format("2006-01-02")
+	stringMap["end_date"] = res.EndDate.Format("2006-01-02")
+	stringMap["token"] = chi.URLParam(r, "token")
+
+	render.Template(w, r, "guest-reservation.page.html", &models.TemplateData{
+		Data:      data,
+		StringMap: stringMap,
+		Form:      form,
+	})
+}
+
+// returns the token for a guest's link to their reservation. It works until app.GuestLinkTTL after they check out,
+// or until the dates change
+func (m *Repository) guestToken(res models.Reservation) string {
+	return guestlink.Sign([]byte(m.App.LinkSecret), res.ID, guestStamp(res), res.EndDate.Add(m.App.GuestLinkTTL))
+}
+
+// ties a guest link to the dates of the stay it was sent for. The count of changes only goes up, so moving the stay
+// back to earlier dates doesn't bring back the links sent for them
+func guestStamp(res models.Reservation) string {
+	return fmt.Sprintf("%s-%s-%d", res.StartDate.Format("20060102"), res.EndDate.Format("20060102"), res.DateChanges)
+}
+
+// returns the full url of a guest link, for emails
+func (m *Repository) guestURL(token string) string {
+	return fmt.Sprintf("%s/my-reservation/%s", m.App.SiteURL, token)
+}
+
+// midnight at the start of today, in UTC like the dates of a stay
+func today() time.Time {
+	y, mo, d := time.Now().Date()
+	return time.Date(y, mo, d, 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andkolbe/bookings/internal/guestlink"
+	"github.com/andkolbe/bookings/internal/models"
+	"github.com/andkolbe/bookings/internal/repository"
+	"github.com/go-chi/chi/v5"
+)
+
+// a guest link token for the reservation's current dates that works for another day
+func testGuestToken(id int) string {
+	res, _ := Repo.DB.GetReservationByID(context.Background(), id)
+	return guestlink.Sign([]byte(app.LinkSecret), id, guestStamp(res), time.Now().Add(24*time.Hour))
+}
+
+func TestRepository_GuestReservation(t *testing.T) {
+	mux := chi.NewRouter()
+	mux.Get("/my-reservation/{token}", Repo.GuestReservation)
+
+	var tests = []struct {
+		name               string
+		token              string
+		expectedStatusCode int
+		expectedLocation   string
+	}{
+		{"valid", testGuestToken(1), http.StatusOK, ""},
+		{"tampered", testGuestToken(1) + "x", http.StatusNotFound, ""},
+		{"expired", guestlink.Sign([]byte(app.LinkSecret), 1, "20500101-20500103-0", time.Now().Add(-time.Minute)), http.StatusSeeOther, "/"},
+		{"dates changed since", guestlink.Sign([]byte(app.LinkSecret), 1, "20500201-20500204-0", time.Now().Add(time.Hour)), http.StatusSeeOther, "/"},
+		{"moved back since", guestlink.Sign([]byte(app.LinkSecret), 98, "20500101-20500103-0", time.Now().Add(time.Hour)), http.StatusSeeOther, "/"},
+		{"after moving back", testGuestToken(98), http.StatusOK, ""},
+		{"cancelled", testGuestToken(101), http.StatusSeeOther, "/"},
+	}
+
+	for _, e := range tests {
+		req := httptest.NewRequest("GET", "/my-reservation/"+e.token, nil)
+		req = req.WithContext(getCtx(req))
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected %d, got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if e.expectedLocation != "" {
+			if loc, _ := rr.Result().Location(); loc == nil || loc.String() != e.expectedLocation {
+				t.Errorf("%s: expected redirect to %s, got %v", e.name, e.expectedLocation, loc)
+			}
+		}
+	}
+}
+
+func TestRepository_GuestPostChangeDates(t *testing.T) {
+	var tests = []struct {
+		name               string
+		id                 int
+		start              string
+		end                string
+		adults             int
+		expectedStatusCode int
+		expectedLocation   string
+		expectedMail       int
+	}{
+		{"moved", 1, "2050-02-01", "2050-02-04", 2, http.StatusSeeOther, "/my-reservation/", 2},
+		{"unchanged", 1, "2050-01-01", "2050-01-03", 2, http.StatusSeeOther, "/my-reservation/" + testGuestToken(1), 0},
+		{"room taken", 1, "2050-12-31", "2051-01-02", 2, http.StatusOK, "", 0},
+		{"backwards", 1, "2050-02-04", "2050-02-01", 2, http.StatusOK, "", 0},
+		{"in the past", 1, "2020-02-01", "2020-02-04", 2, http.StatusOK, "", 0},
+		{"not a date", 1, "soon", "2050-02-04", 2, http.StatusOK, "", 0},
+		{"too many for the room", 1, "2050-02-01", "2050-02-04", 3, http.StatusOK, "", 0},
+		{"already started", 100, "2050-02-01", "2050-02-04", 2, http.StatusSeeOther, "/my-reservation/" + testGuestToken(100), 0},
+	}
+
+	for _, e := range tests {
+		mail := &recordingRepo{DatabaseRepo: partyRepo{DatabaseRepo: Repo.DB, adults: e.adults}}
+		repo := *Repo
+		repo.DB = mail
+
+		postedData := url.Values{}
+		postedData.Add("start", e.start)
+		postedData.Add("end", e.end)
+
+		token := testGuestToken(e.id)
+		req, _ := http.NewRequest("POST", "/my-reservation/"+token+"/change", strings.NewReader(postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("token", token)
+		req = req.WithContext(context.WithValue(getCtx(req), chi.RouteCtxKey, rctx))
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(repo.GuestPostChangeDates).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected %d, got %d", e.name, e.expectedStatusCode, rr.Code)
+			continue
+		}
+		if e.expectedLocation != "" {
+			if loc := rr.Header().Get("Location"); !strings.HasPrefix(loc, e.expectedLocation) {
+				t.Errorf("%s: expected redirect to %s, got %s", e.name, e.expectedLocation, loc)
+			}
+		}
+		if len(mail.mail) != e.expectedMail {
+			t.Errorf("%s: expected %d emails, got %d", e.name, e.expectedMail, len(mail.mail))
+		}
+	}
+}
+
+func TestRepository_GuestPostCancel(t *testing.T) {
+	var tests = []struct {
+		name             string
+		id               int
+		expectedLocation string
+		expectedMail     []string
+	}{
+		{"cancelled", 1, "/", []string{"me@here.com", bookingsEmail}},
+		{"already started", 100, "/my-reservation/", nil},
+	}
+
+	for _, e := range tests {
+		mail := &recordingRepo{DatabaseRepo: Repo.DB}
+		repo := *Repo
+		repo.DB = mail
+
+		token := testGuestToken(e.id)
+		req, _ := http.NewRequest("POST", "/my-reservation/"+token+"/cancel", nil)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("token", token)
+		req = req.WithContext(context.WithValue(getCtx(req), chi.RouteCtxKey, rctx))
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(repo.GuestPostCancel).ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther || !strings.HasPrefix(rr.Header().Get("Location"), e.expectedLocation) {
+			t.Errorf("%s: expected a redirect to %s, got %d %s", e.name, e.expectedLocation, rr.Code, rr.Header().Get("Location"))
+		}
+
+		var to []string
+		for _, m := range mail.mail {
+			to = append(to, m.To)
+		}
+		if strings.Join(to, ",") != strings.Join(e.expectedMail, ",") {
+			t.Errorf("%s: expected mail to %v, got %v", e.name, e.expectedMail, to)
+		}
+	}
+}
+
+// the test repo, with every reservation for a party of adults
+type partyRepo struct {
+	repository.DatabaseRepo
+	adults int
+}
+
+func (m partyRepo) GetReservationByID(ctx context.Context, id int) (models.Reservation, error) {
+	res, err := m.DatabaseRepo.GetReservationByID(ctx, id)
+	res.Adults = m.adults
+	return res, err
+}
+
+// the test repo, remembering the mail that was queued
+type recordingRepo struct {
+	repository.DatabaseRepo
+	mail []models.MailData
+}
+
+func (r *recordingRepo) InsertMail(ctx context.Context, m models.MailData) (int, error) {
+	r.mail = append(r.mail, m)
+	return len(r.mail), nil
+}
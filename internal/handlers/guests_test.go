@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andkolbe/bookings/internal/models"
+)
+
+// the rooms in the test repo sleep 2
+func TestRepository_BookRoom_Guests(t *testing.T) {
+	var tests = []struct {
+		name             string
+		query            string
+		expectedLocation string
+		expectedError    string
+		adults           int
+		children         int
+	}{
+		{"party fits", "a=1&c=1", "/make-reservation", "", 1, 1},
+		{"no party given", "", "/make-reservation", "", 1, 0},
+		{"too many", "a=2&c=1", "/rooms/generals-quarters", "sleeps up to 2 guests", 0, 0},
+		{"no adults", "a=0", "/rooms/generals-quarters", "At least one adult", 0, 0},
+		{"children not a number", "a=1&c=abc", "/rooms/generals-quarters", "Must be a number of children", 0, 0},
+		{"negative children", "a=1&c=-1", "/rooms/generals-quarters", "Must be a number of children", 0, 0},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/book-room?s=2050-01-01&e=2050-01-02&id=1&"+e.query, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.BookRoom).ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != e.expectedLocation {
+			t.Errorf("%s: expected a redirect to %s, got %d %s", e.name, e.expectedLocation, rr.Code, rr.Header().Get("Location"))
+			continue
+		}
+		if msg := session.GetString(ctx, "error"); !strings.Contains(msg, e.expectedError) {
+			t.Errorf("%s: expected the error %q, got %q", e.name, e.expectedError, msg)
+		}
+		if e.adults > 0 {
+			res, _ := session.Get(ctx, "reservation").(models.Reservation)
+			if res.Adults != e.adults || res.Children != e.children {
+				t.Errorf("%s: expected %d adults and %d children in the session, got %+v", e.name, e.adults, e.children, res)
+			}
+		}
+	}
+}
+
+func TestRepository_PostAvailability_Guests(t *testing.T) {
+	var tests = []struct {
+		name          string
+		adults        string
+		children      string
+		expectedError string
+	}{
+		{"no adults", "0", "", "At least one adult"},
+		{"adults not a number", "two", "", "At least one adult"},
+		{"children not a number", "1", "abc", "Must be a number of children"},
+		{"negative children", "1", "-1", "Must be a number of children"},
+	}
+
+	for _, e := range tests {
+		postedData := url.Values{}
+		postedData.Add("start", "2040-01-01")
+		postedData.Add("end", "2040-01-02")
+		postedData.Add("adults", e.adults)
+		postedData.Add("children", e.children)
+
+		req, _ := http.NewRequest("POST", "/search-availability", strings.NewReader(postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.ParseForm()
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.PostAvailability).ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/search-availability" {
+			t.Errorf("%s: expected the search to be refused, got %d %s", e.name, rr.Code, rr.Header().Get("Location"))
+		}
+		if msg := session.GetString(ctx, "error"); !strings.Contains(msg, e.expectedError) {
+			t.Errorf("%s: expected the error %q, got %q", e.name, e.expectedError, msg)
+		}
+	}
+}
+
+func TestRepository_PostReservation_TooManyGuests(t *testing.T) {
+	reservation := models.Reservation{
+		RoomID:    1,
+		StartDate: time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2050, 1, 2, 0, 0, 0, 0, time.UTC),
+		Adults:    2,
+		Children:  2,
+	}
+
+	postedData := url.Values{}
+	postedData.Add("first_name", "John")
+	postedData.Add("last_name", "Smith")
+	postedData.Add("email", "john@smith.com")
+	postedData.Add("phone", "555-555-5555")
+
+	req, _ := http.NewRequest("POST", "/make-reservation", strings.NewReader(postedData.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	session.Put(ctx, "reservation", reservation)
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.PostReservation).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/search-availability" {
+		t.Errorf("expected a redirect to the search, got %d %s", rr.Code, rr.Header().Get("Location"))
+	}
+	if msg := session.GetString(ctx, "error"); !strings.Contains(msg, "sleeps up to 2 guests") {
+		t.Errorf("expected the guest to be told the room is too small, got %q", msg)
+	}
+}
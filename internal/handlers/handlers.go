@@ -1,11 +1,14 @@
 package handlers
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -14,20 +17,29 @@ import (
 	"github.com/andkolbe/bookings/internal/driver"
 	"github.com/andkolbe/bookings/internal/forms"
 	"github.com/andkolbe/bookings/internal/helpers"
+	"github.com/andkolbe/bookings/internal/mailer"
 	"github.com/andkolbe/bookings/internal/models"
 	"github.com/andkolbe/bookings/internal/render"
 	"github.com/andkolbe/bookings/internal/repository"
 	"github.com/andkolbe/bookings/internal/repository/dbrepo"
+	"github.com/andkolbe/bookings/internal/stayrules"
 	"github.com/go-chi/chi/v5"
 )
 
 // the repository used by the handlers
 var Repo *Repository
 
+// where the email templates live
+var pathToMailTemplates = "./email-templates"
+
+// the address our emails come from, and where notifications for the property owner go
+const bookingsEmail = "bnbbooking@gmail.com"
+
 // the repository type
 type Repository struct {
-	App *config.AppConfig
-	DB  repository.DatabaseRepo
+	App  *config.AppConfig
+	DB   repository.DatabaseRepo
+	Mail *mailer.Templates
 }
 
 // creates a new repository
@@ -35,15 +47,17 @@ type Repository struct {
 // the Repository type is populated with all of the info received as parameters and it handed back as a pointer to Repository
 func NewRepo(a *config.AppConfig, db *driver.DB) *Repository {
 	return &Repository{
-		App: a,
-		DB:  dbrepo.NewPostgresRepo(db.SQL, a),
+		App:  a,
+		DB:   dbrepo.NewPostgresRepo(db.SQL, a),
+		Mail: mailer.NewTemplates(pathToMailTemplates, a.UseCache),
 	}
 }
 
 func NewTestRepo(a *config.AppConfig) *Repository {
 	return &Repository{
-		App: a,
-		DB:  dbrepo.NewTestingRepo(a),
+		App:  a,
+		DB:   dbrepo.NewTestingRepo(a),
+		Mail: mailer.NewTemplates(pathToMailTemplates, a.UseCache),
 	}
 }
 
@@ -74,7 +88,7 @@ func (m *Repository) Reservation(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// get the room id off of the session and check if it is valid
-	room, err := m.DB.GetRoomByID(res.RoomID)
+	room, err := m.DB.GetRoomByID(r.Context(), res.RoomID)
 	if err != nil {
 		m.App.Session.Put(r.Context(), "error", "can't find room")
 		http.Redirect(w, r, "/", http.StatusSeeOther)
@@ -83,6 +97,12 @@ func (m *Repository) Reservation(w http.ResponseWriter, r *http.Request) {
 
 	res.Room.RoomName = room.RoomName
 
+	res.Price, err = m.quoteStay(r.Context(), room, res.StartDate, res.EndDate)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
 	m.App.Session.Put(r.Context(), "reservation", res)
 
 	sd := res.StartDate.Format("2006-01-02")
@@ -131,9 +151,7 @@ func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 	form := forms.New(r.PostForm) // PostForm has all of the url values and their associated data
 
 	// check if the incoming form has all of the fields filled out
-	form.Required("first_name", "last_name", "email")
-	form.MinLength("first_name", 3)
-	form.IsEmail("email")
+	validateReservationForm(form)
 
 	// if one of the fields on the form is not valid, repopulate the form with the data they entered and display the error message where it needs to be
 	if !form.Valid() {
@@ -147,60 +165,49 @@ func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// save data to db and get a reservation back
-	newReservationID, err := m.DB.InsertReservation(reservation)
+	room, err := m.DB.GetRoomByID(r.Context(), reservation.RoomID)
 	if err != nil {
-		m.App.Session.Put(r.Context(), "error", "can't insert reservation into db")
+		m.App.Session.Put(r.Context(), "error", "can't find room")
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
 	}
 
-	restriction := models.RoomRestriction{
-		StartDate:     reservation.StartDate,
-		EndDate:       reservation.EndDate,
-		RoomID:        reservation.RoomID,
-		ReservationID: newReservationID,
-		RestrictionID: 1,
+	problems, err := m.bookingProblems(r.Context(), room, reservation.StartDate, reservation.EndDate, reservation.Guests())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	if len(problems) > 0 {
+		m.App.Session.Remove(r.Context(), "reservation")
+		m.App.Session.Put(r.Context(), "error", "Sorry, you can't book that stay. "+stayrules.Explain(problems)+" Please search again.")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
 	}
 
-	err = m.DB.InsertRoomRestriction(restriction)
+	// price the stay again, in case the rates changed while the guest filled in the form
+	reservation.Price, err = m.quoteStay(r.Context(), room, reservation.StartDate, reservation.EndDate)
 	if err != nil {
-		m.App.Session.Put(r.Context(), "error", "can't insert room restriction")
-		http.Redirect(w, r, "/", http.StatusSeeOther)
+		helpers.ServerError(w, err)
 		return
 	}
 
-	// send notifications - to guest
-	htmlMessage := fmt.Sprintf(`
-		<strong>Reservation Confirmation</strong><br>
-		Dear %s, <br>
-		This is to confirm your reservation from %s to %s.
-	`, reservation.FirstName, reservation.StartDate.Format("2006-01-02"), reservation.EndDate.Format("2006-01-02"))
-
-	msg := models.MailData{
-		To:       reservation.Email,
-		From:     "bnbbooking@gmail.com",
-		Subject:  "Reservation Confirmation",
-		Content:  htmlMessage,
-		Template: "basic.html",
+	// save the reservation and block the room for its dates in one go, so the room can't be double booked
+	newReservationID, err := m.DB.CreateReservation(r.Context(), reservation)
+	if err != nil {
+		var conflict *repository.ConflictError
+		if errors.As(err, &conflict) {
+			m.App.Session.Remove(r.Context(), "reservation")
+			m.App.Session.Put(r.Context(), "error", "Sorry, that room was just booked by someone else for those dates. Please search again.")
+			http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+			return
+		}
+		m.App.Session.Put(r.Context(), "error", "can't insert reservation into db")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
 	}
-	// pass the msg into the app.MailChan channel
-	m.App.MailChan <- msg
-
-	// send notifications - to property owner
-	htmlMessage = fmt.Sprintf(`
-		<strong>Reservation Notification</strong><br>
-		A reservation has been made for %s from %s to %s.
-	`, reservation.Room.RoomName, reservation.StartDate.Format("2006-01-02"), reservation.EndDate.Format("2006-01-02"))
+	reservation.ID = newReservationID
 
-	msg = models.MailData{
-		To:      "bnbbooking@gmail.com",
-		From:    "bnbbooking@gmail.com",
-		Subject: "Reservation Notification",
-		Content: htmlMessage,
-	}
-	// pass the msg into the app.MailChan channel
-	m.App.MailChan <- msg
+	m.sendBookingMail(r.Context(), reservation)
 
 	m.App.Session.Put(r.Context(), "reservation", reservation)
 
@@ -209,14 +216,20 @@ func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/reservation-summary", http.StatusSeeOther)
 }
 
-// generals room page handler
-func (m *Repository) Generals(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, r, "generals.page.html", &models.TemplateData{})
-}
+// emails the guest their confirmation and lets the owner know about a new reservation. The booking is already made,
+// so a mail problem shouldn't fail the request. The confirmation has the guest's link back to their reservation,
+// since it leaves the session on the summary page
+func (m *Repository) sendBookingMail(ctx context.Context, reservation models.Reservation) {
+	manageURL := m.guestURL(m.guestToken(reservation))
+	err := m.queueMail(ctx, reservation.Email, mailer.Confirmation{Reservation: reservation, ManageURL: manageURL})
+	if err != nil {
+		helpers.LogError(err)
+	}
 
-// majors room page handler
-func (m *Repository) Majors(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, r, "majors.page.html", &models.TemplateData{})
+	err = m.queueMail(ctx, bookingsEmail, mailer.OwnerNotification{Reservation: reservation})
+	if err != nil {
+		helpers.LogError(err)
+	}
 }
 
 // room availability page handler
@@ -241,30 +254,75 @@ func (m *Repository) PostAvailability(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	rooms, err := m.DB.SearchAvailabilityForAllRooms(startDate, endDate)
+	form := forms.New(r.Form)
+	adults, children := validateGuests(form, "adults", "children")
+	if !form.Valid() {
+		m.App.Session.Put(r.Context(), "error", guestsError(form, "adults", "children"))
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	}
+
+	// rooms too small for the party are left out
+	rooms, err := m.DB.SearchAvailabilityForAllRooms(r.Context(), startDate, endDate, adults+children)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	// a free room can still have rules the stay doesn't meet, like a minimum number of nights
+	rooms, rejected, err := m.allowedRooms(r.Context(), rooms, startDate, endDate)
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
 	}
 
 	if len(rooms) == 0 {
+		if len(rejected) > 0 {
+			// tell the guest what they would need to change to get a room
+			var names []string
+			for name := range rejected {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			var problems []string
+			for _, name := range names {
+				problems = append(problems, rejected[name]...)
+			}
+			m.App.Session.Put(r.Context(), "error", "No availability for those dates. "+stayrules.Explain(uniqueStrings(problems)))
+			http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+			return
+		}
 		// neither room is available
 		m.App.Session.Put(r.Context(), "error", "No availability")
 		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
 		return
 	}
 
+	// what each room would cost for the stay, by room id
+	quotes := make(map[int]models.Quote)
+	for _, room := range rooms {
+		quotes[room.ID], err = m.quoteStay(r.Context(), room, startDate, endDate)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+	}
+
 	// create a data variable that is of string interface
 	data := make(map[string]interface{})
 	// store the rooms in that map
 	data["rooms"] = rooms
+	data["quotes"] = quotes
+	data["rejected"] = rejected
 
 	res := models.Reservation{
 		StartDate: startDate,
 		EndDate:   endDate,
+		Adults:    adults,
+		Children:  children,
 	}
 
-	// store the start and end dates in the session
+	// store the start and end dates and the party in the session
 	m.App.Session.Put(r.Context(), "reservation", res)
 
 	// pass the data to the template choose-room
@@ -280,6 +338,8 @@ type jsonResponse struct {
 	RoomID    string `json:"room_id"`
 	StartDate string `json:"start_date"`
 	EndDate   string `json:"end_date"`
+	Adults    int    `json:"adults"`
+	Children  int    `json:"children"`
 }
 
 // handles request for availability and sends JSON response
@@ -307,8 +367,32 @@ func (m *Repository) AvailabilityJSON(w http.ResponseWriter, r *http.Request) {
 	endDate, _ := time.Parse(layout, ed)
 
 	roomID, _ := strconv.Atoi(r.Form.Get("room_id"))
+	form := forms.New(r.Form)
+	adults, children := validateGuests(form, "adults", "children")
+	if !form.Valid() {
+		resp := jsonResponse{
+			OK:      false,
+			Message: guestsError(form, "adults", "children"),
+		}
+
+		out, _ := json.MarshalIndent(resp, "", "     ")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(out)
+		return
+	}
 
-	available, _ := m.DB.SearchAvailabilityByDatesByRoomID(startDate, endDate, roomID)
+	available, err := m.DB.SearchAvailabilityByDatesByRoomID(r.Context(), startDate, endDate, roomID)
+	var problems []string
+	if err == nil && available {
+		problems, err = m.stayProblems(r.Context(), roomID, startDate, endDate)
+	}
+	if err == nil && available {
+		var room models.Room
+		room, err = m.DB.GetRoomByID(r.Context(), roomID)
+		if err == nil && adults+children > room.Capacity {
+			problems = append(problems, tooManyGuests(room))
+		}
+	}
 	if err != nil {
 		// can't parse form, so return appropriate JSON
 		resp := jsonResponse{
@@ -324,11 +408,13 @@ func (m *Repository) AvailabilityJSON(w http.ResponseWriter, r *http.Request) {
 
 	// create the struct type in a reusable variable
 	resp := jsonResponse{
-		OK:        available,
-		Message:   "",
+		OK:        available && len(problems) == 0,
+		Message:   stayrules.Explain(problems),
 		StartDate: sd,
 		EndDate:   ed,
 		RoomID:    strconv.Itoa(roomID),
+		Adults:    adults,
+		Children:  children,
 	}
 
 	// marshall the resp into json
@@ -388,7 +474,7 @@ func (m *Repository) ChooseRoom(w http.ResponseWriter, r *http.Request) {
 
 	res, ok := m.App.Session.Get(r.Context(), "reservation").(models.Reservation)
 	if !ok {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, errors.New("can't get reservation from session"))
 		return
 	}
 
@@ -409,19 +495,34 @@ func (m *Repository) BookRoom(w http.ResponseWriter, r *http.Request) {
 	layout := "2006-01-02"
 	startDate, _ := time.Parse(layout, sd)
 	endDate, _ := time.Parse(layout, ed)
+	form := forms.New(r.URL.Query())
+	adults, children := validateGuests(form, "a", "c")
 
 	var res models.Reservation
 
-	room, err := m.DB.GetRoomByID(roomID)
+	room, err := m.DB.GetRoomByID(r.Context(), roomID)
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
 	}
 
+	if !form.Valid() {
+		m.App.Session.Put(r.Context(), "error", guestsError(form, "a", "c"))
+		http.Redirect(w, r, "/rooms/"+room.Slug, http.StatusSeeOther)
+		return
+	}
+	if adults+children > room.Capacity {
+		m.App.Session.Put(r.Context(), "error", tooManyGuests(room))
+		http.Redirect(w, r, "/rooms/"+room.Slug, http.StatusSeeOther)
+		return
+	}
+
 	res.Room.RoomName = room.RoomName
 	res.RoomID = roomID
 	res.StartDate = startDate
 	res.EndDate = endDate
+	res.Adults = adults
+	res.Children = children
 
 	// store the data in the session
 	m.App.Session.Put(r.Context(), "reservation", res)
@@ -464,17 +565,54 @@ func (m *Repository) PostShowLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	id, _, err := m.DB.Authenticate(email, password)
+	// the password isn't even checked while the account or ip address has to wait, so guessing can't go faster
+	wait, err := m.loginWait(r, email)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	if wait > 0 {
+		m.App.Session.Put(r.Context(), "error", "Too many failed logins. Try again in "+waitText(wait))
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	id, _, err := m.DB.Authenticate(r.Context(), email, password)
 	if err != nil {
 		log.Println(err)
 
+		err = m.recordLoginFailure(r, email)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
 		m.App.Session.Put(r.Context(), "error", "Invalid login credentials")
 		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
 		return
 	}
 
-	// if they are successfully authenticated, we store their id in the session
-	m.App.Session.Put(r.Context(), "user_id", id)
+	user, err := m.DB.GetUserByID(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	// users with two-factor login turned on aren't logged in until they have entered a code too
+	if user.TwoFactor() {
+		m.App.Session.Put(r.Context(), "two_factor_user_id", id)
+		m.App.Session.Put(r.Context(), "two_factor_started_at", int(time.Now().Unix()))
+		http.Redirect(w, r, "/user/login/two-factor", http.StatusSeeOther)
+		return
+	}
+
+	err = m.clearLoginFailures(r, email)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.logIn(r, user)
 	m.App.Session.Put(r.Context(), "flash", "Logged in successfully")
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 
@@ -484,6 +622,15 @@ func (m *Repository) PostShowLogin(w http.ResponseWriter, r *http.Request) {
 	// return
 }
 
+// stores who is logged in in the session, once they have got through every step of logging in
+func (m *Repository) logIn(r *http.Request, user models.User) {
+	// if they are successfully authenticated, we store their id and access level in the session
+	m.App.Session.Put(r.Context(), "user_id", user.ID)
+	m.App.Session.Put(r.Context(), "access_level", user.AccessLevel)
+	// so the session can be ended if the password changes after this
+	m.App.Session.Put(r.Context(), "logged_in_at", int(time.Now().Unix()))
+}
+
 // logs a user out
 func (m *Repository) Logout(w http.ResponseWriter, r *http.Request) {
 	// destroy the entire session data
@@ -501,7 +648,7 @@ func (m *Repository) AdminDashboard(w http.ResponseWriter, r *http.Request) {
 // shows all new reservations in admin tool
 func (m *Repository) AdminNewReservations(w http.ResponseWriter, r *http.Request) {
 	// get our reservations from the db
-	reservations, err := m.DB.AllNewReservations()
+	reservations, err := m.DB.AllNewReservations(r.Context())
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
@@ -517,8 +664,14 @@ func (m *Repository) AdminNewReservations(w http.ResponseWriter, r *http.Request
 
 // shows all reservations in admin tool
 func (m *Repository) AdminAllReservations(w http.ResponseWriter, r *http.Request) {
+	// the status query parameter narrows the list down to one status
+	status := r.URL.Query().Get("status")
+	if !validReservationStatus(status) {
+		status = ""
+	}
+
 	// get our reservations from the db
-	reservations, err := m.DB.AllReservations()
+	reservations, err := m.DB.AllReservations(r.Context(), status)
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
@@ -526,9 +679,14 @@ func (m *Repository) AdminAllReservations(w http.ResponseWriter, r *http.Request
 
 	data := make(map[string]interface{})
 	data["reservations"] = reservations
+	data["statuses"] = models.ReservationStatuses
+
+	stringMap := make(map[string]string)
+	stringMap["status"] = status
 
 	render.Template(w, r, "admin-all-reservations.page.html", &models.TemplateData{
-		Data: data,
+		Data:      data,
+		StringMap: stringMap,
 	})
 }
 
@@ -545,23 +703,58 @@ func (m *Repository) AdminShowReservation(w http.ResponseWriter, r *http.Request
 
 	stringMap := make(map[string]string)
 	stringMap["src"] = src
+	// set when we came from the calendar, so we can go back to the same month
+	stringMap["year"] = r.URL.Query().Get("y")
+	stringMap["month"] = r.URL.Query().Get("m")
 
 	// get our reservations from the db
-	res, err := m.DB.GetReservationByID(id)
+	res, err := m.DB.GetReservationByID(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	history, err := m.DB.ReservationStatusHistory(r.Context(), id)
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
 	}
 
 	data := make(map[string]interface{})
-	data["reservations"] = res
+	data["reservation"] = res
+	data["history"] = history
+	data["next_statuses"] = models.NextReservationStatuses(res.Status)
 
 	render.Template(w, r, "admin-reservations-show.page.html", &models.TemplateData{
-		Data: data,
-		Form: forms.New(nil),
+		Data:      data,
+		StringMap: stringMap,
+		Form:      forms.New(nil),
 	})
 }
 
+// returns strs without repeats, in the order they first appear
+func uniqueStrings(strs []string) []string {
+	seen := make(map[string]bool)
+	var out []string
+	for _, s := range strs {
+		if !seen[s] {
+			seen[s] = true
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// reports whether status is one of models.ReservationStatuses
+func validReservationStatus(status string) bool {
+	for _, s := range models.ReservationStatuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
 func (m *Repository) AdminPostShowReservation(w http.ResponseWriter, r *http.Request) {
 	// need to parse request body to be able to write a test for it
 	err := r.ParseForm()
@@ -581,9 +774,12 @@ func (m *Repository) AdminPostShowReservation(w http.ResponseWriter, r *http.Req
 
 	stringMap := make(map[string]string)
 	stringMap["src"] = src
+	// set when we came from the calendar, so we can go back to the same month
+	stringMap["year"] = r.URL.Query().Get("y")
+	stringMap["month"] = r.URL.Query().Get("m")
 
 	// get our reservations from the db
-	res, err := m.DB.GetReservationByID(id)
+	res, err := m.DB.GetReservationByID(r.Context(), id)
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
@@ -594,7 +790,7 @@ func (m *Repository) AdminPostShowReservation(w http.ResponseWriter, r *http.Req
 	res.Email = r.Form.Get("email")
 	res.Phone = r.Form.Get("phone")
 
-	err = m.DB.UpdateReservation(res)
+	err = m.DB.UpdateReservation(r.Context(), res)
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
@@ -625,6 +821,11 @@ func (m *Repository) AdminReservationsCalendar(w http.ResponseWriter, r *http.Re
 		now = time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC)
 	}
 
+	m.renderReservationsCalendar(w, r, now, forms.New(nil))
+}
+
+// renders the reservation calendar for the month now is in, with form as the form for a new block
+func (m *Repository) renderReservationsCalendar(w http.ResponseWriter, r *http.Request, now time.Time, form *forms.Form) {
 	data := make(map[string]interface{})
 	data["now"] = now
 
@@ -660,7 +861,7 @@ func (m *Repository) AdminReservationsCalendar(w http.ResponseWriter, r *http.Re
 	intMap["days_in_month"] = lastOfMonth.Day()
 
 	// look up rooms
-	rooms, err := m.DB.AllRooms()
+	rooms, err := m.DB.AllRooms(r.Context())
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
@@ -670,149 +871,243 @@ func (m *Repository) AdminReservationsCalendar(w http.ResponseWriter, r *http.Re
 
 	// loop through the rooms
 	for _, x := range rooms {
-		reservationMap := make(map[string]int)
-		blockMap := make(map[string]int)
-
-		// make sure there is one entry for every day in the current month in both maps
-		// start at the first day of the month, go until after the last day of the month, and at every tick of the loop, add one to the current day
-		for d := firstOfMonth; d.After(lastOfMonth) == false; d = d.AddDate(0, 0, 1) {
-			// add a default value to the maps at every tick for the current day
-			reservationMap[d.Format("2006-01-2")] = 0 // 0 means the room is available
-			blockMap[d.Format("2006-01-2")] = 0       // 0 means the room is available
-		}
-
 		// get all the restrictions for the current room
-		restrictions, err := m.DB.GetRestrictionsForRoomByDate(x.ID, firstOfMonth, lastOfMonth)
+		restrictions, err := m.DB.GetRestrictionsForRoomByDate(r.Context(), x.ID, firstOfMonth, lastOfMonth)
 		if err != nil {
 			helpers.ServerError(w, err)
 			return
 		}
 
-		// loop through restrictions and determine whether it is a reservation or block
-		for _, y := range restrictions {
-			// if reservation id is greater than 0, it is a reservation, otherwise, it is a block
-			if y.ReservationID > 0 {
-				// it's a reservation
-				for d := y.StartDate; d.After(y.EndDate) == false; d = d.AddDate(0, 0, 1) {
-					reservationMap[d.Format("2006-01-2")] = y.ReservationID
-				}
-			} else {
-				// it's a block
-				blockMap[y.StartDate.Format("2006-01-2")] = y.ID
-			}
-		}
-		// pull the reservations and blocks out so we can put them in the template
-		data[fmt.Sprintf("reservation_map_%d", x.ID)] = reservationMap
-		data[fmt.Sprintf("block_map_%d", x.ID)] = blockMap
-
-		// store the blockMap in the session
-		m.App.Session.Put(r.Context(), fmt.Sprintf("block_map_%d", x.ID), blockMap)
+		// turn the reservations and blocks into the cells of the room's row so we can put them in the template
+		data[fmt.Sprintf("calendar_%d", x.ID)] = calendarCells(restrictions, firstOfMonth, lastOfMonth)
 	}
 
 	render.Template(w, r, "admin-reservations-calendar.page.html", &models.TemplateData{
 		StringMap: stringMap,
 		Data:      data,
 		IntMap:    intMap,
+		Form:      form,
 	})
 }
 
-// marks a reservation as processed
-func (m *Repository) AdminProcessReservation(w http.ResponseWriter, r *http.Request) {
-	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+// moves a reservation to the status in the form. Cancelling takes the owner, like deleting a reservation used to
+func (m *Repository) AdminPostReservationStatus(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
 	src := chi.URLParam(r, "src")
-	err := m.DB.UpdateProcessedForReservation(id, 1) // 1 means the reso is processed
+
+	err = r.ParseForm()
 	if err != nil {
-		log.Println(err)
+		helpers.ServerError(w, err)
+		return
 	}
+	status := r.Form.Get("status")
 
-	year := r.URL.Query().Get("y")
-	month := r.URL.Query().Get("m")
+	if status == models.ReservationCancelled && !helpers.HasAccess(r, models.AccessOwner) {
+		helpers.ClientError(w, http.StatusForbidden)
+		return
+	}
 
-	m.App.Session.Put(r.Context(), "flash", "Reservation marked as processed")
+	res, err := m.DB.GetReservationByID(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
 
-	if year == "" {
-		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-%s", src), http.StatusSeeOther)
+	userID := m.App.Session.GetInt(r.Context(), "user_id")
+	if t, ok := helpers.APIToken(r); ok {
+		userID = t.UserID
+	}
+
+	err = m.DB.UpdateReservationStatus(r.Context(), id, status, userID)
+	var statusErr *repository.StatusError
+	if errors.As(err, &statusErr) {
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("A %s reservation can't be marked %s", statusErr.From, statusErr.To))
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
 	} else {
+		if status == models.ReservationCancelled {
+			err = m.queueMail(r.Context(), res.Email, mailer.Cancellation{Reservation: res})
+			if err != nil {
+				helpers.LogError(err)
+			}
+		}
+		m.App.Session.Put(r.Context(), "flash", "Reservation marked "+status)
+	}
+
+	year := r.Form.Get("y")
+	month := r.Form.Get("m")
+
+	// back to the calendar if that is where they came from, otherwise back to the reservation
+	if year != "" {
 		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-calendar?y=%s&m=%s", year, month), http.StatusSeeOther)
+	} else {
+		http.Redirect(w, r, fmt.Sprintf("/admin/reservations/%s/%d/show", src, id), http.StatusSeeOther)
 	}
 }
 
-// deletes a reservation
-func (m *Repository) AdminDeleteReservation(w http.ResponseWriter, r *http.Request) {
-	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
-	src := chi.URLParam(r, "src")
-	_ = m.DB.DeleteReservation(id)
+// shows the logged in user's api tokens and the form to create a new one
+func (m *Repository) AdminAPITokens(w http.ResponseWriter, r *http.Request) {
+	userID := m.App.Session.GetInt(r.Context(), "user_id")
 
-	year := r.URL.Query().Get("y")
-	month := r.URL.Query().Get("m")
+	tokens, err := m.DB.APITokensForUser(r.Context(), userID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
 
-	m.App.Session.Put(r.Context(), "flash", "Reservation deleted")
+	data := make(map[string]interface{})
+	data["tokens"] = tokens
 
-	if year == "" {
-		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-%s", src), http.StatusSeeOther)
-	} else {
-		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-calendar?y=%s&m=%s", year, month), http.StatusSeeOther)
-	}
+	// a new token is only ever shown once, straight after it was created
+	stringMap := make(map[string]string)
+	stringMap["new_token"] = m.App.Session.PopString(r.Context(), "new_api_token")
 
+	render.Template(w, r, "admin-api-tokens.page.html", &models.TemplateData{
+		Data:      data,
+		StringMap: stringMap,
+		Form:      forms.New(nil),
+	})
 }
 
-// handles post of reservations calendar
-func (m *Repository) AdminPostReservationsCalendar(w http.ResponseWriter, r *http.Request) {
+// creates a new api token for the logged in user
+func (m *Repository) AdminPostAPIToken(w http.ResponseWriter, r *http.Request) {
 	err := r.ParseForm()
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
 	}
 
-	year, _ := strconv.Atoi(r.Form.Get("y"))
-	month, _ := strconv.Atoi(r.Form.Get("m"))
+	userID := m.App.Session.GetInt(r.Context(), "user_id")
+
+	form := forms.New(r.PostForm)
+	form.Required("name", "scope")
+	if s := form.Get("scope"); s != models.APIScopeRead && s != models.APIScopeWrite {
+		form.Errors.Add("scope", "Choose read or write")
+	}
+
+	if !form.Valid() {
+		tokens, err := m.DB.APITokensForUser(r.Context(), userID)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		data := make(map[string]interface{})
+		data["tokens"] = tokens
+
+		render.Template(w, r, "admin-api-tokens.page.html", &models.TemplateData{
+			Data: data,
+			Form: form,
+		})
+		return
+	}
+
+	token, hash, err := helpers.NewAPIToken()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	_, err = m.DB.InsertAPIToken(r.Context(), models.APIToken{
+		UserID:    userID,
+		Name:      form.Get("name"),
+		TokenHash: hash,
+		Scope:     form.Get("scope"),
+	})
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "new_api_token", token)
+	m.App.Session.Put(r.Context(), "flash", "Token created")
+	http.Redirect(w, r, "/admin/api-tokens", http.StatusSeeOther)
+}
 
-	// process blocks
-	rooms, err := m.DB.AllRooms()
+// revokes one of the logged in user's api tokens
+func (m *Repository) AdminRevokeAPIToken(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	userID := m.App.Session.GetInt(r.Context(), "user_id")
+
+	err = m.DB.DeleteAPIToken(r.Context(), id, userID)
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
 	}
 
-	form := forms.New(r.PostForm)
+	m.App.Session.Put(r.Context(), "flash", "Token revoked")
+	http.Redirect(w, r, "/admin/api-tokens", http.StatusSeeOther)
+}
 
-	for _, x := range rooms {
-		// Get the block map from the session. Loop through entire map, and if we have an entry in the map that does not exist
-		// in our posted data, and if the restriction id > 0, then it is a block we need to remove
-		curMap := m.App.Session.Get(r.Context(), fmt.Sprintf("block_map_%d", x.ID)).(map[string]int)
-		for name, value := range curMap {
-			// ok will be false if the value is not in the map
-			if val, ok := curMap[name]; ok {
-				// only pay attention to values > 0 and that are not in the form post
-				// the rest are just placeholders for days without blocks
-				if val > 0 {
-					if !form.Has(fmt.Sprintf("remove_block_%d_%s", x.ID, name)) {
-						// delete the restriction by id
-						err := m.DB.DeleteBlockByID(value)
-						if err != nil {
-							log.Println(err)
-						}
-					}
-				}
-			}
-		}
+// shows the emails the mail workers gave up on
+func (m *Repository) AdminFailedMail(w http.ResponseWriter, r *http.Request) {
+	mail, err := m.DB.FailedMail(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
 	}
 
-	// now handle new blocks
-	// loop through entire post form and look for any entries that have the name starts with 'add_block'
-	for name, _ := range r.PostForm {
-		if strings.HasPrefix(name, "add_block") {
-			exploded := strings.Split(name, "_")
-			roomID, _ := strconv.Atoi(exploded[2])
-			t, _ := time.Parse("2006-01-2", exploded[3])
-			// insert a new block
-			err := m.DB.InsertBlockForRoom(roomID, t)
-			if err != nil {
-				log.Println(err)
-			}
-		}
+	data := make(map[string]interface{})
+	data["mail"] = mail
+
+	render.Template(w, r, "admin-mail-failed.page.html", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// puts a failed email back in the outbox
+func (m *Repository) AdminResendMail(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
 	}
 
-	m.App.Session.Put(r.Context(), "flash", "Changes saved")
-	http.Redirect(w, r, fmt.Sprintf("/admin/reservations-calendar?y=%d&m=%d", year, month), http.StatusSeeOther)
+	err = m.DB.ResendMail(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		m.App.Session.Put(r.Context(), "error", "That email isn't waiting to be resent")
+		http.Redirect(w, r, "/admin/mail-failed", http.StatusSeeOther)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.wakeMailWorkers()
+
+	m.App.Session.Put(r.Context(), "flash", "Email queued to be resent")
+	http.Redirect(w, r, "/admin/mail-failed", http.StatusSeeOther)
+}
+
+// renders msg and saves it in the mail outbox, where the mail workers will pick it up and send it
+func (m *Repository) queueMail(ctx context.Context, to string, msg mailer.Message) error {
+	email, err := m.Mail.Build(bookingsEmail, to, msg)
+	if err != nil {
+		return err
+	}
+
+	_, err = m.DB.InsertMail(ctx, email)
+	if err != nil {
+		return err
+	}
+	m.wakeMailWorkers()
+	return nil
+}
+
+// lets the mail workers know there is something to send without waiting for them to poll.
+// If they have already been told, there is no need to tell them again
+func (m *Repository) wakeMailWorkers() {
+	select {
+	case m.App.MailQueued <- struct{}{}:
+	default:
+	}
 }
@@ -15,6 +15,7 @@ import (
 
 	"github.com/andkolbe/bookings/internal/driver"
 	"github.com/andkolbe/bookings/internal/models"
+	"github.com/go-chi/chi/v5"
 )
 
 //
@@ -33,6 +34,10 @@ var theTests = []struct {
 	{"about", "/about", "GET", http.StatusOK},
 	{"gq", "/generals-quarters", "GET", http.StatusOK},
 	{"ms", "/majors-suite", "GET", http.StatusOK},
+	{"rooms", "/rooms", "GET", http.StatusOK},
+	{"room page", "/rooms/generals-quarters", "GET", http.StatusOK},
+	{"inactive room", "/rooms/closed-room", "GET", http.StatusNotFound},
+	{"unknown room", "/rooms/nowhere", "GET", http.StatusNotFound},
 	{"sa", "/search-availability", "GET", http.StatusOK},
 	{"contact", "/contact", "GET", http.StatusOK},
 	{"non-existent", "/this/does/not/exist", "GET", http.StatusNotFound},
@@ -45,6 +50,9 @@ var theTests = []struct {
 	{"show res", "/admin/reservations/new/1/show", "GET", http.StatusOK},
 	{"show res cal", "/admin/reservations-calendar", "GET", http.StatusOK},
 	{"show res cal with params", "/admin/reservations-calendar?y=2020&m=1", "GET", http.StatusOK},
+	{"admin rooms", "/admin/rooms", "GET", http.StatusOK},
+	{"admin new room", "/admin/rooms/new", "GET", http.StatusOK},
+	{"admin show room", "/admin/rooms/1", "GET", http.StatusOK},
 }
 
 func TestHandlers(t *testing.T) {
@@ -103,8 +111,8 @@ func TestRepository_Reservation(t *testing.T) {
 	rr = httptest.NewRecorder()
 
 	handler.ServeHTTP(rr, req)
-	if rr.Code != http.StatusTemporaryRedirect {
-		t.Errorf("Reservation handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusTemporaryRedirect)
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("Reservation handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusSeeOther)
 	}
 
 	// test with non existent room
@@ -116,225 +124,105 @@ func TestRepository_Reservation(t *testing.T) {
 	session.Put(ctx, "reservation", reservation)
 
 	handler.ServeHTTP(rr, req)
-	if rr.Code != http.StatusTemporaryRedirect {
-		t.Errorf("Reservation handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusTemporaryRedirect)
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("Reservation handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusSeeOther)
 	}
 
 }
 
 func TestRepository_PostReservation(t *testing.T) {
-	// build up the request body as a string
-	// reqBody := "start_date=2050-01-01"
-	// reqBody = fmt.Sprintf("%s&%s", reqBody, "end_date=2050-01-02")
-	// reqBody = fmt.Sprintf("%s&%s", reqBody, "first_name=Andrew")
-	// reqBody = fmt.Sprintf("%s&%s", reqBody, "last_name=Kolbe")
-	// reqBody = fmt.Sprintf("%s&%s", reqBody, "email=email@andrew.com")
-	// reqBody = fmt.Sprintf("%s&%s", reqBody, "phone=1111111111")
-	// reqBody = fmt.Sprintf("%s&%s", reqBody, "room_id=1")
-
-	// another way to write this
+	validBody := "first_name=Andrew&last_name=Kolbe&email=email@andrew.com&phone=1111111111"
+
+	var tests = []struct {
+		name               string
+		roomID             int    // 0 leaves the reservation out of the session
+		reqBody            string // blank sends no body at all
+		expectedStatusCode int
+		expectedLocation   string
+	}{
+		{"valid", 1, validBody, http.StatusSeeOther, "/reservation-summary"},
+		{"no reservation in session", 0, validBody, http.StatusInternalServerError, ""},
+		{"missing post body", 1, "", http.StatusSeeOther, "/"},
+		// the dates and the room come from the reservation in the session, so the ones posted are ignored
+		{"invalid start date", 1, "start_date=invalid&" + validBody, http.StatusSeeOther, "/reservation-summary"},
+		{"invalid end date", 1, "end_date=invalid&" + validBody, http.StatusSeeOther, "/reservation-summary"},
+		{"invalid room id", 1, "room_id=invalid&" + validBody, http.StatusSeeOther, "/reservation-summary"},
+		// first name must be 3 characters long
+		{"invalid data", 1, "first_name=A&last_name=Kolbe&email=email@andrew.com&phone=1111111111", http.StatusOK, ""},
+		{"unknown room", 1000, validBody, http.StatusSeeOther, "/"},
+		{"insert fails", 2, validBody, http.StatusSeeOther, "/"},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/make-reservation", nil)
+		if e.reqBody != "" {
+			req, _ = http.NewRequest("POST", "/make-reservation", strings.NewReader(e.reqBody))
+		}
+		// tells the server to expect a POST request
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		if e.roomID != 0 {
+			session.Put(ctx, "reservation", models.Reservation{
+				RoomID:    e.roomID,
+				StartDate: time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+				EndDate:   time.Date(2050, 1, 2, 0, 0, 0, 0, time.UTC),
+				Adults:    1,
+			})
+		}
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.PostReservation).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: PostReservation handler returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if e.expectedLocation != "" && rr.Header().Get("Location") != e.expectedLocation {
+			t.Errorf("%s: expected a redirect to %s, got %s", e.name, e.expectedLocation, rr.Header().Get("Location"))
+		}
+	}
+}
+
+func TestRepository_PostReservationConflict(t *testing.T) {
+	// the test repo reports stays starting on 2050-12-31 as just booked by someone else
+	reservation := models.Reservation{
+		RoomID:    1,
+		StartDate: time.Date(2050, 12, 31, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2051, 1, 2, 0, 0, 0, 0, time.UTC),
+	}
+
 	postedData := url.Values{}
-	postedData.Add("start_date", "2050-01-01")
-	postedData.Add("end_date", "2050-01-02")
 	postedData.Add("first_name", "Andrew")
 	postedData.Add("last_name", "Kolbe")
 	postedData.Add("email", "email@andrew.com")
 	postedData.Add("phone", "111-111-111")
-	postedData.Add("room_id", "1")
 
-	// create request
 	req, _ := http.NewRequest("POST", "/make-reservation", strings.NewReader(postedData.Encode()))
-	// get context with session
 	ctx := getCtx(req)
 	req = req.WithContext(ctx)
-
-	// tells the server to expect a POST request
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	session.Put(ctx, "reservation", reservation)
 
 	rr := httptest.NewRecorder()
-
 	handler := http.HandlerFunc(Repo.PostReservation)
-
 	handler.ServeHTTP(rr, req)
 
 	if rr.Code != http.StatusSeeOther {
-		t.Errorf("PostReservation handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusSeeOther)
-	}
-
-	// test for missing post body
-	req, _ = http.NewRequest("POST", "/make-reservation", nil)
-	ctx = getCtx(req)
-	req = req.WithContext(ctx)
-
-	// tells the server to expect a POST request
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-
-	rr = httptest.NewRecorder()
-
-	handler = http.HandlerFunc(Repo.PostReservation)
-
-	handler.ServeHTTP(rr, req)
-
-	if rr.Code != http.StatusTemporaryRedirect {
-		t.Errorf("PostReservation handler returned wrong response code for missing post body: got %d, wanted %d", rr.Code, http.StatusTemporaryRedirect)
+		t.Errorf("PostReservation returned wrong response code for a conflict: got %d, wanted %d", rr.Code, http.StatusSeeOther)
 	}
 
-	// test for invalid start date
-	reqBody := "start_date=invalid"
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "end_date=2050-01-02")
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "first_name=Andrew")
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "last_name=Kolbe")
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "email=email@andrew.com")
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "phone=1111111111")
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "room_id=1")
-
-	req, _ = http.NewRequest("POST", "/make-reservation", strings.NewReader(reqBody))
-	ctx = getCtx(req)
-	req = req.WithContext(ctx)
-
-	// tells the server to expect a POST request
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-
-	rr = httptest.NewRecorder()
-
-	handler = http.HandlerFunc(Repo.PostReservation)
-
-	handler.ServeHTTP(rr, req)
-
-	if rr.Code != http.StatusTemporaryRedirect {
-		t.Errorf("PostReservation handler returned wrong response code for invalid start date: got %d, wanted %d", rr.Code, http.StatusTemporaryRedirect)
-	}
-
-	// test for invalid end date
-	reqBody = "start_date=2050-01-01"
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "end_date=invalid")
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "first_name=Andrew")
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "last_name=Kolbe")
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "email=email@andrew.com")
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "phone=1111111111")
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "room_id=1")
-
-	req, _ = http.NewRequest("POST", "/make-reservation", strings.NewReader(reqBody))
-	ctx = getCtx(req)
-	req = req.WithContext(ctx)
-
-	// tells the server to expect a POST request
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-
-	rr = httptest.NewRecorder()
-
-	handler = http.HandlerFunc(Repo.PostReservation)
-
-	handler.ServeHTTP(rr, req)
-
-	if rr.Code != http.StatusTemporaryRedirect {
-		t.Errorf("PostReservation handler returned wrong response code for invalid end date: got %d, wanted %d", rr.Code, http.StatusTemporaryRedirect)
-	}
-
-	// test for invalid room id
-	reqBody = "start_date=2050-01-01"
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "end_date=2050-01-02")
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "first_name=Andrew")
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "last_name=Kolbe")
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "email=email@andrew.com")
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "phone=1111111111")
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "room_id=invalid")
-
-	req, _ = http.NewRequest("POST", "/make-reservation", strings.NewReader(reqBody))
-	ctx = getCtx(req)
-	req = req.WithContext(ctx)
-
-	// tells the server to expect a POST request
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-
-	rr = httptest.NewRecorder()
-
-	handler = http.HandlerFunc(Repo.PostReservation)
-
-	handler.ServeHTTP(rr, req)
-
-	if rr.Code != http.StatusSeeOther {
-		t.Errorf("PostReservation handler returned wrong response code for invalid room id: got %d, wanted %d", rr.Code, http.StatusSeeOther)
-	}
-
-	// test for invalid data
-	reqBody = "start_date=2050-01-01"
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "end_date=2050-01-02")
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "first_name=A") // first name must be 3 characters long
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "last_name=Kolbe")
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "email=email@andrew.com")
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "phone=1111111111")
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "room_id=1")
-
-	req, _ = http.NewRequest("POST", "/make-reservation", strings.NewReader(reqBody))
-	ctx = getCtx(req)
-	req = req.WithContext(ctx)
-
-	// tells the server to expect a POST request
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-
-	rr = httptest.NewRecorder()
-
-	handler = http.HandlerFunc(Repo.PostReservation)
-
-	handler.ServeHTTP(rr, req)
-
-	if rr.Code != http.StatusSeeOther {
-		t.Errorf("PostReservation handler returned wrong response code for invalid data: got %d, wanted %d", rr.Code, http.StatusSeeOther)
+	actualLoc, _ := rr.Result().Location()
+	if actualLoc.String() != "/search-availability" {
+		t.Errorf("expected redirect to /search-availability, got %s", actualLoc.String())
 	}
 
-	// test for failure to insert reservation into database
-	reqBody = "start_date=2050-01-01"
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "end_date=2050-01-02")
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "first_name=Andrew")
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "last_name=Kolbe")
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "email=email@andrew.com")
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "phone=1111111111")
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "room_id=2")
-
-	req, _ = http.NewRequest("POST", "/make-reservation", strings.NewReader(reqBody))
-	ctx = getCtx(req)
-	req = req.WithContext(ctx)
-
-	// tells the server to expect a POST request
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-
-	rr = httptest.NewRecorder()
-
-	handler = http.HandlerFunc(Repo.PostReservation)
-
-	handler.ServeHTTP(rr, req)
-
-	if rr.Code != http.StatusTemporaryRedirect {
-		t.Errorf("PostReservation handler failed when trying to fail inserting reservation: got %d, wanted %d", rr.Code, http.StatusTemporaryRedirect)
+	if !strings.Contains(session.GetString(ctx, "error"), "just booked by someone else") {
+		t.Error("expected a friendly double booking message in the session")
 	}
 
-	// test for failure to insert restriction into database
-	reqBody = "start_date=2050-01-01"
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "end_date=2050-01-02")
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "first_name=Andrew")
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "last_name=Kolbe")
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "email=email@andrew.com")
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "phone=1111111111")
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "room_id=1000")
-
-	req, _ = http.NewRequest("POST", "/make-reservation", strings.NewReader(reqBody))
-	ctx = getCtx(req)
-	req = req.WithContext(ctx)
-
-	// tells the server to expect a POST request
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-
-	rr = httptest.NewRecorder()
-
-	handler = http.HandlerFunc(Repo.PostReservation)
-
-	handler.ServeHTTP(rr, req)
-
-	if rr.Code != http.StatusTemporaryRedirect {
-		t.Errorf("PostReservation handler failed when trying to fail inserting reservation: got %d, wanted %d", rr.Code, http.StatusTemporaryRedirect)
+	if session.Exists(ctx, "reservation") {
+		t.Error("expected the reservation to be removed from the session")
 	}
-
 }
 
 func TestNewRepo(t *testing.T) {
@@ -347,214 +235,72 @@ func TestNewRepo(t *testing.T) {
 }
 
 func TestRepository_PostAvailability(t *testing.T) {
-	/*****************************************
-	// first case -- rooms are not available
-	*****************************************/
-	// create our request body
-	reqBody := "start=2050-01-01"
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "end=2050-01-02")
-
-	// create our request
-	req, _ := http.NewRequest("POST", "/search-availability", strings.NewReader(reqBody))
-
-	// get the context with session
-	ctx := getCtx(req)
-	req = req.WithContext(ctx)
-
-	// set the request header
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-
-	// create our response recorder, which satisfies the requirements
-	// for http.ResponseWriter
-	rr := httptest.NewRecorder()
-
-	// make our handler a http.HandlerFunc
-	handler := http.HandlerFunc(Repo.PostAvailability)
-
-	// make the request to our handler
-	handler.ServeHTTP(rr, req)
-
-	// since we have no rooms available, we expect to get status http.StatusSeeOther
-	if rr.Code != http.StatusSeeOther {
-		t.Errorf("Post availability when no rooms available gave wrong status code: got %d, wanted %d", rr.Code, http.StatusSeeOther)
-	}
-
-	/*****************************************
-	// second case -- rooms are available
-	*****************************************/
-	// this time, we specify a start date before 2040-01-01, which will give us
-	// a non-empty slice, indicating that rooms are available
-	reqBody = "start=2040-01-01"
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "end=2040-01-02")
-
-	// create our request
-	req, _ = http.NewRequest("POST", "/search-availability", strings.NewReader(reqBody))
-
-	// get the context with session
-	ctx = getCtx(req)
-	req = req.WithContext(ctx)
-
-	// set the request header
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-
-	// create our response recorder, which satisfies the requirements
-	// for http.ResponseWriter
-	rr = httptest.NewRecorder()
-
-	// make our handler a http.HandlerFunc
-	handler = http.HandlerFunc(Repo.PostAvailability)
-
-	// make the request to our handler
-	handler.ServeHTTP(rr, req)
-
-	// since we have rooms available, we expect to get status http.StatusOK
-	if rr.Code != http.StatusOK {
-		t.Errorf("Post availability when rooms are available gave wrong status code: got %d, wanted %d", rr.Code, http.StatusOK)
-	}
-
-	/*****************************************
-	// third case -- empty post body
-	*****************************************/
-	// create our request with a nil body, so parsing form fails
-	req, _ = http.NewRequest("POST", "/search-availability", nil)
-
-	// get the context with session
-	ctx = getCtx(req)
-	req = req.WithContext(ctx)
-
-	// set the request header
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-
-	// create our response recorder, which satisfies the requirements
-	// for http.ResponseWriter
-	rr = httptest.NewRecorder()
-
-	// make our handler a http.HandlerFunc
-	handler = http.HandlerFunc(Repo.PostAvailability)
-
-	// make the request to our handler
-	handler.ServeHTTP(rr, req)
-
-	// since we have rooms available, we expect to get status http.StatusTemporaryRedirect
-	if rr.Code != http.StatusTemporaryRedirect {
-		t.Errorf("Post availability with empty request body (nil) gave wrong status code: got %d, wanted %d", rr.Code, http.StatusTemporaryRedirect)
-	}
-
-	/*****************************************
-	// fourth case -- start date in wrong format
-	*****************************************/
-	// this time, we specify a start date in the wrong format
-	reqBody = "start=invalid"
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "end=2040-01-02")
-	req, _ = http.NewRequest("POST", "/search-availability", strings.NewReader(reqBody))
-
-	// get the context with session
-	ctx = getCtx(req)
-	req = req.WithContext(ctx)
-
-	// set the request header
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-
-	// create our response recorder, which satisfies the requirements
-	// for http.ResponseWriter
-	rr = httptest.NewRecorder()
-
-	// make our handler a http.HandlerFunc
-	handler = http.HandlerFunc(Repo.PostAvailability)
-
-	// make the request to our handler
-	handler.ServeHTTP(rr, req)
-
-	// since we have rooms available, we expect to get status http.StatusTemporaryRedirect
-	if rr.Code != http.StatusTemporaryRedirect {
-		t.Errorf("Post availability with invalid start date gave wrong status code: got %d, wanted %d", rr.Code, http.StatusTemporaryRedirect)
-	}
-
-	/*****************************************
-	// fifth case -- end date in wrong format
-	*****************************************/
-	// this time, we specify a start date in the wrong format
-	reqBody = "start=2040-01-01"
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "invalid")
-	req, _ = http.NewRequest("POST", "/search-availability", strings.NewReader(reqBody))
-
-	// get the context with session
-	ctx = getCtx(req)
-	req = req.WithContext(ctx)
-
-	// set the request header
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-
-	// create our response recorder, which satisfies the requirements
-	// for http.ResponseWriter
-	rr = httptest.NewRecorder()
-
-	// make our handler a http.HandlerFunc
-	handler = http.HandlerFunc(Repo.PostAvailability)
-
-	// make the request to our handler
-	handler.ServeHTTP(rr, req)
-
-	// since we have rooms available, we expect to get status http.StatusTemporaryRedirect
-	if rr.Code != http.StatusTemporaryRedirect {
-		t.Errorf("Post availability with invalid end date gave wrong status code: got %d, wanted %d", rr.Code, http.StatusTemporaryRedirect)
-	}
-
-	/*****************************************
-	// sixth case -- database query fails
-	*****************************************/
-	// this time, we specify a start date of 2060-01-01, which will cause
-	// our testdb repo to return an error
-	reqBody = "start=2060-01-01"
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "end=2060-01-02")
-	req, _ = http.NewRequest("POST", "/search-availability", strings.NewReader(reqBody))
-
-	// get the context with session
-	ctx = getCtx(req)
-	req = req.WithContext(ctx)
-
-	// set the request header
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-
-	// create our response recorder, which satisfies the requirements
-	// for http.ResponseWriter
-	rr = httptest.NewRecorder()
-
-	// make our handler a http.HandlerFunc
-	handler = http.HandlerFunc(Repo.PostAvailability)
+	var tests = []struct {
+		name               string
+		postedData         url.Values
+		expectedStatusCode int
+	}{
+		// the test repo has no rooms free from 2050 on
+		{"no rooms available", url.Values{"start": {"2050-01-01"}, "end": {"2050-01-02"}}, http.StatusSeeOther},
+		{"rooms available", url.Values{"start": {"2040-01-01"}, "end": {"2040-01-02"}}, http.StatusOK},
+		{"empty post body", nil, http.StatusInternalServerError},
+		{"invalid start date", url.Values{"start": {"invalid"}, "end": {"2040-01-02"}}, http.StatusInternalServerError},
+		{"invalid end date", url.Values{"start": {"2040-01-01"}, "end": {"invalid"}}, http.StatusInternalServerError},
+		// the test repo fails searches starting on 2060-01-01
+		{"database query fails", url.Values{"start": {"2060-01-01"}, "end": {"2060-01-02"}}, http.StatusInternalServerError},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/search-availability", strings.NewReader(e.postedData.Encode()))
+		// set the request header
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		// get the context with session
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		// the csrf middleware parses the form before the handler runs
+		req.ParseForm()
 
-	// make the request to our handler
-	handler.ServeHTTP(rr, req)
+		// create our response recorder, which satisfies the requirements
+		// for http.ResponseWriter
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.PostAvailability).ServeHTTP(rr, req)
 
-	// since we have rooms available, we expect to get status http.StatusTemporaryRedirect
-	if rr.Code != http.StatusTemporaryRedirect {
-		t.Errorf("Post availability when database query fails gave wrong status code: got %d, wanted %d", rr.Code, http.StatusTemporaryRedirect)
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: Post availability gave wrong status code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
 	}
 }
 
 func TestRepository_AvailabilityJSON(t *testing.T) {
-	// first case - rooms are not available
-	reqBody := "start=2050-01-01"
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "end_date=2050-01-02")
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "room_id=1")
-
-	req, _ := http.NewRequest("POST", "/make-reservation", strings.NewReader(reqBody))
-	ctx := getCtx(req)
-	req = req.WithContext(ctx)
-
-	// tells the server to expect a POST request
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-
-	handler := http.HandlerFunc(Repo.PostReservation)
-
-	rr := httptest.NewRecorder()
+	var tests = []struct {
+		name       string
+		postedData url.Values
+		ok         bool
+	}{
+		// the test repo never has a single room free
+		{"rooms are not available", url.Values{"start": {"2050-01-01"}, "end": {"2050-01-02"}, "room_id": {"1"}}, false},
+		{"no post body", nil, false},
+		{"children not a number", url.Values{"start": {"2040-01-01"}, "end": {"2040-01-02"}, "room_id": {"1"}, "children": {"abc"}}, false},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/search-availability-json", strings.NewReader(e.postedData.Encode()))
+		// tells the server to expect a POST request
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
 
-	handler.ServeHTTP(rr, req)
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.AvailabilityJSON).ServeHTTP(rr, req)
 
-	var j jsonResponse
-	err := json.Unmarshal([]byte(rr.Body.String()), &j)
-	if err != nil {
-		t.Error("failed to parse JSON")
+		var j jsonResponse
+		err := json.Unmarshal([]byte(rr.Body.String()), &j)
+		if err != nil {
+			t.Errorf("%s: failed to parse JSON", e.name)
+		}
+		if j.OK != e.ok {
+			t.Errorf("%s: expected ok to be %t", e.name, e.ok)
+		}
 	}
 }
 
@@ -598,8 +344,8 @@ func TestRepository_ReservationSummary(t *testing.T) {
 
 	handler.ServeHTTP(rr, req)
 
-	if rr.Code != http.StatusTemporaryRedirect {
-		t.Errorf("ReservationSummary handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusOK)
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("ReservationSummary handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusSeeOther)
 	}
 }
 
@@ -630,7 +376,7 @@ func TestRepository_ChooseRoom(t *testing.T) {
 	handler.ServeHTTP(rr, req)
 
 	if rr.Code != http.StatusSeeOther {
-		t.Errorf("ChooseRoom handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusTemporaryRedirect)
+		t.Errorf("ChooseRoom handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusSeeOther)
 	}
 
 	///*****************************************
@@ -647,8 +393,8 @@ func TestRepository_ChooseRoom(t *testing.T) {
 
 	handler.ServeHTTP(rr, req)
 
-	if rr.Code != http.StatusTemporaryRedirect {
-		t.Errorf("ChooseRoom handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusTemporaryRedirect)
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("ChooseRoom handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusInternalServerError)
 	}
 
 	///*****************************************
@@ -665,8 +411,8 @@ func TestRepository_ChooseRoom(t *testing.T) {
 
 	handler.ServeHTTP(rr, req)
 
-	if rr.Code != http.StatusTemporaryRedirect {
-		t.Errorf("ChooseRoom handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusTemporaryRedirect)
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("ChooseRoom handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusSeeOther)
 	}
 }
 
@@ -710,8 +456,8 @@ func TestRepository_BookRoom(t *testing.T) {
 
 	handler.ServeHTTP(rr, req)
 
-	if rr.Code != http.StatusTemporaryRedirect {
-		t.Errorf("BookRoom handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusTemporaryRedirect)
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("BookRoom handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusInternalServerError)
 	}
 }
 
@@ -741,7 +487,7 @@ var loginTests = []struct {
 		"invalid-data",
 		"j",
 		http.StatusOK,
-		`action="/user/login"`,
+		`action='/user/login'`,
 		"",
 	},
 }
@@ -884,160 +630,184 @@ func TestAdminPostShowReservation(t *testing.T) {
 	}
 }
 
-var adminPostReservationCalendarTests = []struct {
+var adminPostReservationStatusTests = []struct {
 	name                 string
+	id                   string
+	status               string
 	postedData           url.Values
+	accessLevel          int
 	expectedResponseCode int
 	expectedLocation     string
-	expectedHTML         string
-	blocks               int
-	reservations         int
+	expectedSession      string
 }{
 	{
-		name: "cal",
-		postedData: url.Values{
-			"year":  {time.Now().Format("2006")},
-			"month": {time.Now().Format("01")},
-			fmt.Sprintf("add_block_1_%s", time.Now().AddDate(0, 0, 2).Format("2006-01-2")): {"1"},
-		},
+		name:                 "check-in",
+		id:                   "1",
+		status:               models.ReservationCheckedIn,
+		accessLevel:          models.AccessFrontDesk,
+		expectedResponseCode: http.StatusSeeOther,
+		expectedLocation:     "/admin/reservations/all/1/show",
+		expectedSession:      "flash",
+	},
+	{
+		name:                 "check-in-back-to-cal",
+		id:                   "1",
+		status:               models.ReservationCheckedIn,
+		postedData:           url.Values{"y": {"2021"}, "m": {"12"}},
+		accessLevel:          models.AccessFrontDesk,
 		expectedResponseCode: http.StatusSeeOther,
+		expectedLocation:     "/admin/reservations-calendar?y=2021&m=12",
+		expectedSession:      "flash",
 	},
 	{
-		name:                 "cal-blocks",
-		postedData:           url.Values{},
+		name:                 "not-allowed-from-cancelled",
+		id:                   "99",
+		status:               models.ReservationCheckedIn,
+		accessLevel:          models.AccessFrontDesk,
 		expectedResponseCode: http.StatusSeeOther,
-		blocks:               1,
+		expectedLocation:     "/admin/reservations/all/99/show",
+		expectedSession:      "error",
 	},
 	{
-		name:                 "cal-res",
-		postedData:           url.Values{},
+		name:                 "cancel-as-owner",
+		id:                   "1",
+		status:               models.ReservationCancelled,
+		accessLevel:          models.AccessOwner,
 		expectedResponseCode: http.StatusSeeOther,
-		reservations:         1,
+		expectedLocation:     "/admin/reservations/all/1/show",
+		expectedSession:      "flash",
+	},
+	{
+		name:                 "cancel-as-front-desk",
+		id:                   "1",
+		status:               models.ReservationCancelled,
+		accessLevel:          models.AccessFrontDesk,
+		expectedResponseCode: http.StatusForbidden,
+	},
+	{
+		name:                 "bad-id",
+		id:                   "x",
+		status:               models.ReservationCheckedIn,
+		accessLevel:          models.AccessFrontDesk,
+		expectedResponseCode: http.StatusBadRequest,
 	},
 }
 
-func TestPostReservationCalendar(t *testing.T) {
-	for _, e := range adminPostReservationCalendarTests {
-		var req *http.Request
-		if e.postedData != nil {
-			req, _ = http.NewRequest("POST", "/admin/reservations-calendar", strings.NewReader(e.postedData.Encode()))
-		} else {
-			req, _ = http.NewRequest("POST", "/admin/reservations-calendar", nil)
+func TestAdminPostReservationStatus(t *testing.T) {
+	for _, e := range adminPostReservationStatusTests {
+		postedData := url.Values{}
+		for k, v := range e.postedData {
+			postedData[k] = v
 		}
-		ctx := getCtx(req)
-		req = req.WithContext(ctx)
+		postedData.Set("status", e.status)
 
-		now := time.Now()
-		bm := make(map[string]int)
-		rm := make(map[string]int)
+		req, _ := http.NewRequest("POST", fmt.Sprintf("/admin/reservations/all/%s/status", e.id), strings.NewReader(postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("src", "all")
+		rctx.URLParams.Add("id", e.id)
+		ctx := getCtx(req)
+		session.Put(ctx, "access_level", e.accessLevel)
+		req = req.WithContext(context.WithValue(ctx, chi.RouteCtxKey, rctx))
 
-		currentYear, currentMonth, _ := now.Date()
-		currentLocation := now.Location()
+		rr := httptest.NewRecorder()
 
-		firstOfMonth := time.Date(currentYear, currentMonth, 1, 0, 0, 0, 0, currentLocation)
-		lastOfMonth := firstOfMonth.AddDate(0, 1, -1)
+		handler := http.HandlerFunc(Repo.AdminPostReservationStatus)
+		handler.ServeHTTP(rr, req)
 
-		for d := firstOfMonth; d.After(lastOfMonth) == false; d = d.AddDate(0, 0, 1) {
-			rm[d.Format("2006-01-2")] = 0
-			bm[d.Format("2006-01-2")] = 0
+		if rr.Code != e.expectedResponseCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedResponseCode, rr.Code)
 		}
 
-		if e.blocks > 0 {
-			bm[firstOfMonth.Format("2006-01-2")] = e.blocks
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got %s", e.name, e.expectedLocation, actualLoc.String())
+			}
 		}
 
-		if e.reservations > 0 {
-			rm[lastOfMonth.Format("2006-01-2")] = e.reservations
+		if e.expectedSession != "" && !session.Exists(ctx, e.expectedSession) {
+			t.Errorf("failed %s: expected a %s message in the session", e.name, e.expectedSession)
 		}
+	}
+}
 
-		session.Put(ctx, "block_map_1", bm)
-		session.Put(ctx, "reservation_map_1", rm)
-
-		// set the header
-		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-		rr := httptest.NewRecorder()
+func TestRepository_CancelledRequest(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/admin/reservations-all", nil)
+	ctx := getCtx(req)
 
-		// call the handler
-		handler := http.HandlerFunc(Repo.AdminPostReservationsCalendar)
-		handler.ServeHTTP(rr, req)
+	// the client went away before the handler got to the database
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	req = req.WithContext(ctx)
 
-		if rr.Code != e.expectedResponseCode {
-			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedResponseCode, rr.Code)
-		}
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(Repo.AdminAllReservations)
+	handler.ServeHTTP(rr, req)
 
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("expected the cancelled query to fail with %d, got %d", http.StatusInternalServerError, rr.Code)
 	}
 }
 
-var adminProcessReservationTests = []struct {
-	name                 string
-	queryParams          string
-	expectedResponseCode int
-	expectedLocation     string
-}{
-	{
-		name:                 "process-reservation",
-		queryParams:          "",
-		expectedResponseCode: http.StatusSeeOther,
-		expectedLocation:     "",
-	},
-	{
-		name:                 "process-reservation-back-to-cal",
-		queryParams:          "?y=2021&m=12",
-		expectedResponseCode: http.StatusSeeOther,
-		expectedLocation:     "",
-	},
-}
+func TestRepository_PostReservationMailNotQueued(t *testing.T) {
+	reservation := models.Reservation{
+		RoomID:    1,
+		StartDate: time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2050, 1, 2, 0, 0, 0, 0, time.UTC),
+	}
 
-func TestAdminProcessReservation(t *testing.T) {
-	for _, e := range adminProcessReservationTests {
-		req, _ := http.NewRequest("GET", fmt.Sprintf("/admin/process-reservation/cal/1/do%s", e.queryParams), nil)
-		ctx := getCtx(req)
-		req = req.WithContext(ctx)
+	// the test repo can't queue mail to fail@here.com
+	postedData := url.Values{}
+	postedData.Add("first_name", "Andrew")
+	postedData.Add("last_name", "Kolbe")
+	postedData.Add("email", "fail@here.com")
+	postedData.Add("phone", "111-111-111")
 
-		rr := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/make-reservation", strings.NewReader(postedData.Encode()))
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	session.Put(ctx, "reservation", reservation)
 
-		handler := http.HandlerFunc(Repo.AdminProcessReservation)
-		handler.ServeHTTP(rr, req)
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(Repo.PostReservation)
+	handler.ServeHTTP(rr, req)
 
-		if rr.Code != http.StatusSeeOther {
-			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedResponseCode, rr.Code)
-		}
+	// the booking is made, so the guest still gets their summary
+	actualLoc, _ := rr.Result().Location()
+	if rr.Code != http.StatusSeeOther || actualLoc.String() != "/reservation-summary" {
+		t.Errorf("expected a redirect to the summary when mail can't be queued, got %d %s", rr.Code, actualLoc)
 	}
 }
 
-var adminDeleteReservationTests = []struct {
-	name                 string
-	queryParams          string
-	expectedResponseCode int
-	expectedLocation     string
-}{
-	{
-		name:                 "delete-reservation",
-		queryParams:          "",
-		expectedResponseCode: http.StatusSeeOther,
-		expectedLocation:     "",
-	},
-	{
-		name:                 "delete-reservation-back-to-cal",
-		queryParams:          "?y=2021&m=12",
-		expectedResponseCode: http.StatusSeeOther,
-		expectedLocation:     "",
-	},
-}
-
-func TestAdminDeleteReservation(t *testing.T) {
-	for _, e := range adminDeleteReservationTests {
-		req, _ := http.NewRequest("GET", fmt.Sprintf("/admin/process-reservation/cal/1/do%s", e.queryParams), nil)
+func TestRepository_AdminResendMail(t *testing.T) {
+	var tests = []struct {
+		id      string
+		code    int
+		session string
+	}{
+		{"1", http.StatusSeeOther, "flash"},
+		{"2", http.StatusSeeOther, "error"},
+		{"x", http.StatusBadRequest, ""},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/admin/mail/"+e.id+"/resend", nil)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", e.id)
 		ctx := getCtx(req)
-		req = req.WithContext(ctx)
+		req = req.WithContext(context.WithValue(ctx, chi.RouteCtxKey, rctx))
 
 		rr := httptest.NewRecorder()
-
-		handler := http.HandlerFunc(Repo.AdminDeleteReservation)
+		handler := http.HandlerFunc(Repo.AdminResendMail)
 		handler.ServeHTTP(rr, req)
 
-		if rr.Code != http.StatusSeeOther {
-			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedResponseCode, rr.Code)
+		if rr.Code != e.code {
+			t.Errorf("id %s: expected %d, got %d", e.id, e.code, rr.Code)
+		}
+		if e.session != "" && !session.Exists(ctx, e.session) {
+			t.Errorf("id %s: expected a %s message in the session", e.id, e.session)
 		}
 	}
 }
@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/andkolbe/bookings/internal/helpers"
+	"github.com/andkolbe/bookings/internal/mailer"
+	"github.com/andkolbe/bookings/internal/models"
+	"github.com/andkolbe/bookings/internal/render"
+	"github.com/andkolbe/bookings/internal/throttle"
+)
+
+// lists the accounts and ip addresses that are locked out of logging in
+func (m *Repository) AdminLoginLockouts(w http.ResponseWriter, r *http.Request) {
+	locked, err := m.DB.LockedLogins(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["locked"] = locked
+
+	render.Template(w, r, "admin-login-lockouts.page.html", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// lets a locked out account or ip address log in again straight away
+func (m *Repository) AdminUnlockLogin(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	key := r.Form.Get("key")
+	if key == "" {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	err = m.DB.ClearLoginThrottle(r.Context(), key)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Unlocked")
+	http.Redirect(w, r, "/admin/login-lockouts", http.StatusSeeOther)
+}
+
+// returns how long the request has to wait before it can try to log in as email. The account and the ip address
+// are both checked, and the longer wait wins
+func (m *Repository) loginWait(r *http.Request, email string) (time.Duration, error) {
+	keys := []string{throttle.AccountKey(email)}
+	if m.App.LoginIPLockout {
+		keys = append(keys, throttle.IPKey(m.clientIP(r)))
+	}
+
+	var wait time.Duration
+	for _, key := range keys {
+		t, err := m.DB.GetLoginThrottle(r.Context(), key)
+		if err != nil {
+			return 0, err
+		}
+		if w := throttle.Wait(t, time.Now()); w > wait {
+			wait = w
+		}
+	}
+	return wait, nil
+}
+
+// counts a failed login against the ip address and the account, and locks out either one that has failed too
+// many times in a row. The user is emailed when their account is locked
+func (m *Repository) recordLoginFailure(r *http.Request, email string) error {
+	ip := m.clientIP(r)
+	until := time.Now().Add(m.App.LoginLockout)
+
+	if m.App.LoginIPLockout {
+		t, err := m.DB.RecordLoginFailure(r.Context(), throttle.IPKey(ip), m.App.LoginFailureWindow)
+		if err != nil {
+			return err
+		}
+		if t.Failures >= m.App.LoginMaxIPFailures {
+			m.App.InfoLog.Println("locking out logins from", ip)
+			err = m.DB.LockLogin(r.Context(), t.Key, until)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	t, err := m.DB.RecordLoginFailure(r.Context(), throttle.AccountKey(email), m.App.LoginFailureWindow)
+	if err != nil {
+		return err
+	}
+	if t.Failures < m.App.LoginMaxFailures {
+		return nil
+	}
+
+	m.App.InfoLog.Println("locking out logins to", email)
+	err = m.DB.LockLogin(r.Context(), t.Key, until)
+	if err != nil {
+		return err
+	}
+
+	// the failures are counted for emails nobody has too, so the lock doesn't give away who has an account
+	user, err := m.DB.GetUserByEmail(r.Context(), email)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !user.Active) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	return m.queueMail(r.Context(), user.Email, mailer.AccountLocked{
+		User:     user,
+		Until:    until,
+		IP:       ip,
+		ResetURL: m.App.SiteURL + "/user/forgot-password",
+	})
+}
+
+// lets the account log in without waiting next time. The ip address keeps its count, so knowing one password
+// doesn't give more guesses at other accounts
+func (m *Repository) clearLoginFailures(r *http.Request, email string) error {
+	return m.DB.ClearLoginThrottle(r.Context(), throttle.AccountKey(email))
+}
+
+// returns the address the request came from, without the port. Behind a reverse proxy RemoteAddr is the proxy,
+// so the address comes from app.TrustedProxyHeader instead. Only the last address in it is used, because that is
+// the one the proxy added, and anything before it came from the client
+func (m *Repository) clientIP(r *http.Request) string {
+	if m.App.TrustedProxyHeader != "" {
+		addrs := strings.Split(r.Header.Get(m.App.TrustedProxyHeader), ",")
+		if ip := strings.TrimSpace(addrs[len(addrs)-1]); ip != "" {
+			return ip
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// says how long a wait is, rounded up, e.g. "30 seconds" or "15 minutes"
+func waitText(d time.Duration) string {
+	if d <= time.Minute {
+		s := int((d + time.Second - 1) / time.Second)
+		if s == 1 {
+			return "1 second"
+		}
+		return fmt.Sprintf("%d seconds", s)
+	}
+	return fmt.Sprintf("%d minutes", int((d+time.Minute-1)/time.Minute))
+}
@@ -0,0 +1,264 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andkolbe/bookings/internal/models"
+	"github.com/andkolbe/bookings/internal/repository"
+	"github.com/andkolbe/bookings/internal/repository/dbrepo"
+	"github.com/andkolbe/bookings/internal/throttle"
+	_ "github.com/jackc/pgx/v4/stdlib"
+)
+
+// a repo where every failed login is one too many, and which remembers what it locked
+type lockingRepo struct {
+	repository.DatabaseRepo
+	locked []string
+}
+
+func (r *lockingRepo) RecordLoginFailure(ctx context.Context, key string, window time.Duration) (models.LoginThrottle, error) {
+	return models.LoginThrottle{Key: key, Failures: 100, LastFailureAt: time.Now()}, nil
+}
+
+func (r *lockingRepo) LockLogin(ctx context.Context, key string, until time.Time) error {
+	r.locked = append(r.locked, key)
+	return nil
+}
+
+// a repo that remembers whether anybody tried a password
+type authCountingRepo struct {
+	repository.DatabaseRepo
+	tries int
+}
+
+func (r *authCountingRepo) Authenticate(ctx context.Context, email, password string) (int, string, error) {
+	r.tries++
+	return r.DatabaseRepo.Authenticate(ctx, email, password)
+}
+
+func TestRepository_PostShowLogin_Locked(t *testing.T) {
+	db := &authCountingRepo{DatabaseRepo: Repo.DB}
+	repo := *Repo
+	repo.DB = db
+
+	postedData := url.Values{}
+	postedData.Add("email", "locked@email.com")
+	postedData.Add("password", "password")
+
+	req, _ := http.NewRequest("POST", "/user/login", strings.NewReader(postedData.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(repo.PostShowLogin).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/user/login" {
+		t.Errorf("expected a redirect back to the login page, got %d %s", rr.Code, rr.Header().Get("Location"))
+	}
+	if !strings.Contains(session.GetString(ctx, "error"), "Too many failed logins") {
+		t.Errorf("expected to be told to wait, got %q", session.GetString(ctx, "error"))
+	}
+	if db.tries != 0 {
+		t.Error("expected the password not to be checked while the account is locked")
+	}
+}
+
+func TestRepository_PostShowLogin_LocksOut(t *testing.T) {
+	var tests = []struct {
+		name           string
+		email          string
+		ipLockout      bool
+		expectedLocked string
+		expectedMail   int
+	}{
+		{"user", "new@email.com", true, "ip:10.0.0.1,account:new@email.com", 1},
+		{"nobody", "nobody@email.com", true, "ip:10.0.0.1,account:nobody@email.com", 0},
+		{"deactivated", "gone@email.com", true, "ip:10.0.0.1,account:gone@email.com", 0},
+		{"ip lockout off", "new@email.com", false, "account:new@email.com", 1},
+	}
+
+	for _, e := range tests {
+		mail := &recordingRepo{DatabaseRepo: Repo.DB}
+		db := &lockingRepo{DatabaseRepo: mail}
+		a := *Repo.App
+		a.LoginIPLockout = e.ipLockout
+		repo := *Repo
+		repo.DB = db
+		repo.App = &a
+
+		postedData := url.Values{}
+		postedData.Add("email", e.email)
+		postedData.Add("password", "wrong")
+
+		req, _ := http.NewRequest("POST", "/user/login", strings.NewReader(postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.RemoteAddr = "10.0.0.1:1234"
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(repo.PostShowLogin).ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("%s: expected %d, got %d", e.name, http.StatusSeeOther, rr.Code)
+		}
+		if strings.Join(db.locked, ",") != e.expectedLocked {
+			t.Errorf("%s: expected %s to be locked, got %v", e.name, e.expectedLocked, db.locked)
+		}
+		if len(mail.mail) != e.expectedMail {
+			t.Errorf("%s: expected %d emails, got %d", e.name, e.expectedMail, len(mail.mail))
+		} else if e.expectedMail > 0 && mail.mail[0].To != e.email {
+			t.Errorf("%s: expected the email to go to %s, got %s", e.name, e.email, mail.mail[0].To)
+		}
+	}
+}
+
+func TestRepository_AdminLoginLockouts(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/admin/login-lockouts", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.AdminLoginLockouts).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected %d, got %d", http.StatusOK, rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), "account:locked@email.com") {
+		t.Error("expected the locked account to be listed")
+	}
+}
+
+func TestRepository_AdminUnlockLogin(t *testing.T) {
+	var tests = []struct {
+		name               string
+		key                string
+		expectedStatusCode int
+	}{
+		{"unlocked", "account:locked@email.com", http.StatusSeeOther},
+		{"no key", "", http.StatusBadRequest},
+	}
+
+	for _, e := range tests {
+		postedData := url.Values{}
+		postedData.Add("key", e.key)
+
+		req, _ := http.NewRequest("POST", "/admin/login-lockouts/unlock", strings.NewReader(postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.AdminUnlockLogin).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected %d, got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+	}
+}
+
+// a lock has to last as long as it was meant to on a server that isn't in UTC. Needs a migrated database, so it
+// only runs when BOOKINGS_TEST_DSN says where one is
+func TestRepository_LoginWait_AnotherZone(t *testing.T) {
+	dsn := os.Getenv("BOOKINGS_TEST_DSN")
+	if dsn == "" {
+		t.Skip("BOOKINGS_TEST_DSN isn't set")
+	}
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	local := time.Local
+	time.Local = time.FixedZone("UTC+10", 10*60*60)
+	defer func() { time.Local = local }()
+
+	repo := *Repo
+	repo.DB = dbrepo.NewPostgresRepo(db, repo.App)
+
+	key := throttle.AccountKey("zone@email.com")
+	ctx := context.Background()
+	defer repo.DB.ClearLoginThrottle(ctx, key)
+
+	_, err = repo.DB.RecordLoginFailure(ctx, key, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = repo.DB.LockLogin(ctx, key, time.Now().Add(15*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, _ := http.NewRequest("GET", "/user/login", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	wait, err := repo.loginWait(req, "zone@email.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wait < 14*time.Minute || wait > 15*time.Minute {
+		t.Errorf("expected to wait about 15 minutes, got %s", wait)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	var tests = []struct {
+		name         string
+		remoteAddr   string
+		trustedProxy string
+		forwarded    string
+		ip           string
+	}{
+		{"direct", "10.0.0.1:1234", "", "", "10.0.0.1"},
+		{"ipv6", "[::1]:1234", "", "", "::1"},
+		{"no port", "10.0.0.1", "", "", "10.0.0.1"},
+		{"header not trusted", "10.0.0.1:1234", "", "192.0.2.7", "10.0.0.1"},
+		{"behind a proxy", "10.0.0.1:1234", "X-Forwarded-For", "192.0.2.7", "192.0.2.7"},
+		{"client sent its own header", "10.0.0.1:1234", "X-Forwarded-For", "198.51.100.1, 192.0.2.7", "192.0.2.7"},
+		{"proxy left it out", "10.0.0.1:1234", "X-Forwarded-For", "", "10.0.0.1"},
+	}
+
+	for _, e := range tests {
+		a := *Repo.App
+		a.TrustedProxyHeader = e.trustedProxy
+		repo := *Repo
+		repo.App = &a
+
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.RemoteAddr = e.remoteAddr
+		if e.forwarded != "" {
+			req.Header.Set("X-Forwarded-For", e.forwarded)
+		}
+		if ip := repo.clientIP(req); ip != e.ip {
+			t.Errorf("%s: expected %s, got %s", e.name, e.ip, ip)
+		}
+	}
+}
+
+func TestWaitText(t *testing.T) {
+	var tests = []struct {
+		wait time.Duration
+		text string
+	}{
+		{time.Second, "1 second"},
+		{1500 * time.Millisecond, "2 seconds"},
+		{time.Minute, "60 seconds"},
+		{15 * time.Minute, "15 minutes"},
+		{14*time.Minute + time.Second, "15 minutes"},
+	}
+
+	for _, e := range tests {
+		if s := waitText(e.wait); s != e.text {
+			t.Errorf("%s: expected %q, got %q", e.wait, e.text, s)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/andkolbe/bookings/internal/forms"
+	"github.com/andkolbe/bookings/internal/helpers"
+	"github.com/andkolbe/bookings/internal/mailer"
+	"github.com/andkolbe/bookings/internal/models"
+	"github.com/andkolbe/bookings/internal/render"
+	"github.com/andkolbe/bookings/internal/resetlink"
+	"github.com/go-chi/chi/v5"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// the shortest password we accept
+const minPasswordLength = 10
+
+// shows the form to ask for a link to reset a password
+func (m *Repository) ShowForgotPassword(w http.ResponseWriter, r *http.Request) {
+	render.Template(w, r, "forgot-password.page.html", &models.TemplateData{
+		Form: forms.New(nil),
+	})
+}
+
+// emails a link to reset the password if there is a user with the email. The answer is the same either way,
+// so the form can't be used to find out who has an account
+func (m *Repository) PostForgotPassword(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("email")
+	form.IsEmail("email")
+	if !form.Valid() {
+		render.Template(w, r, "forgot-password.page.html", &models.TemplateData{
+			Form: form,
+		})
+		return
+	}
+
+	user, err := m.DB.GetUserByEmail(r.Context(), strings.TrimSpace(form.Get("email")))
+	if err == nil && user.Active {
+		expires := time.Now().Add(m.App.ResetLinkTTL)
+		err = m.queueMail(r.Context(), user.Email, mailer.PasswordReset{
+			User:     user,
+			ResetURL: m.resetURL(user, expires),
+			Expires:  expires,
+		})
+	} else if errors.Is(err, sql.ErrNoRows) {
+		err = nil
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "If there is an account for that email, we've sent it a link to reset the password")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// shows the form to choose a new password
+func (m *Repository) ShowResetPassword(w http.ResponseWriter, r *http.Request) {
+	_, ok := m.userFromResetToken(w, r)
+	if !ok {
+		return
+	}
+
+	renderResetPassword(w, r, forms.New(nil))
+}
+
+// sets a new password. Changing it makes the link stop working, and logs the user out everywhere they were logged in
+func (m *Repository) PostResetPassword(w http.ResponseWriter, r *http.Request) {
+	user, ok := m.userFromResetToken(w, r)
+	if !ok {
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	password := validatePassword(form, "password", "confirm_password")
+	if !form.Valid() {
+		renderResetPassword(w, r, form)
+		return
+	}
+
+	hash, err := hashPassword(password)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	err = m.DB.UpdateUserPassword(r.Context(), user.ID, hash)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	// getting the email is proof enough, so a locked account can log in with the new password straight away
+	err = m.clearLoginFailures(r, user.Email)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	// whoever was logged in here starts again too
+	_ = m.App.Session.RenewToken(r.Context())
+	m.App.Session.Remove(r.Context(), "user_id")
+	m.App.Session.Remove(r.Context(), "access_level")
+
+	m.App.Session.Put(r.Context(), "flash", "Your password has been changed, log in with the new one")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// looks up the user from the {token} url parameter. If it returns false, a response has already been written
+func (m *Repository) userFromResetToken(w http.ResponseWriter, r *http.Request) (models.User, bool) {
+	token := chi.URLParam(r, "token")
+
+	id, err := resetlink.UserID(token)
+	if err != nil {
+		http.NotFound(w, r)
+		return models.User{}, false
+	}
+
+	user, err := m.DB.GetUserByID(r.Context(), id)
+	if err == nil {
+		err = resetlink.Verify([]byte(m.App.LinkSecret), token, user.Password, time.Now())
+	}
+	if errors.Is(err, sql.ErrNoRows) || errors.Is(err, resetlink.ErrInvalid) || errors.Is(err, resetlink.ErrExpired) || (err == nil && !user.Active) {
+		m.App.Session.Put(r.Context(), "error", "That link has expired or has already been used, ask for a new one")
+		http.Redirect(w, r, "/user/forgot-password", http.StatusSeeOther)
+		return models.User{}, false
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return models.User{}, false
+	}
+
+	return user, true
+}
+
+// returns the full url of a link to reset the user's password, for emails
+func (m *Repository) resetURL(user models.User, expires time.Time) string {
+	token := resetlink.Sign([]byte(m.App.LinkSecret), user.ID, user.Password, expires)
+	return fmt.Sprintf("%s/user/reset-password/%s", m.App.SiteURL, token)
+}
+
+func renderResetPassword(w http.ResponseWriter, r *http.Request, form *forms.Form) {
+	stringMap := make(map[string]string)
+	stringMap["token"] = chi.URLParam(r, "token")
+
+	intMap := make(map[string]int)
+	intMap["min_length"] = minPasswordLength
+
+	render.Template(w, r, "reset-password.page.html", &models.TemplateData{
+		StringMap: stringMap,
+		IntMap:    intMap,
+		Form:      form,
+	})
+}
+
+// checks a new password and its confirmation, adding errors to the form if they aren't right, and returns it
+func validatePassword(form *forms.Form, field, confirmField string) string {
+	form.Required(field, confirmField)
+	if form.Get(field) != "" {
+		form.MinLength(field, minPasswordLength)
+	}
+	if form.Get(confirmField) != "" && form.Get(confirmField) != form.Get(field) {
+		form.Errors.Add(confirmField, "The passwords don't match")
+	}
+	return form.Get(field)
+}
+
+func hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), 12)
+	return string(hash), err
+}
@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andkolbe/bookings/internal/forms"
+	"github.com/andkolbe/bookings/internal/resetlink"
+	"github.com/go-chi/chi/v5"
+)
+
+// the test repo has user 1 with the password hash "hash-1"
+func testResetToken(id int, hash string, expires time.Time) string {
+	return resetlink.Sign([]byte(app.LinkSecret), id, hash, expires)
+}
+
+func TestRepository_PostForgotPassword(t *testing.T) {
+	var tests = []struct {
+		name               string
+		email              string
+		expectedStatusCode int
+		mailed             bool
+	}{
+		{"known user", "email@email.com", http.StatusSeeOther, true},
+		{"unknown user", "nobody@email.com", http.StatusSeeOther, false},
+		{"deactivated user", "gone@email.com", http.StatusSeeOther, false},
+		{"not an email", "nobody", http.StatusOK, false},
+	}
+
+	for _, e := range tests {
+		mail := &recordingRepo{DatabaseRepo: Repo.DB}
+		repo := *Repo
+		repo.DB = mail
+
+		postedData := url.Values{}
+		postedData.Add("email", e.email)
+
+		req, _ := http.NewRequest("POST", "/user/forgot-password", strings.NewReader(postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(repo.PostForgotPassword).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected %d, got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		// the answer doesn't say whether there is an account
+		if rr.Code == http.StatusSeeOther && !strings.Contains(session.GetString(ctx, "flash"), "If there is an account") {
+			t.Errorf("%s: expected the same message either way", e.name)
+		}
+
+		if e.mailed != (len(mail.mail) == 1) {
+			t.Errorf("%s: expected mailed to be %t, got %d messages", e.name, e.mailed, len(mail.mail))
+			continue
+		}
+		if e.mailed {
+			m := mail.mail[0]
+			if m.To != "email@email.com" || !strings.Contains(m.PlainContent, "/user/reset-password/") {
+				t.Errorf("%s: expected a reset link, got %+v", e.name, m)
+			}
+		}
+	}
+}
+
+func TestRepository_PostResetPassword(t *testing.T) {
+	valid := testResetToken(1, "hash-1", time.Now().Add(time.Hour))
+
+	var tests = []struct {
+		name               string
+		token              string
+		password           string
+		confirm            string
+		expectedStatusCode int
+		expectedLocation   string
+		expectedError      string
+	}{
+		{"changed", valid, "a long new password", "a long new password", http.StatusSeeOther, "/user/login", ""},
+		{"too short", valid, "short", "short", http.StatusOK, "", "at least 10 characters"},
+		{"no match", valid, "a long new password", "a different one", http.StatusOK, "", "The passwords don&#39;t match"},
+		{"blank", valid, "", "", http.StatusOK, "", "This field cannot be blank"},
+		{"used", testResetToken(1, "an older hash", time.Now().Add(time.Hour)), "a long new password", "a long new password", http.StatusSeeOther, "/user/forgot-password", ""},
+		{"expired", testResetToken(1, "hash-1", time.Now().Add(-time.Minute)), "a long new password", "a long new password", http.StatusSeeOther, "/user/forgot-password", ""},
+		{"unknown user", testResetToken(9, "", time.Now().Add(time.Hour)), "a long new password", "a long new password", http.StatusSeeOther, "/user/forgot-password", ""},
+		{"deactivated user", testResetToken(3, "hash-3", time.Now().Add(time.Hour)), "a long new password", "a long new password", http.StatusSeeOther, "/user/forgot-password", ""},
+		{"invited user", testResetToken(4, "", time.Now().Add(time.Hour)), "a long new password", "a long new password", http.StatusSeeOther, "/user/login", ""},
+		{"nonsense", "nonsense", "a long new password", "a long new password", http.StatusNotFound, "", ""},
+	}
+
+	for _, e := range tests {
+		postedData := url.Values{}
+		postedData.Add("password", e.password)
+		postedData.Add("confirm_password", e.confirm)
+
+		req, _ := http.NewRequest("POST", "/user/reset-password/"+e.token, strings.NewReader(postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("token", e.token)
+		ctx := getCtx(req)
+		req = req.WithContext(context.WithValue(ctx, chi.RouteCtxKey, rctx))
+		session.Put(ctx, "user_id", 1)
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.PostResetPassword).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected %d, got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if e.expectedLocation != "" && rr.Header().Get("Location") != e.expectedLocation {
+			t.Errorf("%s: expected a redirect to %s, got %s", e.name, e.expectedLocation, rr.Header().Get("Location"))
+		}
+		if e.expectedError != "" && !strings.Contains(rr.Body.String(), e.expectedError) {
+			t.Errorf("%s: expected the form to say %q", e.name, e.expectedError)
+		}
+		if e.name == "changed" && session.Exists(ctx, "user_id") {
+			t.Errorf("%s: expected the session to be logged out", e.name)
+		}
+	}
+}
+
+func TestRepository_ShowResetPassword(t *testing.T) {
+	token := testResetToken(1, "hash-1", time.Now().Add(time.Hour))
+
+	req, _ := http.NewRequest("GET", "/user/reset-password/"+token, nil)
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("token", token)
+	req = req.WithContext(context.WithValue(getCtx(req), chi.RouteCtxKey, rctx))
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.ShowResetPassword).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "action='/user/reset-password/"+token+"'") {
+		t.Errorf("expected the form for the token, got %d", rr.Code)
+	}
+}
+
+func TestValidatePassword(t *testing.T) {
+	var tests = []struct {
+		password string
+		confirm  string
+		valid    bool
+	}{
+		{"long enough!", "long enough!", true},
+		{"0123456789", "0123456789", true},
+		{"012345678", "012345678", false},
+		{"long enough!", "long enough?", false},
+		{"long enough!", "", false},
+	}
+
+	for _, e := range tests {
+		form := forms.New(url.Values{"password": {e.password}, "confirm": {e.confirm}})
+		validatePassword(form, "password", "confirm")
+		if form.Valid() != e.valid {
+			t.Errorf("%q/%q: expected valid to be %t, got %v", e.password, e.confirm, e.valid, form.Errors)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/andkolbe/bookings/internal/forms"
+	"github.com/andkolbe/bookings/internal/helpers"
+	"github.com/andkolbe/bookings/internal/models"
+	"github.com/andkolbe/bookings/internal/pricing"
+	"github.com/andkolbe/bookings/internal/render"
+	"github.com/go-chi/chi/v5"
+)
+
+// lists each room's rates and the seasonal rates, with forms to change them
+func (m *Repository) AdminRates(w http.ResponseWriter, r *http.Request) {
+	m.renderRates(w, r, forms.New(nil))
+}
+
+// changes a room's own nightly and weekend rates
+func (m *Repository) AdminPostRoomRates(w http.ResponseWriter, r *http.Request) {
+	roomID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	err = r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("base_rate")
+	baseRate := parseRate(form, "base_rate")
+	weekendRate := parseRate(form, "weekend_rate")
+	if !form.Valid() {
+		m.App.Session.Put(r.Context(), "error", "Rates have to be amounts in dollars, like 120 or 99.50")
+		http.Redirect(w, r, "/admin/rates", http.StatusSeeOther)
+		return
+	}
+
+	err = m.DB.UpdateRoomRates(r.Context(), roomID, baseRate, weekendRate)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Rates saved")
+	http.Redirect(w, r, "/admin/rates", http.StatusSeeOther)
+}
+
+// adds a seasonal rate to a room
+func (m *Repository) AdminPostSeasonalRate(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("room_id", "name", "nightly_rate")
+
+	roomID, err := strconv.Atoi(form.Get("room_id"))
+	if err != nil || roomID < 1 {
+		form.Errors.Add("room_id", "Choose a room")
+	}
+	startDate, endDate := validateStay(form, "start_date", "end_date")
+	nightlyRate := parseRate(form, "nightly_rate")
+	weekendRate := parseRate(form, "weekend_rate")
+
+	if !form.Valid() {
+		m.renderRates(w, r, form)
+		return
+	}
+
+	_, err = m.DB.InsertSeasonalRate(r.Context(), models.SeasonalRate{
+		RoomID:      roomID,
+		Name:        form.Get("name"),
+		StartDate:   startDate,
+		EndDate:     endDate,
+		NightlyRate: nightlyRate,
+		WeekendRate: weekendRate,
+	})
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Seasonal rate added")
+	http.Redirect(w, r, "/admin/rates", http.StatusSeeOther)
+}
+
+// removes a seasonal rate. Stays that are already booked keep their price
+func (m *Repository) AdminDeleteSeasonalRate(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	err = m.DB.DeleteSeasonalRate(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Seasonal rate removed")
+	http.Redirect(w, r, "/admin/rates", http.StatusSeeOther)
+}
+
+func (m *Repository) renderRates(w http.ResponseWriter, r *http.Request, form *forms.Form) {
+	rooms, err := m.DB.AllRooms(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	seasons, err := m.DB.AllSeasonalRates(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["rooms"] = rooms
+	data["seasons"] = seasons
+
+	render.Template(w, r, "admin-rates.page.html", &models.TemplateData{
+		Data: data,
+		Form: form,
+	})
+}
+
+// parses an optional amount of dollars from field into cents, adding an error to the form if it isn't one
+func parseRate(form *forms.Form, field string) int {
+	if form.Get(field) == "" {
+		return 0
+	}
+	cents, err := pricing.Parse(form.Get(field))
+	if err != nil {
+		form.Errors.Add(field, "Enter an amount in dollars, like 120 or 99.50")
+	}
+	return cents
+}
+
+// prices a stay in room at the rates we have now
+func (m *Repository) quoteStay(ctx context.Context, room models.Room, start, end time.Time) (models.Quote, error) {
+	seasons, err := m.DB.SeasonalRatesForRoom(ctx, room.ID, start, end)
+	if err != nil {
+		return models.Quote{}, err
+	}
+	return pricing.Quote(room, seasons, start, end), nil
+}
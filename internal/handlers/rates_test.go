@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andkolbe/bookings/internal/models"
+	"github.com/go-chi/chi/v5"
+)
+
+func TestRepository_AdminPostRoomRates(t *testing.T) {
+	var tests = []struct {
+		name               string
+		id                 string
+		baseRate           string
+		weekendRate        string
+		expectedStatusCode int
+		expectedSession    string
+	}{
+		{"saved", "1", "120", "$150.50", http.StatusSeeOther, "flash"},
+		{"no weekend rate", "1", "120", "", http.StatusSeeOther, "flash"},
+		{"not an amount", "1", "lots", "", http.StatusSeeOther, "error"},
+		{"no rate", "1", "", "150", http.StatusSeeOther, "error"},
+		{"bad id", "x", "120", "", http.StatusBadRequest, ""},
+		{"database error", "3", "120", "", http.StatusInternalServerError, ""},
+	}
+
+	for _, e := range tests {
+		postedData := url.Values{}
+		postedData.Add("base_rate", e.baseRate)
+		postedData.Add("weekend_rate", e.weekendRate)
+
+		req, _ := http.NewRequest("POST", "/admin/rates/rooms/"+e.id, strings.NewReader(postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", e.id)
+		ctx := getCtx(req)
+		req = req.WithContext(context.WithValue(ctx, chi.RouteCtxKey, rctx))
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminPostRoomRates)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected %d, got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if e.expectedSession != "" && !session.Exists(ctx, e.expectedSession) {
+			t.Errorf("%s: expected a %s message in the session", e.name, e.expectedSession)
+		}
+	}
+}
+
+func TestRepository_AdminPostSeasonalRate(t *testing.T) {
+	var tests = []struct {
+		name               string
+		roomID             string
+		seasonName         string
+		start              string
+		end                string
+		nightlyRate        string
+		expectedStatusCode int
+	}{
+		{"added", "1", "Summer", "2050-06-01", "2050-09-01", "150", http.StatusSeeOther},
+		{"no name", "1", "", "2050-06-01", "2050-09-01", "150", http.StatusOK},
+		{"no room", "", "Summer", "2050-06-01", "2050-09-01", "150", http.StatusOK},
+		{"backwards", "1", "Summer", "2050-09-01", "2050-06-01", "150", http.StatusOK},
+		{"not a date", "1", "Summer", "june", "2050-09-01", "150", http.StatusOK},
+		{"not an amount", "1", "Summer", "2050-06-01", "2050-09-01", "-150", http.StatusOK},
+		{"database error", "3", "Summer", "2050-06-01", "2050-09-01", "150", http.StatusInternalServerError},
+	}
+
+	for _, e := range tests {
+		postedData := url.Values{}
+		postedData.Add("room_id", e.roomID)
+		postedData.Add("name", e.seasonName)
+		postedData.Add("start_date", e.start)
+		postedData.Add("end_date", e.end)
+		postedData.Add("nightly_rate", e.nightlyRate)
+
+		req, _ := http.NewRequest("POST", "/admin/rates/seasons", strings.NewReader(postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req = req.WithContext(getCtx(req))
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminPostSeasonalRate)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected %d, got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+	}
+}
+
+func TestRepository_AdminDeleteSeasonalRate(t *testing.T) {
+	var tests = []struct {
+		id                 string
+		expectedStatusCode int
+	}{
+		{"1", http.StatusSeeOther},
+		{"x", http.StatusBadRequest},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/admin/rates/seasons/"+e.id+"/delete", nil)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", e.id)
+		req = req.WithContext(context.WithValue(getCtx(req), chi.RouteCtxKey, rctx))
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminDeleteSeasonalRate)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("id %s: expected %d, got %d", e.id, e.expectedStatusCode, rr.Code)
+		}
+	}
+}
+
+// the test repo's rooms are $100 a night and $120 at weekends, with a $200 season on the night of January 2nd 2050
+func TestRepository_QuoteStay(t *testing.T) {
+	room, _ := Repo.DB.GetRoomByID(context.Background(), 1)
+
+	// a saturday night and the season
+	q, err := Repo.quoteStay(context.Background(), room, time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.Total != 32000 || len(q.Nights) != 2 || q.Nights[1].Season != "New Year" {
+		t.Errorf("expected $320 over two nights, got %+v", q)
+	}
+}
+
+// moving a stay prices the new dates at today's rates
+func TestRepository_GuestPostChangeDates_Price(t *testing.T) {
+	priced := &pricedRepo{recordingRepo: recordingRepo{DatabaseRepo: Repo.DB}}
+	repo := *Repo
+	repo.DB = priced
+
+	postedData := url.Values{}
+	postedData.Add("start", "2050-01-01")
+	postedData.Add("end", "2050-01-04")
+
+	token := testGuestToken(1)
+	req, _ := http.NewRequest("POST", "/my-reservation/"+token+"/change", strings.NewReader(postedData.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("token", token)
+	req = req.WithContext(context.WithValue(getCtx(req), chi.RouteCtxKey, rctx))
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(repo.GuestPostChangeDates).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("expected the dates to change, got %d", rr.Code)
+	}
+	// saturday, the season, then a monday
+	if priced.price.Total != 42000 || len(priced.price.Nights) != 3 {
+		t.Errorf("expected the new dates to cost $420 over three nights, got %+v", priced.price)
+	}
+}
+
+// the test repo, remembering the price a reservation was moved at
+type pricedRepo struct {
+	recordingRepo
+	price models.Quote
+}
+
+func (r *pricedRepo) ChangeReservationDates(ctx context.Context, id int, start, end time.Time, price models.Quote) error {
+	r.price = price
+	return r.recordingRepo.ChangeReservationDates(ctx, id, start, end, price)
+}
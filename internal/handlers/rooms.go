@@ -0,0 +1,271 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/andkolbe/bookings/internal/forms"
+	"github.com/andkolbe/bookings/internal/helpers"
+	"github.com/andkolbe/bookings/internal/models"
+	"github.com/andkolbe/bookings/internal/render"
+	"github.com/andkolbe/bookings/internal/repository"
+	"github.com/go-chi/chi/v5"
+)
+
+// lowercase words joined by dashes, e.g. generals-quarters
+var slugPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// lists the rooms guests can book
+func (m *Repository) Rooms(w http.ResponseWriter, r *http.Request) {
+	rooms, err := m.DB.AllRooms(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["rooms"] = activeRooms(rooms)
+
+	render.Template(w, r, "rooms.page.html", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// shows a room's page. Inactive rooms look the same as rooms that don't exist
+func (m *Repository) RoomPage(w http.ResponseWriter, r *http.Request) {
+	room, err := m.DB.GetRoomBySlug(r.Context(), chi.URLParam(r, "slug"))
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !room.Active) {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["room"] = room
+
+	render.Template(w, r, "room.page.html", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// lists every room, active or not
+func (m *Repository) AdminRooms(w http.ResponseWriter, r *http.Request) {
+	rooms, err := m.DB.AllRooms(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["rooms"] = rooms
+
+	render.Template(w, r, "admin-rooms.page.html", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// shows the form for a new room
+func (m *Repository) AdminNewRoom(w http.ResponseWriter, r *http.Request) {
+	renderRoom(w, r, models.Room{Capacity: 2, Active: true}, forms.New(nil))
+}
+
+// adds a room
+func (m *Repository) AdminPostNewRoom(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	room := roomFromForm(form)
+	if !form.Valid() {
+		renderRoom(w, r, room, form)
+		return
+	}
+
+	_, err = m.DB.InsertRoom(r.Context(), room)
+	if errors.Is(err, repository.ErrSlugTaken) {
+		form.Errors.Add("slug", "Another room already uses this")
+		renderRoom(w, r, room, form)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Room added. Set its rates before guests book it")
+	http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+}
+
+// shows the form to change a room
+func (m *Repository) AdminShowRoom(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	room, err := m.DB.GetRoomByID(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	renderRoom(w, r, room, forms.New(nil))
+}
+
+// saves changes to a room
+func (m *Repository) AdminPostRoom(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	err = r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	room := roomFromForm(form)
+	room.ID = id
+	if !form.Valid() {
+		renderRoom(w, r, room, form)
+		return
+	}
+
+	err = m.DB.UpdateRoom(r.Context(), room)
+	if errors.Is(err, repository.ErrSlugTaken) {
+		form.Errors.Add("slug", "Another room already uses this")
+		renderRoom(w, r, room, form)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Room saved")
+	http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+}
+
+// deletes a room that has never been booked
+func (m *Repository) AdminDeleteRoom(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	err = m.DB.DeleteRoom(r.Context(), id)
+	if errors.Is(err, repository.ErrRoomInUse) {
+		m.App.Session.Put(r.Context(), "error", "This room has reservations, so it can't be deleted. Make it inactive instead")
+		http.Redirect(w, r, "/admin/rooms/"+strconv.Itoa(id), http.StatusSeeOther)
+		return
+	} else if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Room deleted")
+	http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+}
+
+func renderRoom(w http.ResponseWriter, r *http.Request, room models.Room, form *forms.Form) {
+	data := make(map[string]interface{})
+	data["room"] = room
+
+	render.Template(w, r, "admin-room.page.html", &models.TemplateData{
+		Data: data,
+		Form: form,
+	})
+}
+
+// reads a room from the posted form, adding an error to the form for anything that isn't right.
+// A blank slug is made from the room's name
+func roomFromForm(form *forms.Form) models.Room {
+	form.Required("room_name", "capacity")
+
+	room := models.Room{
+		RoomName:    strings.TrimSpace(form.Get("room_name")),
+		Slug:        strings.TrimSpace(form.Get("slug")),
+		Description: strings.TrimSpace(form.Get("description")),
+		Amenities:   lines(form.Get("amenities")),
+		Photos:      lines(form.Get("photos")),
+		Active:      form.Get("active") != "",
+	}
+
+	if room.Slug == "" {
+		room.Slug = slugify(room.RoomName)
+	}
+	if !slugPattern.MatchString(room.Slug) {
+		form.Errors.Add("slug", "Use lowercase letters, numbers and dashes")
+	}
+
+	if form.Get("capacity") != "" {
+		capacity, err := strconv.Atoi(form.Get("capacity"))
+		if err != nil || capacity < 1 {
+			form.Errors.Add("capacity", "Enter how many guests the room sleeps")
+		}
+		room.Capacity = capacity
+	}
+
+	for _, p := range room.Photos {
+		if !strings.HasPrefix(p, "/") && !strings.HasPrefix(p, "https://") && !strings.HasPrefix(p, "http://") {
+			form.Errors.Add("photos", "Photos have to be links, like /static/images/room.png")
+			break
+		}
+	}
+
+	return room
+}
+
+// makes a slug from a room's name, e.g. "General's Quarters" becomes generals-quarters
+func slugify(name string) string {
+	var b strings.Builder
+	dash := false
+	for _, c := range strings.ToLower(strings.ReplaceAll(name, "'", "")) {
+		if (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(c)
+			dash = false
+		} else {
+			dash = true
+		}
+	}
+	return b.String()
+}
+
+// splits a textarea into its non-blank lines
+func lines(s string) []string {
+	var out []string
+	for _, line := range strings.Split(s, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			out = append(out, line)
+		}
+	}
+	return out
+}
+
+func activeRooms(rooms []models.Room) []models.Room {
+	var out []models.Room
+	for _, room := range rooms {
+		if room.Active {
+			out = append(out, room)
+		}
+	}
+	return out
+}
@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestRepository_AdminPostNewRoom(t *testing.T) {
+	var tests = []struct {
+		name               string
+		roomName           string
+		slug               string
+		capacity           string
+		photos             string
+		expectedStatusCode int
+		expectedError      string
+	}{
+		{"added", "Colonel's Cabin", "colonels-cabin", "3", "/static/images/outside.png", http.StatusSeeOther, ""},
+		{"slug from the name", "Colonel's Cabin", "", "3", "", http.StatusSeeOther, ""},
+		{"no name", "", "colonels-cabin", "3", "", http.StatusOK, "This field cannot be blank"},
+		{"bad slug", "Colonel's Cabin", "Colonel's Cabin", "3", "", http.StatusOK, "lowercase letters"},
+		{"slug taken", "Colonel's Cabin", "taken", "3", "", http.StatusOK, "Another room already uses this"},
+		{"no capacity", "Colonel's Cabin", "", "0", "", http.StatusOK, "how many guests"},
+		{"bad photo", "Colonel's Cabin", "", "3", "outside.png", http.StatusOK, "Photos have to be links"},
+	}
+
+	for _, e := range tests {
+		postedData := url.Values{}
+		postedData.Add("room_name", e.roomName)
+		postedData.Add("slug", e.slug)
+		postedData.Add("capacity", e.capacity)
+		postedData.Add("description", "Cozy")
+		postedData.Add("amenities", "Sea view\n\nFireplace")
+		postedData.Add("photos", e.photos)
+		postedData.Add("active", "1")
+
+		req, _ := http.NewRequest("POST", "/admin/rooms", strings.NewReader(postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req = req.WithContext(getCtx(req))
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminPostNewRoom)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected %d, got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if e.expectedError != "" && !strings.Contains(rr.Body.String(), e.expectedError) {
+			t.Errorf("%s: expected the form to say %q", e.name, e.expectedError)
+		}
+	}
+}
+
+func TestRepository_AdminPostRoom(t *testing.T) {
+	var tests = []struct {
+		name               string
+		id                 string
+		slug               string
+		expectedStatusCode int
+	}{
+		{"saved", "1", "generals-quarters", http.StatusSeeOther},
+		{"slug taken", "1", "taken", http.StatusOK},
+		{"bad id", "x", "generals-quarters", http.StatusBadRequest},
+		{"database error", "3", "closed-room", http.StatusInternalServerError},
+	}
+
+	for _, e := range tests {
+		postedData := url.Values{}
+		postedData.Add("room_name", "General's Quarters")
+		postedData.Add("slug", e.slug)
+		postedData.Add("capacity", "2")
+
+		req, _ := http.NewRequest("POST", "/admin/rooms/"+e.id, strings.NewReader(postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", e.id)
+		req = req.WithContext(context.WithValue(getCtx(req), chi.RouteCtxKey, rctx))
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminPostRoom)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected %d, got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+	}
+}
+
+func TestRepository_AdminDeleteRoom(t *testing.T) {
+	var tests = []struct {
+		id                 string
+		expectedStatusCode int
+		expectedLocation   string
+	}{
+		{"1", http.StatusSeeOther, "/admin/rooms/1"}, // has reservations
+		{"2", http.StatusSeeOther, "/admin/rooms"},
+		{"9", http.StatusNotFound, ""},
+		{"x", http.StatusBadRequest, ""},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/admin/rooms/"+e.id+"/delete", nil)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", e.id)
+		req = req.WithContext(context.WithValue(getCtx(req), chi.RouteCtxKey, rctx))
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminDeleteRoom)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("id %s: expected %d, got %d", e.id, e.expectedStatusCode, rr.Code)
+		}
+		if e.expectedLocation != "" && rr.Header().Get("Location") != e.expectedLocation {
+			t.Errorf("id %s: expected a redirect to %s, got %s", e.id, e.expectedLocation, rr.Header().Get("Location"))
+		}
+	}
+}
+
+func TestRepository_RoomPage(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/rooms/generals-quarters", nil)
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("slug", "generals-quarters")
+	req = req.WithContext(context.WithValue(getCtx(req), chi.RouteCtxKey, rctx))
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.RoomPage).ServeHTTP(rr, req)
+
+	body := rr.Body.String()
+	if rr.Code != http.StatusOK || !strings.Contains(body, "General&#39;s Quarters") || !strings.Contains(body, "Sea view") {
+		t.Errorf("expected the room's page, got %d", rr.Code)
+	}
+	if !strings.Contains(body, `formData.append("room_id", "1")`) {
+		t.Error("expected the availability check to be for room 1")
+	}
+}
+
+func TestSlugify(t *testing.T) {
+	var tests = []struct {
+		name string
+		slug string
+	}{
+		{"General's Quarters", "generals-quarters"},
+		{"  Room 12 -- Garden view ", "room-12-garden-view"},
+		{"!!!", ""},
+	}
+
+	for _, e := range tests {
+		if got := slugify(e.name); got != e.slug {
+			t.Errorf("%q: expected %q, got %q", e.name, e.slug, got)
+		}
+	}
+}
@@ -13,10 +13,12 @@ import (
 
 	"github.com/alexedwards/scs/v2"
 	"github.com/andkolbe/bookings/internal/config"
+	"github.com/andkolbe/bookings/internal/helpers"
 	"github.com/andkolbe/bookings/internal/models"
+	"github.com/andkolbe/bookings/internal/pricing"
 	"github.com/andkolbe/bookings/internal/render"
-	"github.com/go-chi/chi"
-	"github.com/go-chi/chi/middleware"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
 	"github.com/justinas/nosurf"
 )
 
@@ -29,6 +31,8 @@ var functions = template.FuncMap{
 	"formatDate": render.FormatDate,
 	"iterate":    render.Iterate,
 	"add":        render.Add,
+	"roleName":   models.AccessLevelName,
+	"price":      pricing.Format,
 }
 
 func TestMain(m *testing.M) {
@@ -42,6 +46,13 @@ func TestMain(m *testing.M) {
 	// change this to true when in production
 	app.InProduction = false
 
+	// the login throttling defaults
+	app.LoginMaxFailures = 5
+	app.LoginMaxIPFailures = 20
+	app.LoginLockout = 15 * time.Minute
+	app.LoginFailureWindow = time.Hour
+	app.LoginIPLockout = true
+
 	// print these to the terminal
 	infoLog := log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
 	app.InfoLog = infoLog
@@ -58,12 +69,6 @@ func TestMain(m *testing.M) {
 	// store the session in a variable
 	app.Session = session
 
-	mailChan := make(chan models.MailData)
-	app.MailChan = mailChan
-	defer close(mailChan)
-
-	listenForMail()
-
 	// create template cache
 	tc, err := CreateTestTemplateCache()
 	if err != nil {
@@ -73,24 +78,17 @@ func TestMain(m *testing.M) {
 	app.TemplateCache = tc
 	app.UseCache = true
 
+	pathToMailTemplates = "./../../email-templates"
 	repo := NewTestRepo(&app)
 	NewHandlers(repo)
 
 	// gives the render component of our app access to the app config variable
 	render.NewRenderer(&app)
+	helpers.NewHelpers(&app)
 
 	os.Exit(m.Run())
 }
 
-func listenForMail() {
-	// same as what we do in our live app but we skip the sending of mail
-	go func() {
-		for {
-			_ = <-app.MailChan
-		}
-	}()
-}
-
 func getRoutes() http.Handler {
 
 	mux := chi.NewRouter()
@@ -102,8 +100,10 @@ func getRoutes() http.Handler {
 
 	mux.Get("/", Repo.Home)
 	mux.Get("/about", Repo.About)
-	mux.Get("/generals-quarters", Repo.Generals)
-	mux.Get("/majors-suite", Repo.Majors)
+	mux.Get("/rooms", Repo.Rooms)
+	mux.Get("/rooms/{slug}", Repo.RoomPage)
+	mux.Get("/generals-quarters", http.RedirectHandler("/rooms/generals-quarters", http.StatusMovedPermanently).ServeHTTP)
+	mux.Get("/majors-suite", http.RedirectHandler("/rooms/majors-suite", http.StatusMovedPermanently).ServeHTTP)
 	mux.Get("/contact", Repo.Contact)
 
 	mux.Get("/make-reservation", Repo.Reservation)
@@ -116,19 +116,54 @@ func getRoutes() http.Handler {
 
 	mux.Get("/user/login", Repo.ShowLogin)
 	mux.Post("/user/login", Repo.PostShowLogin)
+	mux.Get("/user/login/two-factor", Repo.ShowLoginTwoFactor)
+	mux.Post("/user/login/two-factor", Repo.PostLoginTwoFactor)
 	mux.Get("/user/logout", Repo.Logout)
-
-	mux.Get("/dashboard", Repo.AdminDashboard)
-
-	mux.Get("/reservations-new", Repo.AdminNewReservations)
-	mux.Get("/reservations-all", Repo.AdminAllReservations)
-	mux.Get("/reservations-calendar", Repo.AdminReservationsCalendar)
-	mux.Post("/reservations-calendar", Repo.AdminPostReservationsCalendar)
-	mux.Get("/process-reservation/{src}/{id}/do", Repo.AdminProcessReservation)
-	mux.Delete("/delete-reservation/{src}/{id}/do", Repo.AdminDeleteReservation)
-
-	mux.Get("/reservations/{src}/{id}/show", Repo.AdminShowReservation)
-	mux.Post("/reservations/{src}/{id}", Repo.AdminPostShowReservation)
+	mux.Get("/user/forgot-password", Repo.ShowForgotPassword)
+	mux.Post("/user/forgot-password", Repo.PostForgotPassword)
+	mux.Get("/user/reset-password/{token}", Repo.ShowResetPassword)
+	mux.Post("/user/reset-password/{token}", Repo.PostResetPassword)
+
+	mux.Route("/admin", func(mux chi.Router) {
+		mux.Get("/dashboard", Repo.AdminDashboard)
+
+		mux.Get("/reservations-new", Repo.AdminNewReservations)
+		mux.Get("/reservations-all", Repo.AdminAllReservations)
+		mux.Get("/reservations-calendar", Repo.AdminReservationsCalendar)
+		mux.Post("/blocks", Repo.AdminPostBlock)
+		mux.Post("/blocks/{id}/delete", Repo.AdminDeleteBlock)
+		mux.Post("/reservations/{src}/{id}/status", Repo.AdminPostReservationStatus)
+		mux.Get("/rooms", Repo.AdminRooms)
+		mux.Get("/rooms/new", Repo.AdminNewRoom)
+		mux.Post("/rooms", Repo.AdminPostNewRoom)
+		mux.Get("/rooms/{id}", Repo.AdminShowRoom)
+		mux.Post("/rooms/{id}", Repo.AdminPostRoom)
+		mux.Post("/rooms/{id}/delete", Repo.AdminDeleteRoom)
+		mux.Get("/rates", Repo.AdminRates)
+		mux.Post("/rates/rooms/{id}", Repo.AdminPostRoomRates)
+		mux.Post("/rates/seasons", Repo.AdminPostSeasonalRate)
+		mux.Post("/rates/seasons/{id}/delete", Repo.AdminDeleteSeasonalRate)
+		mux.Get("/stay-rules", Repo.AdminStayRules)
+		mux.Post("/stay-rules", Repo.AdminPostStayRule)
+		mux.Post("/stay-rules/{id}/delete", Repo.AdminDeleteStayRule)
+		mux.Get("/users", Repo.AdminUsers)
+		mux.Get("/users/new", Repo.AdminNewUser)
+		mux.Post("/users", Repo.AdminPostNewUser)
+		mux.Get("/users/{id}", Repo.AdminShowUser)
+		mux.Post("/users/{id}", Repo.AdminPostUser)
+		mux.Post("/users/{id}/invite", Repo.AdminPostUserInvite)
+		mux.Post("/users/{id}/two-factor/reset", Repo.AdminResetUserTwoFactor)
+		mux.Get("/two-factor", Repo.AdminTwoFactor)
+		mux.Post("/two-factor", Repo.AdminPostTwoFactor)
+		mux.Post("/two-factor/recovery-codes", Repo.AdminPostRecoveryCodes)
+		mux.Post("/two-factor/disable", Repo.AdminDisableTwoFactor)
+		mux.Post("/users/two-factor-policy", Repo.AdminPostTwoFactorPolicy)
+		mux.Get("/login-lockouts", Repo.AdminLoginLockouts)
+		mux.Post("/login-lockouts/unlock", Repo.AdminUnlockLogin)
+
+		mux.Get("/reservations/{src}/{id}/show", Repo.AdminShowReservation)
+		mux.Post("/reservations/{src}/{id}", Repo.AdminPostShowReservation)
+	})
 
 	// create a file server - a place to get static files from
 	fileServer := http.FileServer(http.Dir("./static/"))
@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/andkolbe/bookings/internal/forms"
+	"github.com/andkolbe/bookings/internal/helpers"
+	"github.com/andkolbe/bookings/internal/models"
+	"github.com/andkolbe/bookings/internal/render"
+	"github.com/andkolbe/bookings/internal/stayrules"
+	"github.com/go-chi/chi/v5"
+)
+
+// lists the stay rules, and the form to add another
+func (m *Repository) AdminStayRules(w http.ResponseWriter, r *http.Request) {
+	m.renderStayRules(w, r, forms.New(nil))
+}
+
+// adds a stay rule to a room
+func (m *Repository) AdminPostStayRule(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("room_id")
+
+	roomID, err := strconv.Atoi(form.Get("room_id"))
+	if err != nil || roomID < 1 {
+		form.Errors.Add("room_id", "Choose a room")
+	}
+	startDate, endDate := validateStay(form, "start_date", "end_date")
+	minNights := parseNights(form, "min_nights")
+	maxNights := parseNights(form, "max_nights")
+	noArrival := parseWeekdays(form, "no_arrival")
+	noDeparture := parseWeekdays(form, "no_departure")
+
+	if form.Valid() {
+		if minNights > 0 && maxNights > 0 && minNights > maxNights {
+			form.Errors.Add("max_nights", "The maximum can't be less than the minimum")
+		}
+		if minNights == 0 && maxNights == 0 && noArrival == 0 && noDeparture == 0 {
+			form.Errors.Add("min_nights", "Set at least one rule")
+		}
+	}
+
+	if !form.Valid() {
+		m.renderStayRules(w, r, form)
+		return
+	}
+
+	_, err = m.DB.InsertStayRule(r.Context(), models.StayRule{
+		RoomID:          roomID,
+		StartDate:       startDate,
+		EndDate:         endDate,
+		MinNights:       minNights,
+		MaxNights:       maxNights,
+		NoArrivalDays:   noArrival,
+		NoDepartureDays: noDeparture,
+	})
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Stay rule added")
+	http.Redirect(w, r, "/admin/stay-rules", http.StatusSeeOther)
+}
+
+// removes a stay rule
+func (m *Repository) AdminDeleteStayRule(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	err = m.DB.DeleteStayRule(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Stay rule removed")
+	http.Redirect(w, r, "/admin/stay-rules", http.StatusSeeOther)
+}
+
+func (m *Repository) renderStayRules(w http.ResponseWriter, r *http.Request, form *forms.Form) {
+	rules, err := m.DB.AllStayRules(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	rooms, err := m.DB.AllRooms(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["rules"] = rules
+	data["rooms"] = rooms
+	data["weekdays"] = []time.Weekday{time.Sunday, time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday}
+
+	render.Template(w, r, "admin-stay-rules.page.html", &models.TemplateData{
+		Data: data,
+		Form: form,
+	})
+}
+
+// parses an optional number of nights from field, adding an error to the form if it isn't one
+func parseNights(form *forms.Form, field string) int {
+	if form.Get(field) == "" {
+		return 0
+	}
+	n, err := strconv.Atoi(form.Get(field))
+	if err != nil || n < 0 {
+		form.Errors.Add(field, "Enter a number of nights")
+	}
+	return n
+}
+
+// parses the days of the week ticked in field, which has a value of 0 for sunday up to 6 for saturday
+func parseWeekdays(form *forms.Form, field string) models.Weekdays {
+	var w models.Weekdays
+	for _, v := range form.Values[field] {
+		d, err := strconv.Atoi(v)
+		if err != nil || d < 0 || d > 6 {
+			form.Errors.Add(field, "Choose days of the week")
+			continue
+		}
+		w |= models.WeekdaysOf(time.Weekday(d))
+	}
+	return w
+}
+
+// returns what stops a stay in a room from start to end under its stay rules, or nothing if it is allowed
+func (m *Repository) stayProblems(ctx context.Context, roomID int, start, end time.Time) ([]string, error) {
+	rules, err := m.DB.StayRulesForDates(ctx, start, end)
+	if err != nil {
+		return nil, err
+	}
+	return stayrules.Check(rulesForRoom(rules, roomID), start, end), nil
+}
+
+// returns what stops a party of guests staying in room from start to end: its stay rules, and how many it sleeps.
+// Booking a room and a guest moving their booking to new dates both check this, so the rules stay the same
+func (m *Repository) bookingProblems(ctx context.Context, room models.Room, start, end time.Time, guests int) ([]string, error) {
+	problems, err := m.stayProblems(ctx, room.ID, start, end)
+	if err != nil {
+		return nil, err
+	}
+	if guests > room.Capacity {
+		problems = append(problems, tooManyGuests(room))
+	}
+	return problems, nil
+}
+
+// splits free rooms into those the stay rules allow from start to end, and what stops the stay in each of the others
+func (m *Repository) allowedRooms(ctx context.Context, rooms []models.Room, start, end time.Time) ([]models.Room, map[string][]string, error) {
+	rules, err := m.DB.StayRulesForDates(ctx, start, end)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var allowed []models.Room
+	rejected := make(map[string][]string)
+	for _, room := range rooms {
+		problems := stayrules.Check(rulesForRoom(rules, room.ID), start, end)
+		if len(problems) > 0 {
+			rejected[room.RoomName] = problems
+			continue
+		}
+		allowed = append(allowed, room)
+	}
+
+	return allowed, rejected, nil
+}
+
+func rulesForRoom(rules []models.StayRule, roomID int) []models.StayRule {
+	var out []models.StayRule
+	for _, r := range rules {
+		if r.RoomID == roomID {
+			out = append(out, r)
+		}
+	}
+	return out
+}
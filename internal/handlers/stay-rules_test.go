@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andkolbe/bookings/internal/models"
+	"github.com/go-chi/chi/v5"
+)
+
+func TestRepository_AdminPostStayRule(t *testing.T) {
+	var tests = []struct {
+		name               string
+		roomID             string
+		minNights          string
+		maxNights          string
+		noArrival          []string
+		expectedStatusCode int
+	}{
+		{"added", "1", "3", "14", []string{"0", "6"}, http.StatusSeeOther},
+		{"only days", "1", "", "", []string{"0"}, http.StatusSeeOther},
+		{"no rule", "1", "", "", nil, http.StatusOK},
+		{"min over max", "1", "7", "3", nil, http.StatusOK},
+		{"not a number", "1", "three", "", nil, http.StatusOK},
+		{"not a day", "1", "", "", []string{"7"}, http.StatusOK},
+		{"no room", "", "3", "", nil, http.StatusOK},
+		{"database error", "3", "3", "", nil, http.StatusInternalServerError},
+	}
+
+	for _, e := range tests {
+		postedData := url.Values{}
+		postedData.Add("room_id", e.roomID)
+		postedData.Add("start_date", "2050-06-01")
+		postedData.Add("end_date", "2050-09-01")
+		postedData.Add("min_nights", e.minNights)
+		postedData.Add("max_nights", e.maxNights)
+		for _, d := range e.noArrival {
+			postedData.Add("no_arrival", d)
+		}
+
+		req, _ := http.NewRequest("POST", "/admin/stay-rules", strings.NewReader(postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req = req.WithContext(getCtx(req))
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminPostStayRule)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected %d, got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+	}
+}
+
+func TestRepository_AdminDeleteStayRule(t *testing.T) {
+	var tests = []struct {
+		id                 string
+		expectedStatusCode int
+	}{
+		{"1", http.StatusSeeOther},
+		{"x", http.StatusBadRequest},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/admin/stay-rules/"+e.id+"/delete", nil)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", e.id)
+		req = req.WithContext(context.WithValue(getCtx(req), chi.RouteCtxKey, rctx))
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminDeleteStayRule)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("id %s: expected %d, got %d", e.id, e.expectedStatusCode, rr.Code)
+		}
+	}
+}
+
+// the test repo has a 3 night minimum and no sunday check-ins for room 1 in June 2050
+func TestRepository_AllowedRooms(t *testing.T) {
+	rooms := []models.Room{{ID: 1, RoomName: "General's Quarters"}, {ID: 2, RoomName: "Major's Suite"}}
+	start := time.Date(2050, 6, 5, 0, 0, 0, 0, time.UTC) // a sunday
+	end := time.Date(2050, 6, 6, 0, 0, 0, 0, time.UTC)
+
+	allowed, rejected, err := Repo.allowedRooms(context.Background(), rooms, start, end)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(allowed) != 1 || allowed[0].ID != 2 {
+		t.Errorf("expected only room 2 to be allowed, got %+v", allowed)
+	}
+	if len(rejected["General's Quarters"]) != 2 {
+		t.Errorf("expected room 1 to be too short and a sunday check-in, got %v", rejected)
+	}
+
+	// outside june both rooms are fine
+	allowed, rejected, _ = Repo.allowedRooms(context.Background(), rooms, start.AddDate(0, 1, 0), end.AddDate(0, 1, 0))
+	if len(allowed) != 2 || len(rejected) != 0 {
+		t.Errorf("expected both rooms in july, got %+v %v", allowed, rejected)
+	}
+}
+
+func TestRepository_PostReservation_StayRules(t *testing.T) {
+	reservation := models.Reservation{
+		RoomID:    1,
+		StartDate: time.Date(2050, 6, 6, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2050, 6, 7, 0, 0, 0, 0, time.UTC),
+	}
+
+	postedData := url.Values{}
+	postedData.Add("first_name", "John")
+	postedData.Add("last_name", "Smith")
+	postedData.Add("email", "john@smith.com")
+	postedData.Add("phone", "555-555-5555")
+
+	req, _ := http.NewRequest("POST", "/make-reservation", strings.NewReader(postedData.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	session.Put(ctx, "reservation", reservation)
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(Repo.PostReservation)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/search-availability" {
+		t.Errorf("expected a redirect to the search, got %d %s", rr.Code, rr.Header().Get("Location"))
+	}
+	if msg := session.GetString(ctx, "error"); !strings.Contains(msg, "at least 3 nights") {
+		t.Errorf("expected the guest to be told about the minimum stay, got %q", msg)
+	}
+}
+
+func TestRepository_GuestPostChangeDates_StayRules(t *testing.T) {
+	postedData := url.Values{}
+	postedData.Add("start", "2050-06-05")
+	postedData.Add("end", "2050-06-10")
+
+	token := testGuestToken(1)
+	req, _ := http.NewRequest("POST", "/my-reservation/"+token+"/change", strings.NewReader(postedData.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("token", token)
+	req = req.WithContext(context.WithValue(getCtx(req), chi.RouteCtxKey, rctx))
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.GuestPostChangeDates).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "Check-in isn&#39;t available on Sunday, June 5, 2050") {
+		t.Errorf("expected the form again with the reason, got %d", rr.Code)
+	}
+}
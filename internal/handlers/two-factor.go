@@ -0,0 +1,476 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andkolbe/bookings/internal/forms"
+	"github.com/andkolbe/bookings/internal/helpers"
+	"github.com/andkolbe/bookings/internal/models"
+	"github.com/andkolbe/bookings/internal/render"
+	"github.com/andkolbe/bookings/internal/repository"
+	"github.com/andkolbe/bookings/internal/totp"
+	"github.com/go-chi/chi/v5"
+	"github.com/skip2/go-qrcode"
+)
+
+const (
+	// the name authenticator apps show the account under
+	totpIssuer = "Bookings"
+	// how many recovery codes a user gets at a time
+	recoveryCodeCount = 10
+	// how long someone has after entering their password to enter a code
+	twoFactorLoginTTL = 5 * time.Minute
+	// wrong codes allowed before they have to enter their password again
+	twoFactorAttempts = 5
+)
+
+// shows the second step of logging in, for a code from the user's authenticator app
+func (m *Repository) ShowLoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	_, ok := m.pendingTwoFactorUser(w, r)
+	if !ok {
+		return
+	}
+
+	render.Template(w, r, "login-two-factor.page.html", &models.TemplateData{
+		Form: forms.New(nil),
+	})
+}
+
+// logs in a user who has entered their password, if they give a code from their authenticator app or one of their
+// recovery codes
+func (m *Repository) PostLoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	user, ok := m.pendingTwoFactorUser(w, r)
+	if !ok {
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	// wrong codes count against the account like wrong passwords, so it can be locked part way through logging in
+	wait, err := m.loginWait(r, user.Email)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	if wait > 0 {
+		m.forgetTwoFactorLogin(r)
+		m.App.Session.Put(r.Context(), "error", "Too many failed logins. Try again in "+waitText(wait))
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("code")
+	if form.Valid() {
+		ok, err = m.useTwoFactorCode(r.Context(), user, form.Get("code"), true)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		if !ok {
+			form.Errors.Add("code", "That code isn't right, or has already been used")
+			err = m.recordLoginFailure(r, user.Email)
+			if err != nil {
+				helpers.ServerError(w, err)
+				return
+			}
+		}
+	}
+	if !form.Valid() {
+		attempts := m.App.Session.GetInt(r.Context(), "two_factor_attempts") + 1
+		if attempts >= twoFactorAttempts {
+			m.forgetTwoFactorLogin(r)
+			m.App.Session.Put(r.Context(), "error", "Too many wrong codes, log in again")
+			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+			return
+		}
+		m.App.Session.Put(r.Context(), "two_factor_attempts", attempts)
+
+		render.Template(w, r, "login-two-factor.page.html", &models.TemplateData{
+			Form: form,
+		})
+		return
+	}
+
+	err = m.clearLoginFailures(r, user.Email)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.forgetTwoFactorLogin(r)
+	_ = m.App.Session.RenewToken(r.Context())
+	m.logIn(r, user)
+
+	if isRecoveryCode(form.Get("code")) {
+		left, err := m.DB.CountRecoveryCodes(r.Context(), user.ID)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		m.App.Session.Put(r.Context(), "warning", fmt.Sprintf("Logged in with a recovery code. You have %d left", left))
+	} else {
+		m.App.Session.Put(r.Context(), "flash", "Logged in successfully")
+	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// shows whether two-factor login is on for the user. If it isn't, shows a new secret to set up their
+// authenticator app with, which is kept in the session until they confirm it with a code
+func (m *Repository) AdminTwoFactor(w http.ResponseWriter, r *http.Request) {
+	user, err := m.currentUser(r)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	secret := m.App.Session.GetString(r.Context(), "two_factor_secret")
+	if !user.TwoFactor() && secret == "" {
+		secret, err = totp.NewSecret()
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		m.App.Session.Put(r.Context(), "two_factor_secret", secret)
+	}
+
+	err = m.renderTwoFactor(w, r, user, secret, forms.New(nil))
+	if err != nil {
+		helpers.ServerError(w, err)
+	}
+}
+
+// turns on two-factor login once the user has shown their authenticator app has the secret, and shows their
+// recovery codes
+func (m *Repository) AdminPostTwoFactor(w http.ResponseWriter, r *http.Request) {
+	user, err := m.currentUser(r)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	secret := m.App.Session.GetString(r.Context(), "two_factor_secret")
+	if user.TwoFactor() || secret == "" {
+		http.Redirect(w, r, "/admin/two-factor", http.StatusSeeOther)
+		return
+	}
+
+	err = r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("code")
+	step, ok := totp.Validate(secret, form.Get("code"), time.Now())
+	if form.Get("code") != "" && !ok {
+		form.Errors.Add("code", "That code isn't right. Check the time on your phone is correct")
+	}
+	if !form.Valid() {
+		err = m.renderTwoFactor(w, r, user, secret, form)
+		if err != nil {
+			helpers.ServerError(w, err)
+		}
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	err = m.DB.EnableTwoFactor(r.Context(), user.ID, secret, step, hashes)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.App.Session.Remove(r.Context(), "two_factor_secret")
+
+	m.App.Session.Put(r.Context(), "flash", "Two-factor login is on")
+	renderRecoveryCodes(w, r, codes)
+}
+
+// gives the user a new set of recovery codes, if they can enter a code from their authenticator app.
+// Their old ones stop working
+func (m *Repository) AdminPostRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	user, ok := m.confirmTwoFactor(w, r, false)
+	if !ok {
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	err = m.DB.ReplaceRecoveryCodes(r.Context(), user.ID, hashes)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	renderRecoveryCodes(w, r, codes)
+}
+
+// turns off two-factor login for the user, if they can enter a code and it isn't required
+func (m *Repository) AdminDisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	required, err := m.twoFactorRequired(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	if required {
+		m.App.Session.Put(r.Context(), "error", "Two-factor login is required for everybody, so it can't be turned off")
+		http.Redirect(w, r, "/admin/two-factor", http.StatusSeeOther)
+		return
+	}
+
+	user, ok := m.confirmTwoFactor(w, r, true)
+	if !ok {
+		return
+	}
+
+	err = m.DB.DisableTwoFactor(r.Context(), user.ID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Two-factor login is off")
+	http.Redirect(w, r, "/admin/two-factor", http.StatusSeeOther)
+}
+
+// sets whether every admin user has to turn on two-factor login. Users who haven't are sent to set it up the next
+// time they open an admin page
+func (m *Repository) AdminPostTwoFactorPolicy(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	value, message := "", "Two-factor login is optional"
+	if r.Form.Get("require") != "" {
+		value, message = "1", "Two-factor login is required for everybody"
+	}
+
+	err = m.DB.PutSetting(r.Context(), models.SettingRequireTwoFactor, value)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", message)
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+// turns off two-factor login for another user, e.g. because they lost their phone and their recovery codes
+func (m *Repository) AdminResetUserTwoFactor(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	err = m.DB.DisableTwoFactor(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Two-factor login turned off. They can set it up again from their account")
+	http.Redirect(w, r, "/admin/users/"+strconv.Itoa(id), http.StatusSeeOther)
+}
+
+// returns the user who has entered their password but not their code yet. If it returns false, they have been sent
+// back to the login page
+func (m *Repository) pendingTwoFactorUser(w http.ResponseWriter, r *http.Request) (models.User, bool) {
+	id := m.App.Session.GetInt(r.Context(), "two_factor_user_id")
+	started := time.Unix(int64(m.App.Session.GetInt(r.Context(), "two_factor_started_at")), 0)
+	if id == 0 || time.Since(started) > twoFactorLoginTTL {
+		m.forgetTwoFactorLogin(r)
+		m.App.Session.Put(r.Context(), "error", "Log in again")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return models.User{}, false
+	}
+
+	user, err := m.DB.GetUserByID(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return models.User{}, false
+	}
+
+	return user, true
+}
+
+func (m *Repository) forgetTwoFactorLogin(r *http.Request) {
+	m.App.Session.Remove(r.Context(), "two_factor_user_id")
+	m.App.Session.Remove(r.Context(), "two_factor_started_at")
+	m.App.Session.Remove(r.Context(), "two_factor_attempts")
+}
+
+// checks the code posted with a form that changes the user's two-factor login. If it returns false, a response
+// has already been written
+func (m *Repository) confirmTwoFactor(w http.ResponseWriter, r *http.Request, allowRecovery bool) (models.User, bool) {
+	user, err := m.currentUser(r)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return user, false
+	}
+
+	err = r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return user, false
+	}
+
+	ok, err := m.useTwoFactorCode(r.Context(), user, r.Form.Get("code"), allowRecovery)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return user, false
+	}
+	if !ok {
+		m.App.Session.Put(r.Context(), "error", "That code isn't right, or has already been used")
+		http.Redirect(w, r, "/admin/two-factor", http.StatusSeeOther)
+		return user, false
+	}
+
+	return user, true
+}
+
+// checks a code from the user's authenticator app, or one of their recovery codes if allowRecovery is true, and
+// uses it up so it can't be used again
+func (m *Repository) useTwoFactorCode(ctx context.Context, user models.User, code string, allowRecovery bool) (bool, error) {
+	if !user.TwoFactor() || strings.TrimSpace(code) == "" {
+		return false, nil
+	}
+
+	if isRecoveryCode(code) {
+		if !allowRecovery {
+			return false, nil
+		}
+		err := m.DB.UseRecoveryCode(ctx, user.ID, totp.HashRecoveryCode(code))
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+
+	step, ok := totp.Validate(user.TOTPSecret, code, time.Now())
+	if !ok {
+		return false, nil
+	}
+	err := m.DB.UseTOTPStep(ctx, user.ID, step)
+	if errors.Is(err, repository.ErrCodeUsed) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// codes from an authenticator app are only digits, recovery codes have letters in them too
+func isRecoveryCode(code string) bool {
+	for _, c := range strings.TrimSpace(code) {
+		if (c < '0' || c > '9') && c != ' ' {
+			return true
+		}
+	}
+	return false
+}
+
+// returns true if every admin user has to use two-factor login
+func (m *Repository) twoFactorRequired(ctx context.Context) (bool, error) {
+	value, err := m.DB.GetSetting(ctx, models.SettingRequireTwoFactor)
+	return value == "1", err
+}
+
+// returns the user who is logged in
+func (m *Repository) currentUser(r *http.Request) (models.User, error) {
+	return m.DB.GetUserByID(r.Context(), m.App.Session.GetInt(r.Context(), "user_id"))
+}
+
+// returns new recovery codes to show the user, and the hashes to store
+func newRecoveryCodes() ([]string, []string, error) {
+	codes, err := totp.NewRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	hashes := make([]string, len(codes))
+	for i, c := range codes {
+		hashes[i] = totp.HashRecoveryCode(c)
+	}
+	return codes, hashes, nil
+}
+
+func (m *Repository) renderTwoFactor(w http.ResponseWriter, r *http.Request, user models.User, secret string, form *forms.Form) error {
+	required, err := m.twoFactorRequired(r.Context())
+	if err != nil {
+		return err
+	}
+
+	data := make(map[string]interface{})
+	data["enabled"] = user.TwoFactor()
+	data["required"] = required
+
+	stringMap := make(map[string]string)
+	intMap := make(map[string]int)
+	if user.TwoFactor() {
+		intMap["recovery_codes"], err = m.DB.CountRecoveryCodes(r.Context(), user.ID)
+		if err != nil {
+			return err
+		}
+	} else {
+		stringMap["secret"] = secret
+		data["qr"], err = qrCodeImage(totp.URI(totpIssuer, user.Email, secret))
+		if err != nil {
+			return err
+		}
+	}
+
+	render.Template(w, r, "admin-two-factor.page.html", &models.TemplateData{
+		StringMap: stringMap,
+		IntMap:    intMap,
+		Data:      data,
+		Form:      form,
+	})
+	return nil
+}
+
+// shows recovery codes. This is the only time they are shown, only their hashes are stored
+func renderRecoveryCodes(w http.ResponseWriter, r *http.Request, codes []string) {
+	data := make(map[string]interface{})
+	data["codes"] = codes
+
+	render.Template(w, r, "admin-recovery-codes.page.html", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// draws uri as a QR code image for an authenticator app to scan. It is drawn here rather than by a script in the
+// browser, because the uri has the user's secret in it
+func qrCodeImage(uri string) (template.URL, error) {
+	png, err := qrcode.Encode(uri, qrcode.Medium, 256)
+	if err != nil {
+		return "", err
+	}
+	return template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(png)), nil
+}
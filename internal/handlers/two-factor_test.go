@@ -0,0 +1,279 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andkolbe/bookings/internal/models"
+	"github.com/andkolbe/bookings/internal/repository"
+	"github.com/andkolbe/bookings/internal/totp"
+)
+
+// user 2 in the test repo has two-factor login turned on with this secret
+const testTOTPSecret = "JBSWY3DPEHPK3PXP"
+
+func testTOTPCode(t *testing.T) string {
+	code, err := totp.Code(testTOTPSecret, totp.Step(time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+// a repo where every code from an authenticator app has already been used
+type usedCodeRepo struct {
+	repository.DatabaseRepo
+}
+
+func (usedCodeRepo) UseTOTPStep(ctx context.Context, userID int, step int64) error {
+	return repository.ErrCodeUsed
+}
+
+// a repo where owners have said everybody needs two-factor login
+type requireTwoFactorRepo struct {
+	repository.DatabaseRepo
+}
+
+func (requireTwoFactorRepo) GetSetting(ctx context.Context, name string) (string, error) {
+	if name == models.SettingRequireTwoFactor {
+		return "1", nil
+	}
+	return "", nil
+}
+
+func TestRepository_PostShowLogin_TwoFactor(t *testing.T) {
+	postedData := url.Values{}
+	postedData.Add("email", "desk@email.com")
+	postedData.Add("password", "password")
+
+	req, _ := http.NewRequest("POST", "/user/login", strings.NewReader(postedData.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.PostShowLogin).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/user/login/two-factor" {
+		t.Errorf("expected to be asked for a code, got %d %s", rr.Code, rr.Header().Get("Location"))
+	}
+	if session.Exists(ctx, "user_id") {
+		t.Error("expected not to be logged in before entering a code")
+	}
+	if session.GetInt(ctx, "two_factor_user_id") != 2 {
+		t.Error("expected the session to remember who entered their password")
+	}
+}
+
+func TestRepository_PostLoginTwoFactor(t *testing.T) {
+	var tests = []struct {
+		name               string
+		db                 func(repository.DatabaseRepo) repository.DatabaseRepo
+		code               string
+		started            time.Time
+		attempts           int
+		expectedStatusCode int
+		expectedLocation   string
+		loggedIn           bool
+	}{
+		{"code", nil, "app", time.Now(), 0, http.StatusSeeOther, "/", true},
+		{"recovery code", nil, "AAAAA-BBBBB", time.Now(), 0, http.StatusSeeOther, "/", true},
+		{"wrong code", nil, "000000", time.Now(), 0, http.StatusOK, "", false},
+		{"wrong recovery code", nil, "aaaaa-ccccc", time.Now(), 0, http.StatusOK, "", false},
+		{"code used already", func(db repository.DatabaseRepo) repository.DatabaseRepo { return usedCodeRepo{db} }, "app", time.Now(), 0, http.StatusOK, "", false},
+		{"too many wrong codes", nil, "000000", time.Now(), 4, http.StatusSeeOther, "/user/login", false},
+		{"too slow", nil, "app", time.Now().Add(-10 * time.Minute), 0, http.StatusSeeOther, "/user/login", false},
+		{"no password first", nil, "app", time.Time{}, 0, http.StatusSeeOther, "/user/login", false},
+	}
+
+	for _, e := range tests {
+		repo := *Repo
+		if e.db != nil {
+			repo.DB = e.db(Repo.DB)
+		}
+
+		code := e.code
+		if code == "app" {
+			code = testTOTPCode(t)
+		}
+		postedData := url.Values{}
+		postedData.Add("code", code)
+
+		req, _ := http.NewRequest("POST", "/user/login/two-factor", strings.NewReader(postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		if !e.started.IsZero() {
+			session.Put(ctx, "two_factor_user_id", 2)
+			session.Put(ctx, "two_factor_started_at", int(e.started.Unix()))
+			session.Put(ctx, "two_factor_attempts", e.attempts)
+		}
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(repo.PostLoginTwoFactor).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected %d, got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if e.expectedLocation != "" && rr.Header().Get("Location") != e.expectedLocation {
+			t.Errorf("%s: expected a redirect to %s, got %s", e.name, e.expectedLocation, rr.Header().Get("Location"))
+		}
+		if session.Exists(ctx, "user_id") != e.loggedIn {
+			t.Errorf("%s: expected logged in to be %t", e.name, e.loggedIn)
+		}
+		if e.loggedIn && session.Exists(ctx, "two_factor_user_id") {
+			t.Errorf("%s: expected the half finished login to be forgotten", e.name)
+		}
+	}
+}
+
+func TestRepository_AdminPostTwoFactor(t *testing.T) {
+	secret, err := totp.NewSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, _ := totp.Code(secret, totp.Step(time.Now()))
+
+	var tests = []struct {
+		name               string
+		secret             string
+		code               string
+		expectedStatusCode int
+		expectedHTML       string
+	}{
+		{"turned on", secret, code, http.StatusOK, "Recovery Codes"},
+		{"wrong code", secret, "000000", http.StatusOK, "That code isn&#39;t right"},
+		{"no secret", "", code, http.StatusSeeOther, ""},
+	}
+
+	for _, e := range tests {
+		postedData := url.Values{}
+		postedData.Add("code", e.code)
+
+		req, _ := http.NewRequest("POST", "/admin/two-factor", strings.NewReader(postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		session.Put(ctx, "user_id", 1)
+		if e.secret != "" {
+			session.Put(ctx, "two_factor_secret", e.secret)
+		}
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.AdminPostTwoFactor).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected %d, got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("%s: expected the page to say %q", e.name, e.expectedHTML)
+		}
+		if e.name == "turned on" && session.Exists(ctx, "two_factor_secret") {
+			t.Errorf("%s: expected the secret to be taken out of the session", e.name)
+		}
+	}
+}
+
+func TestRepository_AdminDisableTwoFactor(t *testing.T) {
+	var tests = []struct {
+		name          string
+		db            func(repository.DatabaseRepo) repository.DatabaseRepo
+		code          string
+		expectedFlash string
+		expectedError string
+	}{
+		{"turned off", nil, "app", "Two-factor login is off", ""},
+		{"with a recovery code", nil, "aaaaa-bbbbb", "Two-factor login is off", ""},
+		{"wrong code", nil, "000000", "", "isn't right"},
+		{"required", func(db repository.DatabaseRepo) repository.DatabaseRepo { return requireTwoFactorRepo{db} }, "app", "", "required for everybody"},
+	}
+
+	for _, e := range tests {
+		repo := *Repo
+		if e.db != nil {
+			repo.DB = e.db(Repo.DB)
+		}
+
+		code := e.code
+		if code == "app" {
+			code = testTOTPCode(t)
+		}
+		postedData := url.Values{}
+		postedData.Add("code", code)
+
+		req, _ := http.NewRequest("POST", "/admin/two-factor/disable", strings.NewReader(postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		session.Put(ctx, "user_id", 2)
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(repo.AdminDisableTwoFactor).ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/admin/two-factor" {
+			t.Errorf("%s: expected a redirect to /admin/two-factor, got %d %s", e.name, rr.Code, rr.Header().Get("Location"))
+		}
+		if flash := session.GetString(ctx, "flash"); flash != e.expectedFlash {
+			t.Errorf("%s: expected the flash %q, got %q", e.name, e.expectedFlash, flash)
+		}
+		if e.expectedError != "" && !strings.Contains(session.GetString(ctx, "error"), e.expectedError) {
+			t.Errorf("%s: expected the error to say %q, got %q", e.name, e.expectedError, session.GetString(ctx, "error"))
+		}
+	}
+}
+
+func TestRepository_AdminTwoFactor(t *testing.T) {
+	var tests = []struct {
+		name         string
+		userID       int
+		expectedHTML string
+	}{
+		{"not turned on", 1, "src='data:image/png;base64,"},
+		{"turned on", 2, "You have 8 recovery"},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/admin/two-factor", nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		session.Put(ctx, "user_id", e.userID)
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.AdminTwoFactor).ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Errorf("%s: expected %d, got %d", e.name, http.StatusOK, rr.Code)
+		}
+		body := strings.ReplaceAll(rr.Body.String(), `\/`, "/")
+		if !strings.Contains(body, e.expectedHTML) {
+			t.Errorf("%s: expected the page to have %q", e.name, e.expectedHTML)
+		}
+		// the secret mustn't be handed to a script from somewhere else
+		if strings.Contains(body, "otpauth://") || strings.Contains(body, "qrcode-generator") {
+			t.Errorf("%s: expected the QR code to be drawn on the server", e.name)
+		}
+	}
+}
+
+func TestIsRecoveryCode(t *testing.T) {
+	var tests = []struct {
+		code     string
+		recovery bool
+	}{
+		{"123456", false},
+		{"123 456", false},
+		{"7kq2m-x4bzt", true},
+		{"12345-67890", true},
+	}
+
+	for _, e := range tests {
+		if isRecoveryCode(e.code) != e.recovery {
+			t.Errorf("%q: expected recovery to be %t", e.code, e.recovery)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andkolbe/bookings/internal/forms"
+	"github.com/andkolbe/bookings/internal/helpers"
+	"github.com/andkolbe/bookings/internal/mailer"
+	"github.com/andkolbe/bookings/internal/models"
+	"github.com/andkolbe/bookings/internal/render"
+	"github.com/andkolbe/bookings/internal/repository"
+	"github.com/go-chi/chi/v5"
+)
+
+// the access levels a user can be given, lowest first
+var accessLevels = []int{models.AccessFrontDesk, models.AccessManager, models.AccessOwner}
+
+// lists everybody who can log in to the admin area, and everybody who used to
+func (m *Repository) AdminUsers(w http.ResponseWriter, r *http.Request) {
+	users, err := m.DB.AllUsers(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	required, err := m.twoFactorRequired(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["users"] = users
+	data["two_factor_required"] = required
+
+	render.Template(w, r, "admin-users.page.html", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// shows the form to invite a user
+func (m *Repository) AdminNewUser(w http.ResponseWriter, r *http.Request) {
+	renderUser(w, r, models.User{AccessLevel: models.AccessFrontDesk, Active: true}, forms.New(nil))
+}
+
+// adds a user and emails them a link to choose a password
+func (m *Repository) AdminPostNewUser(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	user := userFromForm(form)
+	user.Active = true
+	if !form.Valid() {
+		renderUser(w, r, user, form)
+		return
+	}
+
+	user.ID, err = m.DB.InsertUser(r.Context(), user)
+	if errors.Is(err, repository.ErrEmailTaken) {
+		form.Errors.Add("email", "Another user already has this email")
+		renderUser(w, r, user, form)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	err = m.sendInvite(r, user)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Invite sent to "+user.Email)
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+// shows the form to change a user
+func (m *Repository) AdminShowUser(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	user, err := m.DB.GetUserByID(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	renderUser(w, r, user, forms.New(nil))
+}
+
+// saves changes to a user. Deactivated users are logged out on their next request, and a new access level
+// takes effect on the next request too
+func (m *Repository) AdminPostUser(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	err = r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	user := userFromForm(form)
+	user.ID = id
+	user.Active = form.Get("active") != ""
+	if !form.Valid() {
+		renderUser(w, r, user, form)
+		return
+	}
+
+	err = m.DB.UpdateUser(r.Context(), user)
+	if errors.Is(err, repository.ErrEmailTaken) {
+		form.Errors.Add("email", "Another user already has this email")
+		renderUser(w, r, user, form)
+		return
+	} else if errors.Is(err, repository.ErrLastOwner) {
+		form.Errors.Add("access_level", "This is the only active owner. Make somebody else an owner first")
+		renderUser(w, r, user, form)
+		return
+	} else if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "User saved")
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+// sends a new invite to a user who hasn't chosen a password yet, e.g. because the first one expired
+func (m *Repository) AdminPostUserInvite(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	user, err := m.DB.GetUserByID(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	if user.Password != "" || !user.Active {
+		m.App.Session.Put(r.Context(), "error", "Only active users who haven't chosen a password yet can be sent an invite")
+		http.Redirect(w, r, "/admin/users/"+strconv.Itoa(id), http.StatusSeeOther)
+		return
+	}
+
+	err = m.sendInvite(r, user)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Invite sent to "+user.Email)
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+// emails a user a link to choose their first password. It is a reset link, so it stops working once they have
+func (m *Repository) sendInvite(r *http.Request, user models.User) error {
+	inviter, err := m.DB.GetUserByID(r.Context(), m.App.Session.GetInt(r.Context(), "user_id"))
+	if err != nil {
+		return err
+	}
+
+	expires := time.Now().Add(m.App.InviteLinkTTL)
+	return m.queueMail(r.Context(), user.Email, mailer.UserInvite{
+		User:      user,
+		InvitedBy: inviter,
+		SetURL:    m.resetURL(user, expires),
+		Expires:   expires,
+	})
+}
+
+func renderUser(w http.ResponseWriter, r *http.Request, user models.User, form *forms.Form) {
+	data := make(map[string]interface{})
+	data["user"] = user
+	data["levels"] = accessLevels
+
+	render.Template(w, r, "admin-user.page.html", &models.TemplateData{
+		Data: data,
+		Form: form,
+	})
+}
+
+// reads a user's name, email and access level from the posted form, adding an error to the form for anything
+// that isn't right
+func userFromForm(form *forms.Form) models.User {
+	form.Required("first_name", "email", "access_level")
+	form.IsEmail("email")
+
+	user := models.User{
+		FirstName: strings.TrimSpace(form.Get("first_name")),
+		LastName:  strings.TrimSpace(form.Get("last_name")),
+		Email:     strings.TrimSpace(form.Get("email")),
+	}
+
+	if form.Get("access_level") != "" {
+		level, _ := strconv.Atoi(form.Get("access_level"))
+		if models.AccessLevelName(level) == "none" {
+			form.Errors.Add("access_level", "Choose an access level")
+		}
+		user.AccessLevel = level
+	}
+
+	return user
+}
@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestRepository_AdminPostNewUser(t *testing.T) {
+	var tests = []struct {
+		name               string
+		email              string
+		accessLevel        string
+		expectedStatusCode int
+		expectedError      string
+	}{
+		{"invited", "jo@email.com", "1", http.StatusSeeOther, ""},
+		{"email taken", "taken@email.com", "1", http.StatusOK, "Another user already has this email"},
+		{"bad email", "jo", "1", http.StatusOK, "Invalid email address"},
+		{"bad access level", "jo@email.com", "4", http.StatusOK, "Choose an access level"},
+	}
+
+	for _, e := range tests {
+		mail := &recordingRepo{DatabaseRepo: Repo.DB}
+		repo := *Repo
+		repo.DB = mail
+
+		postedData := url.Values{}
+		postedData.Add("first_name", "Jo")
+		postedData.Add("last_name", "Smith")
+		postedData.Add("email", e.email)
+		postedData.Add("access_level", e.accessLevel)
+
+		req, _ := http.NewRequest("POST", "/admin/users", strings.NewReader(postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		session.Put(ctx, "user_id", 1)
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(repo.AdminPostNewUser).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected %d, got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if e.expectedError != "" && !strings.Contains(rr.Body.String(), e.expectedError) {
+			t.Errorf("%s: expected the form to say %q", e.name, e.expectedError)
+		}
+
+		// only users that were added get an invite, with a link to choose a password
+		invited := rr.Code == http.StatusSeeOther
+		if invited != (len(mail.mail) == 1) {
+			t.Errorf("%s: expected invited to be %t, got %d messages", e.name, invited, len(mail.mail))
+			continue
+		}
+		if invited && (mail.mail[0].To != e.email || !strings.Contains(mail.mail[0].PlainContent, "/user/reset-password/")) {
+			t.Errorf("%s: expected an invite with a link, got %+v", e.name, mail.mail[0])
+		}
+	}
+}
+
+func TestRepository_AdminPostUser(t *testing.T) {
+	var tests = []struct {
+		name               string
+		id                 string
+		email              string
+		accessLevel        string
+		active             bool
+		expectedStatusCode int
+		expectedError      string
+	}{
+		{"saved", "2", "desk@email.com", "2", true, http.StatusSeeOther, ""},
+		{"deactivated", "2", "desk@email.com", "1", false, http.StatusSeeOther, ""},
+		{"email taken", "2", "taken@email.com", "1", true, http.StatusOK, "Another user already has this email"},
+		{"last owner demoted", "1", "email@email.com", "2", true, http.StatusOK, "only active owner"},
+		{"last owner deactivated", "1", "email@email.com", "3", false, http.StatusOK, "only active owner"},
+		{"owner saved", "1", "email@email.com", "3", true, http.StatusSeeOther, ""},
+		{"unknown user", "9", "nobody@email.com", "1", true, http.StatusNotFound, ""},
+		{"bad id", "x", "desk@email.com", "1", true, http.StatusBadRequest, ""},
+	}
+
+	for _, e := range tests {
+		postedData := url.Values{}
+		postedData.Add("first_name", "Someone")
+		postedData.Add("email", e.email)
+		postedData.Add("access_level", e.accessLevel)
+		if e.active {
+			postedData.Add("active", "1")
+		}
+
+		req, _ := http.NewRequest("POST", "/admin/users/"+e.id, strings.NewReader(postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", e.id)
+		req = req.WithContext(context.WithValue(getCtx(req), chi.RouteCtxKey, rctx))
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.AdminPostUser).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected %d, got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if e.expectedError != "" && !strings.Contains(rr.Body.String(), e.expectedError) {
+			t.Errorf("%s: expected the form to say %q", e.name, e.expectedError)
+		}
+	}
+}
+
+func TestRepository_AdminPostUserInvite(t *testing.T) {
+	var tests = []struct {
+		name             string
+		id               string
+		expectedLocation string
+		mailed           bool
+	}{
+		{"not chosen a password", "4", "/admin/users", true},
+		{"has a password", "2", "/admin/users/2", false},
+		{"deactivated", "3", "/admin/users/3", false},
+	}
+
+	for _, e := range tests {
+		mail := &recordingRepo{DatabaseRepo: Repo.DB}
+		repo := *Repo
+		repo.DB = mail
+
+		req, _ := http.NewRequest("POST", "/admin/users/"+e.id+"/invite", nil)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", e.id)
+		ctx := getCtx(req)
+		req = req.WithContext(context.WithValue(ctx, chi.RouteCtxKey, rctx))
+		session.Put(ctx, "user_id", 1)
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(repo.AdminPostUserInvite).ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != e.expectedLocation {
+			t.Errorf("%s: expected a redirect to %s, got %d %s", e.name, e.expectedLocation, rr.Code, rr.Header().Get("Location"))
+		}
+		if e.mailed != (len(mail.mail) == 1) {
+			t.Errorf("%s: expected mailed to be %t, got %d messages", e.name, e.mailed, len(mail.mail))
+		}
+	}
+}
+
+func TestRepository_AdminUsers(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/admin/users", nil)
+	req = req.WithContext(getCtx(req))
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.AdminUsers).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected %d, got %d", http.StatusOK, rr.Code)
+	}
+	for _, status := range []string{"Active", "Inactive", "Invited"} {
+		if !strings.Contains(rr.Body.String(), ">"+status+"<") {
+			t.Errorf("expected a user to be %s", status)
+		}
+	}
+}
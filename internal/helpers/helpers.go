@@ -1,13 +1,24 @@
 package helpers
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"net/http"
 	"runtime/debug"
 
 	"github.com/andkolbe/bookings/internal/config"
+	"github.com/andkolbe/bookings/internal/models"
 )
 
+type contextKey string
+
+// the request context key for the api token a request was authenticated with
+const apiTokenKey contextKey = "api_token"
+
 
 var app *config.AppConfig
 
@@ -23,12 +34,67 @@ func ClientError(w http.ResponseWriter, status int) {
 }
 
 func ServerError(w http.ResponseWriter, err error) {
+	LogError(err)
+	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+}
+
+// logs an error along with a stack trace
+func LogError(err error) {
 	trace := fmt.Sprintf("%s\n%s", err.Error(), debug.Stack())
 	app.ErrorLog.Println(trace)
-	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 }
 
 func IsAuthenticated(r *http.Request) bool { // return true or false if they are authenticated or not
+	if _, ok := APIToken(r); ok {
+		return true
+	}
 	exists := app.Session.Exists(r.Context(), "user_id")
 	return exists
-}
\ No newline at end of file
+}
+
+// returns true if the logged in user's access level is at least level
+func HasAccess(r *http.Request, level int) bool {
+	if t, ok := APIToken(r); ok {
+		return t.User.AccessLevel >= level
+	}
+	return app.Session.GetInt(r.Context(), "access_level") >= level
+}
+
+// returns a new random api token, which is shown to the user once, and the hash we store
+func NewAPIToken() (string, string, error) {
+	t, err := RandomToken()
+	if err != nil {
+		return "", "", err
+	}
+
+	token := "bk_" + t
+	return token, HashAPIToken(token), nil
+}
+
+// returns 32 random bytes, encoded so they can be used in a url
+func RandomToken() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// returns the hash of an api token. Tokens are long and random, so a plain sha256 is enough and lets us look them up
+func HashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// returns a copy of ctx carrying the api token the request was authenticated with
+func WithAPIToken(ctx context.Context, t models.APIToken) context.Context {
+	return context.WithValue(ctx, apiTokenKey, t)
+}
+
+// returns the api token the request was authenticated with, if it used one
+func APIToken(r *http.Request) (models.APIToken, bool) {
+	t, ok := r.Context().Value(apiTokenKey).(models.APIToken)
+	return t, ok
+}
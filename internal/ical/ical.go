@@ -0,0 +1,100 @@
+package ical
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// the content type of an .ics file
+const ContentType = "text/calendar; charset=utf-8"
+
+const prodID = "-//Bookings//Bookings and Reservations//EN"
+
+// an iCalendar (RFC 5545) calendar
+type Calendar struct {
+	Name   string // shown by clients that support X-WR-CALNAME, may be blank
+	Method string // e.g. PUBLISH, may be blank
+	Events []Event
+}
+
+// a VEVENT. Stays are all day events, so only the dates of Start and End are used, and End is the day
+// the guest leaves, which the spec treats as exclusive
+type Event struct {
+	UID         string
+	Summary     string
+	Description string
+	Location    string
+	Start       time.Time
+	End         time.Time
+	Stamp       time.Time // when the event was created or last changed, defaults to now
+}
+
+// encodes the calendar as an .ics file
+func (c Calendar) Marshal() []byte {
+	var b bytes.Buffer
+
+	writeLine(&b, "BEGIN:VCALENDAR")
+	writeLine(&b, "VERSION:2.0")
+	writeLine(&b, "PRODID:"+prodID)
+	writeLine(&b, "CALSCALE:GREGORIAN")
+	if c.Method != "" {
+		writeLine(&b, "METHOD:"+c.Method)
+	}
+	if c.Name != "" {
+		writeLine(&b, "X-WR-CALNAME:"+escape(c.Name))
+	}
+
+	for _, e := range c.Events {
+		stamp := e.Stamp
+		if stamp.IsZero() {
+			stamp = time.Now()
+		}
+
+		writeLine(&b, "BEGIN:VEVENT")
+		writeLine(&b, "UID:"+e.UID)
+		writeLine(&b, "DTSTAMP:"+stamp.UTC().Format("20060102T150405Z"))
+		writeLine(&b, "DTSTART;VALUE=DATE:"+e.Start.Format("20060102"))
+		writeLine(&b, "DTEND;VALUE=DATE:"+e.End.Format("20060102"))
+		writeLine(&b, "SUMMARY:"+escape(e.Summary))
+		if e.Description != "" {
+			writeLine(&b, "DESCRIPTION:"+escape(e.Description))
+		}
+		if e.Location != "" {
+			writeLine(&b, "LOCATION:"+escape(e.Location))
+		}
+		writeLine(&b, "TRANSP:OPAQUE")
+		writeLine(&b, "END:VEVENT")
+	}
+
+	writeLine(&b, "END:VCALENDAR")
+	return b.Bytes()
+}
+
+// escapes the characters that mean something in a TEXT value
+func escape(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`)
+	return r.Replace(s)
+}
+
+// writes a content line, folding it so no line is longer than 75 octets, without splitting a utf-8 character.
+// Continuation lines start with a space
+func writeLine(b *bytes.Buffer, line string) {
+	limit := 75
+	for len(line) > limit {
+		cut := limit
+		// back up to the start of a character
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		fmt.Fprintf(b, "%s\r\n ", line[:cut])
+		line = line[cut:]
+		// the leading space counts towards the next line's length
+		limit = 74
+	}
+	b.WriteString(line)
+	b.WriteString("\r\n")
+}
+
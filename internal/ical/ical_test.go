@@ -0,0 +1,163 @@
+package ical
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCalendar_Marshal(t *testing.T) {
+	cal := Calendar{
+		Name:   "General's Quarters",
+		Method: "PUBLISH",
+		Events: []Event{{
+			UID:         "reservation-1@bookings",
+			Summary:     "Stay in the General's Quarters",
+			Description: "Smith, John; two nights\nearly check in",
+			Start:       time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+			End:         time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC),
+			Stamp:       time.Date(2049, 12, 1, 10, 30, 0, 0, time.UTC),
+		}},
+	}
+
+	out := string(cal.Marshal())
+
+	for _, line := range []string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"METHOD:PUBLISH",
+		"X-WR-CALNAME:General's Quarters",
+		"BEGIN:VEVENT",
+		"UID:reservation-1@bookings",
+		"DTSTAMP:20491201T103000Z",
+		"DTSTART;VALUE=DATE:20500101",
+		"DTEND;VALUE=DATE:20500103",
+		`DESCRIPTION:Smith\, John\; two nights\nearly check in`,
+		"END:VEVENT",
+		"END:VCALENDAR",
+	} {
+		if !strings.Contains(out, line+"\r\n") {
+			t.Errorf("expected line %q in:\n%s", line, out)
+		}
+	}
+
+	if strings.Contains(strings.ReplaceAll(out, "\r\n", ""), "\n") {
+		t.Error("expected every line to end with CRLF")
+	}
+}
+
+func TestCalendar_Fold(t *testing.T) {
+	long := strings.Repeat("é", 100) // 2 bytes each
+	cal := Calendar{Events: []Event{{UID: "1", Summary: long}}}
+
+	out := string(cal.Marshal())
+	for _, line := range strings.Split(strings.TrimSuffix(out, "\r\n"), "\r\n") {
+		if len(line) > 75 {
+			t.Errorf("line longer than 75 octets: %q", line)
+		}
+	}
+
+	// unfolding gives back the original value
+	unfolded := strings.ReplaceAll(out, "\r\n ", "")
+	if !strings.Contains(unfolded, "SUMMARY:"+long+"\r\n") {
+		t.Error("folded summary doesn't unfold to the original")
+	}
+}
+
+func TestParse(t *testing.T) {
+	feed := strings.Join([]string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"PRODID:-//Airbnb Inc//Hosting Calendar 0.8.8//EN",
+		"BEGIN:VEVENT",
+		"DTSTART;VALUE=DATE:20500101",
+		"DTEND;VALUE=DATE:20500104",
+		"UID:abc-1@airbnb.com",
+		"SUMMARY:Reserved\\, thanks",
+		"DESCRIPTION:a long description that has been folded",
+		"  onto a second line",
+		"BEGIN:VALARM",
+		"UID:not-the-event",
+		"DESCRIPTION:reminder",
+		"END:VALARM",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"DTSTART;TZID=America/New_York:20500110T150000",
+		"DTEND;TZID=America/New_York:20500112T110000",
+		"UID:abc-2@airbnb.com",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"DTSTART;VALUE=DATE:20500120",
+		"UID:no-end",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"DTSTART;VALUE=DATE:20500201",
+		"DTEND;VALUE=DATE:20500203",
+		"UID:gone",
+		"STATUS:CANCELLED",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"DTSTART;VALUE=DATE:20500301",
+		"DTEND;VALUE=DATE:20500303",
+		"END:VEVENT",
+		"END:VCALENDAR",
+	}, "\r\n")
+
+	events, err := Parse(strings.NewReader(feed))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(events) != 3 {
+		t.Fatalf("expected 3 events, got %d: %+v", len(events), events)
+	}
+
+	day := func(d int) time.Time { return time.Date(2050, 1, d, 0, 0, 0, 0, time.UTC) }
+
+	e := events[0]
+	if e.UID != "abc-1@airbnb.com" || e.Summary != "Reserved, thanks" || !e.Start.Equal(day(1)) || !e.End.Equal(day(4)) {
+		t.Errorf("unexpected first event %+v", e)
+	}
+	if e.Description != "a long description that has been folded onto a second line" {
+		t.Errorf("folded description not joined: %q", e.Description)
+	}
+
+	if e := events[1]; !e.Start.Equal(day(10)) || !e.End.Equal(day(12)) {
+		t.Errorf("expected date-times to become days, got %+v", e)
+	}
+
+	if e := events[2]; !e.End.Equal(day(21)) {
+		t.Errorf("expected an event without an end to last a day, got %+v", e)
+	}
+}
+
+func TestParse_RoundTrip(t *testing.T) {
+	in := Event{
+		UID:     "reservation-1@bookings",
+		Summary: strings.Repeat("a; b, c\\ ", 20),
+		Start:   time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+		End:     time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC),
+	}
+
+	events, err := Parse(strings.NewReader(string(Calendar{Events: []Event{in}}.Marshal())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].UID != in.UID || events[0].Summary != in.Summary || !events[0].End.Equal(in.End) {
+		t.Errorf("expected %+v back, got %+v", in, events)
+	}
+}
+
+func TestParse_Invalid(t *testing.T) {
+	var tests = []string{
+		"<html>not found</html>",
+		"BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nUID:1\r\nDTSTART;VALUE=DATE:tomorrow\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n",
+	}
+
+	for _, in := range tests {
+		_, err := Parse(strings.NewReader(in))
+		if err == nil {
+			t.Errorf("expected an error for %q", in)
+		}
+	}
+}
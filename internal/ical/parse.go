@@ -0,0 +1,168 @@
+package ical
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// reads the events out of an .ics file. Only the properties Event has are kept, the dates are reduced to
+// whole days, and cancelled events or events without a UID are skipped
+func Parse(r io.Reader) ([]Event, error) {
+	lines, err := unfold(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []Event
+	var e *Event
+	cancelled := false
+	nested := 0 // depth of components inside the current event, like VALARM, whose properties we ignore
+	sawCalendar := false
+
+	for i, line := range lines {
+		name, params, value, ok := splitLine(line)
+		if !ok {
+			return nil, fmt.Errorf("ical: line %d is not a content line", i+1)
+		}
+
+		switch {
+		case name == "BEGIN" && value == "VCALENDAR":
+			sawCalendar = true
+		case name == "BEGIN" && value == "VEVENT":
+			e = &Event{}
+			cancelled = false
+			nested = 0
+		case e == nil:
+			// outside an event
+		case name == "BEGIN":
+			nested++
+		case name == "END" && nested > 0:
+			nested--
+		case nested > 0:
+			// a property of a nested component
+		case name == "END" && value == "VEVENT":
+			if e.End.IsZero() {
+				// an all day event without an end lasts one day
+				e.End = e.Start.AddDate(0, 0, 1)
+			}
+			if !cancelled && e.UID != "" && !e.Start.IsZero() && e.End.After(e.Start) {
+				events = append(events, *e)
+			}
+			e = nil
+		case name == "UID":
+			e.UID = value
+		case name == "SUMMARY":
+			e.Summary = unescape(value)
+		case name == "DESCRIPTION":
+			e.Description = unescape(value)
+		case name == "LOCATION":
+			e.Location = unescape(value)
+		case name == "STATUS":
+			cancelled = strings.EqualFold(value, "CANCELLED")
+		case name == "DTSTART":
+			e.Start, err = parseDay(value, params)
+		case name == "DTEND":
+			e.End, err = parseDay(value, params)
+		case name == "DTSTAMP", name == "LAST-MODIFIED":
+			e.Stamp, _ = parseTime(value, params)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("ical: line %d: %w", i+1, err)
+		}
+	}
+
+	if !sawCalendar {
+		return nil, errors.New("ical: not a calendar")
+	}
+
+	return events, nil
+}
+
+// reads the content lines, joining folded lines back together
+func unfold(r io.Reader) ([]string, error) {
+	var lines []string
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		l := strings.TrimRight(scanner.Text(), "\r")
+		if l == "" {
+			continue
+		}
+		if (l[0] == ' ' || l[0] == '\t') && len(lines) > 0 {
+			lines[len(lines)-1] += l[1:]
+			continue
+		}
+		lines = append(lines, l)
+	}
+
+	return lines, scanner.Err()
+}
+
+// splits a content line like DTSTART;VALUE=DATE:20500101 into its name, parameters and value
+func splitLine(line string) (string, map[string]string, string, bool) {
+	// the value starts after the first colon that isn't inside a quoted parameter value
+	quoted := false
+	colon := -1
+	for i, c := range line {
+		if c == '"' {
+			quoted = !quoted
+		} else if c == ':' && !quoted {
+			colon = i
+			break
+		}
+	}
+	if colon < 1 {
+		return "", nil, "", false
+	}
+
+	parts := strings.Split(line[:colon], ";")
+	params := make(map[string]string)
+	for _, p := range parts[1:] {
+		kv := strings.SplitN(p, "=", 2)
+		if len(kv) == 2 {
+			params[strings.ToUpper(kv[0])] = strings.Trim(kv[1], `"`)
+		}
+	}
+
+	return strings.ToUpper(parts[0]), params, line[colon+1:], true
+}
+
+// parses a DATE or DATE-TIME value and returns the day it falls on, in the event's own time zone
+func parseDay(value string, params map[string]string) (time.Time, error) {
+	t, err := parseTime(value, params)
+	if err != nil {
+		return t, err
+	}
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC), nil
+}
+
+func parseTime(value string, params map[string]string) (time.Time, error) {
+	if params["VALUE"] == "DATE" || len(value) == 8 {
+		return time.Parse("20060102", value)
+	}
+
+	if strings.HasSuffix(value, "Z") {
+		return time.Parse("20060102T150405Z", value)
+	}
+
+	loc := time.UTC
+	if tz, ok := params["TZID"]; ok {
+		l, err := time.LoadLocation(tz)
+		if err == nil {
+			loc = l
+		}
+	}
+	return time.ParseInLocation("20060102T150405", value, loc)
+}
+
+// undoes the escaping of a TEXT value
+func unescape(s string) string {
+	r := strings.NewReplacer(`\\`, `\`, `\;`, ";", `\,`, ",", `\n`, "\n", `\N`, "\n")
+	return r.Replace(s)
+}
@@ -0,0 +1,151 @@
+package mailer
+
+import (
+	"sync"
+
+	"github.com/andkolbe/bookings/internal/config"
+	"github.com/andkolbe/bookings/internal/models"
+	mail "github.com/xhit/go-simple-mail/v2"
+)
+
+// sends a single email. The mail workers use one of these, so tests can swap the smtp mailer for a Recorder
+type Mailer interface {
+	Send(m models.MailData) error
+}
+
+// sends email through an smtp server
+type SMTPMailer struct {
+	server *mail.SMTPServer
+
+	// with keep-alive on, one connection is shared by every send, so they take turns
+	mu     sync.Mutex
+	client *mail.SMTPClient
+}
+
+// returns a mailer for the smtp server in the app config
+func NewSMTP(a *config.AppConfig) *SMTPMailer {
+	server := mail.NewSMTPClient()
+	server.Host = a.MailHost
+	server.Port = a.MailPort
+	server.Username = a.MailUsername
+	server.Password = a.MailPassword
+	server.Encryption = encryption(a.MailEncryption)
+	server.ConnectTimeout = a.MailTimeout
+	server.SendTimeout = a.MailTimeout
+	server.KeepAlive = a.MailKeepAlive
+
+	return &SMTPMailer{server: server}
+}
+
+// maps the mail-encryption setting onto go-simple-mail's encryption types
+func encryption(mode string) mail.Encryption {
+	switch mode {
+	case "starttls":
+		return mail.EncryptionSTARTTLS
+	case "tls":
+		return mail.EncryptionSSLTLS
+	default:
+		return mail.EncryptionNone
+	}
+}
+
+func (s *SMTPMailer) Send(m models.MailData) error {
+	email, err := buildMessage(m)
+	if err != nil {
+		return err
+	}
+
+	if !s.server.KeepAlive {
+		client, err := s.server.Connect()
+		if err != nil {
+			return err
+		}
+		// the client quits and closes the connection itself once the message is sent
+		return email.Send(client)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.client == nil {
+		s.client, err = s.server.Connect()
+		if err != nil {
+			s.client = nil
+			return err
+		}
+	}
+
+	err = email.Send(s.client)
+	if err != nil {
+		// the server may have dropped the connection, so start with a new one next time
+		s.client.Close()
+		s.client = nil
+	}
+	return err
+}
+
+// closes the kept alive connection, if there is one
+func (s *SMTPMailer) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.client == nil {
+		return nil
+	}
+	err := s.client.Quit()
+	s.client.Close()
+	s.client = nil
+	return err
+}
+
+func buildMessage(m models.MailData) (*mail.Email, error) {
+	email := mail.NewMSG()
+	email.SetFrom(m.From).AddTo(m.To).SetSubject(m.Subject)
+
+	if m.PlainContent == "" {
+		email.SetBody(mail.TextHTML, m.Content)
+	} else {
+		// mail clients show the last alternative they understand, so the html goes after the plain text
+		email.SetBody(mail.TextPlain, m.PlainContent)
+		email.AddAlternative(mail.TextHTML, m.Content)
+	}
+
+	for _, a := range m.Attachments {
+		email.Attach(&mail.File{Name: a.Name, MimeType: a.ContentType, Data: a.Data})
+	}
+
+	return email, email.GetError()
+}
+
+// a Mailer that keeps messages in memory instead of sending them, so tests can check what was sent
+type Recorder struct {
+	// when set, it is called before each message is recorded. If it returns an error, the message isn't
+	// recorded and Send returns the error, which lets tests fake a broken mail server
+	OnSend func(m models.MailData) error
+
+	mu   sync.Mutex
+	sent []models.MailData
+}
+
+func (r *Recorder) Send(m models.MailData) error {
+	if r.OnSend != nil {
+		if err := r.OnSend(m); err != nil {
+			return err
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sent = append(r.sent, m)
+	return nil
+}
+
+// returns a copy of every message sent so far, oldest first
+func (r *Recorder) Sent() []models.MailData {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	sent := make([]models.MailData, len(r.sent))
+	copy(sent, r.sent)
+	return sent
+}
@@ -0,0 +1,238 @@
+package mailer
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/andkolbe/bookings/internal/config"
+	"github.com/andkolbe/bookings/internal/models"
+	mail "github.com/xhit/go-simple-mail/v2"
+)
+
+// just enough of an smtp server to accept mail, counting connections and remembering the AUTH lines it sees
+type fakeSMTP struct {
+	l net.Listener
+
+	mu          sync.Mutex
+	connections int
+	auth        []string
+	messages    int
+	last        string // the DATA of the last message
+}
+
+func newFakeSMTP(t *testing.T) *fakeSMTP {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeSMTP{l: l}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.connections++
+			s.mu.Unlock()
+			go s.serve(conn)
+		}
+	}()
+
+	return s
+}
+
+func (s *fakeSMTP) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+
+	reply("220 fake ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.TrimSpace(line))
+
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"):
+			reply("250-fake")
+			reply("250 AUTH PLAIN")
+		case strings.HasPrefix(cmd, "AUTH"):
+			s.mu.Lock()
+			s.auth = append(s.auth, strings.TrimSpace(line))
+			s.mu.Unlock()
+			reply("235 ok")
+		case cmd == "DATA":
+			reply("354 go ahead")
+			var data strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				data.WriteString(l)
+			}
+			s.mu.Lock()
+			s.messages++
+			s.last = data.String()
+			s.mu.Unlock()
+			reply("250 queued")
+		case cmd == "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+}
+
+func (s *fakeSMTP) config() *config.AppConfig {
+	return &config.AppConfig{
+		MailHost:       "127.0.0.1",
+		MailPort:       s.l.Addr().(*net.TCPAddr).Port,
+		MailEncryption: "none",
+		MailTimeout:    2 * time.Second,
+	}
+}
+
+var testMail = models.MailData{To: "guest@here.com", From: "me@here.com", Subject: "Hi", Content: "<p>hello</p>"}
+
+func TestSMTPMailer_Send(t *testing.T) {
+	server := newFakeSMTP(t)
+	a := server.config()
+	a.MailUsername = "user"
+	a.MailPassword = "secret"
+
+	m := NewSMTP(a)
+	for i := 0; i < 2; i++ {
+		err := m.Send(testMail)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if server.messages != 2 {
+		t.Errorf("expected 2 messages, got %d", server.messages)
+	}
+	if server.connections != 2 {
+		t.Errorf("expected a new connection per message without keep-alive, got %d", server.connections)
+	}
+	if len(server.auth) == 0 || !strings.HasPrefix(server.auth[0], "AUTH PLAIN") {
+		t.Errorf("expected the mailer to log in, got %v", server.auth)
+	}
+}
+
+func TestSMTPMailer_KeepAlive(t *testing.T) {
+	server := newFakeSMTP(t)
+	a := server.config()
+	a.MailKeepAlive = true
+
+	m := NewSMTP(a)
+	for i := 0; i < 3; i++ {
+		err := m.Send(testMail)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := m.Close()
+	if err != nil {
+		t.Error(err)
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if server.messages != 3 || server.connections != 1 {
+		t.Errorf("expected 3 messages over 1 connection, got %d over %d", server.messages, server.connections)
+	}
+	if len(server.auth) != 0 {
+		t.Errorf("didn't expect a login without a username, got %v", server.auth)
+	}
+}
+
+func TestSMTPMailer_Attachments(t *testing.T) {
+	server := newFakeSMTP(t)
+
+	m := testMail
+	m.PlainContent = "hello"
+	m.Attachments = []models.MailAttachment{{Name: "reservation.ics", ContentType: "text/calendar; charset=utf-8", Data: []byte("BEGIN:VCALENDAR\r\n")}}
+
+	err := NewSMTP(server.config()).Send(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	for _, part := range []string{"multipart/mixed", "multipart/alternative", "text/plain", "text/html", "text/calendar", `filename="reservation.ics"`} {
+		if !strings.Contains(server.last, part) {
+			t.Errorf("expected %s in the message:\n%s", part, server.last)
+		}
+	}
+}
+
+func TestSMTPMailer_ConnectError(t *testing.T) {
+	server := newFakeSMTP(t)
+	a := server.config()
+	server.l.Close()
+
+	err := NewSMTP(a).Send(testMail)
+	if err == nil {
+		t.Error("expected an error when the smtp server is down")
+	}
+}
+
+func TestEncryption(t *testing.T) {
+	var tests = []struct {
+		mode string
+		want mail.Encryption
+	}{
+		{"none", mail.EncryptionNone},
+		{"starttls", mail.EncryptionSTARTTLS},
+		{"tls", mail.EncryptionSSLTLS},
+	}
+
+	for _, e := range tests {
+		if got := encryption(e.mode); got != e.want {
+			t.Errorf("%s: expected %s, got %s", e.mode, e.want, got)
+		}
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	r := &Recorder{
+		OnSend: func(m models.MailData) error {
+			if m.To == "down@here.com" {
+				return errors.New("connection refused")
+			}
+			return nil
+		},
+	}
+
+	err := r.Send(testMail)
+	if err != nil {
+		t.Error(err)
+	}
+	err = r.Send(models.MailData{To: "down@here.com"})
+	if err == nil {
+		t.Error("expected the recorder to fail")
+	}
+
+	sent := r.Sent()
+	if len(sent) != 1 || sent[0].To != testMail.To {
+		t.Errorf("expected only the first message to be recorded, got %+v", sent)
+	}
+}
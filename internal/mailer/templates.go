@@ -0,0 +1,300 @@
+package mailer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html"
+	htmltemplate "html/template"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/andkolbe/bookings/internal/ical"
+	"github.com/andkolbe/bookings/internal/models"
+	"github.com/andkolbe/bookings/internal/pricing"
+)
+
+// an email that can be rendered from the email templates. Template returns the base name of its templates:
+// confirmation is rendered from confirmation.mail.html, inside base.layout.html, and confirmation.mail.txt
+type Message interface {
+	Template() string
+	Subject() string
+}
+
+// a Message that has files to attach
+type withAttachments interface {
+	Attachments() []models.MailAttachment
+}
+
+// sent to the guest when they make a reservation. ManageURL is their link to view, change or cancel it
+type Confirmation struct {
+	Reservation models.Reservation
+	ManageURL   string
+}
+
+func (Confirmation) Template() string { return "confirmation" }
+func (Confirmation) Subject() string  { return "Reservation Confirmation" }
+
+// attaches the stay as a calendar event, so the guest can add it to their calendar
+func (c Confirmation) Attachments() []models.MailAttachment {
+	return stayAttachments(c.Reservation)
+}
+
+// sent to the property owner when a reservation is made
+type OwnerNotification struct {
+	Reservation models.Reservation
+}
+
+func (OwnerNotification) Template() string { return "owner-notification" }
+func (OwnerNotification) Subject() string  { return "Reservation Notification" }
+
+// sent to the guest when their reservation is cancelled
+type Cancellation struct {
+	Reservation models.Reservation
+}
+
+func (Cancellation) Template() string { return "cancellation" }
+func (Cancellation) Subject() string  { return "Reservation Cancelled" }
+
+// sent to the guest when they change the dates of their stay, with a new link to manage it
+type DatesChanged struct {
+	Reservation models.Reservation
+	ManageURL   string
+}
+
+func (DatesChanged) Template() string { return "dates-changed" }
+func (DatesChanged) Subject() string  { return "Reservation Changed" }
+
+// the event has the same uid as the one in the confirmation, so calendars move the stay instead of adding another
+func (c DatesChanged) Attachments() []models.MailAttachment {
+	return stayAttachments(c.Reservation)
+}
+
+// sent to the property owner when a guest changes the dates of their stay
+type OwnerDatesChanged struct {
+	Reservation models.Reservation
+	Previous    models.Reservation
+}
+
+func (OwnerDatesChanged) Template() string { return "owner-dates-changed" }
+func (OwnerDatesChanged) Subject() string  { return "Reservation Changed" }
+
+// sent to the property owner when a guest cancels their reservation
+type OwnerCancellation struct {
+	Reservation models.Reservation
+}
+
+func (OwnerCancellation) Template() string { return "owner-cancellation" }
+func (OwnerCancellation) Subject() string  { return "Reservation Cancelled" }
+
+// sent to an admin user who asked to reset their password. The link works until Expires, and only once
+type PasswordReset struct {
+	User     models.User
+	ResetURL string
+	Expires  time.Time
+}
+
+func (PasswordReset) Template() string { return "password-reset" }
+func (PasswordReset) Subject() string  { return "Reset Your Password" }
+
+// sent to a new admin user. They log in for the first time by choosing a password with the link, before Expires
+type UserInvite struct {
+	User      models.User
+	InvitedBy models.User
+	SetURL    string
+	Expires   time.Time
+}
+
+func (UserInvite) Template() string { return "user-invite" }
+func (UserInvite) Subject() string  { return "You've Been Invited to Bookings" }
+
+// sent to an admin user when too many wrong passwords or codes lock their account. IP is where the last one came from
+type AccountLocked struct {
+	User     models.User
+	Until    time.Time
+	IP       string
+	ResetURL string
+}
+
+func (AccountLocked) Template() string { return "account-locked" }
+func (AccountLocked) Subject() string  { return "Your Account Has Been Locked" }
+
+// the stay as a calendar event
+func stayAttachments(res models.Reservation) []models.MailAttachment {
+	cal := ical.Calendar{
+		Method: "PUBLISH",
+		Events: []ical.Event{{
+			UID:         fmt.Sprintf("reservation-%d@bookings", res.ID),
+			Summary:     fmt.Sprintf("Stay in the %s", res.Room.RoomName),
+			Description: fmt.Sprintf("Reservation %d for %s %s", res.ID, res.FirstName, res.LastName),
+			Location:    res.Room.RoomName,
+			Start:       res.StartDate,
+			End:         res.EndDate,
+			Stamp:       time.Now(),
+		}},
+	}
+
+	return []models.MailAttachment{{
+		Name:        "reservation.ics",
+		ContentType: ical.ContentType,
+		Data:        cal.Marshal(),
+	}}
+}
+
+var mailFunctions = map[string]interface{}{
+	"longDate": longDate,
+	"price":    pricing.Format,
+	"roleName": models.AccessLevelName,
+}
+
+// formats a date the way we write it in emails, e.g. Monday, January 2, 2006
+func longDate(t time.Time) string {
+	return t.Format("Monday, January 2, 2006")
+}
+
+// parses and caches the email templates
+type Templates struct {
+	dir      string
+	useCache bool
+
+	mu    sync.Mutex
+	cache map[string]*messageTemplates
+}
+
+// the parsed templates for one message type. text is nil when there is no .mail.txt template
+type messageTemplates struct {
+	html *htmltemplate.Template
+	text *texttemplate.Template
+}
+
+// returns the templates in dir. With useCache off the templates are parsed again for every message,
+// so they can be edited without restarting the app
+func NewTemplates(dir string, useCache bool) *Templates {
+	return &Templates{
+		dir:      dir,
+		useCache: useCache,
+		cache:    make(map[string]*messageTemplates),
+	}
+}
+
+// renders msg and returns it as an email ready to be queued
+func (t *Templates) Build(from, to string, msg Message) (models.MailData, error) {
+	htmlBody, textBody, err := t.Render(msg)
+	if err != nil {
+		return models.MailData{}, err
+	}
+
+	m := models.MailData{
+		To:           to,
+		From:         from,
+		Subject:      msg.Subject(),
+		Content:      htmlBody,
+		PlainContent: textBody,
+	}
+	if a, ok := msg.(withAttachments); ok {
+		m.Attachments = a.Attachments()
+	}
+
+	return m, nil
+}
+
+// renders the html and plain text bodies of msg. If the message has no text template,
+// the plain text is generated from its html content instead
+func (t *Templates) Render(msg Message) (string, string, error) {
+	mt, err := t.get(msg.Template())
+	if err != nil {
+		return "", "", err
+	}
+
+	var htmlBody bytes.Buffer
+	err = mt.html.Execute(&htmlBody, msg)
+	if err != nil {
+		return "", "", err
+	}
+
+	var textBody bytes.Buffer
+	if mt.text != nil {
+		err = mt.text.Execute(&textBody, msg)
+	} else {
+		// only the content block, we don't want the layout's styles in the text
+		var content bytes.Buffer
+		err = mt.html.ExecuteTemplate(&content, "content", msg)
+		textBody.WriteString(htmlToText(content.String()))
+	}
+	if err != nil {
+		return "", "", err
+	}
+
+	return htmlBody.String(), strings.TrimSpace(textBody.String()) + "\n", nil
+}
+
+// returns the parsed templates for a message type, from the cache if we are using it
+func (t *Templates) get(name string) (*messageTemplates, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if mt, ok := t.cache[name]; ok && t.useCache {
+		return mt, nil
+	}
+
+	mt, err := t.parse(name)
+	if err != nil {
+		return nil, err
+	}
+	t.cache[name] = mt
+	return mt, nil
+}
+
+func (t *Templates) parse(name string) (*messageTemplates, error) {
+	var mt messageTemplates
+
+	page := filepath.Join(t.dir, name+".mail.html")
+	ts, err := htmltemplate.New(filepath.Base(page)).Funcs(mailFunctions).ParseFiles(page)
+	if err != nil {
+		return nil, err
+	}
+	ts, err = ts.ParseGlob(filepath.Join(t.dir, "*.layout.html"))
+	if err != nil {
+		return nil, err
+	}
+	mt.html = ts
+
+	text := filepath.Join(t.dir, name+".mail.txt")
+	_, err = os.Stat(text)
+	if errors.Is(err, os.ErrNotExist) {
+		return &mt, nil
+	}
+	mt.text, err = texttemplate.New(filepath.Base(text)).Funcs(mailFunctions).ParseFiles(text)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", text, err)
+	}
+
+	return &mt, nil
+}
+
+var (
+	lineBreaks  = regexp.MustCompile(`(?i)<br\s*/?>|</(p|div|h[1-6]|li|tr)>`)
+	tags        = regexp.MustCompile(`<[^>]*>`)
+	blankLines  = regexp.MustCompile(`\n{3,}`)
+	extraSpaces = regexp.MustCompile(`[ \t]+`)
+)
+
+// turns an html fragment into readable plain text by dropping the tags and keeping the line breaks
+func htmlToText(s string) string {
+	s = lineBreaks.ReplaceAllString(s, "\n")
+	s = tags.ReplaceAllString(s, "")
+	s = html.UnescapeString(s)
+
+	lines := strings.Split(s, "\n")
+	for i, l := range lines {
+		lines[i] = strings.TrimSpace(extraSpaces.ReplaceAllString(l, " "))
+	}
+	s = strings.Join(lines, "\n")
+
+	return strings.TrimSpace(blankLines.ReplaceAllString(s, "\n\n"))
+}
@@ -0,0 +1,260 @@
+package mailer
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andkolbe/bookings/internal/models"
+)
+
+var pathToTemplates = "./../../email-templates"
+
+var testReservation = models.Reservation{
+	FirstName: "Andrew <script>",
+	LastName:  "Kolbe",
+	Email:     "guest@here.com",
+	StartDate: time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+	EndDate:   time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC),
+	Adults:    2,
+	Children:  1,
+	Room:      models.Room{RoomName: "General's Quarters"},
+}
+
+func TestTemplates_Build(t *testing.T) {
+	tmpl := NewTemplates(pathToTemplates, true)
+
+	var tests = []Message{
+		Confirmation{Reservation: testReservation},
+		OwnerNotification{Reservation: testReservation},
+		Cancellation{Reservation: testReservation},
+		DatesChanged{Reservation: testReservation},
+		OwnerDatesChanged{Reservation: testReservation, Previous: testReservation},
+		OwnerCancellation{Reservation: testReservation},
+	}
+
+	for _, msg := range tests {
+		m, err := tmpl.Build("me@here.com", "you@here.com", msg)
+		if err != nil {
+			t.Errorf("%s: %s", msg.Template(), err)
+			continue
+		}
+
+		if m.Subject != msg.Subject() || m.To != "you@here.com" || m.From != "me@here.com" {
+			t.Errorf("%s: wrong headers %+v", msg.Template(), m)
+		}
+
+		// guest input has to be escaped in the html, but not in the plain text
+		if strings.Contains(m.Content, "<script>") || !strings.Contains(m.Content, "Andrew &lt;script&gt;") {
+			t.Errorf("%s: guest name not escaped in html", msg.Template())
+		}
+		if !strings.Contains(m.PlainContent, "Andrew <script>") {
+			t.Errorf("%s: guest name missing from plain text:\n%s", msg.Template(), m.PlainContent)
+		}
+
+		if !strings.Contains(m.Content, "Saturday, January 1, 2050") || !strings.Contains(m.PlainContent, "Monday, January 3, 2050") {
+			t.Errorf("%s: dates missing", msg.Template())
+		}
+		if !strings.Contains(m.Content, "<title>"+msg.Subject()+"</title>") {
+			t.Errorf("%s: expected the layout to be used", msg.Template())
+		}
+	}
+}
+
+func TestConfirmation_Attachments(t *testing.T) {
+	res := testReservation
+	res.ID = 7
+
+	m, err := NewTemplates(pathToTemplates, true).Build("me@here.com", res.Email, Confirmation{Reservation: res})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(m.Attachments) != 1 || m.Attachments[0].Name != "reservation.ics" {
+		t.Fatalf("expected a reservation.ics attachment, got %+v", m.Attachments)
+	}
+	ics := string(m.Attachments[0].Data)
+	for _, line := range []string{"UID:reservation-7@bookings", "DTSTART;VALUE=DATE:20500101", "DTEND;VALUE=DATE:20500103", "SUMMARY:Stay in the General's Quarters"} {
+		if !strings.Contains(ics, line) {
+			t.Errorf("expected %q in the attachment:\n%s", line, ics)
+		}
+	}
+
+	// the guest's link is in both bodies
+	m, err = NewTemplates(pathToTemplates, true).Build("me@here.com", res.Email, Confirmation{Reservation: res, ManageURL: "https://example.com/my-reservation/abc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(m.Content, `href="https://example.com/my-reservation/abc"`) || !strings.Contains(m.PlainContent, "https://example.com/my-reservation/abc") {
+		t.Errorf("expected the manage link in the confirmation:\n%s\n%s", m.Content, m.PlainContent)
+	}
+
+	// and so is the price, once the stay has one
+	if strings.Contains(m.PlainContent, "total") {
+		t.Errorf("didn't expect a total without a price:\n%s", m.PlainContent)
+	}
+	res.Price = models.Quote{Nights: []models.NightPrice{{Date: res.StartDate, Rate: 12050}, {Date: res.StartDate.AddDate(0, 0, 1), Rate: 10000}}, Total: 22050}
+	m, err = NewTemplates(pathToTemplates, true).Build("me@here.com", res.Email, Confirmation{Reservation: res})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(m.Content, "$220.50") || !strings.Contains(m.PlainContent, "The total for your stay is $220.50.") {
+		t.Errorf("expected the total in the confirmation:\n%s\n%s", m.Content, m.PlainContent)
+	}
+
+	if !strings.Contains(m.PlainContent, "for 2 adults, 1 child.") {
+		t.Errorf("expected the party in the confirmation:\n%s", m.PlainContent)
+	}
+
+	// the other messages don't have one
+	m, err = NewTemplates(pathToTemplates, true).Build("me@here.com", "owner@here.com", OwnerNotification{Reservation: res})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(m.Content, "Guests: 2 adults, 1 child") {
+		t.Errorf("expected the party in the owner notification:\n%s", m.Content)
+	}
+	if len(m.Attachments) != 0 {
+		t.Errorf("didn't expect attachments on the owner notification, got %d", len(m.Attachments))
+	}
+}
+
+// a message type that only has an html template, so its plain text is generated
+type onlyHTML struct {
+	Name string
+}
+
+func (onlyHTML) Template() string { return "only-html" }
+func (onlyHTML) Subject() string  { return "Hello" }
+
+func writeTemplates(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, contents := range files {
+		err := ioutil.WriteFile(filepath.Join(dir, name), []byte(contents), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestTemplates_GeneratedText(t *testing.T) {
+	dir := writeTemplates(t, map[string]string{
+		"base.layout.html": `{{define "base"}}<style>p { color: red; }</style>{{template "content" .}}{{end}}`,
+		"only-html.mail.html": `{{template "base" .}}{{define "content"}}<p><strong>Hi</strong> {{.Name}},</p>
+			<p>See you    soon<br>Bye &amp; thanks</p>{{end}}`,
+	})
+
+	_, text, err := NewTemplates(dir, true).Render(onlyHTML{Name: "Tom & Jerry"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "Hi Tom & Jerry,\n\nSee you soon\nBye & thanks\n"
+	if text != expected {
+		t.Errorf("expected generated text %q, got %q", expected, text)
+	}
+}
+
+func TestTemplates_Cache(t *testing.T) {
+	files := map[string]string{
+		"base.layout.html":    `{{define "base"}}{{template "content" .}}{{end}}`,
+		"only-html.mail.html": `{{template "base" .}}{{define "content"}}first{{end}}`,
+	}
+
+	for _, useCache := range []bool{true, false} {
+		dir := writeTemplates(t, files)
+		tmpl := NewTemplates(dir, useCache)
+
+		_, _, err := tmpl.Render(onlyHTML{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = ioutil.WriteFile(filepath.Join(dir, "only-html.mail.html"), []byte(`{{template "base" .}}{{define "content"}}second{{end}}`), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		body, _, err := tmpl.Render(onlyHTML{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected := "second"
+		if useCache {
+			expected = "first"
+		}
+		if body != expected {
+			t.Errorf("useCache %t: expected %q, got %q", useCache, expected, body)
+		}
+	}
+}
+
+func TestTemplates_Missing(t *testing.T) {
+	_, _, err := NewTemplates(t.TempDir(), true).Render(onlyHTML{})
+	if err == nil {
+		t.Error("expected an error for a message with no templates")
+	}
+}
+
+func TestPasswordReset(t *testing.T) {
+	msg := PasswordReset{
+		User:     models.User{FirstName: "Andrew", Email: "admin@here.com"},
+		ResetURL: "http://localhost:8080/user/reset-password/abc?x=1&y=2",
+		Expires:  time.Date(2050, 1, 1, 15, 30, 0, 0, time.UTC),
+	}
+
+	m, err := NewTemplates(pathToTemplates, true).Build("me@here.com", "admin@here.com", msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(m.Content, `href="http://localhost:8080/user/reset-password/abc?x=1&amp;y=2"`) {
+		t.Errorf("expected the link in the html:\n%s", m.Content)
+	}
+	if !strings.Contains(m.PlainContent, msg.ResetURL+"\n") || !strings.Contains(m.PlainContent, "until 3:30 PM UTC on January 1") {
+		t.Errorf("expected the link and when it expires in the plain text:\n%s", m.PlainContent)
+	}
+}
+
+func TestUserInvite(t *testing.T) {
+	msg := UserInvite{
+		User:      models.User{FirstName: "Jo", Email: "jo@here.com", AccessLevel: models.AccessFrontDesk},
+		InvitedBy: models.User{FirstName: "Andrew", LastName: "Kolbe"},
+		SetURL:    "http://localhost:8080/user/reset-password/abc",
+		Expires:   time.Date(2050, 1, 4, 15, 30, 0, 0, time.UTC),
+	}
+
+	m, err := NewTemplates(pathToTemplates, true).Build("me@here.com", "jo@here.com", msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(m.Content, `href="http://localhost:8080/user/reset-password/abc"`) || !strings.Contains(m.Content, "Andrew Kolbe") {
+		t.Errorf("expected the link and who sent the invite in the html:\n%s", m.Content)
+	}
+	if !strings.Contains(m.PlainContent, msg.SetURL+"\n") || !strings.Contains(m.PlainContent, "front desk") {
+		t.Errorf("expected the link and the access level in the plain text:\n%s", m.PlainContent)
+	}
+}
+
+func TestAccountLocked(t *testing.T) {
+	msg := AccountLocked{
+		User:     models.User{FirstName: "Jo", Email: "jo@here.com"},
+		Until:    time.Date(2050, 1, 1, 15, 30, 0, 0, time.UTC),
+		IP:       "203.0.113.7",
+		ResetURL: "http://localhost:8080/user/forgot-password",
+	}
+
+	m, err := NewTemplates(pathToTemplates, true).Build("me@here.com", "jo@here.com", msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(m.Content, "203.0.113.7") || !strings.Contains(m.Content, `href="http://localhost:8080/user/forgot-password"`) {
+		t.Errorf("expected where the logins came from and the link in the html:\n%s", m.Content)
+	}
+	if !strings.Contains(m.PlainContent, "until 3:30 PM UTC on January 1") {
+		t.Errorf("expected when the lock ends in the plain text:\n%s", m.PlainContent)
+	}
+}
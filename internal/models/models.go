@@ -1,25 +1,160 @@
 package models
 
 import (
+	"strconv"
+	"strings"
 	"time"
 )
 
 type User struct {
+	ID                int
+	FirstName         string
+	LastName          string
+	Email             string
+	Password          string
+	AccessLevel       int
+	Active            bool      // inactive users can't log in, and are logged out if they were
+	PasswordChangedAt time.Time // sessions that logged in before this are logged out
+	TOTPSecret        string    // the authenticator app secret, blank unless two-factor login is turned on
+	CreatedAt         time.Time
+	UpdatedAt         time.Time
+}
+
+// returns true if the user has to enter a code from their authenticator app to log in
+func (u User) TwoFactor() bool {
+	return u.TOTPSecret != ""
+}
+
+// failed logins for an account or an ip address. Key is "account:" and the email, or "ip:" and the address
+type LoginThrottle struct {
+	Key           string
+	Failures      int // in a row, since the last lock or successful login
+	LastFailureAt time.Time
+	LockedUntil   time.Time // zero if it has never been locked
+}
+
+// names of site wide settings, kept in the settings table
+const (
+	// "1" if every admin user has to turn on two-factor login before they can use the admin area
+	SettingRequireTwoFactor = "require_two_factor"
+)
+
+// access levels stored in users.access_level. Each level can do everything the levels below it can
+const (
+	AccessFrontDesk = 1
+	AccessManager   = 2
+	AccessOwner     = 3
+)
+
+// returns the role name for an access level
+func AccessLevelName(level int) string {
+	switch level {
+	case AccessFrontDesk:
+		return "front desk"
+	case AccessManager:
+		return "manager"
+	case AccessOwner:
+		return "owner"
+	}
+	return "none"
+}
+
+type Room struct {
 	ID          int
-	FirstName   string
-	LastName    string
-	Email       string
-	Password    string
-	AccessLevel int
+	RoomName    string
+	Slug        string // the room's page is /rooms/{slug}
+	Description string
+	Capacity    int      // the most guests the room sleeps
+	Amenities   []string // e.g. "Sea view", "Kitchenette"
+	Photos      []string // image urls, the first one is the room's main picture
+	Active      bool     // inactive rooms are hidden from guests and can't be booked
+	ICalToken   string   // the secret in the room's calendar feed url, blank if the feed is turned off
+	BaseRate    int      // the nightly rate in cents
+	WeekendRate int      // the rate for friday and saturday nights in cents, 0 to charge BaseRate
 	CreatedAt   time.Time
 	UpdatedAt   time.Time
 }
 
-type Room struct {
-	ID        int
-	RoomName  string
-	CreatedAt time.Time
-	UpdatedAt time.Time
+// a room's rates for a season, e.g. summer or christmas. Like a stay, it covers the nights from StartDate up to
+// but not including EndDate
+type SeasonalRate struct {
+	ID          int
+	RoomID      int
+	Name        string
+	StartDate   time.Time
+	EndDate     time.Time
+	NightlyRate int // in cents
+	WeekendRate int // in cents, 0 to charge NightlyRate
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	Room        Room
+}
+
+// rules for stays in a room. Min and max nights and no arrival days apply to stays arriving from StartDate up to
+// but not including EndDate, and no departure days to stays leaving in that time. 0 means no minimum or maximum
+type StayRule struct {
+	ID              int
+	RoomID          int
+	StartDate       time.Time
+	EndDate         time.Time
+	MinNights       int
+	MaxNights       int
+	NoArrivalDays   Weekdays
+	NoDepartureDays Weekdays
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+	Room            Room
+}
+
+// a set of days of the week, with bit n set for time.Weekday(n)
+type Weekdays int
+
+// returns the set of days
+func WeekdaysOf(days ...time.Weekday) Weekdays {
+	var w Weekdays
+	for _, d := range days {
+		w |= 1 << uint(d)
+	}
+	return w
+}
+
+// reports whether d is in the set
+func (w Weekdays) Has(d time.Weekday) bool {
+	return w&(1<<uint(d)) != 0
+}
+
+// returns the days in the set, starting with sunday
+func (w Weekdays) Days() []time.Weekday {
+	var days []time.Weekday
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if w.Has(d) {
+			days = append(days, d)
+		}
+	}
+	return days
+}
+
+// the days in the set, e.g. "Friday, Saturday"
+func (w Weekdays) String() string {
+	var names []string
+	for _, d := range w.Days() {
+		names = append(names, d.String())
+	}
+	return strings.Join(names, ", ")
+}
+
+// the price of one night of a stay
+type NightPrice struct {
+	Date    time.Time
+	Rate    int    // in cents
+	Season  string // the name of the seasonal rate used, blank for the room's own rates
+	Weekend bool
+}
+
+// the price of a stay, night by night
+type Quote struct {
+	Nights []NightPrice
+	Total  int // in cents
 }
 
 type Restriction struct {
@@ -38,10 +173,96 @@ type Reservation struct {
 	StartDate time.Time
 	EndDate   time.Time
 	RoomID    int
+	Adults    int
+	Children  int
 	CreatedAt time.Time
 	UpdatedAt time.Time
-	Processed int
+	Status    string
+	Price     Quote // worked out when the stay was booked, so later rate changes don't change it
 	Room      Room
+	// how many times the guest has moved the stay. Guest links sent before the last move stop working
+	DateChanges int
+}
+
+// how many people are staying, which a room's capacity has to fit
+func (r Reservation) Guests() int {
+	return r.Adults + r.Children
+}
+
+// describes who is staying, e.g. "2 adults, 1 child"
+func (r Reservation) Party() string {
+	party := plural(r.Adults, "adult", "adults")
+	if r.Children > 0 {
+		party += ", " + plural(r.Children, "child", "children")
+	}
+	return party
+}
+
+func plural(n int, one, many string) string {
+	if n == 1 {
+		return "1 " + one
+	}
+	return strconv.Itoa(n) + " " + many
+}
+
+// the statuses a reservation goes through. New reservations are pending until someone at the front desk confirms them
+const (
+	ReservationPending    = "pending"
+	ReservationConfirmed  = "confirmed"
+	ReservationCheckedIn  = "checked-in"
+	ReservationCheckedOut = "checked-out"
+	ReservationCancelled  = "cancelled"
+	ReservationNoShow     = "no-show"
+)
+
+// every reservation status, in the order a stay goes through them
+var ReservationStatuses = []string{
+	ReservationPending,
+	ReservationConfirmed,
+	ReservationCheckedIn,
+	ReservationCheckedOut,
+	ReservationCancelled,
+	ReservationNoShow,
+}
+
+// the statuses a reservation can move to from each status. Checked out, cancelled and no-show are final
+var reservationTransitions = map[string][]string{
+	ReservationPending:   {ReservationConfirmed, ReservationCancelled},
+	ReservationConfirmed: {ReservationCheckedIn, ReservationCancelled, ReservationNoShow},
+	ReservationCheckedIn: {ReservationCheckedOut},
+}
+
+// returns the statuses a reservation in status can move to
+func NextReservationStatuses(status string) []string {
+	return reservationTransitions[status]
+}
+
+// reports whether a reservation can move from one status to another. Everything that changes a reservation's
+// status checks with this first
+func CanChangeReservationStatus(from, to string) bool {
+	for _, s := range reservationTransitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// reports whether a reservation in status still has its room. Cancelled and no-show reservations give it up
+func ReservationHoldsRoom(status string) bool {
+	return status != ReservationCancelled && status != ReservationNoShow
+}
+
+// one change in the status of a reservation. FromStatus is blank for the status it was made with,
+// and UserID is 0 when the guest made the change
+type ReservationStatusChange struct {
+	ID            int
+	ReservationID int
+	FromStatus    string
+	ToStatus      string
+	UserID        int
+	CreatedAt     time.Time
+	User          User
 }
 
 type RoomRestriction struct {
@@ -51,6 +272,9 @@ type RoomRestriction struct {
 	RoomID        int
 	ReservationID int
 	RestrictionID int
+	SourceID      int    // the calendar source an external block came from
+	ExternalUID   string // the UID of the event in that calendar
+	Reason        string // why the owner blocked the room, e.g. maintenance
 	CreatedAt     time.Time
 	UpdatedAt     time.Time
 	Room          Room
@@ -58,10 +282,77 @@ type RoomRestriction struct {
 	Restriction   Restriction
 }
 
+// the rows of the restrictions table
+const (
+	RestrictionReservation = 1
+	RestrictionOwnerBlock  = 2
+	RestrictionExternal    = 3 // a block synced from another booking site's calendar
+)
+
+// an external iCal feed, e.g. from another booking site, whose events block a room
+type CalendarSource struct {
+	ID           int
+	RoomID       int
+	Name         string
+	URL          string // an http(s) url, or a path to a file
+	LastSyncedAt time.Time
+	LastError    string
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	Room         Room
+}
+
+// scopes an api token can have. Read tokens can only make GET requests
+const (
+	APIScopeRead  = "read"
+	APIScopeWrite = "write"
+)
+
+// a personal api token. Only the sha256 hash of the token is stored
+type APIToken struct {
+	ID         int
+	UserID     int
+	Name       string
+	TokenHash  string
+	Scope      string
+	LastUsedAt time.Time
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	User       User
+}
+
 type MailData struct {
-	To       string
-	From     string
-	Subject  string
-	Content  string
-	Template string
+	To           string
+	From         string
+	Subject      string
+	Content      string // the html body
+	PlainContent string // the plain text alternative, if there is one
+	Attachments  []MailAttachment
+}
+
+// a file attached to an email
+type MailAttachment struct {
+	Name        string
+	ContentType string
+	Data        []byte
+}
+
+// states a message in the mail outbox goes through
+const (
+	MailPending = "pending"
+	MailSending = "sending"
+	MailSent    = "sent"
+	MailFailed  = "failed" // gave up after too many attempts
+)
+
+// a message waiting in, or already sent from, the mail outbox
+type OutboxMail struct {
+	ID            int
+	Mail          MailData
+	Status        string
+	Attempts      int
+	NextAttemptAt time.Time
+	LastError     string
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
 }
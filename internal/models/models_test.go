@@ -0,0 +1,88 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCanChangeReservationStatus(t *testing.T) {
+	var tests = []struct {
+		from     string
+		to       string
+		expected bool
+	}{
+		{ReservationPending, ReservationConfirmed, true},
+		{ReservationPending, ReservationCancelled, true},
+		{ReservationPending, ReservationCheckedIn, false},
+		{ReservationConfirmed, ReservationCheckedIn, true},
+		{ReservationConfirmed, ReservationNoShow, true},
+		{ReservationCheckedIn, ReservationCheckedOut, true},
+		{ReservationCheckedIn, ReservationCancelled, false},
+		{ReservationCancelled, ReservationConfirmed, false},
+		{ReservationNoShow, ReservationCheckedIn, false},
+		{ReservationCheckedOut, ReservationCheckedIn, false},
+		{ReservationConfirmed, ReservationConfirmed, false},
+		{"", ReservationPending, false},
+	}
+
+	for _, e := range tests {
+		if got := CanChangeReservationStatus(e.from, e.to); got != e.expected {
+			t.Errorf("%q to %q: expected %t, got %t", e.from, e.to, e.expected, got)
+		}
+	}
+}
+
+func TestReservationStatuses(t *testing.T) {
+	// every status a reservation can move to has to be one we know about
+	known := make(map[string]bool)
+	for _, s := range ReservationStatuses {
+		known[s] = true
+	}
+	for from, next := range reservationTransitions {
+		if !known[from] {
+			t.Errorf("unknown status %q", from)
+		}
+		for _, to := range next {
+			if !known[to] {
+				t.Errorf("unknown status %q", to)
+			}
+		}
+	}
+}
+
+func TestWeekdays(t *testing.T) {
+	w := WeekdaysOf(time.Saturday, time.Friday, time.Saturday)
+
+	if !w.Has(time.Friday) || !w.Has(time.Saturday) || w.Has(time.Sunday) {
+		t.Errorf("expected friday and saturday, got %v", w.Days())
+	}
+	if w.String() != "Friday, Saturday" {
+		t.Errorf("expected Friday, Saturday, got %s", w)
+	}
+	if Weekdays(0).String() != "" || len(Weekdays(0).Days()) != 0 {
+		t.Errorf("expected no days in an empty set")
+	}
+}
+
+func TestReservationParty(t *testing.T) {
+	var tests = []struct {
+		adults   int
+		children int
+		party    string
+	}{
+		{1, 0, "1 adult"},
+		{2, 0, "2 adults"},
+		{2, 1, "2 adults, 1 child"},
+		{1, 3, "1 adult, 3 children"},
+	}
+
+	for _, e := range tests {
+		res := Reservation{Adults: e.adults, Children: e.children}
+		if res.Party() != e.party {
+			t.Errorf("expected %q, got %q", e.party, res.Party())
+		}
+		if res.Guests() != e.adults+e.children {
+			t.Errorf("expected %d guests, got %d", e.adults+e.children, res.Guests())
+		}
+	}
+}
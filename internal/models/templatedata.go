@@ -14,4 +14,7 @@ type TemplateData struct {
 	Error           string
 	Form            *forms.Form
 	IsAuthenticated int
+	AccessLevel     int
+	IsManager       bool // a manager or owner is signed in
+	IsOwner         bool // an owner is signed in
 }
@@ -0,0 +1,108 @@
+package pricing
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andkolbe/bookings/internal/models"
+)
+
+// returned by Parse for anything that isn't an amount of dollars
+var ErrInvalidAmount = errors.New("pricing: invalid amount")
+
+var amountPattern = regexp.MustCompile(`^(\d+)(\.(\d{1,2}))?$`)
+
+// prices a stay in room from start up to but not including end, night by night.
+// A night in one of seasons gets the season's rates instead of the room's. When seasons overlap,
+// the one that starts latest wins, so a short holiday season can sit inside a longer one
+func Quote(room models.Room, seasons []models.SeasonalRate, start, end time.Time) models.Quote {
+	var q models.Quote
+
+	for day := start; day.Before(end); day = day.AddDate(0, 0, 1) {
+		night := models.NightPrice{Date: day, Weekend: isWeekend(day)}
+		nightly, weekend := room.BaseRate, room.WeekendRate
+
+		if s, ok := seasonFor(seasons, day); ok {
+			night.Season = s.Name
+			nightly, weekend = s.NightlyRate, s.WeekendRate
+		}
+
+		night.Rate = nightly
+		if night.Weekend && weekend > 0 {
+			night.Rate = weekend
+		}
+
+		q.Nights = append(q.Nights, night)
+		q.Total += night.Rate
+	}
+
+	return q
+}
+
+// returns the season that covers the night starting on day, if there is one
+func seasonFor(seasons []models.SeasonalRate, day time.Time) (models.SeasonalRate, bool) {
+	var found models.SeasonalRate
+	ok := false
+	for _, s := range seasons {
+		if day.Before(s.StartDate) || !day.Before(s.EndDate) {
+			continue
+		}
+		if !ok || s.StartDate.After(found.StartDate) {
+			found = s
+			ok = true
+		}
+	}
+	return found, ok
+}
+
+// friday and saturday nights are the weekend
+func isWeekend(day time.Time) bool {
+	return day.Weekday() == time.Friday || day.Weekday() == time.Saturday
+}
+
+// formats an amount in cents as dollars, e.g. $1,234.50
+func Format(cents int) string {
+	sign := ""
+	if cents < 0 {
+		sign = "-"
+		cents = -cents
+	}
+
+	dollars := strconv.Itoa(cents / 100)
+	// put a comma between each group of three digits
+	for i := len(dollars) - 3; i > 0; i -= 3 {
+		dollars = dollars[:i] + "," + dollars[i:]
+	}
+
+	return fmt.Sprintf("%s$%s.%02d", sign, dollars, cents%100)
+}
+
+// parses an amount of dollars, like 120, $1,200 or 99.50, into cents
+func Parse(s string) (int, error) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "$")
+	s = strings.ReplaceAll(s, ",", "")
+
+	m := amountPattern.FindStringSubmatch(s)
+	if m == nil {
+		return 0, ErrInvalidAmount
+	}
+
+	dollars, err := strconv.Atoi(m[1])
+	if err != nil {
+		return 0, ErrInvalidAmount
+	}
+
+	cents := 0
+	if m[3] != "" {
+		cents, _ = strconv.Atoi(m[3])
+		if len(m[3]) == 1 {
+			cents *= 10
+		}
+	}
+
+	return dollars*100 + cents, nil
+}
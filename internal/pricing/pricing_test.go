@@ -0,0 +1,106 @@
+package pricing
+
+import (
+	"testing"
+	"time"
+
+	"github.com/andkolbe/bookings/internal/models"
+)
+
+// January 1st 2050 is a saturday, so the 7th and 8th are a friday and saturday
+func day(d int) time.Time {
+	return time.Date(2050, 1, d, 0, 0, 0, 0, time.UTC)
+}
+
+func TestQuote(t *testing.T) {
+	room := models.Room{ID: 1, BaseRate: 10000, WeekendRate: 12000}
+	seasons := []models.SeasonalRate{
+		{Name: "Winter", StartDate: day(3), EndDate: day(20), NightlyRate: 15000},
+		{Name: "Festival", StartDate: day(14), EndDate: day(16), NightlyRate: 30000, WeekendRate: 35000},
+	}
+
+	var tests = []struct {
+		name   string
+		start  int
+		end    int
+		total  int
+		rates  []int
+		season []string
+	}{
+		{"weekend", 1, 3, 22000, []int{12000, 10000}, []string{"", ""}},
+		{"weekday", 2, 3, 10000, []int{10000}, []string{""}},
+		{"into a season", 2, 4, 25000, []int{10000, 15000}, []string{"", "Winter"}},
+		{"season without weekend rate", 7, 9, 30000, []int{15000, 15000}, []string{"Winter", "Winter"}},
+		{"season inside a season", 13, 17, 100000, []int{15000, 35000, 35000, 15000}, []string{"Winter", "Festival", "Festival", "Winter"}},
+		{"out of a season", 19, 21, 25000, []int{15000, 10000}, []string{"Winter", ""}},
+		{"no nights", 5, 5, 0, nil, nil},
+	}
+
+	for _, e := range tests {
+		q := Quote(room, seasons, day(e.start), day(e.end))
+		if q.Total != e.total {
+			t.Errorf("%s: expected a total of %d, got %d", e.name, e.total, q.Total)
+		}
+		if len(q.Nights) != len(e.rates) {
+			t.Errorf("%s: expected %d nights, got %d", e.name, len(e.rates), len(q.Nights))
+			continue
+		}
+		for i, n := range q.Nights {
+			if !n.Date.Equal(day(e.start + i)) {
+				t.Errorf("%s: expected night %d to be %s, got %s", e.name, i, day(e.start+i), n.Date)
+			}
+			if n.Rate != e.rates[i] || n.Season != e.season[i] {
+				t.Errorf("%s: expected night %d to be %d from %q, got %d from %q", e.name, i, e.rates[i], e.season[i], n.Rate, n.Season)
+			}
+		}
+	}
+}
+
+func TestFormat(t *testing.T) {
+	var tests = []struct {
+		cents int
+		want  string
+	}{
+		{0, "$0.00"},
+		{5, "$0.05"},
+		{12050, "$120.50"},
+		{123456789, "$1,234,567.89"},
+		{100000, "$1,000.00"},
+		{-2500, "-$25.00"},
+	}
+
+	for _, e := range tests {
+		if got := Format(e.cents); got != e.want {
+			t.Errorf("Format(%d): expected %s, got %s", e.cents, e.want, got)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	var tests = []struct {
+		in    string
+		cents int
+		err   bool
+	}{
+		{"120", 12000, false},
+		{"99.5", 9950, false},
+		{"99.05", 9905, false},
+		{" $1,200 ", 120000, false},
+		{"0", 0, false},
+		{"", 0, true},
+		{"-5", 0, true},
+		{"12.345", 0, true},
+		{"abc", 0, true},
+		{"1.", 0, true},
+	}
+
+	for _, e := range tests {
+		cents, err := Parse(e.in)
+		if (err != nil) != e.err {
+			t.Errorf("Parse(%q): expected an error %v, got %v", e.in, e.err, err)
+		}
+		if !e.err && cents != e.cents {
+			t.Errorf("Parse(%q): expected %d, got %d", e.in, e.cents, cents)
+		}
+	}
+}
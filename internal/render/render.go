@@ -11,6 +11,7 @@ import (
 
 	"github.com/andkolbe/bookings/internal/config"
 	"github.com/andkolbe/bookings/internal/models"
+	"github.com/andkolbe/bookings/internal/pricing"
 	"github.com/justinas/nosurf"
 )
 
@@ -21,6 +22,8 @@ var functions = template.FuncMap{
 	"formatDate": FormatDate,
 	"iterate": Iterate,
 	"add": Add,
+	"roleName": models.AccessLevelName,
+	"price": pricing.Format,
 }
 
 var app *config.AppConfig
@@ -64,6 +67,9 @@ func AddDefaultData(td *models.TemplateData, r *http.Request) *models.TemplateDa
 	// AddDefaultData has access to the session because it has the request
 	if app.Session.Exists(r.Context(), "user_id") {
 		td.IsAuthenticated = 1 // 1 means the user is logged in. 0 means the user is logged out
+		td.AccessLevel = app.Session.GetInt(r.Context(), "access_level")
+		td.IsManager = td.AccessLevel >= models.AccessManager
+		td.IsOwner = td.AccessLevel >= models.AccessOwner
 	}
 	return td
 }
@@ -22,6 +22,33 @@ func TestAddDefaultData(t *testing.T) {
 	}
 }
 
+func TestAddDefaultData_Roles(t *testing.T) {
+	var tests = []struct {
+		name      string
+		level     int
+		isManager bool
+		isOwner   bool
+	}{
+		{"front desk", models.AccessFrontDesk, false, false},
+		{"manager", models.AccessManager, true, false},
+		{"owner", models.AccessOwner, true, true},
+	}
+
+	for _, e := range tests {
+		r, err := getSession()
+		if err != nil {
+			t.Fatal(err)
+		}
+		session.Put(r.Context(), "user_id", 1)
+		session.Put(r.Context(), "access_level", e.level)
+
+		td := AddDefaultData(&models.TemplateData{}, r)
+		if td.IsManager != e.isManager || td.IsOwner != e.isOwner {
+			t.Errorf("%s: expected manager %t owner %t, got %t %t", e.name, e.isManager, e.isOwner, td.IsManager, td.IsOwner)
+		}
+	}
+}
+
 func TestRenderTemplate(t *testing.T) {
 	pathToTemplates = "./../../templates"
 	tc, err := CreateTemplateCache()
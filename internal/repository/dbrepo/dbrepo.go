@@ -1,7 +1,9 @@
 package dbrepo
 
 import (
+	"context"
 	"database/sql"
+	"time"
 
 	"github.com/andkolbe/bookings/internal/config"
 	"github.com/andkolbe/bookings/internal/repository"
@@ -30,4 +32,17 @@ func NewTestingRepo(a *config.AppConfig) repository.DatabaseRepo {
 	return &testDBRepo{
 		App: a,
 	}
-}
\ No newline at end of file
+}
+
+// used when the app config doesn't set a query timeout
+const defaultQueryTimeout = 3 * time.Second
+
+// returns a context for a single query. It is cancelled when ctx is, for example when the client goes away,
+// or when the configured query timeout runs out, whichever comes first
+func (m *postgresDBRepo) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	timeout := defaultQueryTimeout
+	if m.App != nil && m.App.DBTimeout > 0 {
+		timeout = m.App.DBTimeout
+	}
+	return context.WithTimeout(ctx, timeout)
+}
@@ -2,31 +2,62 @@ package dbrepo
 
 import (
 	"context"
+	"database/sql"
+	"encoding/json"
 	"errors"
 	"log"
+	"strings"
 	"time"
 
 	"github.com/andkolbe/bookings/internal/models"
+	"github.com/andkolbe/bookings/internal/repository"
+	"github.com/jackc/pgconn"
 	"golang.org/x/crypto/bcrypt"
 )
 
-func (m *postgresDBRepo) AllUsers() bool {
-	return true
+// returns every user, active or not, owners first
+func (m *postgresDBRepo) AllUsers(ctx context.Context) ([]models.User, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var users []models.User
+
+	query := `SELECT ` + userColumns + ` FROM users ORDER BY access_level DESC, last_name, first_name, email`
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return users, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return users, err
+		}
+		users = append(users, u)
+	}
+
+	if err = rows.Err(); err != nil {
+		return users, err
+	}
+
+	return users, nil
 }
 
 // inserts a reservation into the db
-func (m *postgresDBRepo) InsertReservation(res models.Reservation) (int, error) {
+func (m *postgresDBRepo) InsertReservation(ctx context.Context, res models.Reservation) (int, error) {
 	// if a user loses their connection to the internet while in the middle of submitting data to the db, we want that to close and not go through
 	// this is called a transaction
 	// Go uses something called context to fix this
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // cancel transaction if it takes longer than 3 seconds to complete
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
 
 	var newID int
 
 	stmt := `INSERT INTO reservations (first_name, last_name, email, phone, start_date, end_date, room_id,
-				created_at, updated_at)
-				VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) returning id`
+				adults, children, created_at, updated_at)
+				VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) returning id`
 	err := m.DB.QueryRowContext(ctx, stmt,
 		res.FirstName,
 		res.LastName,
@@ -35,6 +66,8 @@ func (m *postgresDBRepo) InsertReservation(res models.Reservation) (int, error)
 		res.StartDate,
 		res.EndDate,
 		res.RoomID,
+		res.Adults,
+		res.Children,
 		time.Now(),
 		time.Now(),
 	).Scan(&newID)
@@ -47,8 +80,8 @@ func (m *postgresDBRepo) InsertReservation(res models.Reservation) (int, error)
 }
 
 // inserts a room restriction into the db
-func (m *postgresDBRepo) InsertRoomRestriction(r models.RoomRestriction) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // cancel transaction if it takes longer than 3 seconds to complete
+func (m *postgresDBRepo) InsertRoomRestriction(ctx context.Context, r models.RoomRestriction) error {
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
 
 	stmt := `INSERT INTO room_restrictions (start_date, end_date, room_id, reservation_id, created_at, updated_at, restriction_id)
@@ -70,64 +103,272 @@ func (m *postgresDBRepo) InsertRoomRestriction(r models.RoomRestriction) error {
 	return nil
 }
 
-// returns true if availability exists for roomID
-func (m *postgresDBRepo) SearchAvailabilityByDatesByRoomID(start, end time.Time, roomID int) (bool, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // cancel transaction if it takes longer than 3 seconds to complete
+// inserts a reservation and the room restriction for its dates in one transaction.
+// Availability is checked again inside the transaction with the room row locked, so two guests booking the
+// same room at the same time are handled one after the other. Returns a *repository.ConflictError if the dates are taken
+func (m *postgresDBRepo) CreateReservation(ctx context.Context, res models.Reservation) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
 
+	conflict := &repository.ConflictError{RoomID: res.RoomID, StartDate: res.StartDate, EndDate: res.EndDate}
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	// does nothing once the transaction is committed, so this only undoes our work if we return early
+	defer tx.Rollback()
+
+	// lock the room so any other booking for it waits until this transaction is finished
+	var roomID int
+	var active bool
+	err = tx.QueryRowContext(ctx, `SELECT id, active FROM rooms WHERE id = $1 FOR UPDATE`, res.RoomID).Scan(&roomID, &active)
+	if err != nil {
+		return 0, err
+	}
+	if !active {
+		return 0, conflict
+	}
+
 	var numRows int
+	query := `
+		SELECT COUNT(id)
+		FROM room_restrictions
+		WHERE room_id = $1 AND $2 < end_date AND $3 > start_date
+	`
+	err = tx.QueryRowContext(ctx, query, res.RoomID, res.StartDate, res.EndDate).Scan(&numRows)
+	if err != nil {
+		return 0, err
+	}
+	if numRows > 0 {
+		return 0, conflict
+	}
+
+	var newID int
+	stmt := `INSERT INTO reservations (first_name, last_name, email, phone, start_date, end_date, room_id,
+				adults, children, total_price, created_at, updated_at)
+				VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12) returning id`
+	err = tx.QueryRowContext(ctx, stmt,
+		res.FirstName,
+		res.LastName,
+		res.Email,
+		res.Phone,
+		res.StartDate,
+		res.EndDate,
+		res.RoomID,
+		res.Adults,
+		res.Children,
+		res.Price.Total,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	err = insertReservationNights(ctx, tx, newID, res.Price)
+	if err != nil {
+		return 0, err
+	}
+
+	stmt = `INSERT INTO room_restrictions (start_date, end_date, room_id, reservation_id, created_at, updated_at, restriction_id)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)`
+	_, err = tx.ExecContext(ctx, stmt,
+		res.StartDate,
+		res.EndDate,
+		res.RoomID,
+		newID,
+		time.Now(),
+		time.Now(),
+		1, // reservation
+	)
+	if err != nil {
+		// the exclusion constraint on room_restrictions is the last line of defence against overlapping reservations
+		if isExclusionViolation(err) {
+			return 0, conflict
+		}
+		return 0, err
+	}
+
+	// new reservations start out pending, which is the first entry in their history
+	stmt = `
+		INSERT INTO reservation_status_history (reservation_id, from_status, to_status, created_at, updated_at)
+		VALUES ($1, '', $2, $3, $4)
+	`
+	_, err = tx.ExecContext(ctx, stmt, newID, models.ReservationPending, time.Now(), time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		if isExclusionViolation(err) {
+			return 0, conflict
+		}
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// moves a reservation and its room restriction to new dates, and replaces its price with price. Like CreateReservation
+// it locks the room while it checks availability, and returns a *repository.ConflictError if anything other than
+// the reservation itself is in the way
+func (m *postgresDBRepo) ChangeReservationDates(ctx context.Context, id int, start, end time.Time, price models.Quote) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var roomID int
+	err = tx.QueryRowContext(ctx, `SELECT room_id FROM reservations WHERE id = $1`, id).Scan(&roomID)
+	if err != nil {
+		return err
+	}
+
+	conflict := &repository.ConflictError{RoomID: roomID, StartDate: start, EndDate: end}
+
+	_, err = tx.ExecContext(ctx, `SELECT id FROM rooms WHERE id = $1 FOR UPDATE`, roomID)
+	if err != nil {
+		return err
+	}
 
-	// make sure the dates for the room are not already taken
+	// the same check as SearchAvailabilityByDatesByRoomID, without counting the stay we are moving
+	var numRows int
 	query := `
 		SELECT COUNT(id)
 		FROM room_restrictions
 		WHERE room_id = $1 AND $2 < end_date AND $3 > start_date
+		AND (reservation_id IS NULL OR reservation_id <> $4)
+	`
+	err = tx.QueryRowContext(ctx, query, roomID, start, end, id).Scan(&numRows)
+	if err != nil {
+		return err
+	}
+	if numRows > 0 {
+		return conflict
+	}
+
+	// counting the change retires the guest links sent for the old dates, even if the stay is later moved back
+	_, err = tx.ExecContext(ctx, `UPDATE reservations SET start_date = $1, end_date = $2, total_price = $3, updated_at = $4,
+			date_changes = date_changes + 1 WHERE id = $5`,
+		start, end, price.Total, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM reservation_nights WHERE reservation_id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	err = insertReservationNights(ctx, tx, id, price)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE room_restrictions SET start_date = $1, end_date = $2, updated_at = $3 WHERE reservation_id = $4`,
+		start, end, time.Now(), id)
+	if err != nil {
+		if isExclusionViolation(err) {
+			return conflict
+		}
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		if isExclusionViolation(err) {
+			return conflict
+		}
+		return err
+	}
+
+	return nil
+}
+
+// stores the night by night price of a reservation, as part of tx
+func insertReservationNights(ctx context.Context, tx *sql.Tx, reservationID int, price models.Quote) error {
+	stmt := `
+		INSERT INTO reservation_nights (reservation_id, night, rate, season, weekend, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`
+	for _, n := range price.Nights {
+		_, err := tx.ExecContext(ctx, stmt, reservationID, n.Date, n.Rate, n.Season, n.Weekend, time.Now(), time.Now())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// returns true if err is postgres refusing a row because of an exclusion constraint
+func isExclusionViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23P01"
+}
+
+// returns true if availability exists for roomID
+func (m *postgresDBRepo) SearchAvailabilityByDatesByRoomID(ctx context.Context, start, end time.Time, roomID int) (bool, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var available bool
+
+	// make sure the room is open to guests and the dates for it are not already taken
+	query := `
+		SELECT rooms.active AND NOT EXISTS (
+			SELECT 1
+			FROM room_restrictions
+			WHERE room_id = rooms.id AND $2 < end_date AND $3 > start_date
+			)
+		FROM rooms
+		WHERE rooms.id = $1
 	`
-	// run the query in PG
 	row := m.DB.QueryRowContext(ctx, query, roomID, start, end)
-	// scan the value in numRows
-	err := row.Scan(&numRows)
+	err := row.Scan(&available)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
 	if err != nil {
 		return false, err
 	}
 
-	if numRows == 0 {
-		// return the numRows. If it returns true, the room is available
-		return true, nil
-	}
-	return false, nil
+	return available, nil
 
 }
 
-// returns a slice of available rooms, if any, for given date range
+// returns a slice of available rooms, if any, for given date range that sleep at least guests
 // we don't need the room id as a param because we are searching through all rooms
-func (m *postgresDBRepo) SearchAvailabilityForAllRooms(start, end time.Time) ([]models.Room, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // cancel transaction if it takes longer than 3 seconds to complete
+func (m *postgresDBRepo) SearchAvailabilityForAllRooms(ctx context.Context, start, end time.Time, guests int) ([]models.Room, error) {
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
 
 	var rooms []models.Room
 
 	query := `
-		SELECT rooms.id, rooms.room_name
+		SELECT ` + roomColumns + `
 		FROM rooms
-		WHERE rooms.id NOT IN (
+		WHERE rooms.active AND rooms.capacity >= $3 AND rooms.id NOT IN (
 			SELECT room_id
 			FROM room_restrictions
 			WHERE $1 < room_restrictions.end_date AND $2 > room_restrictions.start_date
 			)
+		ORDER BY rooms.room_name
 	`
 
-	rows, err := m.DB.QueryContext(ctx, query, start, end)
+	rows, err := m.DB.QueryContext(ctx, query, start, end, guests)
 	if err != nil {
 		return rooms, err
 	}
+	defer rows.Close()
 
 	for rows.Next() {
-		var room models.Room
-		err := rows.Scan(
-			&room.ID,
-			&room.RoomName,
-		)
+		room, err := scanRoom(rows)
 		if err != nil {
 			return rooms, err
 		}
@@ -143,41 +384,40 @@ func (m *postgresDBRepo) SearchAvailabilityForAllRooms(start, end time.Time) ([]
 }
 
 // gets a room by id
-func (m *postgresDBRepo) GetRoomByID(id int) (models.Room, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // cancel transaction if it takes longer than 3 seconds to complete
+func (m *postgresDBRepo) GetRoomByID(ctx context.Context, id int) (models.Room, error) {
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
 
-	var room models.Room
+	query := `SELECT ` + roomColumns + ` FROM rooms WHERE id = $1`
 
-	query := `
-		SELECT id, room_name, created_at, updated_at
-		FROM rooms
-		WHERE id = $1
-	`
+	return scanRoom(m.DB.QueryRowContext(ctx, query, id))
+}
 
-	row := m.DB.QueryRowContext(ctx, query, id)
-	err := row.Scan(
-		&room.ID,
-		&room.RoomName,
-		&room.CreatedAt,
-		&room.UpdatedAt,
-	)
-	if err != nil {
-		return room, err
-	}
+// returns a user by ID
+func (m *postgresDBRepo) GetUserByID(ctx context.Context, id int) (models.User, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
 
-	return room, nil
+	query := `SELECT ` + userColumns + ` FROM users WHERE id = $1`
+
+	return scanUser(m.DB.QueryRowContext(ctx, query, id))
 }
 
-// returns a user by ID
-func (m *postgresDBRepo) GetUserByID(id int) (models.User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // cancel transaction if it takes longer than 3 seconds to complete
+// returns a user by their email address, ignoring case
+func (m *postgresDBRepo) GetUserByEmail(ctx context.Context, email string) (models.User, error) {
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
 
-	query := `SELECT id, first_name, last_name, email, password, access_level, created_at, updated_at FROM users WHERE id = $1`
+	query := `SELECT ` + userColumns + ` FROM users WHERE lower(email) = lower($1)`
 
-	row := m.DB.QueryRowContext(ctx, query, id)
+	return scanUser(m.DB.QueryRowContext(ctx, query, email))
+}
 
+// the columns scanUser reads, in order
+const userColumns = `id, first_name, last_name, email, password, access_level, active, password_changed_at, totp_secret,
+	created_at, updated_at`
+
+func scanUser(row interface{ Scan(...interface{}) error }) (models.User, error) {
 	var u models.User
 	err := row.Scan(
 		&u.ID,
@@ -186,104 +426,475 @@ func (m *postgresDBRepo) GetUserByID(id int) (models.User, error) {
 		&u.Email,
 		&u.Password,
 		&u.AccessLevel,
+		&u.Active,
+		&u.PasswordChangedAt,
+		&u.TOTPSecret,
 		&u.CreatedAt,
 		&u.UpdatedAt,
 	)
 
+	return u, err
+}
+
+// adds a user without a password. They choose one with the link in their invite
+func (m *postgresDBRepo) InsertUser(ctx context.Context, u models.User) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var newID int
+	stmt := `INSERT INTO users (first_name, last_name, email, password, access_level, active, password_changed_at, created_at, updated_at)
+			VALUES ($1, $2, $3, '', $4, $5, $6, $6, $6) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt,
+		u.FirstName,
+		u.LastName,
+		u.Email,
+		u.AccessLevel,
+		u.Active,
+		time.Now(),
+	).Scan(&newID)
+	if isUniqueViolation(err) {
+		return 0, repository.ErrEmailTaken
+	}
 	if err != nil {
-		return u, err
+		return 0, err
 	}
 
-	return u, nil
+	return newID, nil
 }
 
-// updates a user in the db
-func (m *postgresDBRepo) UpdateUser(u models.User) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // cancel transaction if it takes longer than 3 seconds to complete
+// saves a user's name, email, access level and whether they are active. Returns ErrLastOwner instead if that would
+// leave no active owners. The owners are locked while we check, so two owners demoting each other at the same
+// time can't both win
+func (m *postgresDBRepo) UpdateUser(ctx context.Context, u models.User) error {
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
 
-	query := `UPDATE users SET first_name = $1, last_name = $2, email = $3, access_level = $4, updated_at = $5`
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	// does nothing once the transaction is committed
+	defer tx.Rollback()
+
+	if u.AccessLevel < models.AccessOwner || !u.Active {
+		var others int
+		var isOwner bool
+		err = tx.QueryRowContext(ctx, `
+			SELECT count(*) FILTER (WHERE id <> $2), count(*) FILTER (WHERE id = $2) > 0 FROM (
+				SELECT id FROM users WHERE access_level >= $1 AND active FOR UPDATE
+			) owners`, models.AccessOwner, u.ID).Scan(&others, &isOwner)
+		if err != nil {
+			return err
+		}
+		if isOwner && others == 0 {
+			return repository.ErrLastOwner
+		}
+	}
+
+	stmt := `UPDATE users SET first_name = $1, last_name = $2, email = $3, access_level = $4, active = $5, updated_at = $6
+			WHERE id = $7`
 
-	_, err := m.DB.ExecContext(ctx, query,
+	result, err := tx.ExecContext(ctx, stmt,
 		u.FirstName,
 		u.LastName,
 		u.Email,
 		u.AccessLevel,
+		u.Active,
 		time.Now(),
+		u.ID,
 	)
+	if isUniqueViolation(err) {
+		return repository.ErrEmailTaken
+	}
+	if err != nil {
+		return err
+	}
 
+	n, err := result.RowsAffected()
 	if err != nil {
 		return err
 	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
 
-	return nil
+	return tx.Commit()
 }
 
-// authenticates a user
-func (m *postgresDBRepo) Authenticate(email, testPassword string) (int, string, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // cancel transaction if it takes longer than 3 seconds to complete
+// sets a user's password to hash, which logs out everywhere they were logged in
+func (m *postgresDBRepo) UpdateUserPassword(ctx context.Context, id int, hash string) error {
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
 
-	// declare two variables to store info we get from the db
-	var id int 
-	var hashedPassword string
+	query := `UPDATE users SET password = $1, password_changed_at = $2, updated_at = $2 WHERE id = $3`
 
-	// query the db
-	row := m.DB.QueryRowContext(ctx, "SELECT id, password FROM users WHERE email = $1", email)
-	// store id and password values in id and hashedPassword variables
-	err := row.Scan(&id, &hashedPassword)
+	result, err := m.DB.ExecContext(ctx, query, hash, time.Now(), id)
 	if err != nil {
-		return id, "", err
+		return err
 	}
 
-	// compare the hash we grabbed from the db against the hash created from the password that the user typed in the form
-	err = bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(testPassword))
-	// if they don't match
-	if err == bcrypt.ErrMismatchedHashAndPassword {
-		return 0, "", errors.New("incorrect password")
-		// if there's another error that isn't a mismatched password
-	} else if err != nil {
-		return 0, "", err
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
 	}
 
-	return id, hashedPassword, nil
+	return nil
 }
 
-// returns a slice of all reservations
-func (m *postgresDBRepo) AllReservations() ([]models.Reservation, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // cancel transaction if it takes longer than 3 seconds to complete
+// turns on two-factor login for a user with the secret they set up their authenticator app with. step is the one
+// the code they confirmed it with was for, so that code can't be used again to log in. Any recovery codes they had
+// are replaced
+func (m *postgresDBRepo) EnableTwoFactor(ctx context.Context, userID int, secret string, step int64, recoveryHashes []string) error {
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
 
-	var reservations []models.Reservation
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	// does nothing once the transaction is committed
+	defer tx.Rollback()
 
-	query := `
-		SELECT r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date, r.end_date, r.room_id, r.created_at, r.updated_at,
-		r.processed, rm.id, rm.room_name
-		FROM reservations r
-		LEFT JOIN rooms rm ON r.room_id = rm.id
-		ORDER BY r.start_date
-	`
+	result, err := tx.ExecContext(ctx,
+		`UPDATE users SET totp_secret = $1, totp_last_step = $2, updated_at = $3 WHERE id = $4`,
+		secret, step, time.Now(), userID)
+	if err != nil {
+		return err
+	}
 
-	rows, err := m.DB.QueryContext(ctx, query)
+	n, err := result.RowsAffected()
 	if err != nil {
-		return reservations, err
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
 	}
-	defer rows.Close()
 
-	for rows.Next() {
-		var i models.Reservation
-		err := rows.Scan(
-			&i.ID,
-			&i.FirstName,
-			&i.LastName,
-			&i.Email,
-			&i.Phone,
-			&i.StartDate,
+	err = replaceRecoveryCodes(ctx, tx, userID, recoveryHashes)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// turns off two-factor login for a user and throws away their recovery codes
+func (m *postgresDBRepo) DisableTwoFactor(ctx context.Context, userID int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	// does nothing once the transaction is committed
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx,
+		`UPDATE users SET totp_secret = '', totp_last_step = 0, updated_at = $1 WHERE id = $2`, time.Now(), userID)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	err = replaceRecoveryCodes(ctx, tx, userID, nil)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// marks the step a user's two-factor code was for as used. Returns ErrCodeUsed if that step, or a later one, has
+// already been used, so a code that was seen over someone's shoulder can't log in a second time
+func (m *postgresDBRepo) UseTOTPStep(ctx context.Context, userID int, step int64) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx,
+		`UPDATE users SET totp_last_step = $1 WHERE id = $2 AND totp_last_step < $1`, step, userID)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return repository.ErrCodeUsed
+	}
+
+	return nil
+}
+
+// throws away a user's recovery codes and stores new ones
+func (m *postgresDBRepo) ReplaceRecoveryCodes(ctx context.Context, userID int, hashes []string) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	// does nothing once the transaction is committed
+	defer tx.Rollback()
+
+	err = replaceRecoveryCodes(ctx, tx, userID, hashes)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func replaceRecoveryCodes(ctx context.Context, tx *sql.Tx, userID int, hashes []string) error {
+	_, err := tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	for _, hash := range hashes {
+		_, err = tx.ExecContext(ctx,
+			`INSERT INTO recovery_codes (user_id, code_hash, created_at, updated_at) VALUES ($1, $2, $3, $3)`,
+			userID, hash, time.Now())
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// uses up one of a user's recovery codes. Returns sql.ErrNoRows if they don't have it
+func (m *postgresDBRepo) UseRecoveryCode(ctx context.Context, userID int, hash string) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1 AND code_hash = $2`, userID, hash)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// returns how many recovery codes a user has left
+func (m *postgresDBRepo) CountRecoveryCodes(ctx context.Context, userID int) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var n int
+	err := m.DB.QueryRowContext(ctx, `SELECT count(*) FROM recovery_codes WHERE user_id = $1`, userID).Scan(&n)
+
+	return n, err
+}
+
+// returns the failed logins for key. A key that has never failed comes back with no failures
+func (m *postgresDBRepo) GetLoginThrottle(ctx context.Context, key string) (models.LoginThrottle, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := `SELECT key, failures, last_failure_at, locked_until FROM login_throttles WHERE key = $1`
+
+	t, err := scanLoginThrottle(m.DB.QueryRowContext(ctx, query, key))
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.LoginThrottle{Key: key}, nil
+	}
+
+	return t, err
+}
+
+// counts a failed login for key and returns the new count. If the last failure was longer ago than window,
+// the count starts again. The count is done in the database so logins at the same time can't lose failures
+func (m *postgresDBRepo) RecordLoginFailure(ctx context.Context, key string, window time.Duration) (models.LoginThrottle, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	now := time.Now()
+	stmt := `INSERT INTO login_throttles (key, failures, last_failure_at, created_at, updated_at) VALUES ($1, 1, $2, $2, $2)
+			ON CONFLICT (key) DO UPDATE SET
+				failures = CASE WHEN login_throttles.last_failure_at < $3 THEN 1 ELSE login_throttles.failures + 1 END,
+				last_failure_at = $2, updated_at = $2
+			RETURNING key, failures, last_failure_at, locked_until`
+
+	return scanLoginThrottle(m.DB.QueryRowContext(ctx, stmt, key, now, now.Add(-window)))
+}
+
+// locks key out until until. The count of failures starts again, so once the lock is over there are as many
+// tries before the next one
+func (m *postgresDBRepo) LockLogin(ctx context.Context, key string, until time.Time) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx,
+		`UPDATE login_throttles SET failures = 0, locked_until = $1, updated_at = $2 WHERE key = $3`, until, time.Now(), key)
+
+	return err
+}
+
+// forgets the failed logins for key, e.g. after a successful login or when an owner unlocks it
+func (m *postgresDBRepo) ClearLoginThrottle(ctx context.Context, key string) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM login_throttles WHERE key = $1`, key)
+
+	return err
+}
+
+// returns the accounts and ip addresses that are locked out now, the ones locked longest first
+func (m *postgresDBRepo) LockedLogins(ctx context.Context) ([]models.LoginThrottle, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var locked []models.LoginThrottle
+
+	query := `SELECT key, failures, last_failure_at, locked_until FROM login_throttles
+			WHERE locked_until > $1 ORDER BY locked_until DESC, key`
+
+	rows, err := m.DB.QueryContext(ctx, query, time.Now())
+	if err != nil {
+		return locked, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		t, err := scanLoginThrottle(rows)
+		if err != nil {
+			return locked, err
+		}
+		locked = append(locked, t)
+	}
+
+	if err = rows.Err(); err != nil {
+		return locked, err
+	}
+
+	return locked, nil
+}
+
+func scanLoginThrottle(row interface{ Scan(...interface{}) error }) (models.LoginThrottle, error) {
+	var t models.LoginThrottle
+	var lockedUntil sql.NullTime
+	err := row.Scan(&t.Key, &t.Failures, &t.LastFailureAt, &lockedUntil)
+	t.LockedUntil = lockedUntil.Time
+
+	return t, err
+}
+
+// returns a site wide setting, or a blank string if it has never been set
+func (m *postgresDBRepo) GetSetting(ctx context.Context, name string) (string, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var value string
+	err := m.DB.QueryRowContext(ctx, `SELECT value FROM settings WHERE name = $1`, name).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+
+	return value, err
+}
+
+// saves a site wide setting
+func (m *postgresDBRepo) PutSetting(ctx context.Context, name, value string) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `INSERT INTO settings (name, value, created_at, updated_at) VALUES ($1, $2, $3, $3)
+			ON CONFLICT (name) DO UPDATE SET value = excluded.value, updated_at = excluded.updated_at`
+
+	_, err := m.DB.ExecContext(ctx, stmt, name, value, time.Now())
+
+	return err
+}
+
+// authenticates a user
+func (m *postgresDBRepo) Authenticate(ctx context.Context, email, testPassword string) (int, string, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	// declare two variables to store info we get from the db
+	var id int 
+	var hashedPassword string
+
+	// query the db
+	row := m.DB.QueryRowContext(ctx, "SELECT id, password FROM users WHERE email = $1 AND active", email)
+	// store id and password values in id and hashedPassword variables
+	err := row.Scan(&id, &hashedPassword)
+	if err != nil {
+		return id, "", err
+	}
+
+	// compare the hash we grabbed from the db against the hash created from the password that the user typed in the form
+	err = bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(testPassword))
+	// if they don't match
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return 0, "", errors.New("incorrect password")
+		// if there's another error that isn't a mismatched password
+	} else if err != nil {
+		return 0, "", err
+	}
+
+	return id, hashedPassword, nil
+}
+
+// returns a slice of all reservations, or only the ones with status if it isn't blank
+func (m *postgresDBRepo) AllReservations(ctx context.Context, status string) ([]models.Reservation, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var reservations []models.Reservation
+
+	query := `
+		SELECT r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date, r.end_date, r.room_id, r.created_at, r.updated_at,
+		r.status, rm.id, rm.room_name
+		FROM reservations r
+		LEFT JOIN rooms rm ON r.room_id = rm.id
+		WHERE $1 = '' OR r.status = $1
+		ORDER BY r.start_date
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, status)
+	if err != nil {
+		return reservations, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var i models.Reservation
+		err := rows.Scan(
+			&i.ID,
+			&i.FirstName,
+			&i.LastName,
+			&i.Email,
+			&i.Phone,
+			&i.StartDate,
 			&i.EndDate,
 			&i.RoomID,
 			&i.CreatedAt,
 			&i.UpdatedAt,
-			&i.Processed,
+			&i.Status,
 			&i.Room.ID,
 			&i.Room.RoomName,
 		)
@@ -301,23 +912,23 @@ func (m *postgresDBRepo) AllReservations() ([]models.Reservation, error) {
 	return reservations, nil
 }
 
-// returns a slice of all new reservations
-func (m *postgresDBRepo) AllNewReservations() ([]models.Reservation, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // cancel transaction if it takes longer than 3 seconds to complete
+// returns a slice of all new reservations, the ones nobody has confirmed yet
+func (m *postgresDBRepo) AllNewReservations(ctx context.Context) ([]models.Reservation, error) {
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
 
 	var reservations []models.Reservation
 
 	query := `
 		SELECT r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date, r.end_date, r.room_id, r.created_at, r.updated_at,
-		rm.id, rm.room_name
+		r.status, rm.id, rm.room_name
 		FROM reservations r
 		LEFT JOIN rooms rm ON r.room_id = rm.id
-		WHERE processed = 0
+		WHERE r.status = $1
 		ORDER BY r.start_date
 	`
 
-	rows, err := m.DB.QueryContext(ctx, query)
+	rows, err := m.DB.QueryContext(ctx, query, models.ReservationPending)
 	if err != nil {
 		return reservations, err
 	}
@@ -336,6 +947,7 @@ func (m *postgresDBRepo) AllNewReservations() ([]models.Reservation, error) {
 			&i.RoomID,
 			&i.CreatedAt,
 			&i.UpdatedAt,
+			&i.Status,
 			&i.Room.ID,
 			&i.Room.RoomName,
 		)
@@ -354,15 +966,15 @@ func (m *postgresDBRepo) AllNewReservations() ([]models.Reservation, error) {
 }
 
 // returns one reservation by id
-func (m *postgresDBRepo) GetReservationByID(id int) (models.Reservation, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // cancel transaction if it takes longer than 3 seconds to complete
+func (m *postgresDBRepo) GetReservationByID(ctx context.Context, id int) (models.Reservation, error) {
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
 
 	var res models.Reservation
 
 	query := `
-		SELECT r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date, r.end_date, r.room_id, r.created_at, r.updated_at, r.processed,
-		rm.id, rm.room_name
+		SELECT r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date, r.end_date, r.room_id, r.adults, r.children,
+		r.created_at, r.updated_at, r.status, r.total_price, r.date_changes, rm.id, rm.room_name
 		FROM reservations r
 		LEFT JOIN rooms rm ON r.room_id = rm.id
 		WHERE r.id = $1
@@ -378,9 +990,13 @@ func (m *postgresDBRepo) GetReservationByID(id int) (models.Reservation, error)
 		&res.StartDate,
 		&res.EndDate,
 		&res.RoomID,
+		&res.Adults,
+		&res.Children,
 		&res.CreatedAt,
 		&res.UpdatedAt,
-		&res.Processed,
+		&res.Status,
+		&res.Price.Total,
+		&res.DateChanges,
 		&res.Room.ID,
 		&res.Room.RoomName,
 	)
@@ -389,12 +1005,38 @@ func (m *postgresDBRepo) GetReservationByID(id int) (models.Reservation, error)
 		return res, err
 	}
 
+	query = `
+		SELECT night, rate, season, weekend
+		FROM reservation_nights
+		WHERE reservation_id = $1
+		ORDER BY night
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, id)
+	if err != nil {
+		return res, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var n models.NightPrice
+		err := rows.Scan(&n.Date, &n.Rate, &n.Season, &n.Weekend)
+		if err != nil {
+			return res, err
+		}
+		res.Price.Nights = append(res.Price.Nights, n)
+	}
+
+	if err = rows.Err(); err != nil {
+		return res, err
+	}
+
 	return res, nil
 }
 
 // updates a reservation in the db
-func (m *postgresDBRepo) UpdateReservation(u models.Reservation) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // cancel transaction if it takes longer than 3 seconds to complete
+func (m *postgresDBRepo) UpdateReservation(ctx context.Context, u models.Reservation) error {
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
 
 	query := `UPDATE reservations SET first_name = $1, last_name = $2, email = $3, phone = $4, updated_at = $5
@@ -416,47 +1058,114 @@ func (m *postgresDBRepo) UpdateReservation(u models.Reservation) error {
 	return nil
 }
 
-// deletes a reservation in the db by id
-func (m *postgresDBRepo) DeleteReservation(id int) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) 
+// moves a reservation to a new status and records the change in its history. The move has to be one
+// models.CanChangeReservationStatus allows, or a *repository.StatusError is returned. Cancelling a reservation,
+// or marking it a no-show, gives up its room. userID is the user making the change, or 0 for the guest
+func (m *postgresDBRepo) UpdateReservationStatus(ctx context.Context, id int, status string, userID int) error {
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
 
-	query := `DELETE FROM reservations WHERE id = $1`
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// lock the reservation so two changes at once can't both pass the check
+	var current string
+	err = tx.QueryRowContext(ctx, `SELECT status FROM reservations WHERE id = $1 FOR UPDATE`, id).Scan(&current)
+	if err != nil {
+		return err
+	}
 
-	_, err := m.DB.ExecContext(ctx, query, id)
+	if !models.CanChangeReservationStatus(current, status) {
+		return &repository.StatusError{ReservationID: id, From: current, To: status}
+	}
 
+	_, err = tx.ExecContext(ctx, `UPDATE reservations SET status = $1, updated_at = $2 WHERE id = $3`, status, time.Now(), id)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	// changes by the guest have no user
+	var user sql.NullInt64
+	if userID > 0 {
+		user = sql.NullInt64{Int64: int64(userID), Valid: true}
+	}
+	stmt := `
+		INSERT INTO reservation_status_history (reservation_id, from_status, to_status, user_id, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`
+	_, err = tx.ExecContext(ctx, stmt, id, current, status, user, time.Now(), time.Now())
+	if err != nil {
+		return err
+	}
+
+	if !models.ReservationHoldsRoom(status) {
+		_, err = tx.ExecContext(ctx, `DELETE FROM room_restrictions WHERE reservation_id = $1`, id)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
 }
 
-// updates processed for a reservation by id
-func (m *postgresDBRepo) UpdateProcessedForReservation(id, processed int) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) 
+// returns every status change of a reservation, oldest first
+func (m *postgresDBRepo) ReservationStatusHistory(ctx context.Context, id int) ([]models.ReservationStatusChange, error) {
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
 
-	query := `UPDATE reservations SET processed = $1 WHERE id = $2`
+	var changes []models.ReservationStatusChange
 
-	_, err := m.DB.ExecContext(ctx, query, processed, id)
+	query := `
+		SELECT h.id, h.reservation_id, h.from_status, h.to_status, COALESCE(h.user_id, 0), h.created_at,
+		COALESCE(u.first_name, ''), COALESCE(u.last_name, '')
+		FROM reservation_status_history h
+		LEFT JOIN users u ON h.user_id = u.id
+		WHERE h.reservation_id = $1
+		ORDER BY h.created_at, h.id
+	`
 
+	rows, err := m.DB.QueryContext(ctx, query, id)
 	if err != nil {
-		return err
+		return changes, err
 	}
+	defer rows.Close()
 
-	return nil
+	for rows.Next() {
+		var c models.ReservationStatusChange
+		err := rows.Scan(
+			&c.ID,
+			&c.ReservationID,
+			&c.FromStatus,
+			&c.ToStatus,
+			&c.UserID,
+			&c.CreatedAt,
+			&c.User.FirstName,
+			&c.User.LastName,
+		)
+		if err != nil {
+			return changes, err
+		}
+		c.User.ID = c.UserID
+		changes = append(changes, c)
+	}
+
+	if err = rows.Err(); err != nil {
+		return changes, err
+	}
+
+	return changes, nil
 }
 
-func (m *postgresDBRepo) AllRooms() ([]models.Room, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) 
+func (m *postgresDBRepo) AllRooms(ctx context.Context) ([]models.Room, error) {
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
 
 	var rooms []models.Room
 
-	query := `
-		SELECT id, room_name, created_at, updated_at FROM rooms ORDER BY room_name
-	`
+	query := `SELECT ` + roomColumns + ` FROM rooms ORDER BY room_name`
 
 	rows, err := m.DB.QueryContext(ctx, query)
 	if err != nil {
@@ -465,13 +1174,7 @@ func (m *postgresDBRepo) AllRooms() ([]models.Room, error) {
 	defer rows.Close()
 
 	for rows.Next() {
-		var rm models.Room
-		err := rows.Scan(
-			&rm.ID,
-			&rm.RoomName,
-			&rm.CreatedAt,
-			&rm.UpdatedAt,
-		)
+		rm, err := scanRoom(rows)
 		if err != nil {
 			return rooms, err
 		}
@@ -486,14 +1189,14 @@ func (m *postgresDBRepo) AllRooms() ([]models.Room, error) {
 }
 
 // returns restrictions for a room by date range
-func (m *postgresDBRepo) GetRestrictionsForRoomByDate(roomID int, start, end time.Time) ([]models.RoomRestriction, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) 
+func (m *postgresDBRepo) GetRestrictionsForRoomByDate(ctx context.Context, roomID int, start, end time.Time) ([]models.RoomRestriction, error) {
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
 
 	var restrictions []models.RoomRestriction
 	// add COALESCE to reservation_id because the value might be null. If it is null, store it as 0 instead to prevent errors
 	query := ` 
-			SELECT id, COALESCE(reservation_id, 0), restriction_id, room_id, start_date, end_date
+			SELECT id, COALESCE(reservation_id, 0), restriction_id, room_id, start_date, end_date, reason
 			FROM room_restrictions WHERE $1 < end_date AND $2 >= start_date
 			AND room_id = $3
 			`
@@ -515,6 +1218,7 @@ func (m *postgresDBRepo) GetRestrictionsForRoomByDate(roomID int, start, end tim
 			&r.RoomID,
 			&r.StartDate,
 			&r.EndDate,
+			&r.Reason,
 		)
 		if err != nil {
 			return nil, err
@@ -529,42 +1233,1069 @@ func (m *postgresDBRepo) GetRestrictionsForRoomByDate(roomID int, start, end tim
 	return restrictions, nil
 }
 
-// inserts a room restriction
-func (m *postgresDBRepo) InsertBlockForRoom(id int, startDate time.Time) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) 
+// blocks a room from the night of start up to, but not including, end
+// blocks a room for the nights from start to end. Returns a *repository.ConflictError if any of them are already
+// booked or blocked
+func (m *postgresDBRepo) InsertBlockForRoom(ctx context.Context, roomID int, start, end time.Time, reason string) error {
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
 
-	query := ` 
-			INSERT INTO room_restrictions (start_date, end_date, room_id, restriction_id, created_at, updated_at)
-			VALUES ($1, $2, $3, $4, $5, $6)
-			`
-
-	_, err := m.DB.ExecContext(ctx, query, startDate, startDate.AddDate(0, 0, 1), id, 2, time.Now(), time.Now())
+	conflict := &repository.ConflictError{RoomID: roomID, StartDate: start, EndDate: end}
 
+	tx, err := m.DB.BeginTx(ctx, nil)
 	if err != nil {
-		log.Println(err)
 		return err
 	}
+	defer tx.Rollback()
 
-	return nil
-}
-
-// deletes a room restriction
-func (m *postgresDBRepo) DeleteBlockByID(id int) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) 
-	defer cancel()
+	// lock the room like CreateReservation does, so a booking can't take these nights between the check and the insert
+	var id int
+	err = tx.QueryRowContext(ctx, `SELECT id FROM rooms WHERE id = $1 FOR UPDATE`, roomID).Scan(&id)
+	if err != nil {
+		return err
+	}
+
+	var numRows int
+	query := `
+		SELECT COUNT(id)
+		FROM room_restrictions
+		WHERE room_id = $1 AND $2 < end_date AND $3 > start_date
+	`
+	err = tx.QueryRowContext(ctx, query, roomID, start, end).Scan(&numRows)
+	if err != nil {
+		return err
+	}
+	if numRows > 0 {
+		return conflict
+	}
+
+	stmt := `
+		INSERT INTO room_restrictions (start_date, end_date, room_id, restriction_id, reason, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`
+	_, err = tx.ExecContext(ctx, stmt, start, end, roomID, models.RestrictionOwnerBlock, reason, time.Now(), time.Now())
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// deletes a block. A reservation's restriction is never removed here, and sql.ErrNoRows means there is no block
+// with that id
+func (m *postgresDBRepo) DeleteBlockByID(ctx context.Context, id int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
 
 	query := ` 
-			DELETE FROM room_restrictions WHERE id = $1
+			DELETE FROM room_restrictions WHERE id = $1 AND reservation_id IS NULL
 			`
 
-	_, err := m.DB.ExecContext(ctx, query, id)
+	result, err := m.DB.ExecContext(ctx, query, id)
 
 	if err != nil {
 		log.Println(err)
 		return err
 	}
 
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+
+// inserts an api token and returns its id
+func (m *postgresDBRepo) InsertAPIToken(ctx context.Context, t models.APIToken) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var newID int
+
+	stmt := `INSERT INTO api_tokens (user_id, name, token_hash, scope, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt,
+		t.UserID,
+		t.Name,
+		t.TokenHash,
+		t.Scope,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// returns all api tokens belonging to a user, newest first
+func (m *postgresDBRepo) APITokensForUser(ctx context.Context, userID int) ([]models.APIToken, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var tokens []models.APIToken
+
+	query := `
+		SELECT id, user_id, name, scope, last_used_at, created_at, updated_at
+		FROM api_tokens
+		WHERE user_id = $1
+		ORDER BY created_at DESC
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return tokens, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var t models.APIToken
+		var lastUsed sql.NullTime
+		err := rows.Scan(
+			&t.ID,
+			&t.UserID,
+			&t.Name,
+			&t.Scope,
+			&lastUsed,
+			&t.CreatedAt,
+			&t.UpdatedAt,
+		)
+		if err != nil {
+			return tokens, err
+		}
+		t.LastUsedAt = lastUsed.Time
+		tokens = append(tokens, t)
+	}
+
+	if err = rows.Err(); err != nil {
+		return tokens, err
+	}
+
+	return tokens, nil
+}
+
+// returns the api token with the given hash, along with the user it belongs to
+func (m *postgresDBRepo) GetAPITokenByHash(ctx context.Context, hash string) (models.APIToken, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var t models.APIToken
+	var lastUsed sql.NullTime
+
+	query := `
+		SELECT t.id, t.user_id, t.name, t.token_hash, t.scope, t.last_used_at, t.created_at, t.updated_at,
+		u.id, u.first_name, u.last_name, u.email, u.access_level
+		FROM api_tokens t
+		JOIN users u ON t.user_id = u.id
+		WHERE t.token_hash = $1 AND u.active
+	`
+
+	row := m.DB.QueryRowContext(ctx, query, hash)
+	err := row.Scan(
+		&t.ID,
+		&t.UserID,
+		&t.Name,
+		&t.TokenHash,
+		&t.Scope,
+		&lastUsed,
+		&t.CreatedAt,
+		&t.UpdatedAt,
+		&t.User.ID,
+		&t.User.FirstName,
+		&t.User.LastName,
+		&t.User.Email,
+		&t.User.AccessLevel,
+	)
+	if err != nil {
+		return t, err
+	}
+	t.LastUsedAt = lastUsed.Time
+
+	return t, nil
+}
+
+// records that an api token was just used
+func (m *postgresDBRepo) UpdateAPITokenLastUsed(ctx context.Context, id int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `UPDATE api_tokens SET last_used_at = $1 WHERE id = $2`, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// revokes one of a user's api tokens
+func (m *postgresDBRepo) DeleteAPIToken(ctx context.Context, id, userID int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM api_tokens WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// adds a message to the mail outbox. It will be picked up by the mail workers straight away
+func (m *postgresDBRepo) InsertMail(ctx context.Context, msg models.MailData) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	message, err := json.Marshal(msg)
+	if err != nil {
+		return 0, err
+	}
+
+	var newID int
+	now := time.Now()
+
+	stmt := `
+		INSERT INTO mail_outbox (message, status, attempts, next_attempt_at, last_error, created_at, updated_at)
+		VALUES ($1, $2, 0, $3, '', $3, $3) RETURNING id
+	`
+
+	err = m.DB.QueryRowContext(ctx, stmt, message, models.MailPending, now).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// claims up to limit messages that are due to be sent and counts the attempt. A claimed message is leased to the
+// caller for the lease duration; if it hasn't been marked sent, retried or failed by then (say the app crashed
+// mid send), another worker will pick it up again. SKIP LOCKED lets several workers claim at the same time
+func (m *postgresDBRepo) ClaimMail(ctx context.Context, limit int, lease time.Duration) ([]models.OutboxMail, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var mail []models.OutboxMail
+	now := time.Now()
+
+	query := `
+		UPDATE mail_outbox
+		SET status = $1, attempts = attempts + 1, next_attempt_at = $2, updated_at = $3
+		WHERE id IN (
+			SELECT id FROM mail_outbox
+			WHERE status IN ($4, $1) AND next_attempt_at <= $3
+			ORDER BY next_attempt_at
+			LIMIT $5
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, message, status, attempts, next_attempt_at, last_error, created_at, updated_at
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, models.MailSending, now.Add(lease), now, models.MailPending, limit)
+	if err != nil {
+		return mail, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		o, err := scanOutboxMail(rows)
+		if err != nil {
+			return mail, err
+		}
+		mail = append(mail, o)
+	}
+
+	if err = rows.Err(); err != nil {
+		return mail, err
+	}
+
+	return mail, nil
+}
+
+// records that a message from the outbox was sent
+func (m *postgresDBRepo) MarkMailSent(ctx context.Context, id int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	now := time.Now()
+
+	_, err := m.DB.ExecContext(ctx, `UPDATE mail_outbox SET status = $1, sent_at = $2, last_error = '', updated_at = $2 WHERE id = $3`,
+		models.MailSent, now, id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// puts a message that couldn't be sent back in the outbox, to be tried again at the given time
+func (m *postgresDBRepo) RetryMail(ctx context.Context, id int, at time.Time, lastError string) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `UPDATE mail_outbox SET status = $1, next_attempt_at = $2, last_error = $3, updated_at = $4 WHERE id = $5`,
+		models.MailPending, at, lastError, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// gives up on a message. It stays in the outbox so staff can see it and resend it
+func (m *postgresDBRepo) MarkMailFailed(ctx context.Context, id int, lastError string) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `UPDATE mail_outbox SET status = $1, last_error = $2, updated_at = $3 WHERE id = $4`,
+		models.MailFailed, lastError, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// returns every message the mail workers gave up on, newest first
+func (m *postgresDBRepo) FailedMail(ctx context.Context) ([]models.OutboxMail, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var mail []models.OutboxMail
+
+	query := `
+		SELECT id, message, status, attempts, next_attempt_at, last_error, created_at, updated_at
+		FROM mail_outbox
+		WHERE status = $1
+		ORDER BY updated_at DESC
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, models.MailFailed)
+	if err != nil {
+		return mail, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		o, err := scanOutboxMail(rows)
+		if err != nil {
+			return mail, err
+		}
+		mail = append(mail, o)
+	}
+
+	if err = rows.Err(); err != nil {
+		return mail, err
+	}
+
+	return mail, nil
+}
+
+// puts a failed message back in the outbox with a fresh set of attempts
+func (m *postgresDBRepo) ResendMail(ctx context.Context, id int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	now := time.Now()
+
+	result, err := m.DB.ExecContext(ctx, `UPDATE mail_outbox SET status = $1, attempts = 0, next_attempt_at = $2, updated_at = $2 WHERE id = $3 AND status = $4`,
+		models.MailPending, now, id, models.MailFailed)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+func scanOutboxMail(rows *sql.Rows) (models.OutboxMail, error) {
+	var o models.OutboxMail
+	var message []byte
+
+	err := rows.Scan(
+		&o.ID,
+		&message,
+		&o.Status,
+		&o.Attempts,
+		&o.NextAttemptAt,
+		&o.LastError,
+		&o.CreatedAt,
+		&o.UpdatedAt,
+	)
+	if err != nil {
+		return o, err
+	}
+
+	err = json.Unmarshal(message, &o.Mail)
+	return o, err
+}
+
+// returns the room whose calendar feed uses the given token
+func (m *postgresDBRepo) GetRoomByICalToken(ctx context.Context, token string) (models.Room, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var room models.Room
+
+	query := `
+		SELECT id, room_name, ical_token, created_at, updated_at
+		FROM rooms
+		WHERE ical_token = $1
+	`
+
+	row := m.DB.QueryRowContext(ctx, query, token)
+	err := row.Scan(
+		&room.ID,
+		&room.RoomName,
+		&room.ICalToken,
+		&room.CreatedAt,
+		&room.UpdatedAt,
+	)
+	if err != nil {
+		return room, err
+	}
+
+	return room, nil
+}
+
+// sets the token in a room's calendar feed url. A blank token turns the feed off
+func (m *postgresDBRepo) UpdateRoomICalToken(ctx context.Context, roomID int, token string) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `UPDATE rooms SET ical_token = NULLIF($1, ''), updated_at = $2 WHERE id = $3`,
+		token, time.Now(), roomID)
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
+// returns every external calendar source with the name of its room
+func (m *postgresDBRepo) AllCalendarSources(ctx context.Context) ([]models.CalendarSource, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var sources []models.CalendarSource
+
+	query := `
+		SELECT s.id, s.room_id, s.name, s.url, s.last_synced_at, s.last_error, s.created_at, s.updated_at,
+		r.id, r.room_name
+		FROM calendar_sources s
+		LEFT JOIN rooms r ON s.room_id = r.id
+		ORDER BY r.room_name, s.name
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return sources, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var s models.CalendarSource
+		var lastSynced sql.NullTime
+		err := rows.Scan(
+			&s.ID,
+			&s.RoomID,
+			&s.Name,
+			&s.URL,
+			&lastSynced,
+			&s.LastError,
+			&s.CreatedAt,
+			&s.UpdatedAt,
+			&s.Room.ID,
+			&s.Room.RoomName,
+		)
+		if err != nil {
+			return sources, err
+		}
+		s.LastSyncedAt = lastSynced.Time
+		sources = append(sources, s)
+	}
+
+	if err = rows.Err(); err != nil {
+		return sources, err
+	}
+
+	return sources, nil
+}
+
+// adds an external calendar for a room
+func (m *postgresDBRepo) InsertCalendarSource(ctx context.Context, s models.CalendarSource) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var newID int
+
+	stmt := `
+		INSERT INTO calendar_sources (room_id, name, url, last_error, created_at, updated_at)
+		VALUES ($1, $2, $3, '', $4, $5) RETURNING id
+	`
+
+	err := m.DB.QueryRowContext(ctx, stmt, s.RoomID, s.Name, s.URL, time.Now(), time.Now()).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// removes an external calendar, along with the blocks it created
+func (m *postgresDBRepo) DeleteCalendarSource(ctx context.Context, id int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	// the blocks go with it through the foreign key's on delete cascade
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM calendar_sources WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// records when an external calendar was last synced, and the error if it failed
+func (m *postgresDBRepo) UpdateCalendarSourceSynced(ctx context.Context, id int, at time.Time, lastError string) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `UPDATE calendar_sources SET last_synced_at = $1, last_error = $2, updated_at = $3 WHERE id = $4`,
+		at, lastError, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// returns the blocks that were created from an external calendar
+func (m *postgresDBRepo) ExternalBlocksForSource(ctx context.Context, sourceID int) ([]models.RoomRestriction, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var blocks []models.RoomRestriction
+
+	query := `
+		SELECT id, room_id, restriction_id, source_id, external_uid, start_date, end_date
+		FROM room_restrictions
+		WHERE source_id = $1
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, sourceID)
+	if err != nil {
+		return blocks, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r models.RoomRestriction
+		err := rows.Scan(
+			&r.ID,
+			&r.RoomID,
+			&r.RestrictionID,
+			&r.SourceID,
+			&r.ExternalUID,
+			&r.StartDate,
+			&r.EndDate,
+		)
+		if err != nil {
+			return blocks, err
+		}
+		blocks = append(blocks, r)
+	}
+
+	if err = rows.Err(); err != nil {
+		return blocks, err
+	}
+
+	return blocks, nil
+}
+
+// inserts a block for an event in an external calendar
+func (m *postgresDBRepo) InsertExternalBlock(ctx context.Context, r models.RoomRestriction) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `
+		INSERT INTO room_restrictions (start_date, end_date, room_id, restriction_id, source_id, external_uid, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`
+
+	_, err := m.DB.ExecContext(ctx, stmt, r.StartDate, r.EndDate, r.RoomID, models.RestrictionExternal, r.SourceID, r.ExternalUID, time.Now(), time.Now())
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// moves an external block to the new dates of its event
+func (m *postgresDBRepo) UpdateExternalBlock(ctx context.Context, id int, start, end time.Time) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `UPDATE room_restrictions SET start_date = $1, end_date = $2, updated_at = $3 WHERE id = $4 AND source_id IS NOT NULL`,
+		start, end, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// updates a room's own nightly and weekend rates
+func (m *postgresDBRepo) UpdateRoomRates(ctx context.Context, roomID, baseRate, weekendRate int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `UPDATE rooms SET base_rate = $1, weekend_rate = $2, updated_at = $3 WHERE id = $4`
+
+	_, err := m.DB.ExecContext(ctx, stmt, baseRate, weekendRate, time.Now(), roomID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// returns every seasonal rate, with the name of its room
+func (m *postgresDBRepo) AllSeasonalRates(ctx context.Context) ([]models.SeasonalRate, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var seasons []models.SeasonalRate
+
+	query := `
+		SELECT s.id, s.room_id, s.name, s.start_date, s.end_date, s.nightly_rate, s.weekend_rate, s.created_at, s.updated_at,
+		rm.room_name
+		FROM seasonal_rates s
+		LEFT JOIN rooms rm ON s.room_id = rm.id
+		ORDER BY rm.room_name, s.start_date
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return seasons, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var s models.SeasonalRate
+		err := rows.Scan(
+			&s.ID,
+			&s.RoomID,
+			&s.Name,
+			&s.StartDate,
+			&s.EndDate,
+			&s.NightlyRate,
+			&s.WeekendRate,
+			&s.CreatedAt,
+			&s.UpdatedAt,
+			&s.Room.RoomName,
+		)
+		if err != nil {
+			return seasons, err
+		}
+		s.Room.ID = s.RoomID
+		seasons = append(seasons, s)
+	}
+
+	if err = rows.Err(); err != nil {
+		return seasons, err
+	}
+
+	return seasons, nil
+}
+
+// returns the seasonal rates for a room that cover any of the nights from start up to end
+func (m *postgresDBRepo) SeasonalRatesForRoom(ctx context.Context, roomID int, start, end time.Time) ([]models.SeasonalRate, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var seasons []models.SeasonalRate
+
+	query := `
+		SELECT id, room_id, name, start_date, end_date, nightly_rate, weekend_rate, created_at, updated_at
+		FROM seasonal_rates
+		WHERE room_id = $1 AND $2 < end_date AND $3 > start_date
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, roomID, start, end)
+	if err != nil {
+		return seasons, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var s models.SeasonalRate
+		err := rows.Scan(
+			&s.ID,
+			&s.RoomID,
+			&s.Name,
+			&s.StartDate,
+			&s.EndDate,
+			&s.NightlyRate,
+			&s.WeekendRate,
+			&s.CreatedAt,
+			&s.UpdatedAt,
+		)
+		if err != nil {
+			return seasons, err
+		}
+		seasons = append(seasons, s)
+	}
+
+	if err = rows.Err(); err != nil {
+		return seasons, err
+	}
+
+	return seasons, nil
+}
+
+// inserts a seasonal rate and returns its id
+func (m *postgresDBRepo) InsertSeasonalRate(ctx context.Context, s models.SeasonalRate) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var newID int
+
+	stmt := `
+		INSERT INTO seasonal_rates (room_id, name, start_date, end_date, nightly_rate, weekend_rate, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8) returning id
+	`
+
+	err := m.DB.QueryRowContext(ctx, stmt,
+		s.RoomID,
+		s.Name,
+		s.StartDate,
+		s.EndDate,
+		s.NightlyRate,
+		s.WeekendRate,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// deletes a seasonal rate. Reservations already made keep the price they were booked at
+func (m *postgresDBRepo) DeleteSeasonalRate(ctx context.Context, id int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM seasonal_rates WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// returns every stay rule, with the name of its room
+func (m *postgresDBRepo) AllStayRules(ctx context.Context) ([]models.StayRule, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		SELECT s.id, s.room_id, s.start_date, s.end_date, s.min_nights, s.max_nights, s.no_arrival_days, s.no_departure_days,
+		s.created_at, s.updated_at, rm.room_name
+		FROM stay_rules s
+		LEFT JOIN rooms rm ON s.room_id = rm.id
+		ORDER BY rm.room_name, s.start_date
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rules []models.StayRule
+	for rows.Next() {
+		var r models.StayRule
+		err := rows.Scan(
+			&r.ID,
+			&r.RoomID,
+			&r.StartDate,
+			&r.EndDate,
+			&r.MinNights,
+			&r.MaxNights,
+			&r.NoArrivalDays,
+			&r.NoDepartureDays,
+			&r.CreatedAt,
+			&r.UpdatedAt,
+			&r.Room.RoomName,
+		)
+		if err != nil {
+			return rules, err
+		}
+		r.Room.ID = r.RoomID
+		rules = append(rules, r)
+	}
+
+	if err = rows.Err(); err != nil {
+		return rules, err
+	}
+
+	return rules, nil
+}
+
+// returns the stay rules, for every room, that cover either the arrival or the departure date of a stay
+func (m *postgresDBRepo) StayRulesForDates(ctx context.Context, start, end time.Time) ([]models.StayRule, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		SELECT id, room_id, start_date, end_date, min_nights, max_nights, no_arrival_days, no_departure_days,
+		created_at, updated_at
+		FROM stay_rules
+		WHERE ($1 >= start_date AND $1 < end_date) OR ($2 >= start_date AND $2 < end_date)
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, start, end)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rules []models.StayRule
+	for rows.Next() {
+		var r models.StayRule
+		err := rows.Scan(
+			&r.ID,
+			&r.RoomID,
+			&r.StartDate,
+			&r.EndDate,
+			&r.MinNights,
+			&r.MaxNights,
+			&r.NoArrivalDays,
+			&r.NoDepartureDays,
+			&r.CreatedAt,
+			&r.UpdatedAt,
+		)
+		if err != nil {
+			return rules, err
+		}
+		rules = append(rules, r)
+	}
+
+	if err = rows.Err(); err != nil {
+		return rules, err
+	}
+
+	return rules, nil
+}
+
+// inserts a stay rule and returns its id
+func (m *postgresDBRepo) InsertStayRule(ctx context.Context, r models.StayRule) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var newID int
+
+	stmt := `
+		INSERT INTO stay_rules (room_id, start_date, end_date, min_nights, max_nights, no_arrival_days, no_departure_days,
+			created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) returning id
+	`
+
+	err := m.DB.QueryRowContext(ctx, stmt,
+		r.RoomID,
+		r.StartDate,
+		r.EndDate,
+		r.MinNights,
+		r.MaxNights,
+		r.NoArrivalDays,
+		r.NoDepartureDays,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// deletes a stay rule
+func (m *postgresDBRepo) DeleteStayRule(ctx context.Context, id int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM stay_rules WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// the columns scanRoom reads, in order
+const roomColumns = `rooms.id, rooms.room_name, rooms.slug, rooms.description, rooms.capacity, rooms.amenities,
+	rooms.photos, rooms.active, COALESCE(rooms.ical_token, ''), rooms.base_rate, rooms.weekend_rate,
+	rooms.created_at, rooms.updated_at`
+
+// scans a row of roomColumns
+func scanRoom(row interface{ Scan(...interface{}) error }) (models.Room, error) {
+	var room models.Room
+	var amenities, photos string
+
+	err := row.Scan(
+		&room.ID,
+		&room.RoomName,
+		&room.Slug,
+		&room.Description,
+		&room.Capacity,
+		&amenities,
+		&photos,
+		&room.Active,
+		&room.ICalToken,
+		&room.BaseRate,
+		&room.WeekendRate,
+		&room.CreatedAt,
+		&room.UpdatedAt,
+	)
+	if err != nil {
+		return room, err
+	}
+
+	room.Amenities = splitLines(amenities)
+	room.Photos = splitLines(photos)
+	return room, nil
+}
+
+// amenities and photos are stored one per line
+func splitLines(s string) []string {
+	var out []string
+	for _, line := range strings.Split(s, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			out = append(out, line)
+		}
+	}
+	return out
+}
+
+// returns the room whose page is /rooms/{slug}
+func (m *postgresDBRepo) GetRoomBySlug(ctx context.Context, slug string) (models.Room, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := `SELECT ` + roomColumns + ` FROM rooms WHERE slug = $1`
+
+	return scanRoom(m.DB.QueryRowContext(ctx, query, slug))
+}
+
+// adds a room and returns its id
+func (m *postgresDBRepo) InsertRoom(ctx context.Context, room models.Room) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var newID int
+	stmt := `INSERT INTO rooms (room_name, slug, description, capacity, amenities, photos, active, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt,
+		room.RoomName,
+		room.Slug,
+		room.Description,
+		room.Capacity,
+		strings.Join(room.Amenities, "\n"),
+		strings.Join(room.Photos, "\n"),
+		room.Active,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+	if isUniqueViolation(err) {
+		return 0, repository.ErrSlugTaken
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// saves a room's details. Its rates and calendar feed are changed elsewhere
+func (m *postgresDBRepo) UpdateRoom(ctx context.Context, room models.Room) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `UPDATE rooms SET room_name = $1, slug = $2, description = $3, capacity = $4, amenities = $5, photos = $6,
+			active = $7, updated_at = $8
+			WHERE id = $9`
+
+	_, err := m.DB.ExecContext(ctx, stmt,
+		room.RoomName,
+		room.Slug,
+		room.Description,
+		room.Capacity,
+		strings.Join(room.Amenities, "\n"),
+		strings.Join(room.Photos, "\n"),
+		room.Active,
+		time.Now(),
+		room.ID,
+	)
+	if isUniqueViolation(err) {
+		return repository.ErrSlugTaken
+	}
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// deletes a room with its blocks, rates, rules and calendars. Deleting a room would delete its reservations too,
+// so a room that has ever been booked is left alone and ErrRoomInUse is returned
+func (m *postgresDBRepo) DeleteRoom(ctx context.Context, id int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx,
+		`DELETE FROM rooms WHERE id = $1 AND NOT EXISTS (SELECT 1 FROM reservations WHERE room_id = $1)`, id)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		var exists bool
+		err = m.DB.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM rooms WHERE id = $1)`, id).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return sql.ErrNoRows
+		}
+		return repository.ErrRoomInUse
+	}
+
+	return nil
+}
+
+// returns true if err is postgres refusing a row because of a unique index
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
@@ -1,19 +1,36 @@
 package dbrepo
 
 import (
+	"context"
+	"database/sql"
 	"errors"
 	"time"
 
+	"github.com/andkolbe/bookings/internal/helpers"
 	"github.com/andkolbe/bookings/internal/models"
+	"github.com/andkolbe/bookings/internal/repository"
+	"github.com/andkolbe/bookings/internal/totp"
 )
 
 
-func (m *testDBRepo) AllUsers() bool {
-	return true
+// returns the users GetUserByID knows
+func (m *testDBRepo) AllUsers(ctx context.Context) ([]models.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var users []models.User
+	for id := 1; id <= 4; id++ {
+		u, _ := m.GetUserByID(ctx, id)
+		users = append(users, u)
+	}
+	return users, nil
 }
 
 // inserts a reservation into the db
-func (m *testDBRepo) InsertReservation(res models.Reservation) (int, error) {
+func (m *testDBRepo) InsertReservation(ctx context.Context, res models.Reservation) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
 	// if the room id is 2, then fail; otherwise, pass
 	if res.RoomID == 2 {
 		return 0, errors.New("some error")
@@ -22,7 +39,10 @@ func (m *testDBRepo) InsertReservation(res models.Reservation) (int, error) {
 }
 
 // inserts a room restriction into the db
-func (m *testDBRepo) InsertRoomRestriction(r models.RoomRestriction) error {
+func (m *testDBRepo) InsertRoomRestriction(ctx context.Context, r models.RoomRestriction) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	// when trying to insert a room restriction for room id 1000, fail
 	if r.RoomID == 1000 {
 		return errors.New("some error")
@@ -30,88 +50,748 @@ func (m *testDBRepo) InsertRoomRestriction(r models.RoomRestriction) error {
 	return nil
 }
 
+// inserts a reservation and its room restriction
+func (m *testDBRepo) CreateReservation(ctx context.Context, res models.Reservation) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	// room id 2 fails like a broken db
+	if res.RoomID == 2 {
+		return 0, errors.New("some error")
+	}
+	// acts like someone else just booked any stay starting on new year's eve 2050
+	if res.StartDate.Equal(time.Date(2050, 12, 31, 0, 0, 0, 0, time.UTC)) {
+		return 0, &repository.ConflictError{RoomID: res.RoomID, StartDate: res.StartDate, EndDate: res.EndDate}
+	}
+	return 1, nil
+}
+
+// acts like the room is taken from new year's eve 2050, like CreateReservation
+func (m *testDBRepo) ChangeReservationDates(ctx context.Context, id int, start, end time.Time, price models.Quote) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if start.Equal(time.Date(2050, 12, 31, 0, 0, 0, 0, time.UTC)) {
+		return &repository.ConflictError{RoomID: 1, StartDate: start, EndDate: end}
+	}
+	return nil
+}
+
 // returns true if availability exists for roomID
-func (m *testDBRepo) SearchAvailabilityByDatesByRoomID(start, end time.Time, roomID int) (bool, error) {
+func (m *testDBRepo) SearchAvailabilityByDatesByRoomID(ctx context.Context, start, end time.Time, roomID int) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
 	return false, nil
 }
 
-// returns a slice of available rooms, if any, for given date range
+// returns a slice of available rooms, if any, for given date range that sleep at least guests
 // we don't need the room id as a param because we are searching through all rooms
-func (m *testDBRepo) SearchAvailabilityForAllRooms(start, end time.Time) ([]models.Room, error) {
+func (m *testDBRepo) SearchAvailabilityForAllRooms(ctx context.Context, start, end time.Time, guests int) ([]models.Room, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	var rooms []models.Room
+	// a stay starting on 2060-01-01 fails like a broken db
+	if start.Equal(time.Date(2060, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		return rooms, errors.New("some error")
+	}
+	// everything is booked from 2050 on, before that room 1 is free for up to 2 guests
+	if !start.Before(time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC)) || guests > 2 {
+		return rooms, nil
+	}
+	room, err := m.GetRoomByID(ctx, 1)
+	if err != nil {
+		return rooms, err
+	}
+	rooms = append(rooms, room)
 	return rooms, nil
 }
 
 // gets a room by id
-func (m *testDBRepo) GetRoomByID(id int) (models.Room, error) {
+func (m *testDBRepo) GetRoomByID(ctx context.Context, id int) (models.Room, error) {
+	if err := ctx.Err(); err != nil {
+		return models.Room{}, err
+	}
 	var room models.Room
 	if id > 2 { // if the room id is greater than 2, throw an error
 		return room, errors.New("Some error")
 	}
+	room.ID = id
+	room.Slug = map[int]string{1: "generals-quarters", 2: "majors-suite"}[id]
+	room.Capacity = 2
+	room.Active = true
+	room.BaseRate = 10000
+	room.WeekendRate = 12000
 	return room, nil
 }
 
+// knows the two seeded rooms, and a closed one
+func (m *testDBRepo) GetRoomBySlug(ctx context.Context, slug string) (models.Room, error) {
+	if err := ctx.Err(); err != nil {
+		return models.Room{}, err
+	}
+	switch slug {
+	case "generals-quarters":
+		return models.Room{ID: 1, RoomName: "General's Quarters", Slug: slug, Capacity: 2, Active: true,
+			Amenities: []string{"Sea view"}, Photos: []string{"/static/images/generals-quarters.png"}}, nil
+	case "majors-suite":
+		return models.Room{ID: 2, RoomName: "Major's Suite", Slug: slug, Capacity: 4, Active: true}, nil
+	case "closed-room":
+		return models.Room{ID: 3, RoomName: "Closed Room", Slug: slug, Capacity: 2}, nil
+	}
+	return models.Room{}, sql.ErrNoRows
+}
+
+// the slug "taken" is already used
+func (m *testDBRepo) InsertRoom(ctx context.Context, room models.Room) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	if room.Slug == "taken" {
+		return 0, repository.ErrSlugTaken
+	}
+	return 3, nil
+}
+
+// the slug "taken" is already used, and room ids over 2 fail like GetRoomByID
+func (m *testDBRepo) UpdateRoom(ctx context.Context, room models.Room) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if room.Slug == "taken" {
+		return repository.ErrSlugTaken
+	}
+	if room.ID > 2 {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+// room 1 has reservations, room 2 can go, and there are no others
+func (m *testDBRepo) DeleteRoom(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	switch id {
+	case 1:
+		return repository.ErrRoomInUse
+	case 2:
+		return nil
+	}
+	return sql.ErrNoRows
+}
+
+// fails for room ids over 2, like GetRoomByID
+func (m *testDBRepo) UpdateRoomRates(ctx context.Context, roomID, baseRate, weekendRate int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if roomID > 2 {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+func (m *testDBRepo) AllSeasonalRates(ctx context.Context) ([]models.SeasonalRate, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var seasons []models.SeasonalRate
+	return seasons, nil
+}
+
+// every room has a season for the night of January 2nd 2050, the second night of the made up reservations
+func (m *testDBRepo) SeasonalRatesForRoom(ctx context.Context, roomID int, start, end time.Time) ([]models.SeasonalRate, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	seasons := []models.SeasonalRate{
+		{
+			ID:          1,
+			RoomID:      roomID,
+			Name:        "New Year",
+			StartDate:   time.Date(2050, 1, 2, 0, 0, 0, 0, time.UTC),
+			EndDate:     time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC),
+			NightlyRate: 20000,
+		},
+	}
+	return seasons, nil
+}
+
+// fails for room ids over 2, like GetRoomByID
+func (m *testDBRepo) InsertSeasonalRate(ctx context.Context, s models.SeasonalRate) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	if s.RoomID > 2 {
+		return 0, errors.New("some error")
+	}
+	return 1, nil
+}
+
+func (m *testDBRepo) DeleteSeasonalRate(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (m *testDBRepo) AllStayRules(ctx context.Context) ([]models.StayRule, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var rules []models.StayRule
+	return rules, nil
+}
+
+// room 1 has a 3 night minimum and no sunday check-ins in June 2050
+func (m *testDBRepo) StayRulesForDates(ctx context.Context, start, end time.Time) ([]models.StayRule, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	rule := models.StayRule{
+		ID:            1,
+		RoomID:        1,
+		StartDate:     time.Date(2050, 6, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:       time.Date(2050, 7, 1, 0, 0, 0, 0, time.UTC),
+		MinNights:     3,
+		NoArrivalDays: models.WeekdaysOf(time.Sunday),
+	}
+	var rules []models.StayRule
+	for _, d := range []time.Time{start, end} {
+		if !d.Before(rule.StartDate) && d.Before(rule.EndDate) {
+			rules = append(rules, rule)
+			break
+		}
+	}
+	return rules, nil
+}
+
+// fails for room ids over 2, like GetRoomByID
+func (m *testDBRepo) InsertStayRule(ctx context.Context, r models.StayRule) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	if r.RoomID > 2 {
+		return 0, errors.New("some error")
+	}
+	return 1, nil
+}
+
+func (m *testDBRepo) DeleteStayRule(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return nil
+}
+
 // gets a room by id
-func (m *testDBRepo) GetUserByID(id int) (models.User, error) {
-	var u models.User
-	
-	return u, nil
+// knows user 1, the only owner, user 2, who changed their password in 2050 and has two-factor login turned on,
+// user 3, who has been deactivated, and user 4, a manager who hasn't chosen a password from their invite yet
+func (m *testDBRepo) GetUserByID(ctx context.Context, id int) (models.User, error) {
+	if err := ctx.Err(); err != nil {
+		return models.User{}, err
+	}
+	switch id {
+	case 1:
+		return models.User{ID: 1, FirstName: "Admin", Email: "email@email.com", Password: "hash-1", AccessLevel: models.AccessOwner, Active: true}, nil
+	case 2:
+		changed := time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC)
+		return models.User{ID: 2, FirstName: "Desk", Email: "desk@email.com", Password: "hash-2", AccessLevel: models.AccessFrontDesk, Active: true, PasswordChangedAt: changed, TOTPSecret: "JBSWY3DPEHPK3PXP"}, nil
+	case 3:
+		return models.User{ID: 3, FirstName: "Gone", Email: "gone@email.com", Password: "hash-3", AccessLevel: models.AccessFrontDesk}, nil
+	case 4:
+		return models.User{ID: 4, FirstName: "New", Email: "new@email.com", AccessLevel: models.AccessManager, Active: true}, nil
+	}
+	return models.User{}, sql.ErrNoRows
+}
+
+func (m *testDBRepo) GetUserByEmail(ctx context.Context, email string) (models.User, error) {
+	if err := ctx.Err(); err != nil {
+		return models.User{}, err
+	}
+	switch email {
+	case "email@email.com":
+		return m.GetUserByID(ctx, 1)
+	case "desk@email.com":
+		return m.GetUserByID(ctx, 2)
+	case "gone@email.com":
+		return m.GetUserByID(ctx, 3)
+	case "new@email.com":
+		return m.GetUserByID(ctx, 4)
+	}
+	return models.User{}, sql.ErrNoRows
+}
+
+// the email "taken@email.com" belongs to somebody else
+func (m *testDBRepo) InsertUser(ctx context.Context, u models.User) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	if u.Email == "taken@email.com" {
+		return 0, repository.ErrEmailTaken
+	}
+	return 5, nil
+}
+
+// user 1 is the only owner, so they can't be demoted or deactivated
+func (m *testDBRepo) UpdateUser(ctx context.Context, u models.User) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if u.Email == "taken@email.com" {
+		return repository.ErrEmailTaken
+	}
+	if u.ID == 1 && (u.AccessLevel < models.AccessOwner || !u.Active) {
+		return repository.ErrLastOwner
+	}
+	if u.ID > 4 {
+		return sql.ErrNoRows
+	}
+	return nil
 }
 
-func (m *testDBRepo) UpdateUser(u models.User) error {
+func (m *testDBRepo) UpdateUserPassword(ctx context.Context, id int, hash string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if id > 4 {
+		return sql.ErrNoRows
+	}
 	return nil
 }
 
-func (m *testDBRepo) Authenticate(email, testPassword string) (int, string, error) {  
+func (m *testDBRepo) Authenticate(ctx context.Context, email, testPassword string) (int, string, error) {  
+	if err := ctx.Err(); err != nil {
+		return 0, "", err
+	}
 	if email == "email@email.com" {
 		return 1, "", nil
 	}
+	if email == "desk@email.com" {
+		return 2, "", nil
+	}
 	return 0, "", errors.New("some error")
 }
 
-func (m *testDBRepo) AllReservations() ([]models.Reservation, error) {
+func (m *testDBRepo) EnableTwoFactor(ctx context.Context, userID int, secret string, step int64, recoveryHashes []string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if userID > 4 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (m *testDBRepo) DisableTwoFactor(ctx context.Context, userID int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if userID > 4 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// never remembers a step, so every code works once
+func (m *testDBRepo) UseTOTPStep(ctx context.Context, userID int, step int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (m *testDBRepo) ReplaceRecoveryCodes(ctx context.Context, userID int, hashes []string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// user 2 has the recovery code aaaaa-bbbbb
+func (m *testDBRepo) UseRecoveryCode(ctx context.Context, userID int, hash string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if userID == 2 && hash == totp.HashRecoveryCode("aaaaa-bbbbb") {
+		return nil
+	}
+	return sql.ErrNoRows
+}
+
+func (m *testDBRepo) CountRecoveryCodes(ctx context.Context, userID int) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	if userID == 2 {
+		return 8, nil
+	}
+	return 0, nil
+}
+
+// the account locked@email.com is locked out until 2050
+func (m *testDBRepo) GetLoginThrottle(ctx context.Context, key string) (models.LoginThrottle, error) {
+	if err := ctx.Err(); err != nil {
+		return models.LoginThrottle{}, err
+	}
+	if key == "account:locked@email.com" {
+		return models.LoginThrottle{Key: key, LastFailureAt: time.Now(), LockedUntil: time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC)}, nil
+	}
+	return models.LoginThrottle{Key: key}, nil
+}
+
+// every failure is the first in a row
+func (m *testDBRepo) RecordLoginFailure(ctx context.Context, key string, window time.Duration) (models.LoginThrottle, error) {
+	if err := ctx.Err(); err != nil {
+		return models.LoginThrottle{}, err
+	}
+	return models.LoginThrottle{Key: key, Failures: 1, LastFailureAt: time.Now()}, nil
+}
+
+func (m *testDBRepo) LockLogin(ctx context.Context, key string, until time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (m *testDBRepo) ClearLoginThrottle(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (m *testDBRepo) LockedLogins(ctx context.Context) ([]models.LoginThrottle, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	t, err := m.GetLoginThrottle(ctx, "account:locked@email.com")
+	return []models.LoginThrottle{t}, err
+}
+
+// nothing has been set
+func (m *testDBRepo) GetSetting(ctx context.Context, name string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	return "", nil
+}
+
+func (m *testDBRepo) PutSetting(ctx context.Context, name, value string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (m *testDBRepo) AllReservations(ctx context.Context, status string) ([]models.Reservation, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	var reservations []models.Reservation
 	return reservations, nil
 }
 
-func (m *testDBRepo) AllNewReservations() ([]models.Reservation, error) {
+func (m *testDBRepo) AllNewReservations(ctx context.Context) ([]models.Reservation, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	var reservations []models.Reservation
 	return reservations, nil
 }
 
-func (m *testDBRepo) GetReservationByID(id int) (models.Reservation, error) {
+func (m *testDBRepo) GetReservationByID(ctx context.Context, id int) (models.Reservation, error) {
+	if err := ctx.Err(); err != nil {
+		return models.Reservation{}, err
+	}
 	var res models.Reservation
+	// pretend the first 100 reservations exist
+	if id > 100 {
+		return res, sql.ErrNoRows
+	}
+	res.ID = id
+	res.RoomID = 1
+	res.Email = "me@here.com"
+	// reservation 100 is a stay that has already started, 99 was cancelled and 98 was moved away and back again.
+	// The rest are confirmed stays in 2050
+	res.StartDate = time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC)
+	res.EndDate = time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC)
+	res.Status = models.ReservationConfirmed
+	switch id {
+	case 100:
+		res.StartDate = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+		res.EndDate = time.Date(2020, 1, 3, 0, 0, 0, 0, time.UTC)
+		res.Status = models.ReservationCheckedIn
+	case 99:
+		res.Status = models.ReservationCancelled
+	case 98:
+		res.DateChanges = 2
+	}
 	return res, nil
 }
 
-func (m *testDBRepo) UpdateReservation(u models.Reservation) error {
+func (m *testDBRepo) UpdateReservation(ctx context.Context, u models.Reservation) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	return nil
 }
 
-func (m *testDBRepo) DeleteReservation(id int) error {
+// checks the move the same way the database repo does, against the reservations GetReservationByID makes up
+func (m *testDBRepo) UpdateReservationStatus(ctx context.Context, id int, status string, userID int) error {
+	res, err := m.GetReservationByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	if !models.CanChangeReservationStatus(res.Status, status) {
+		return &repository.StatusError{ReservationID: id, From: res.Status, To: status}
+	}
 	return nil
 }
 
-func (m *testDBRepo) UpdateProcessedForReservation(id, processed int) error {
-	return nil
+func (m *testDBRepo) ReservationStatusHistory(ctx context.Context, id int) ([]models.ReservationStatusChange, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	changes := []models.ReservationStatusChange{
+		{ReservationID: id, ToStatus: models.ReservationPending},
+		{ReservationID: id, FromStatus: models.ReservationPending, ToStatus: models.ReservationConfirmed, UserID: 1, User: models.User{ID: 1, FirstName: "Admin"}},
+	}
+	return changes, nil
 }
 
-func (m *testDBRepo) AllRooms() ([]models.Room, error) {
-	var rooms []models.Room
+func (m *testDBRepo) AllRooms(ctx context.Context) ([]models.Room, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	rooms := []models.Room{
+		{ID: 1, RoomName: "General's Quarters", Slug: "generals-quarters", Capacity: 2, Active: true},
+		{ID: 2, RoomName: "Major's Suite", Slug: "majors-suite", Capacity: 4, Active: true},
+	}
 	return rooms, nil
 }
 
-func (m *testDBRepo) GetRestrictionsForRoomByDate(roomID int, start, end time.Time) ([]models.RoomRestriction, error) {
+// room 1 is blocked for a deep clean from July 10 to 13, 2050
+func (m *testDBRepo) GetRestrictionsForRoomByDate(ctx context.Context, roomID int, start, end time.Time) ([]models.RoomRestriction, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	var restrictions []models.RoomRestriction
+	block := models.RoomRestriction{
+		ID:            7,
+		RoomID:        1,
+		RestrictionID: models.RestrictionOwnerBlock,
+		StartDate:     time.Date(2050, 7, 10, 0, 0, 0, 0, time.UTC),
+		EndDate:       time.Date(2050, 7, 13, 0, 0, 0, 0, time.UTC),
+		Reason:        "Deep clean",
+	}
+	if roomID == block.RoomID && start.Before(block.EndDate) && !end.Before(block.StartDate) {
+		restrictions = append(restrictions, block)
+	}
 	return restrictions, nil
 }
 
-// inserts a room restriction
-func (m *testDBRepo) InsertBlockForRoom(id int, startDate time.Time) error {
+// blocking room 3 fails, and room 1 is already blocked from July 10 to 13, 2050
+func (m *testDBRepo) InsertBlockForRoom(ctx context.Context, roomID int, start, end time.Time, reason string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if roomID > 2 {
+		return errors.New("some error")
+	}
+	if roomID == 1 && start.Before(time.Date(2050, 7, 13, 0, 0, 0, 0, time.UTC)) && end.After(time.Date(2050, 7, 10, 0, 0, 0, 0, time.UTC)) {
+		return &repository.ConflictError{RoomID: roomID, StartDate: start, EndDate: end}
+	}
+	return nil
+}
+
+// only knows block 7
+func (m *testDBRepo) DeleteBlockByID(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if id != 7 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+func (m *testDBRepo) InsertAPIToken(ctx context.Context, t models.APIToken) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	return 1, nil
+}
+
+func (m *testDBRepo) APITokensForUser(ctx context.Context, userID int) ([]models.APIToken, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var tokens []models.APIToken
+	return tokens, nil
+}
+
+// knows two tokens: "read-token" for a front desk user and "write-token" for an owner
+func (m *testDBRepo) GetAPITokenByHash(ctx context.Context, hash string) (models.APIToken, error) {
+	if err := ctx.Err(); err != nil {
+		return models.APIToken{}, err
+	}
+	switch hash {
+	case helpers.HashAPIToken("read-token"):
+		return models.APIToken{ID: 1, UserID: 1, Scope: models.APIScopeRead, User: models.User{ID: 1, AccessLevel: models.AccessFrontDesk}}, nil
+	case helpers.HashAPIToken("write-token"):
+		return models.APIToken{ID: 2, UserID: 2, Scope: models.APIScopeWrite, User: models.User{ID: 2, AccessLevel: models.AccessOwner}}, nil
+	}
+	return models.APIToken{}, sql.ErrNoRows
+}
+
+func (m *testDBRepo) UpdateAPITokenLastUsed(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (m *testDBRepo) DeleteAPIToken(ctx context.Context, id, userID int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// messages sent to fail@here.com fail to queue
+func (m *testDBRepo) InsertMail(ctx context.Context, msg models.MailData) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	if msg.To == "fail@here.com" {
+		return 0, errors.New("some error")
+	}
+	return 1, nil
+}
+
+func (m *testDBRepo) ClaimMail(ctx context.Context, limit int, lease time.Duration) ([]models.OutboxMail, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var mail []models.OutboxMail
+	return mail, nil
+}
+
+func (m *testDBRepo) MarkMailSent(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (m *testDBRepo) RetryMail(ctx context.Context, id int, at time.Time, lastError string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (m *testDBRepo) MarkMailFailed(ctx context.Context, id int, lastError string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (m *testDBRepo) FailedMail(ctx context.Context) ([]models.OutboxMail, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var mail []models.OutboxMail
+	return mail, nil
+}
+
+// only message 1 is waiting to be resent
+func (m *testDBRepo) ResendMail(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if id != 1 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// knows one feed token, "room-1-token"
+func (m *testDBRepo) GetRoomByICalToken(ctx context.Context, token string) (models.Room, error) {
+	if err := ctx.Err(); err != nil {
+		return models.Room{}, err
+	}
+	if token != "room-1-token" {
+		return models.Room{}, sql.ErrNoRows
+	}
+	return models.Room{ID: 1, RoomName: "General's Quarters", ICalToken: token}, nil
+}
+
+func (m *testDBRepo) UpdateRoomICalToken(ctx context.Context, roomID int, token string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if roomID > 2 {
+		return errors.New("some error")
+	}
 	return nil
 }
 
-// deletes a room restriction
-func (m *testDBRepo) DeleteBlockByID(id int) error {
+func (m *testDBRepo) AllCalendarSources(ctx context.Context) ([]models.CalendarSource, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var sources []models.CalendarSource
+	return sources, nil
+}
+
+// fails for room ids over 2, like GetRoomByID
+func (m *testDBRepo) InsertCalendarSource(ctx context.Context, s models.CalendarSource) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	if s.RoomID > 2 {
+		return 0, errors.New("some error")
+	}
+	return 1, nil
+}
+
+func (m *testDBRepo) DeleteCalendarSource(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	return nil
-}
\ No newline at end of file
+}
+
+func (m *testDBRepo) UpdateCalendarSourceSynced(ctx context.Context, id int, at time.Time, lastError string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (m *testDBRepo) ExternalBlocksForSource(ctx context.Context, sourceID int) ([]models.RoomRestriction, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var blocks []models.RoomRestriction
+	return blocks, nil
+}
+
+func (m *testDBRepo) InsertExternalBlock(ctx context.Context, r models.RoomRestriction) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (m *testDBRepo) UpdateExternalBlock(ctx context.Context, id int, start, end time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return nil
+}
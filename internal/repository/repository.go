@@ -1,32 +1,132 @@
 package repository
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"time"
 
 	"github.com/andkolbe/bookings/internal/models")
 
+// returned when a room is no longer available for the requested dates, usually because someone else booked it first
+type ConflictError struct {
+	RoomID    int
+	StartDate time.Time
+	EndDate   time.Time
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("room %d is not available from %s to %s", e.RoomID, e.StartDate.Format("2006-01-02"), e.EndDate.Format("2006-01-02"))
+}
+
+// returned when a reservation can't move from its status to the one asked for
+type StatusError struct {
+	ReservationID int
+	From          string
+	To            string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("reservation %d can't go from %s to %s", e.ReservationID, e.From, e.To)
+}
+
+// returned when a room is saved with a slug another room already uses
+var ErrSlugTaken = errors.New("slug is already used by another room")
+
+// returned when deleting a room that has reservations. Deactivate it instead
+var ErrRoomInUse = errors.New("room has reservations")
+
+// returned when a user is saved with an email another user already has
+var ErrEmailTaken = errors.New("email is already used by another user")
+
+// returned when a change would leave nobody who can manage users
+var ErrLastOwner = errors.New("there has to be at least one active owner")
+
+// returned when a two-factor code, or one from before it, has already been used to log in
+var ErrCodeUsed = errors.New("two-factor code has already been used")
 
 type DatabaseRepo interface {
-	AllUsers() bool
+	AllUsers(ctx context.Context) ([]models.User, error)
+
+	InsertReservation(ctx context.Context, res models.Reservation) (int, error) 
+	InsertRoomRestriction(ctx context.Context, r models.RoomRestriction) error
+	CreateReservation(ctx context.Context, res models.Reservation) (int, error)
+	ChangeReservationDates(ctx context.Context, id int, start, end time.Time, price models.Quote) error
+	SearchAvailabilityByDatesByRoomID(ctx context.Context, start, end time.Time, roomID int) (bool, error)
+	SearchAvailabilityForAllRooms(ctx context.Context, start, end time.Time, guests int) ([]models.Room, error)
+	GetRoomByID(ctx context.Context, id int) (models.Room, error)
+	UpdateRoomRates(ctx context.Context, roomID, baseRate, weekendRate int) error
+	GetRoomBySlug(ctx context.Context, slug string) (models.Room, error)
+	InsertRoom(ctx context.Context, room models.Room) (int, error)
+	UpdateRoom(ctx context.Context, room models.Room) error
+	DeleteRoom(ctx context.Context, id int) error
 
-	InsertReservation(res models.Reservation) (int, error) 
-	InsertRoomRestriction(r models.RoomRestriction) error
-	SearchAvailabilityByDatesByRoomID(start, end time.Time, roomID int) (bool, error)
-	SearchAvailabilityForAllRooms(start, end time.Time) ([]models.Room, error)
-	GetRoomByID(id int) (models.Room, error)
+	AllSeasonalRates(ctx context.Context) ([]models.SeasonalRate, error)
+	SeasonalRatesForRoom(ctx context.Context, roomID int, start, end time.Time) ([]models.SeasonalRate, error)
+	InsertSeasonalRate(ctx context.Context, s models.SeasonalRate) (int, error)
+	DeleteSeasonalRate(ctx context.Context, id int) error
+
+	AllStayRules(ctx context.Context) ([]models.StayRule, error)
+	StayRulesForDates(ctx context.Context, start, end time.Time) ([]models.StayRule, error)
+	InsertStayRule(ctx context.Context, r models.StayRule) (int, error)
+	DeleteStayRule(ctx context.Context, id int) error
 	
-	GetUserByID(id int) (models.User, error)
-	UpdateUser(u models.User) error
-	Authenticate(email, testPassword string) (int, string, error)
-
-	AllReservations() ([]models.Reservation, error)
-	AllNewReservations() ([]models.Reservation, error)
-	GetReservationByID(id int) (models.Reservation, error)
-	UpdateReservation(u models.Reservation) error
-	DeleteReservation(id int) error
-	UpdateProcessedForReservation(id, processed int) error
-	AllRooms() ([]models.Room, error)
-	GetRestrictionsForRoomByDate(roomID int, start, end time.Time) ([]models.RoomRestriction, error)
-	InsertBlockForRoom(id int, startDate time.Time) error
-	DeleteBlockByID(id int) error
+	GetUserByID(ctx context.Context, id int) (models.User, error)
+	GetUserByEmail(ctx context.Context, email string) (models.User, error)
+	InsertUser(ctx context.Context, u models.User) (int, error)
+	UpdateUser(ctx context.Context, u models.User) error
+	UpdateUserPassword(ctx context.Context, id int, hash string) error
+	Authenticate(ctx context.Context, email, testPassword string) (int, string, error)
+
+	EnableTwoFactor(ctx context.Context, userID int, secret string, step int64, recoveryHashes []string) error
+	DisableTwoFactor(ctx context.Context, userID int) error
+	UseTOTPStep(ctx context.Context, userID int, step int64) error
+	ReplaceRecoveryCodes(ctx context.Context, userID int, hashes []string) error
+	UseRecoveryCode(ctx context.Context, userID int, hash string) error
+	CountRecoveryCodes(ctx context.Context, userID int) (int, error)
+
+	GetLoginThrottle(ctx context.Context, key string) (models.LoginThrottle, error)
+	RecordLoginFailure(ctx context.Context, key string, window time.Duration) (models.LoginThrottle, error)
+	LockLogin(ctx context.Context, key string, until time.Time) error
+	ClearLoginThrottle(ctx context.Context, key string) error
+	LockedLogins(ctx context.Context) ([]models.LoginThrottle, error)
+
+	GetSetting(ctx context.Context, name string) (string, error)
+	PutSetting(ctx context.Context, name, value string) error
+
+	AllReservations(ctx context.Context, status string) ([]models.Reservation, error)
+	AllNewReservations(ctx context.Context) ([]models.Reservation, error)
+	GetReservationByID(ctx context.Context, id int) (models.Reservation, error)
+	UpdateReservation(ctx context.Context, u models.Reservation) error
+	UpdateReservationStatus(ctx context.Context, id int, status string, userID int) error
+	ReservationStatusHistory(ctx context.Context, id int) ([]models.ReservationStatusChange, error)
+	AllRooms(ctx context.Context) ([]models.Room, error)
+	GetRestrictionsForRoomByDate(ctx context.Context, roomID int, start, end time.Time) ([]models.RoomRestriction, error)
+	InsertBlockForRoom(ctx context.Context, roomID int, start, end time.Time, reason string) error
+	DeleteBlockByID(ctx context.Context, id int) error
+
+	InsertAPIToken(ctx context.Context, t models.APIToken) (int, error)
+	APITokensForUser(ctx context.Context, userID int) ([]models.APIToken, error)
+	GetAPITokenByHash(ctx context.Context, hash string) (models.APIToken, error)
+	UpdateAPITokenLastUsed(ctx context.Context, id int) error
+	DeleteAPIToken(ctx context.Context, id, userID int) error
+
+	GetRoomByICalToken(ctx context.Context, token string) (models.Room, error)
+	UpdateRoomICalToken(ctx context.Context, roomID int, token string) error
+
+	AllCalendarSources(ctx context.Context) ([]models.CalendarSource, error)
+	InsertCalendarSource(ctx context.Context, s models.CalendarSource) (int, error)
+	DeleteCalendarSource(ctx context.Context, id int) error
+	UpdateCalendarSourceSynced(ctx context.Context, id int, at time.Time, lastError string) error
+	ExternalBlocksForSource(ctx context.Context, sourceID int) ([]models.RoomRestriction, error)
+	InsertExternalBlock(ctx context.Context, r models.RoomRestriction) error
+	UpdateExternalBlock(ctx context.Context, id int, start, end time.Time) error
+
+	InsertMail(ctx context.Context, m models.MailData) (int, error)
+	ClaimMail(ctx context.Context, limit int, lease time.Duration) ([]models.OutboxMail, error)
+	MarkMailSent(ctx context.Context, id int) error
+	RetryMail(ctx context.Context, id int, at time.Time, lastError string) error
+	MarkMailFailed(ctx context.Context, id int, lastError string) error
+	FailedMail(ctx context.Context) ([]models.OutboxMail, error)
+	ResendMail(ctx context.Context, id int) error
 }
\ No newline at end of file
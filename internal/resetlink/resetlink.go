@@ -0,0 +1,35 @@
+package resetlink
+
+import (
+	"time"
+
+	"github.com/andkolbe/bookings/internal/signedlink"
+)
+
+var (
+	// the token wasn't made with our secret, has been changed, or the password has been set since it was made
+	ErrInvalid = signedlink.ErrInvalid
+	// the token was ours, but it has expired
+	ErrExpired = signedlink.ErrExpired
+)
+
+const purpose = "reset"
+
+// returns a token that lets a user set a new password until expires. It is signed with their password hash as
+// well as the secret, so it stops working once the password has been changed and can only be used once
+func Sign(secret []byte, userID int, passwordHash string, expires time.Time) string {
+	return signedlink.Sign(secret, purpose, userID, "", passwordHash, expires)
+}
+
+// returns the user a token is for, without checking it. Look up their password hash and pass it to Verify
+// before trusting the token
+func UserID(token string) (int, error) {
+	id, _, err := signedlink.Parse(purpose, token)
+	return id, err
+}
+
+// checks a token made by Sign for a user whose password hash is passwordHash
+func Verify(secret []byte, token, passwordHash string, now time.Time) error {
+	_, _, err := signedlink.Verify(secret, purpose, token, passwordHash, now)
+	return err
+}
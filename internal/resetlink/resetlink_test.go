@@ -0,0 +1,52 @@
+package resetlink
+
+import (
+	"testing"
+	"time"
+
+	"github.com/andkolbe/bookings/internal/guestlink"
+)
+
+var secret = []byte("0123456789abcdef0123456789abcdef")
+
+func TestVerify(t *testing.T) {
+	now := time.Date(2050, 1, 1, 12, 0, 0, 0, time.UTC)
+	token := Sign(secret, 7, "old-hash", now.Add(time.Hour))
+
+	id, err := UserID(token)
+	if err != nil || id != 7 {
+		t.Fatalf("expected user 7, got %d, %v", id, err)
+	}
+
+	err = Verify(secret, token, "old-hash", now)
+	if err != nil {
+		t.Fatalf("expected the token to work, got %v", err)
+	}
+
+	_, err = UserID("nonsense")
+	if err != ErrInvalid {
+		t.Errorf("expected nonsense to be invalid, got %v", err)
+	}
+
+	var tests = []struct {
+		name  string
+		token string
+		hash  string
+		now   time.Time
+		want  error
+	}{
+		{"expired", token, "old-hash", now.Add(2 * time.Hour), ErrExpired},
+		{"password changed", token, "new-hash", now, ErrInvalid},
+		{"another secret", Sign([]byte("a different secret of enough length"), 7, "old-hash", now.Add(time.Hour)), "old-hash", now, ErrInvalid},
+		{"edited", token + "x", "old-hash", now, ErrInvalid},
+		{"guest link", guestlink.Sign(secret, 7, "", now.Add(time.Hour)), "old-hash", now, ErrInvalid},
+		{"blank", "", "old-hash", now, ErrInvalid},
+	}
+
+	for _, e := range tests {
+		err := Verify(secret, e.token, e.hash, e.now)
+		if err != e.want {
+			t.Errorf("%s: expected %v, got %v", e.name, e.want, err)
+		}
+	}
+}
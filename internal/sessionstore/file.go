@@ -0,0 +1,131 @@
+package sessionstore
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// a session file that is too short to have been written by Commit
+var errCorrupt = errors.New("sessionstore: corrupt session file")
+
+// keeps each session in a file of its own in a directory
+type File struct {
+	dir         string
+	mu          sync.Mutex
+	stopCleanup chan struct{}
+}
+
+// returns a store that keeps sessions in dir, making it if it doesn't exist. Expired sessions are deleted every
+// cleanupInterval, until StopCleanup is called
+func NewFile(dir string, cleanupInterval time.Duration) (*File, error) {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &File{dir: dir, stopCleanup: make(chan struct{})}
+	go cleanup(f.deleteExpired, cleanupInterval, f.stopCleanup)
+	return f, nil
+}
+
+// returns the data for a session that hasn't expired
+func (f *File) Find(token string) ([]byte, bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	expiry, b, err := f.read(f.path(token))
+	if os.IsNotExist(err) || err == errCorrupt {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+	if !time.Now().Before(expiry) {
+		return nil, false, nil
+	}
+	return b, true, nil
+}
+
+// saves a session, replacing whatever was saved for it before. The file is written under another name first
+// so a crash never leaves half a session behind
+func (f *File) Commit(token string, b []byte, expiry time.Time) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	contents := make([]byte, 8, 8+len(b))
+	binary.BigEndian.PutUint64(contents, uint64(expiry.UnixNano()))
+	contents = append(contents, b...)
+
+	path := f.path(token)
+	err := ioutil.WriteFile(path+".tmp", contents, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// removes a session. Removing one that isn't there is fine
+func (f *File) Delete(token string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	err := os.Remove(f.path(token))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// stops deleting expired sessions
+func (f *File) StopCleanup() {
+	close(f.stopCleanup)
+}
+
+func (f *File) deleteExpired() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	files, err := ioutil.ReadDir(f.dir)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, fi := range files {
+		if fi.IsDir() || strings.HasSuffix(fi.Name(), ".tmp") {
+			continue
+		}
+		path := filepath.Join(f.dir, fi.Name())
+		expiry, _, err := f.read(path)
+		if err != nil || now.After(expiry) {
+			_ = os.Remove(path)
+		}
+	}
+	return nil
+}
+
+// the token comes from a cookie, so it is hashed rather than trusted as a file name
+func (f *File) path(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return filepath.Join(f.dir, hex.EncodeToString(sum[:]))
+}
+
+// reads a session file, which is the expiry in unix nanoseconds followed by the session's data
+func (f *File) read(path string) (time.Time, []byte, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return time.Time{}, nil, err
+	}
+	if len(contents) < 8 {
+		return time.Time{}, nil, errCorrupt
+	}
+	expiry := time.Unix(0, int64(binary.BigEndian.Uint64(contents)))
+	return expiry, contents[8:], nil
+}
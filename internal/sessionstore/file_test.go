@@ -0,0 +1,93 @@
+package sessionstore
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestFile(t *testing.T) *File {
+	f, err := NewFile(filepath.Join(t.TempDir(), "sessions"), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(f.StopCleanup)
+	return f
+}
+
+func TestFile_CommitFindDelete(t *testing.T) {
+	f := newTestFile(t)
+
+	err := f.Commit("abc", []byte("hello"), time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, found, err := f.Find("abc")
+	if err != nil || !found || string(b) != "hello" {
+		t.Fatalf("expected to find the session, got %q %t %v", b, found, err)
+	}
+
+	// committing again replaces it
+	_ = f.Commit("abc", []byte("goodbye"), time.Now().Add(time.Hour))
+	b, _, _ = f.Find("abc")
+	if string(b) != "goodbye" {
+		t.Errorf("expected the session to be replaced, got %q", b)
+	}
+
+	err = f.Delete("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, found, _ = f.Find("abc"); found {
+		t.Error("expected the session to be gone")
+	}
+	if err = f.Delete("abc"); err != nil {
+		t.Errorf("expected deleting a missing session to be fine, got %v", err)
+	}
+}
+
+func TestFile_Expired(t *testing.T) {
+	f := newTestFile(t)
+
+	_ = f.Commit("old", []byte("x"), time.Now().Add(-time.Minute))
+	_ = f.Commit("new", []byte("y"), time.Now().Add(time.Hour))
+
+	if _, found, err := f.Find("old"); found || err != nil {
+		t.Errorf("expected an expired session not to be found, got %t %v", found, err)
+	}
+
+	err := f.deleteExpired()
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, _ := ioutil.ReadDir(f.dir)
+	if len(files) != 1 {
+		t.Errorf("expected only the live session to be left, got %d files", len(files))
+	}
+	if _, found, _ := f.Find("new"); !found {
+		t.Error("expected the live session to be kept")
+	}
+}
+
+func TestFile_Tokens(t *testing.T) {
+	f := newTestFile(t)
+
+	// tokens come from cookies, so one can't pick where its file goes
+	err := f.Commit("../../escape", []byte("x"), time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Dir(f.path("../../escape")) != f.dir {
+		t.Error("expected the session file to be in the store's directory")
+	}
+
+	// a file that is too short is treated as no session, not an error
+	err = ioutil.WriteFile(f.path("short"), []byte("abc"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, found, err := f.Find("short"); found || err != nil {
+		t.Errorf("expected a corrupt session not to be found, got %t %v", found, err)
+	}
+}
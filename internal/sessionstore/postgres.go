@@ -0,0 +1,78 @@
+// Package sessionstore has scs session stores that keep sessions somewhere that outlives the app, so a restart
+// doesn't log everyone out
+package sessionstore
+
+import (
+	"database/sql"
+	"log"
+	"time"
+)
+
+// keeps sessions in the sessions table
+type Postgres struct {
+	db          *sql.DB
+	stopCleanup chan struct{}
+}
+
+// returns a store using the sessions table in db. Expired sessions are deleted every cleanupInterval,
+// until StopCleanup is called
+func NewPostgres(db *sql.DB, cleanupInterval time.Duration) *Postgres {
+	p := &Postgres{db: db, stopCleanup: make(chan struct{})}
+	go cleanup(p.deleteExpired, cleanupInterval, p.stopCleanup)
+	return p
+}
+
+// returns the data for a session that hasn't expired
+func (p *Postgres) Find(token string) ([]byte, bool, error) {
+	var b []byte
+	err := p.db.QueryRow(`SELECT data FROM sessions WHERE token = $1 AND current_timestamp < expiry`, token).Scan(&b)
+	if err == sql.ErrNoRows {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+	return b, true, nil
+}
+
+// saves a session, replacing whatever was saved for it before
+func (p *Postgres) Commit(token string, b []byte, expiry time.Time) error {
+	_, err := p.db.Exec(`
+			INSERT INTO sessions (token, data, expiry) VALUES ($1, $2, $3)
+			ON CONFLICT (token) DO UPDATE SET data = EXCLUDED.data, expiry = EXCLUDED.expiry
+			`, token, b, expiry)
+	return err
+}
+
+// removes a session. Removing one that isn't there is fine
+func (p *Postgres) Delete(token string) error {
+	_, err := p.db.Exec(`DELETE FROM sessions WHERE token = $1`, token)
+	return err
+}
+
+// stops deleting expired sessions
+func (p *Postgres) StopCleanup() {
+	close(p.stopCleanup)
+}
+
+func (p *Postgres) deleteExpired() error {
+	_, err := p.db.Exec(`DELETE FROM sessions WHERE expiry < current_timestamp`)
+	return err
+}
+
+// calls deleteExpired every interval until stop is closed
+func cleanup(deleteExpired func() error, interval time.Duration, stop chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			err := deleteExpired()
+			if err != nil {
+				log.Println("deleting expired sessions:", err)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package signedlink
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// the token wasn't made with our secret and key for this purpose, or has been changed
+	ErrInvalid = errors.New("signedlink: invalid token")
+	// the token was ours, but it has expired
+	ErrExpired = errors.New("signedlink: token expired")
+)
+
+// returns a token for the thing with id that works until expires. The purpose, like "guest" or "reset", goes in the
+// token so one made for one purpose never passes for another, and must not contain a dot. Data is carried in the
+// token as it is. Key is signed along with the secret but isn't in the token, so the token stops working as soon as
+// key changes, like a password hash once the password has been set
+func Sign(secret []byte, purpose string, id int, data, key string, expires time.Time) string {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%s.%d.%d.%s", purpose, id, expires.Unix(), data)))
+	return payload + "." + sign(secret, payload, key)
+}
+
+// returns the id and data in a token for purpose, without checking it. Use it to find the key to pass to Verify,
+// and don't trust anything else until Verify says so
+func Parse(purpose, token string) (int, string, error) {
+	id, _, data, err := parse(purpose, token)
+	return id, data, err
+}
+
+// checks a token made by Sign with the same purpose and key, and returns the id and data in it
+func Verify(secret []byte, purpose, token, key string, now time.Time) (int, string, error) {
+	id, expires, data, err := parse(purpose, token)
+	if err != nil {
+		return 0, "", err
+	}
+
+	parts := strings.Split(token, ".")
+	if !hmac.Equal([]byte(parts[1]), []byte(sign(secret, parts[0], key))) {
+		return 0, "", ErrInvalid
+	}
+
+	if now.Unix() > expires {
+		return 0, "", ErrExpired
+	}
+
+	return id, data, nil
+}
+
+// reads the id, expiry and data out of a token for purpose
+func parse(purpose, token string) (int, int64, string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return 0, 0, "", ErrInvalid
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return 0, 0, "", ErrInvalid
+	}
+
+	fields := strings.SplitN(string(payload), ".", 4)
+	if len(fields) != 4 || fields[0] != purpose {
+		return 0, 0, "", ErrInvalid
+	}
+	id, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return 0, 0, "", ErrInvalid
+	}
+	expires, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return 0, 0, "", ErrInvalid
+	}
+
+	return id, expires, fields[3], nil
+}
+
+func sign(secret []byte, payload, key string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(payload))
+	mac.Write([]byte{0})
+	mac.Write([]byte(key))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,58 @@
+package signedlink
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+var secret = []byte("0123456789abcdef0123456789abcdef")
+
+func TestVerify(t *testing.T) {
+	now := time.Date(2050, 1, 1, 12, 0, 0, 0, time.UTC)
+	token := Sign(secret, "test", 42, "some.data", "key", now.Add(time.Hour))
+
+	id, data, err := Verify(secret, "test", token, "key", now)
+	if err != nil || id != 42 || data != "some.data" {
+		t.Fatalf("expected 42 with its data, got %d %q, %v", id, data, err)
+	}
+
+	id, data, err = Parse("test", token)
+	if err != nil || id != 42 || data != "some.data" {
+		t.Fatalf("expected to read 42 with its data, got %d %q, %v", id, data, err)
+	}
+
+	// the payload of one token with the signature of another
+	other := Sign(secret, "test", 43, "some.data", "key", now.Add(time.Hour))
+	swapped := strings.Split(other, ".")[0] + "." + strings.Split(token, ".")[1]
+
+	var tests = []struct {
+		name    string
+		purpose string
+		token   string
+		key     string
+		now     time.Time
+		want    error
+	}{
+		{"expired", "test", token, "key", now.Add(2 * time.Hour), ErrExpired},
+		{"another purpose", "other", token, "key", now, ErrInvalid},
+		{"another key", "test", token, "new-key", now, ErrInvalid},
+		{"another secret", "test", Sign([]byte("a different secret of enough length"), "test", 42, "some.data", "key", now.Add(time.Hour)), "key", now, ErrInvalid},
+		{"swapped signature", "test", swapped, "key", now, ErrInvalid},
+		{"edited", "test", token + "x", "key", now, ErrInvalid},
+		{"too many parts", "test", "a.b.c", "key", now, ErrInvalid},
+		{"blank", "test", "", "key", now, ErrInvalid},
+	}
+
+	for _, e := range tests {
+		_, _, err := Verify(secret, e.purpose, e.token, e.key, e.now)
+		if err != e.want {
+			t.Errorf("%s: expected %v, got %v", e.name, e.want, err)
+		}
+	}
+
+	_, _, err = Parse("other", token)
+	if err != ErrInvalid {
+		t.Errorf("expected a token for another purpose not to parse, got %v", err)
+	}
+}
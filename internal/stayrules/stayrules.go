@@ -0,0 +1,59 @@
+package stayrules
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/andkolbe/bookings/internal/models"
+)
+
+const dateLayout = "Monday, January 2, 2006"
+
+// returns what is wrong with a stay from start to end under rules, in words a guest can act on.
+// It returns nothing when the stay is allowed. rules should all be for the room being booked
+func Check(rules []models.StayRule, start, end time.Time) []string {
+	var problems []string
+	seen := make(map[string]bool)
+	add := func(format string, args ...interface{}) {
+		p := fmt.Sprintf(format, args...)
+		if !seen[p] {
+			seen[p] = true
+			problems = append(problems, p)
+		}
+	}
+
+	nights := int(end.Sub(start).Hours() / 24)
+
+	for _, r := range rules {
+		if covers(r, start) {
+			if r.MinNights > 0 && nights < r.MinNights {
+				add("Stays arriving on %s have to be at least %d nights", start.Format(dateLayout), r.MinNights)
+			}
+			if r.MaxNights > 0 && nights > r.MaxNights {
+				add("Stays arriving on %s can't be more than %d nights", start.Format(dateLayout), r.MaxNights)
+			}
+			if r.NoArrivalDays.Has(start.Weekday()) {
+				add("Check-in isn't available on %s", start.Format(dateLayout))
+			}
+		}
+		if covers(r, end) && r.NoDepartureDays.Has(end.Weekday()) {
+			add("Check-out isn't available on %s", end.Format(dateLayout))
+		}
+	}
+
+	return problems
+}
+
+// joins the problems from Check into sentences for a message
+func Explain(problems []string) string {
+	if len(problems) == 0 {
+		return ""
+	}
+	return strings.Join(problems, ". ") + "."
+}
+
+// reports whether day is in the dates a rule covers
+func covers(r models.StayRule, day time.Time) bool {
+	return !day.Before(r.StartDate) && day.Before(r.EndDate)
+}
@@ -0,0 +1,67 @@
+package stayrules
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andkolbe/bookings/internal/models"
+)
+
+// June 1st 2050 is a wednesday
+func day(d int) time.Time {
+	return time.Date(2050, 6, d, 0, 0, 0, 0, time.UTC)
+}
+
+func TestCheck(t *testing.T) {
+	rules := []models.StayRule{
+		// june stays are 2 to 7 nights, with no sunday check-ins or check-outs
+		{StartDate: day(1), EndDate: day(31), MinNights: 2, MaxNights: 7, NoArrivalDays: models.WeekdaysOf(time.Sunday), NoDepartureDays: models.WeekdaysOf(time.Sunday)},
+		// the festival weekend is 3 nights at least
+		{StartDate: day(9), EndDate: day(12), MinNights: 3},
+	}
+
+	var tests = []struct {
+		name     string
+		start    time.Time
+		end      time.Time
+		problems []string
+	}{
+		{"allowed", day(1), day(3), nil},
+		{"too short", day(1), day(2), []string{"at least 2 nights"}},
+		{"too long", day(1), day(9), []string{"more than 7 nights"}},
+		{"sunday check-in", day(5), day(7), []string{"Check-in isn't available on Sunday, June 5, 2050"}},
+		{"sunday check-out", day(3), day(5), []string{"Check-out isn't available on Sunday, June 5, 2050"}},
+		{"the stricter rule", day(9), day(11), []string{"at least 3 nights"}},
+		{"both", day(5), day(6), []string{"at least 2 nights", "Check-in isn't available"}},
+		{"before the rules", time.Date(2050, 5, 1, 0, 0, 0, 0, time.UTC), time.Date(2050, 5, 2, 0, 0, 0, 0, time.UTC), nil},
+		{"leaving into the rules", time.Date(2050, 5, 30, 0, 0, 0, 0, time.UTC), day(5), []string{"Check-out isn't available"}},
+		{"no rules", day(1), day(2), nil},
+	}
+
+	for _, e := range tests {
+		r := rules
+		if e.name == "no rules" {
+			r = nil
+		}
+		problems := Check(r, e.start, e.end)
+		if len(problems) != len(e.problems) {
+			t.Errorf("%s: expected %d problems, got %v", e.name, len(e.problems), problems)
+			continue
+		}
+		for i, p := range e.problems {
+			if !strings.Contains(problems[i], p) {
+				t.Errorf("%s: expected %q, got %q", e.name, p, problems[i])
+			}
+		}
+	}
+}
+
+func TestExplain(t *testing.T) {
+	if got := Explain([]string{"One thing", "Another"}); got != "One thing. Another." {
+		t.Errorf("expected sentences, got %q", got)
+	}
+	if got := Explain(nil); got != "" {
+		t.Errorf("expected nothing without problems, got %q", got)
+	}
+}
@@ -0,0 +1,52 @@
+package throttle
+
+import (
+	"strings"
+	"time"
+
+	"github.com/andkolbe/bookings/internal/models"
+)
+
+const (
+	// failures allowed before there is any wait between attempts
+	freeFailures = 2
+	// the longest wait between attempts before a lock
+	maxDelay = time.Minute
+)
+
+// returns the key failed logins for an account are kept under
+func AccountKey(email string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+// returns the key failed logins from an ip address are kept under
+func IPKey(ip string) string {
+	return "ip:" + ip
+}
+
+// returns how long after a failure the next attempt has to wait, when it was the failures'th in a row. The first
+// couple are free, then the wait doubles from a second each time, up to a minute
+func Delay(failures int) time.Duration {
+	if failures <= freeFailures {
+		return 0
+	}
+	d := time.Second
+	for i := freeFailures + 1; i < failures && d < maxDelay; i++ {
+		d *= 2
+	}
+	if d > maxDelay {
+		d = maxDelay
+	}
+	return d
+}
+
+// returns how long until another login can be tried, or 0 if one can be tried now
+func Wait(t models.LoginThrottle, now time.Time) time.Duration {
+	if t.LockedUntil.After(now) {
+		return t.LockedUntil.Sub(now)
+	}
+	if ready := t.LastFailureAt.Add(Delay(t.Failures)); ready.After(now) {
+		return ready.Sub(now)
+	}
+	return 0
+}
@@ -0,0 +1,61 @@
+package throttle
+
+import (
+	"testing"
+	"time"
+
+	"github.com/andkolbe/bookings/internal/models"
+)
+
+func TestDelay(t *testing.T) {
+	var tests = []struct {
+		failures int
+		delay    time.Duration
+	}{
+		{0, 0},
+		{2, 0},
+		{3, time.Second},
+		{4, 2 * time.Second},
+		{6, 8 * time.Second},
+		{9, time.Minute},
+		{100, time.Minute},
+	}
+
+	for _, e := range tests {
+		if d := Delay(e.failures); d != e.delay {
+			t.Errorf("%d failures: expected %s, got %s", e.failures, e.delay, d)
+		}
+	}
+}
+
+func TestWait(t *testing.T) {
+	now := time.Date(2050, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	var tests = []struct {
+		name     string
+		throttle models.LoginThrottle
+		wait     time.Duration
+	}{
+		{"never failed", models.LoginThrottle{}, 0},
+		{"free failures", models.LoginThrottle{Failures: 2, LastFailureAt: now}, 0},
+		{"slowed down", models.LoginThrottle{Failures: 4, LastFailureAt: now.Add(-time.Second)}, time.Second},
+		{"waited long enough", models.LoginThrottle{Failures: 4, LastFailureAt: now.Add(-time.Minute)}, 0},
+		{"locked", models.LoginThrottle{LockedUntil: now.Add(10 * time.Minute), LastFailureAt: now}, 10 * time.Minute},
+		{"lock over", models.LoginThrottle{LockedUntil: now.Add(-time.Minute), LastFailureAt: now.Add(-16 * time.Minute)}, 0},
+	}
+
+	for _, e := range tests {
+		if w := Wait(e.throttle, now); w != e.wait {
+			t.Errorf("%s: expected %s, got %s", e.name, e.wait, w)
+		}
+	}
+}
+
+func TestKeys(t *testing.T) {
+	if AccountKey(" Me@Here.com ") != "account:me@here.com" {
+		t.Errorf("expected account keys to ignore case and spaces, got %q", AccountKey(" Me@Here.com "))
+	}
+	if IPKey("127.0.0.1") != "ip:127.0.0.1" {
+		t.Errorf("unexpected ip key %q", IPKey("127.0.0.1"))
+	}
+}
@@ -0,0 +1,121 @@
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// codes are 6 digits and change every 30 seconds, which is what authenticator apps expect when the
+// provisioning uri doesn't say otherwise
+const (
+	Digits = 6
+	Period = 30 * time.Second
+)
+
+// how many periods either side of now a code is still accepted, for clocks that are a little out
+const skew = 1
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// returns a new random secret, base32 encoded the way authenticator apps want it
+func NewSecret() (string, error) {
+	b := make([]byte, 20)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return encoding.EncodeToString(b), nil
+}
+
+// returns the number of the period t is in. A code can only be used once, so callers keep the last step that was
+// used and refuse codes for it or anything before it
+func Step(t time.Time) int64 {
+	return t.Unix() / int64(Period/time.Second)
+}
+
+// returns the code for secret in the given step
+func Code(secret string, step int64) (string, error) {
+	key, err := encoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	// dynamic truncation from RFC 4226
+	offset := sum[len(sum)-1] & 0xf
+	n := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", Digits, n%1000000), nil
+}
+
+// checks a code someone typed in against secret, allowing for a little clock drift. Returns the step the code was
+// for, so it can be marked as used
+func Validate(secret, code string, now time.Time) (int64, bool) {
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if len(code) != Digits {
+		return 0, false
+	}
+
+	step := Step(now)
+	for s := step - skew; s <= step+skew; s++ {
+		want, err := Code(secret, s)
+		if err != nil {
+			return 0, false
+		}
+		if hmac.Equal([]byte(want), []byte(code)) {
+			return s, true
+		}
+	}
+	return 0, false
+}
+
+// returns the otpauth:// uri authenticator apps read from a qr code
+func URI(issuer, account, secret string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprint(Digits))
+	v.Set("period", fmt.Sprint(int(Period/time.Second)))
+
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+	return "otpauth://totp/" + label + "?" + v.Encode()
+}
+
+// returns n one-time recovery codes like 7kq2m-x4bzt, for logging in without the authenticator app
+func NewRecoveryCodes(n int) ([]string, error) {
+	codes := make([]string, n)
+	for i := range codes {
+		b := make([]byte, 7)
+		_, err := rand.Read(b)
+		if err != nil {
+			return nil, err
+		}
+		s := strings.ToLower(encoding.EncodeToString(b))[:10]
+		codes[i] = s[:5] + "-" + s[5:]
+	}
+	return codes, nil
+}
+
+// returns the hash a recovery code is stored as. Case, spaces and dashes don't matter, so codes can be typed in
+// however they were written down
+func HashRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.NewReplacer("-", "", " ", "").Replace(strings.TrimSpace(code))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,99 @@
+package totp
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+// the sha1 test vectors from RFC 6238, cut down to 6 digits
+func TestCode(t *testing.T) {
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+	var tests = []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, e := range tests {
+		code, err := Code(secret, Step(time.Unix(e.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != e.code {
+			t.Errorf("%d: expected %s, got %s", e.unix, e.code, code)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret, err := NewSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2050, 1, 1, 12, 0, 10, 0, time.UTC)
+	code, _ := Code(secret, Step(now))
+	previous, _ := Code(secret, Step(now)-1)
+	old, _ := Code(secret, Step(now)-3)
+
+	var tests = []struct {
+		name string
+		code string
+		step int64
+		ok   bool
+	}{
+		{"now", code, Step(now), true},
+		{"with a space", code[:3] + " " + code[3:], Step(now), true},
+		{"a period ago", previous, Step(now) - 1, true},
+		{"too old", old, 0, false},
+		{"blank", "", 0, false},
+		{"too short", code[:5], 0, false},
+	}
+
+	for _, e := range tests {
+		step, ok := Validate(secret, e.code, now)
+		if ok != e.ok || step != e.step {
+			t.Errorf("%s: expected %d %t, got %d %t", e.name, e.step, e.ok, step, ok)
+		}
+	}
+
+	if _, ok := Validate("not base32!", code, now); ok {
+		t.Error("expected a bad secret not to validate anything")
+	}
+}
+
+func TestURI(t *testing.T) {
+	uri := URI("Bookings", "jo smith@here.com", "ABC")
+	if !strings.HasPrefix(uri, "otpauth://totp/Bookings:jo%20smith@here.com?") || !strings.Contains(uri, "secret=ABC") || !strings.Contains(uri, "issuer=Bookings") {
+		t.Errorf("unexpected uri %s", uri)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := NewRecoveryCodes(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[string]bool)
+	for _, c := range codes {
+		if len(c) != 11 || c[5] != '-' || seen[c] {
+			t.Errorf("unexpected code %q", c)
+		}
+		seen[c] = true
+	}
+
+	if HashRecoveryCode("7kq2m-x4bzt") != HashRecoveryCode(" 7KQ2M X4BZT") {
+		t.Error("expected the hash to ignore case, spaces and dashes")
+	}
+	if HashRecoveryCode("7kq2m-x4bzt") == HashRecoveryCode("7kq2m-x4bzu") {
+		t.Error("expected different codes to have different hashes")
+	}
+}
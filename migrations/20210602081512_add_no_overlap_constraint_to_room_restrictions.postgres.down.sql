@@ -0,0 +1 @@
+ALTER TABLE room_restrictions DROP CONSTRAINT room_restrictions_no_overlapping_reservations;